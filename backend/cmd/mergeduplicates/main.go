@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+)
+
+// mergeduplicates merges documents that repeat the verification code, document hash or access key of an older
+// document of the same company, which keeps migration 017_add_document_unique_indexes from creating the unique
+// document indexes. The oldest document is kept and the duplicates are deleted, so the default run is a dry run
+// that only reports the merges; the XMLs left without a document are removed by the storage reconciliation.
+//
+//	go run ./cmd/mergeduplicates         # report the duplicates that would be merged
+//	go run ./cmd/mergeduplicates -apply  # merge them, then restart the server to finish the migrations
+func main() {
+	apply := flag.Bool("apply", false, "merge the duplicates instead of only reporting them")
+	flag.Parse()
+
+	config.Load()
+	logger.Initialize()
+
+	if err := database.Connect(); err != nil {
+		fmt.Printf("FAIL connect to database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	report, err := database.RunDuplicateMerge(context.Background(), database.DB, !*apply)
+	if err != nil {
+		fmt.Printf("FAIL %v\n", err)
+		os.Exit(1)
+	}
+
+	action := "merged"
+	if report.DryRun {
+		action = "would merge"
+	}
+	for _, merge := range report.Merges {
+		fmt.Printf("company %d: %s document %d into %d (%s) %s\n",
+			merge.CompanyID, action, merge.DocumentID, merge.KeeperID, merge.Key, merge.StorageKey)
+	}
+
+	keys := make([]string, 0, len(report.Merged))
+	for key := range report.Merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s: %d duplicates\n", key, report.Merged[key])
+	}
+	for _, storageKey := range report.OrphanedStorageKeys {
+		fmt.Printf("orphaned XML: %s\n", storageKey)
+	}
+
+	fmt.Printf("%s %d duplicate documents of %d companies, %d XMLs left without a document\n",
+		action, len(report.Merges), len(report.Companies), len(report.OrphanedStorageKeys))
+	if report.DryRun && len(report.Merges) > 0 {
+		fmt.Println("run again with -apply to merge them")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/services"
+)
+
+// rewrapkeys wraps the per-company data keys with the current master key after a master key rotation.
+// Set the new key in STORAGE_MASTER_KEY and the previous one in STORAGE_OLD_MASTER_KEYS, run the command,
+// then remove the old key once it reports no failures. Stored objects are not rewritten.
+//
+//	go run ./cmd/rewrapkeys           # rewrap every data key not under the current master key
+//	go run ./cmd/rewrapkeys -dry-run  # only check that every data key can be unwrapped
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only check that the data keys can be unwrapped, without rewrapping them")
+	flag.Parse()
+
+	config.Load()
+	logger.Initialize()
+
+	keys, err := services.NewDataKeys()
+	if err != nil {
+		fmt.Printf("FAIL %v\n", err)
+		os.Exit(1)
+	}
+	if keys == nil {
+		fmt.Println("FAIL STORAGE_MASTER_KEY is not set")
+		os.Exit(1)
+	}
+
+	if err := database.Connect(); err != nil {
+		fmt.Printf("FAIL connect to database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	result, err := keys.Rewrap(context.Background(), *dryRun)
+	if err != nil {
+		fmt.Printf("FAIL %v\n", err)
+		os.Exit(1)
+	}
+
+	action := "rewrapped"
+	if *dryRun {
+		action = "to rewrap"
+	}
+	fmt.Printf("master key %s: %d data keys, %d %s, %d already current, %d failed\n",
+		result.MasterKey, result.Total, result.Rewrapped, action, result.Current, result.Failed)
+
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/zoomxml/internal/api/routes"
 	"github.com/zoomxml/internal/database"
 	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/metrics"
 	"github.com/zoomxml/internal/services"
 	"github.com/zoomxml/internal/storage"
 
@@ -69,15 +70,102 @@ func main() {
 		logger.Fatal("Failed to run migrations:", err)
 	}
 
+	// Executar migrações incrementais (colunas e índices adicionados após a criação das tabelas)
+	if err := database.RunMigrations(ctx); err != nil {
+		logger.Fatal("Failed to run incremental migrations:", err)
+	}
+
 	// Executar seeders (criar usuário admin automaticamente)
 	if err := database.RunSeeders(ctx); err != nil {
 		logger.Fatal("Failed to run seeders:", err)
 	}
 
-	// Inicializar storage (MinIO)
-	if err := storage.InitializeStorage(); err != nil {
+	// Chaves de dados das empresas para a criptografia do storage (STORAGE_MASTER_KEY)
+	storageKeys, err := services.StorageKeyStore()
+	if err != nil {
+		logger.Fatal("Failed to load storage master keys:", err)
+	}
+
+	// Inicializar storage (driver definido em STORAGE_DRIVER)
+	if err := storage.InitializeStorage(storageKeys); err != nil {
 		logger.Fatal("Failed to initialize storage:", err)
 	}
+	if !storage.NewURLSigner().Enabled() {
+		logger.WarnWithFields("No storage signing key configured, download links signed by the API are disabled", map[string]any{
+			"operation": "init_storage",
+			"driver":    cfg.Storage.Driver,
+		})
+	}
+
+	// Exportações que estavam em andamento quando o servidor parou não serão concluídas
+	if err := services.NewDocumentExporter().FailInterrupted(ctx); err != nil {
+		logger.ErrorWithFields("Failed to fail interrupted export jobs", err, map[string]any{
+			"operation": "export_documents",
+		})
+	}
+
+	// Preencher hashes, colunas fiscais, datas, autenticidade, participantes, itens de serviço e municípios de documentos antigos
+	go func() {
+		backfiller := services.NewDocumentBackfiller()
+		if _, err := backfiller.BackfillContentHashes(context.Background()); err != nil {
+			logger.ErrorWithFields("Content hashes backfill failed", err, map[string]any{
+				"operation": "backfill_content_hashes",
+			})
+		}
+		if _, err := backfiller.BackfillTaxFields(context.Background()); err != nil {
+			logger.ErrorWithFields("Tax fields backfill failed", err, map[string]any{
+				"operation": "backfill_tax_fields",
+			})
+		}
+		if _, err := backfiller.BackfillIssueDates(context.Background()); err != nil {
+			logger.ErrorWithFields("Issue dates backfill failed", err, map[string]any{
+				"operation": "backfill_issue_dates",
+			})
+		}
+		if _, err := backfiller.BackfillAuthenticity(context.Background()); err != nil {
+			logger.ErrorWithFields("Authenticity backfill failed", err, map[string]any{
+				"operation": "backfill_authenticity",
+			})
+		}
+		if _, err := backfiller.BackfillParties(context.Background()); err != nil {
+			logger.ErrorWithFields("Parties backfill failed", err, map[string]any{
+				"operation": "backfill_parties",
+			})
+		}
+		if _, err := backfiller.BackfillServiceItems(context.Background()); err != nil {
+			logger.ErrorWithFields("Service items backfill failed", err, map[string]any{
+				"operation": "backfill_service_items",
+			})
+		}
+		if _, err := backfiller.BackfillLocations(context.Background()); err != nil {
+			logger.ErrorWithFields("Locations backfill failed", err, map[string]any{
+				"operation": "backfill_locations",
+			})
+		}
+	}()
+
+	// Criptografar os XMLs já armazenados com as chaves das empresas e depois comprimi-los com o codec
+	// definido em STORAGE_COMPRESSION; a compressão preserva a empresa registrada pela criptografia
+	encryption := storageKeys != nil
+	compression := cfg.Storage.Compression != "" && cfg.Storage.Compression != storage.CodecNone
+	if encryption || compression {
+		go func() {
+			if encryption {
+				if _, err := services.NewStorageEncryptor().EncryptExisting(context.Background()); err != nil {
+					logger.ErrorWithFields("Storage encryption failed", err, map[string]any{
+						"operation": "encrypt_storage",
+					})
+				}
+			}
+			if compression {
+				if _, err := services.NewStorageCompressor().CompressExisting(context.Background()); err != nil {
+					logger.ErrorWithFields("Storage compression failed", err, map[string]any{
+						"operation": "compress_storage",
+					})
+				}
+			}
+		}()
+	}
 
 	// Inicializar e iniciar o scheduler NFSe
 	nfseScheduler := services.NewNFSeScheduler()
@@ -88,6 +176,27 @@ func main() {
 	// Graceful shutdown do scheduler
 	defer nfseScheduler.Stop()
 
+	// Verificação periódica de integridade do storage (STORAGE_SCRUB_INTERVAL)
+	storageScrubber := services.NewStorageScrubber()
+	if err := storageScrubber.Start(); err != nil {
+		logger.Fatal("Failed to start storage scrubber:", err)
+	}
+	defer storageScrubber.Stop()
+
+	// Expurgo periódico de documentos com a retenção vencida (RETENTION_PURGE_INTERVAL)
+	retentionPurger := services.NewRetentionPurger()
+	if err := retentionPurger.Start(); err != nil {
+		logger.Fatal("Failed to start retention purger:", err)
+	}
+	defer retentionPurger.Stop()
+
+	// Reconciliação periódica do storage com os documentos (STORAGE_RECONCILE_INTERVAL)
+	storageReconciler := services.NewStorageReconciler()
+	if err := storageReconciler.Start(); err != nil {
+		logger.Fatal("Failed to start storage reconciler:", err)
+	}
+	defer storageReconciler.Stop()
+
 	// Criar aplicação Fiber
 	app := fiber.New(fiber.Config{
 		AppName:      cfg.App.Name,
@@ -165,6 +274,18 @@ func setupMiddleware(app *fiber.App, cfg *config.Config) {
 		})
 	})
 
+	// Metrics endpoint
+	// @Summary Métricas
+	// @Description Métricas da aplicação no formato de texto do Prometheus
+	// @Tags health
+	// @Produce plain
+	// @Success 200 {string} string "Métricas"
+	// @Router /metrics [get]
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		return metrics.WriteText(c)
+	})
+
 	// Swagger documentation
 	app.Get("/swagger/*", swagger.HandlerDefault)
 }
@@ -10,6 +10,10 @@ import (
 	"github.com/joho/godotenv"
 )
 
+// DefaultJWTSecret is the placeholder JWT_SECRET used when none is set. It is public, so nothing that must
+// stay secret may be signed with it.
+const DefaultJWTSecret = "your-secret-key-change-in-production"
+
 // Config holds all application configuration
 type Config struct {
 	App           AppConfig
@@ -20,6 +24,11 @@ type Config struct {
 	Logger        LoggerConfig
 	RateLimit     RateLimitConfig
 	NFSeScheduler NFSeSchedulerConfig
+	XMLValidation XMLValidationConfig
+	Signature     SignatureConfig
+	Catalog       CatalogConfig
+	Export        ExportConfig
+	Retention     RetentionConfig
 }
 
 // AppConfig holds application-specific configuration
@@ -43,14 +52,25 @@ type DatabaseConfig struct {
 	ConnMaxLifetime time.Duration
 }
 
-// StorageConfig holds MinIO/S3 storage configuration
+// StorageConfig holds the storage backend configuration
 type StorageConfig struct {
-	Endpoint  string
-	AccessKey string
-	SecretKey string
-	Bucket    string
-	UseSSL    bool
-	Region    string
+	Driver            string        // minio, filesystem or memory
+	Path              string        // Root directory of the filesystem driver
+	Compression       string        // none, gzip or zstd
+	ScrubInterval     time.Duration // Interval between storage integrity scrubs; 0 disables them
+	PublicURL         string        // Base URL of the API in the download links it signs; defaults to http://localhost:PORT
+	SigningKey        string        // HMAC key of the download links signed by the API; defaults to a non-default JWT_SECRET
+	MasterKey         string        // Base64 AES-256 key wrapping the per-company data keys; empty disables encryption
+	OldMasterKeys     []string      // Previous master keys, still accepted to unwrap data keys until they are rewrapped
+	ReconcileInterval time.Duration // Interval between storage reconciliations with the documents; 0 disables them
+	ReconcilePolicy   string        // What scheduled reconciliations do with orphaned objects: report, reimport or delete
+	ReconcileMinAge   time.Duration // Orphaned objects written more recently than this are left for the next reconciliation
+	Endpoint          string
+	AccessKey         string
+	SecretKey         string
+	Bucket            string
+	UseSSL            bool
+	Region            string
 }
 
 // AuthConfig holds authentication configuration
@@ -106,6 +126,47 @@ type NFSeSchedulerConfig struct {
 	APIDelaySeconds int
 }
 
+// XMLValidationConfig holds fiscal XML schema validation configuration
+type XMLValidationConfig struct {
+	DefaultMode   string
+	ProviderModes map[string]string
+}
+
+// SignatureConfig holds XMLDSig verification configuration
+type SignatureConfig struct {
+	TrustStorePath string
+}
+
+// CatalogConfig holds the LC 116/2003 service list and CNAE catalog configuration
+type CatalogConfig struct {
+	Path string
+}
+
+// ExportConfig holds the document export configuration
+type ExportConfig struct {
+	SyncLimit int // Exports with up to this many documents are streamed in the response; larger ones run as jobs
+}
+
+// RetentionConfig holds the document retention configuration
+type RetentionConfig struct {
+	DefaultYears  int           // Retention of documents without a policy; never less than the legal minimum of five years
+	PurgeInterval time.Duration // Interval between scheduled purges of expired documents; 0 disables them
+}
+
+// Storage drivers
+const (
+	StorageDriverMinIO      = "minio"
+	StorageDriverFilesystem = "filesystem"
+	StorageDriverMemory     = "memory"
+)
+
+// XML validation modes
+const (
+	XMLValidationReject = "reject"
+	XMLValidationWarn   = "warn"
+	XMLValidationOff    = "off"
+)
+
 var appConfig *Config
 
 // Load loads configuration from environment variables
@@ -134,15 +195,26 @@ func Load() *Config {
 			ConnMaxLifetime: getEnvDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute),
 		},
 		Storage: StorageConfig{
-			Endpoint:  getEnv("MINIO_ENDPOINT", "localhost:9000"),
-			AccessKey: getEnv("MINIO_ACCESS_KEY", "admin"),
-			SecretKey: getEnv("MINIO_SECRET_KEY", "password123"),
-			Bucket:    getEnv("MINIO_BUCKET", "nfse-storage"),
-			UseSSL:    getEnvBool("MINIO_USE_SSL", false),
-			Region:    getEnv("MINIO_REGION", "us-east-1"),
+			Driver:            getEnv("STORAGE_DRIVER", StorageDriverMinIO),
+			Path:              getEnv("STORAGE_PATH", "data/storage"),
+			Compression:       getEnv("STORAGE_COMPRESSION", "none"),
+			ScrubInterval:     getEnvDuration("STORAGE_SCRUB_INTERVAL", 24*time.Hour),
+			PublicURL:         getEnv("STORAGE_PUBLIC_URL", ""),
+			SigningKey:        getEnv("STORAGE_SIGNING_KEY", ""),
+			MasterKey:         getEnv("STORAGE_MASTER_KEY", ""),
+			OldMasterKeys:     getEnvSlice("STORAGE_OLD_MASTER_KEYS", nil),
+			ReconcileInterval: getEnvDuration("STORAGE_RECONCILE_INTERVAL", 0),
+			ReconcilePolicy:   getEnv("STORAGE_RECONCILE_POLICY", "report"),
+			ReconcileMinAge:   getEnvDuration("STORAGE_RECONCILE_MIN_AGE", time.Hour),
+			Endpoint:          getEnv("MINIO_ENDPOINT", "localhost:9000"),
+			AccessKey:         getEnv("MINIO_ACCESS_KEY", "admin"),
+			SecretKey:         getEnv("MINIO_SECRET_KEY", "password123"),
+			Bucket:            getEnv("MINIO_BUCKET", "nfse-storage"),
+			UseSSL:            getEnvBool("MINIO_USE_SSL", false),
+			Region:            getEnv("MINIO_REGION", "us-east-1"),
 		},
 		Auth: AuthConfig{
-			JWTSecret:           getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+			JWTSecret:           getEnv("JWT_SECRET", DefaultJWTSecret),
 			JWTExpirationHours:  getEnvInt("JWT_EXPIRATION_HOURS", 24),
 			RefreshTokenExpiry:  getEnvDuration("REFRESH_TOKEN_EXPIRY", 168*time.Hour),
 			PasswordMinLength:   getEnvInt("PASSWORD_MIN_LENGTH", 8),
@@ -184,6 +256,23 @@ func Load() *Config {
 			MaxPagesPerRun:  getEnvInt("NFSE_MAX_PAGES_PER_RUN", 10),
 			APIDelaySeconds: getEnvInt("NFSE_API_DELAY_SECONDS", 2),
 		},
+		XMLValidation: XMLValidationConfig{
+			DefaultMode:   getEnv("XML_VALIDATION_MODE", XMLValidationWarn),
+			ProviderModes: getEnvMap("XML_VALIDATION_PROVIDER_MODES"),
+		},
+		Signature: SignatureConfig{
+			TrustStorePath: getEnv("XMLDSIG_TRUST_STORE_PATH", ""),
+		},
+		Catalog: CatalogConfig{
+			Path: getEnv("CATALOG_PATH", ""),
+		},
+		Export: ExportConfig{
+			SyncLimit: getEnvInt("EXPORT_SYNC_LIMIT", 500),
+		},
+		Retention: RetentionConfig{
+			DefaultYears:  getEnvInt("RETENTION_DEFAULT_YEARS", 5),
+			PurgeInterval: getEnvDuration("RETENTION_PURGE_INTERVAL", 0),
+		},
 	}
 
 	appConfig = config
@@ -240,6 +329,33 @@ func getEnvSlice(key string, fallback []string) []string {
 	return fallback
 }
 
+// getEnvMap parses values in the form "key1:value1,key2:value2"
+func getEnvMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if found && name != "" {
+			result[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return result
+}
+
+// ModeFor returns the validation mode configured for a provider, falling back to the default mode
+func (c XMLValidationConfig) ModeFor(provider string) string {
+	mode, ok := c.ProviderModes[provider]
+	if !ok {
+		mode = c.DefaultMode
+	}
+
+	switch mode {
+	case XMLValidationReject, XMLValidationWarn, XMLValidationOff:
+		return mode
+	default:
+		return XMLValidationWarn
+	}
+}
+
 // IsDevelopment returns true if the app is running in development mode
 func (c *Config) IsDevelopment() bool {
 	return c.App.Env == "development"
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+// newAuditLog monta o registro de auditoria de uma ação do usuário autenticado
+func newAuditLog(c *fiber.Ctx, user *models.User, action, entity string, entityID int64, details map[string]any) *models.AuditLog {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		encoded = []byte("{}")
+	}
+
+	return &models.AuditLog{
+		ActorID:   user.ID,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Details:   string(encoded),
+		IPAddress: c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+}
+
+// recordAuditLog grava um registro de auditoria; falhas são apenas registradas no log para não interromper a ação já executada
+func recordAuditLog(c *fiber.Ctx, audit *models.AuditLog) {
+	if _, err := database.DB.NewInsert().Model(audit).Exec(c.Context()); err != nil {
+		logger.ErrorWithFields("Failed to record audit log", err, map[string]any{
+			"operation": "record_audit_log",
+			"actor_id":  audit.ActorID,
+			"action":    audit.Action,
+			"entity":    audit.Entity,
+			"entity_id": audit.EntityID,
+		})
+	}
+}
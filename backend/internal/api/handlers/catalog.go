@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/catalog"
+	"github.com/zoomxml/internal/services"
+)
+
+// CatalogHandler gerencia a consulta aos catálogos de serviços (LC 116/2003) e CNAE
+type CatalogHandler struct {
+	catalogs *catalog.Catalogs
+}
+
+// NewCatalogHandler cria uma nova instância do handler de catálogos
+func NewCatalogHandler() *CatalogHandler {
+	return &CatalogHandler{
+		catalogs: services.LoadServiceCatalogs(),
+	}
+}
+
+// CatalogSearchResponse representa a resposta da busca em um catálogo
+type CatalogSearchResponse struct {
+	Catalog string          `json:"catalog"`
+	Version string          `json:"version"`
+	Entries []catalog.Entry `json:"entries"`
+}
+
+// CatalogEntryResponse representa um código do catálogo com seus códigos filhos
+type CatalogEntryResponse struct {
+	Catalog  string          `json:"catalog"`
+	Version  string          `json:"version"`
+	Entry    catalog.Entry   `json:"entry"`
+	Children []catalog.Entry `json:"children"`
+}
+
+// MunicipalitiesResponse representa a resposta da busca de municípios
+type MunicipalitiesResponse struct {
+	Version        string                 `json:"version"`
+	Municipalities []catalog.Municipality `json:"municipalities"`
+}
+
+// MunicipalityResponse representa um município com sua UF
+type MunicipalityResponse struct {
+	Version      string               `json:"version"`
+	Municipality catalog.Municipality `json:"municipality"`
+	UF           catalog.UF           `json:"uf"`
+}
+
+// SearchCatalog busca códigos em um catálogo para autocomplete
+// @Summary Buscar no catálogo
+// @Description Busca códigos da lista de serviços da LC 116/2003 (lc116) ou da CNAE (cnae). Consultas numéricas buscam pelo prefixo do código; as demais, por palavras da descrição (sem diferenciar acentos).
+// @Tags catalog
+// @Produce json
+// @Param catalog path string true "Catálogo: 'lc116' ou 'cnae'"
+// @Param q query string false "Código ou palavras da descrição"
+// @Param limit query int false "Quantidade máxima de resultados (padrão: 20, máximo: 100)"
+// @Success 200 {object} CatalogSearchResponse "Códigos encontrados"
+// @Failure 401 {object} SwaggerError "Token inválido"
+// @Failure 404 {object} SwaggerError "Catálogo não encontrado"
+// @Failure 503 {object} SwaggerError "Catálogos indisponíveis"
+// @Security BearerAuth
+// @Router /catalog/{catalog} [get]
+func (h *CatalogHandler) SearchCatalog(c *fiber.Ctx) error {
+	selected, err := h.catalog(c)
+	if selected == nil {
+		return err
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	return c.JSON(CatalogSearchResponse{
+		Catalog: selected.Name,
+		Version: selected.Version,
+		Entries: selected.Search(c.Query("q"), limit),
+	})
+}
+
+// GetCatalogEntry obtém um código do catálogo
+// @Summary Obter código do catálogo
+// @Description Retorna a descrição de um código da LC 116/2003 ou da CNAE e seus códigos filhos. Códigos CNAE que não constam do catálogo são resolvidos pelo nível superior mais específico.
+// @Tags catalog
+// @Produce json
+// @Param catalog path string true "Catálogo: 'lc116' ou 'cnae'"
+// @Param code path string true "Código (ex: 1.07, 01.07, 6201501)"
+// @Success 200 {object} CatalogEntryResponse "Código encontrado"
+// @Failure 401 {object} SwaggerError "Token inválido"
+// @Failure 404 {object} SwaggerError "Código não encontrado"
+// @Failure 503 {object} SwaggerError "Catálogos indisponíveis"
+// @Security BearerAuth
+// @Router /catalog/{catalog}/{code} [get]
+func (h *CatalogHandler) GetCatalogEntry(c *fiber.Ctx) error {
+	selected, err := h.catalog(c)
+	if selected == nil {
+		return err
+	}
+
+	entry, ok := selected.Resolve(c.Params("code"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Code not found",
+		})
+	}
+
+	return c.JSON(CatalogEntryResponse{
+		Catalog:  selected.Name,
+		Version:  selected.Version,
+		Entry:    entry,
+		Children: selected.Children(entry.Code),
+	})
+}
+
+// SearchMunicipalities busca municípios da tabela do IBGE pelo nome
+// @Summary Buscar municípios
+// @Description Busca municípios da tabela do IBGE por palavras do nome (sem diferenciar acentos), opcionalmente em uma UF
+// @Tags catalog
+// @Produce json
+// @Param q query string false "Palavras do nome do município"
+// @Param uf query string false "Sigla da UF"
+// @Param limit query int false "Quantidade máxima de resultados (padrão: 20, máximo: 100)"
+// @Success 200 {object} MunicipalitiesResponse "Municípios encontrados"
+// @Failure 401 {object} SwaggerError "Token inválido"
+// @Failure 503 {object} SwaggerError "Catálogos indisponíveis"
+// @Security BearerAuth
+// @Router /catalog/municipalities [get]
+func (h *CatalogHandler) SearchMunicipalities(c *fiber.Ctx) error {
+	if h.catalogs == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Service catalogs unavailable",
+		})
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	return c.JSON(MunicipalitiesResponse{
+		Version:        h.catalogs.Municipalities.Version,
+		Municipalities: h.catalogs.Municipalities.Search(c.Query("q"), c.Query("uf"), limit),
+	})
+}
+
+// GetMunicipality obtém um município pelo código IBGE
+// @Summary Obter município
+// @Description Retorna o nome e a UF de um código IBGE. Códigos válidos ausentes da tabela embutida retornam apenas a UF.
+// @Tags catalog
+// @Produce json
+// @Param code path string true "Código IBGE de 7 dígitos"
+// @Success 200 {object} MunicipalityResponse "Município encontrado"
+// @Failure 401 {object} SwaggerError "Token inválido"
+// @Failure 404 {object} SwaggerError "Código inválido"
+// @Failure 503 {object} SwaggerError "Catálogos indisponíveis"
+// @Security BearerAuth
+// @Router /catalog/municipalities/{code} [get]
+func (h *CatalogHandler) GetMunicipality(c *fiber.Ctx) error {
+	if h.catalogs == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Service catalogs unavailable",
+		})
+	}
+
+	municipality, ok := h.catalogs.Municipalities.Resolve(c.Params("code"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Municipality not found",
+		})
+	}
+	uf, _ := catalog.UFByCode(municipality.Code)
+
+	return c.JSON(MunicipalityResponse{
+		Version:      h.catalogs.Municipalities.Version,
+		Municipality: municipality,
+		UF:           uf,
+	})
+}
+
+// catalog seleciona o catálogo indicado na rota, respondendo com erro quando não existe
+func (h *CatalogHandler) catalog(c *fiber.Ctx) (*catalog.Catalog, error) {
+	if h.catalogs == nil {
+		return nil, c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Service catalogs unavailable",
+		})
+	}
+
+	switch c.Params("catalog") {
+	case h.catalogs.ServiceList.Name:
+		return h.catalogs.ServiceList, nil
+	case h.catalogs.CNAE.Name:
+		return h.catalogs.CNAE, nil
+	}
+
+	return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": "Catalog not found. Use 'lc116' or 'cnae'",
+	})
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"regexp"
 	"strconv"
 
@@ -8,14 +10,21 @@ import (
 	"github.com/zoomxml/internal/api/middleware"
 	"github.com/zoomxml/internal/database"
 	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/services"
 )
 
 // CompanyHandler gerencia as rotas de empresas
-type CompanyHandler struct{}
+type CompanyHandler struct {
+	retention *services.RetentionPolicies
+	deletion  *services.CompanyDeletion
+}
 
 // NewCompanyHandler cria uma nova instância do handler de empresas
 func NewCompanyHandler() *CompanyHandler {
-	return &CompanyHandler{}
+	return &CompanyHandler{
+		retention: services.NewRetentionPolicies(),
+		deletion:  services.NewCompanyDeletion(),
+	}
 }
 
 // CreateCompanyRequest representa a requisição para criar empresa
@@ -551,11 +560,32 @@ func (h *CompanyHandler) DeleteCompany(c *fiber.Ctx) error {
 		})
 	}
 
-	_, err = database.DB.NewDelete().
-		Model((*models.Company)(nil)).
-		Where("id = ?", id).
-		Exec(c.Context())
+	// Os documentos da empresa são removidos junto com ela, então valem as mesmas regras de retenção
+	retainedUntil, err := h.retention.CheckCompanyDeletable(c.Context(), id)
+	if errors.Is(err, services.ErrLegalHold) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Company has documents under legal hold",
+		})
+	}
+	if errors.Is(err, services.ErrUnderRetention) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":          "Company has documents under retention",
+			"retained_until": retainedUntil,
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check document retention",
+		})
+	}
 
+	// Os XMLs da empresa são removidos do storage junto com a sua chave de dados
+	err = h.deletion.Delete(c.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Company not found",
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete company",
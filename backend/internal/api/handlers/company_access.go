@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/api/middleware"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/permissions"
+)
+
+// companyAccess valida a empresa da rota e o acesso do usuário, respondendo com erro quando negado
+func companyAccess(c *fiber.Ctx) (int64, *models.User, error) {
+	companyID, err := strconv.ParseInt(c.Params("company_id"), 10, 64)
+	if err != nil {
+		return 0, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid company ID",
+		})
+	}
+
+	// Obter usuário do contexto
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		return 0, nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	// Verificar permissões
+	err = permissions.CanAccessCompany(c.Context(), user, companyID)
+	if err != nil {
+		if err == permissions.ErrCompanyNotFound {
+			return 0, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Company not found",
+			})
+		}
+		if err == permissions.ErrAccessDenied {
+			return 0, nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied to this company",
+			})
+		}
+		return 0, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to validate permissions",
+		})
+	}
+
+	return companyID, user, nil
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"github.com/zoomxml/internal/api/middleware"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/permissions"
+)
+
+// CounterpartyHandler gerencia o cadastro de contrapartes (clientes e fornecedores) das empresas
+type CounterpartyHandler struct{}
+
+// NewCounterpartyHandler cria uma nova instância do handler de contrapartes
+func NewCounterpartyHandler() *CounterpartyHandler {
+	return &CounterpartyHandler{}
+}
+
+// Counterparty representa um participante com o faturamento e os gastos da empresa com ele
+type Counterparty struct {
+	models.Party `bun:",extend"`
+
+	Revenue          decimal.Decimal `bun:"revenue" json:"revenue"`                     // Notas emitidas pela empresa para o participante (cliente)
+	RevenueDocuments int64           `bun:"revenue_documents" json:"revenue_documents"` // Quantidade de notas emitidas para o participante
+	Spend            decimal.Decimal `bun:"spend" json:"spend"`                         // Notas emitidas pelo participante para a empresa (fornecedor)
+	SpendDocuments   int64           `bun:"spend_documents" json:"spend_documents"`     // Quantidade de notas recebidas do participante
+}
+
+// CounterpartiesResponse representa a resposta da listagem de contrapartes
+type CounterpartiesResponse struct {
+	Counterparties []Counterparty `json:"counterparties"`
+	Pagination     struct {
+		Page       int `json:"page"`
+		Limit      int `json:"limit"`
+		Total      int `json:"total"`
+		TotalPages int `json:"total_pages"`
+	} `json:"pagination"`
+}
+
+// Expressões de agregação; o CNPJ da empresa define o papel do participante em cada nota
+const (
+	counterpartyIssuedByCompany = "d.taker_party_id = pt.id AND regexp_replace(d.provider_cnpj, '\\D', '', 'g') = ?"
+	counterpartyIssuedToCompany = "d.provider_party_id = pt.id AND regexp_replace(d.taker_cnpj, '\\D', '', 'g') = ?"
+)
+
+var counterpartyDigits = regexp.MustCompile(`\D`)
+
+// GetCounterparties lista os clientes e fornecedores de uma empresa
+// @Summary Listar contrapartes da empresa
+// @Description Lista os participantes (prestadores, tomadores, emitentes e destinatários) vistos nos documentos da empresa, com faturamento por cliente e gasto por fornecedor. Notas canceladas não entram nos totais.
+// @Tags companies
+// @Produce json
+// @Param company_id path int true "ID da empresa"
+// @Param page query int false "Página (padrão: 1)"
+// @Param limit query int false "Itens por página (padrão: 50)"
+// @Param role query string false "Filtrar por papel: 'client' (clientes) ou 'supplier' (fornecedores)"
+// @Param search query string false "Filtrar por nome ou CNPJ/CPF"
+// @Param start_date query string false "Data inicial de emissão dos documentos (YYYY-MM-DD)"
+// @Param end_date query string false "Data final de emissão dos documentos (YYYY-MM-DD)"
+// @Success 200 {object} CounterpartiesResponse "Contrapartes encontradas"
+// @Failure 400 {object} fiber.Map "Parâmetros inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Empresa não encontrada"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /companies/{company_id}/counterparties [get]
+func (h *CounterpartyHandler) GetCounterparties(c *fiber.Ctx) error {
+	companyID, err := strconv.ParseInt(c.Params("company_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid company ID",
+		})
+	}
+
+	// Obter usuário do contexto
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	// Verificar permissões
+	err = permissions.CanAccessCompany(c.Context(), user, companyID)
+	if err != nil {
+		if err == permissions.ErrCompanyNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Company not found",
+			})
+		}
+		if err == permissions.ErrAccessDenied {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied to this company",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to validate permissions",
+		})
+	}
+
+	company := &models.Company{}
+	err = database.DB.NewSelect().
+		Model(company).
+		Column("id", "cnpj").
+		Where("id = ?", companyID).
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Company not found",
+		})
+	}
+	companyCNPJ := counterpartyDigits.ReplaceAllString(company.CNPJ, "")
+
+	// Parse pagination parameters
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 50)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	role := c.Query("role")
+	if role != "" && role != "client" && role != "supplier" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role parameter. Use 'client' or 'supplier'",
+		})
+	}
+
+	// Documentos considerados nos totais
+	join := "LEFT JOIN documents AS d ON d.company_id = pt.company_id AND (d.provider_party_id = pt.id OR d.taker_party_id = pt.id) AND d.is_cancelled = false"
+	joinArgs := make([]any, 0, 2)
+	if startDate := c.Query("start_date"); startDate != "" {
+		date, err := time.Parse("2006-01-02", startDate)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid start_date format. Use YYYY-MM-DD",
+			})
+		}
+		join += " AND d.issue_date >= ?"
+		joinArgs = append(joinArgs, date)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		date, err := time.Parse("2006-01-02", endDate)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid end_date format. Use YYYY-MM-DD",
+			})
+		}
+		join += " AND d.issue_date < ?"
+		joinArgs = append(joinArgs, date.AddDate(0, 0, 1))
+	}
+
+	counterparties := make([]Counterparty, 0)
+	query := database.DB.NewSelect().
+		Model(&counterparties).
+		ColumnExpr("pt.*").
+		ColumnExpr("COALESCE(SUM(d.service_value) FILTER (WHERE "+counterpartyIssuedByCompany+"), 0) AS revenue", companyCNPJ).
+		ColumnExpr("COUNT(d.id) FILTER (WHERE "+counterpartyIssuedByCompany+") AS revenue_documents", companyCNPJ).
+		ColumnExpr("COALESCE(SUM(d.service_value) FILTER (WHERE "+counterpartyIssuedToCompany+"), 0) AS spend", companyCNPJ).
+		ColumnExpr("COUNT(d.id) FILTER (WHERE "+counterpartyIssuedToCompany+") AS spend_documents", companyCNPJ).
+		Join(join, joinArgs...).
+		Where("pt.company_id = ?", companyID).
+		Group("pt.id")
+
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		taxID := counterpartyDigits.ReplaceAllString(search, "")
+		if taxID != "" {
+			query = query.Where("(pt.name ILIKE ? OR pt.trade_name ILIKE ? OR pt.tax_id LIKE ?)", "%"+search+"%", "%"+search+"%", taxID+"%")
+		} else {
+			query = query.Where("(pt.name ILIKE ? OR pt.trade_name ILIKE ?)", "%"+search+"%", "%"+search+"%")
+		}
+	}
+
+	switch role {
+	case "client":
+		query = query.Having("COUNT(d.id) FILTER (WHERE "+counterpartyIssuedByCompany+") > 0", companyCNPJ)
+	case "supplier":
+		query = query.Having("COUNT(d.id) FILTER (WHERE "+counterpartyIssuedToCompany+") > 0", companyCNPJ)
+	}
+
+	// Count total counterparties
+	total, err := query.Count(c.Context())
+	if err != nil {
+		logger.ErrorWithFields("Failed to count counterparties", err, map[string]any{
+			"operation":  "get_counterparties",
+			"company_id": companyID,
+			"user_id":    user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count counterparties",
+		})
+	}
+
+	err = query.
+		Order("revenue DESC", "spend DESC", "pt.name ASC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Scan(c.Context())
+	if err != nil {
+		logger.ErrorWithFields("Failed to fetch counterparties", err, map[string]any{
+			"operation":  "get_counterparties",
+			"company_id": companyID,
+			"user_id":    user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch counterparties",
+		})
+	}
+
+	response := CounterpartiesResponse{
+		Counterparties: counterparties,
+	}
+	response.Pagination.Page = page
+	response.Pagination.Limit = limit
+	response.Pagination.Total = total
+	response.Pagination.TotalPages = (total + limit - 1) / limit
+
+	return c.JSON(response)
+}
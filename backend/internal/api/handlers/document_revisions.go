@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/services"
+)
+
+// DocumentRevisionDiffResponse representa a comparação entre duas revisões de um documento
+type DocumentRevisionDiffResponse struct {
+	DocumentID int64                     `json:"document_id"`
+	From       int                       `json:"from"`
+	To         int                       `json:"to"`
+	Changes    []services.RevisionChange `json:"changes"`
+}
+
+// GetDocumentRevisions lista as revisões de um documento
+// @Summary Listar revisões do documento
+// @Description Lista as versões recebidas de um documento cujo conteúdo mudou (cancelamento, substituição, correções), respeitando permissões de acesso
+// @Tags documents
+// @Produce json
+// @Param id path int true "ID do documento"
+// @Success 200 {array} models.DocumentRevision "Revisões do documento"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Documento não encontrado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /documents/{id}/revisions [get]
+func (h *DocumentHandler) GetDocumentRevisions(c *fiber.Ctx) error {
+	document, err := h.visibleDocument(c)
+	if document == nil {
+		return err
+	}
+
+	revisions := make([]models.DocumentRevision, 0)
+	err = database.DB.NewSelect().
+		Model(&revisions).
+		Where("document_id = ?", document.ID).
+		Order("revision ASC").
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch document revisions",
+		})
+	}
+
+	return c.JSON(revisions)
+}
+
+// GetDocumentRevisionDiff compara duas revisões de um documento
+// @Summary Comparar revisões do documento
+// @Description Mostra os campos que diferem entre duas revisões de um documento. Sem parâmetros, compara a última revisão com a anterior
+// @Tags documents
+// @Produce json
+// @Param id path int true "ID do documento"
+// @Param from query int false "Revisão de origem (padrão: anterior à de destino)"
+// @Param to query int false "Revisão de destino (padrão: última)"
+// @Success 200 {object} DocumentRevisionDiffResponse "Diferenças entre as revisões"
+// @Failure 400 {object} fiber.Map "Parâmetros inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Documento ou revisão não encontrados"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /documents/{id}/revisions/diff [get]
+func (h *DocumentHandler) GetDocumentRevisionDiff(c *fiber.Ctx) error {
+	document, err := h.visibleDocument(c)
+	if document == nil {
+		return err
+	}
+
+	revisions := make([]models.DocumentRevision, 0)
+	err = database.DB.NewSelect().
+		Model(&revisions).
+		Where("document_id = ?", document.ID).
+		Order("revision ASC").
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch document revisions",
+		})
+	}
+	if len(revisions) < 2 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Document has no revisions to compare",
+		})
+	}
+
+	to := c.QueryInt("to", revisions[len(revisions)-1].Revision)
+	from := c.QueryInt("from", to-1)
+	if from == to {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "from and to must be different revisions",
+		})
+	}
+
+	byNumber := make(map[int]*models.DocumentRevision, len(revisions))
+	for i := range revisions {
+		byNumber[revisions[i].Revision] = &revisions[i]
+	}
+
+	fromRevision, ok := byNumber[from]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Revision not found",
+		})
+	}
+	toRevision, ok := byNumber[to]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Revision not found",
+		})
+	}
+
+	return c.JSON(DocumentRevisionDiffResponse{
+		DocumentID: document.ID,
+		From:       from,
+		To:         to,
+		Changes:    services.DiffRevisions(fromRevision, toRevision),
+	})
+}
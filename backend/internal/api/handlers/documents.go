@@ -1,21 +1,32 @@
 package handlers
 
 import (
+	"errors"
+	"path"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/zoomxml/internal/api/middleware"
 	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
 	"github.com/zoomxml/internal/models"
 	"github.com/zoomxml/internal/permissions"
+	"github.com/zoomxml/internal/services"
+	"github.com/zoomxml/internal/storage"
 )
 
 // DocumentHandler gerencia as operações de documentos
-type DocumentHandler struct{}
+type DocumentHandler struct {
+	files     *services.DocumentFiles
+	retention *services.RetentionPolicies
+}
 
 // NewDocumentHandler cria uma nova instância do handler de documentos
 func NewDocumentHandler() *DocumentHandler {
-	return &DocumentHandler{}
+	return &DocumentHandler{
+		files:     services.NewDocumentFiles(),
+		retention: services.NewRetentionPolicies(),
+	}
 }
 
 // DocumentsResponse representa a resposta da listagem de documentos
@@ -39,7 +50,19 @@ type DocumentsResponse struct {
 // @Param type query string false "Filtrar por tipo de documento (nfse, nfe, cte)"
 // @Param status query string false "Filtrar por status (pending, processed, error)"
 // @Param company_id query int false "Filtrar por empresa"
+// @Param validation_status query string false "Filtrar por resultado da validação XSD (valid, invalid, skipped)"
+// @Param authenticity query string false "Filtrar por autenticidade da assinatura (valid, invalid, unsigned, unverified)"
+// @Param signer_cnpj query string false "Filtrar por CNPJ do certificado signatário"
+// @Param service_item query string false "Filtrar por subitem da LC 116/2003 (ex: 1.07 ou 01.07)"
+// @Param service_uf query string false "Filtrar pela UF do local da prestação"
+// @Param iss_outside_provider query bool false "Filtrar notas com ISS devido fora do município do prestador"
+// @Param cancelled query bool false "Filtrar notas canceladas (true) ou não canceladas (false)"
+// @Param storage_missing query bool false "Filtrar documentos cujo XML a reconciliação não encontrou no storage"
+// @Param issue_date_from query string false "Data de emissão inicial (YYYY-MM-DD)"
+// @Param issue_date_to query string false "Data de emissão final, inclusive (YYYY-MM-DD)"
+// @Param competence query string false "Competência (YYYY-MM)"
 // @Success 200 {object} DocumentsResponse "Lista de documentos"
+// @Failure 400 {object} fiber.Map "Parâmetros inválidos"
 // @Failure 401 {object} fiber.Map "Token inválido"
 // @Failure 500 {object} fiber.Map "Erro interno"
 // @Security BearerAuth
@@ -66,67 +89,20 @@ func (h *DocumentHandler) GetDocuments(c *fiber.Ctx) error {
 	}
 
 	// Parse filter parameters
-	docType := c.Query("type")
-	status := c.Query("status")
-	companyIDStr := c.Query("company_id")
+	filter, err := documentFilter(c)
+	if filter == nil {
+		return err
+	}
+	if filter.CompanyID != 0 && !filterCompanyAccess(c, user, filter.CompanyID) {
+		return nil
+	}
 
 	// Build query
 	query := database.DB.NewSelect().
 		Model((*models.Document)(nil)).
 		Relation("Company")
-
-	// Apply company visibility rules
-	if !user.IsAdmin() {
-		// Usuário comum - apenas documentos de empresas não restritas + empresas onde é membro
-		query = query.Where(`
-			company_id IN (
-				SELECT c.id FROM companies c
-				WHERE (c.restricted = false AND c.active = true) OR
-				(c.id IN (
-					SELECT cm.company_id FROM company_members cm
-					WHERE cm.user_id = ? AND cm.company_id = c.id
-				))
-			)
-		`, user.ID)
-	}
-
-	// Apply filters
-	if docType != "" {
-		query = query.Where("type = ?", docType)
-	}
-	if status != "" {
-		query = query.Where("status = ?", status)
-	}
-	if companyIDStr != "" {
-		companyID, err := strconv.ParseInt(companyIDStr, 10, 64)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid company_id parameter",
-			})
-		}
-
-		// Verificar se o usuário tem acesso à empresa
-		if !user.IsAdmin() {
-			err := permissions.CanAccessCompany(c.Context(), user, companyID)
-			if err != nil {
-				if err == permissions.ErrCompanyNotFound {
-					return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-						"error": "Company not found",
-					})
-				}
-				if err == permissions.ErrAccessDenied {
-					return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-						"error": "Access denied to this company",
-					})
-				}
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "Failed to check company access",
-				})
-			}
-		}
-
-		query = query.Where("company_id = ?", companyID)
-	}
+	query = services.VisibleDocuments(query, user)
+	query = filter.Apply(query)
 
 	// Count total documents
 	total, err := query.Count(c.Context())
@@ -170,6 +146,96 @@ func (h *DocumentHandler) GetDocuments(c *fiber.Ctx) error {
 	return c.JSON(response)
 }
 
+// documentFilter lê os filtros da listagem de documentos da query string
+func documentFilter(c *fiber.Ctx) (*services.DocumentFilter, error) {
+	filter := &services.DocumentFilter{
+		Type:             c.Query("type"),
+		Status:           c.Query("status"),
+		ValidationStatus: c.Query("validation_status"),
+		Authenticity:     c.Query("authenticity"),
+		SignerCNPJ:       c.Query("signer_cnpj"),
+		ServiceItem:      c.Query("service_item"),
+		ServiceUF:        c.Query("service_uf"),
+		IssueDateFrom:    c.Query("issue_date_from"),
+		IssueDateTo:      c.Query("issue_date_to"),
+		Competence:       c.Query("competence"),
+	}
+
+	if companyIDStr := c.Query("company_id"); companyIDStr != "" {
+		companyID, err := strconv.ParseInt(companyIDStr, 10, 64)
+		if err != nil {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid company_id parameter",
+			})
+		}
+		filter.CompanyID = companyID
+	}
+
+	if issOutsideProvider := c.Query("iss_outside_provider"); issOutsideProvider != "" {
+		outside, err := strconv.ParseBool(issOutsideProvider)
+		if err != nil {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid iss_outside_provider parameter",
+			})
+		}
+		filter.IssOutsideProvider = &outside
+	}
+
+	if cancelledStr := c.Query("cancelled"); cancelledStr != "" {
+		cancelled, err := strconv.ParseBool(cancelledStr)
+		if err != nil {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid cancelled parameter",
+			})
+		}
+		filter.Cancelled = &cancelled
+	}
+
+	if storageMissingStr := c.Query("storage_missing"); storageMissingStr != "" {
+		storageMissing, err := strconv.ParseBool(storageMissingStr)
+		if err != nil {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid storage_missing parameter",
+			})
+		}
+		filter.StorageMissing = &storageMissing
+	}
+
+	if err := filter.Validate(); err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return filter, nil
+}
+
+// filterCompanyAccess verifica se o usuário pode filtrar documentos da empresa, respondendo a requisição quando não pode
+func filterCompanyAccess(c *fiber.Ctx, user *models.User, companyID int64) bool {
+	if user.IsAdmin() {
+		return true
+	}
+
+	err := permissions.CanAccessCompany(c.Context(), user, companyID)
+	switch {
+	case err == nil:
+		return true
+	case err == permissions.ErrCompanyNotFound:
+		c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Company not found",
+		})
+	case err == permissions.ErrAccessDenied:
+		c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied to this company",
+		})
+	default:
+		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check company access",
+		})
+	}
+	return false
+}
+
 // GetDocument obtém um documento específico
 // @Summary Obter documento
 // @Description Obtém um documento específico por ID, respeitando permissões de acesso
@@ -231,9 +297,59 @@ func (h *DocumentHandler) GetDocument(c *fiber.Ctx) error {
 	return c.JSON(document)
 }
 
+// GetDocumentXML baixa o XML original de um documento
+// @Summary Baixar XML do documento
+// @Description Envia o arquivo XML armazenado do documento, respeitando permissões de acesso. Documentos com revisões enviam o XML da versão mais recente. O conteúdo é conferido com o SHA-256 registrado na ingestão antes do envio.
+// @Tags documents
+// @Produce xml
+// @Param id path int true "ID do documento"
+// @Success 200 {file} file "XML do documento"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Documento ou XML não encontrado"
+// @Failure 500 {object} fiber.Map "Erro interno ou XML corrompido"
+// @Security BearerAuth
+// @Router /documents/{id}/xml [get]
+func (h *DocumentHandler) GetDocumentXML(c *fiber.Ctx) error {
+	document, err := h.visibleDocument(c)
+	if document == nil {
+		return err
+	}
+
+	file, info, err := h.files.Open(c.Context(), document)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Document XML not found",
+			})
+		}
+		if errors.Is(err, services.ErrIntegrityMismatch) {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Stored document XML failed integrity verification",
+			})
+		}
+		logger.ErrorWithFields("Failed to open document XML", err, map[string]any{
+			"operation":   "get_document_xml",
+			"document_id": document.ID,
+			"storage_key": document.StorageKey,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch document XML",
+		})
+	}
+
+	contentType := info.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = "application/xml"
+	}
+
+	c.Attachment(path.Base(document.StorageKey))
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.SendStream(file, int(info.Size))
+}
+
 // DeleteDocument remove um documento
 // @Summary Remover documento
-// @Description Remove um documento específico (apenas admin ou membro da empresa)
+// @Description Remove um documento específico (apenas admin ou membro da empresa). Documentos dentro do prazo de retenção ou em retenção legal (legal hold) não podem ser removidos.
 // @Tags documents
 // @Produce json
 // @Param id path int true "ID do documento"
@@ -241,6 +357,7 @@ func (h *DocumentHandler) GetDocument(c *fiber.Ctx) error {
 // @Failure 401 {object} fiber.Map "Token inválido"
 // @Failure 403 {object} fiber.Map "Acesso negado"
 // @Failure 404 {object} fiber.Map "Documento não encontrado"
+// @Failure 409 {object} fiber.Map "Documento em retenção ou em legal hold"
 // @Failure 500 {object} fiber.Map "Erro interno"
 // @Security BearerAuth
 // @Router /documents/{id} [delete]
@@ -294,13 +411,30 @@ func (h *DocumentHandler) DeleteDocument(c *fiber.Ctx) error {
 		}
 	}
 
-	// Delete document
-	_, err = database.DB.NewDelete().
-		Model(&document).
-		Where("id = ?", documentID).
-		Exec(c.Context())
+	// Check retention period and legal hold
+	retention, err := h.retention.CheckDeletable(c.Context(), &document)
+	if err != nil && !errors.Is(err, services.ErrLegalHold) && !errors.Is(err, services.ErrUnderRetention) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check document retention",
+		})
+	}
+	if err != nil {
+		return retentionConflict(c, err, retention)
+	}
 
+	// Delete document with its stored XML
+	err = h.files.Delete(c.Context(), &document)
+	if errors.Is(err, services.ErrLegalHold) {
+		retention.LegalHold = true
+		retention.Deletable = false
+		return retentionConflict(c, err, retention)
+	}
 	if err != nil {
+		logger.ErrorWithFields("Failed to delete document", err, map[string]any{
+			"operation":   "delete_document",
+			"document_id": documentID,
+			"user_id":     user.ID,
+		})
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete document",
 		})
@@ -310,3 +444,247 @@ func (h *DocumentHandler) DeleteDocument(c *fiber.Ctx) error {
 		"message": "Document deleted successfully",
 	})
 }
+
+// DocumentItemsResponse representa a resposta da busca de itens de documentos
+type DocumentItemsResponse struct {
+	Items      []models.DocumentItem `json:"items"`
+	Pagination struct {
+		Page       int `json:"page"`
+		Limit      int `json:"limit"`
+		Total      int `json:"total"`
+		TotalPages int `json:"total_pages"`
+	} `json:"pagination"`
+}
+
+// GetDocumentItems lista os itens de um documento
+// @Summary Listar itens do documento
+// @Description Lista os itens (det) de uma NF-e com produto, NCM, CFOP e tributos, respeitando permissões de acesso
+// @Tags documents
+// @Produce json
+// @Param id path int true "ID do documento"
+// @Success 200 {array} models.DocumentItem "Itens do documento"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Documento não encontrado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /documents/{id}/items [get]
+func (h *DocumentHandler) GetDocumentItems(c *fiber.Ctx) error {
+	// Obter usuário do contexto
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	// Parse document ID
+	documentID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	// Verificar se o documento existe e é visível para o usuário
+	query := database.DB.NewSelect().
+		Model((*models.Document)(nil)).
+		Where("id = ?", documentID)
+
+	if !user.IsAdmin() {
+		query = query.Where(`
+			company_id IN (
+				SELECT c.id FROM companies c
+				WHERE (c.restricted = false AND c.active = true) OR
+				(c.id IN (
+					SELECT cm.company_id FROM company_members cm
+					WHERE cm.user_id = ? AND cm.company_id = c.id
+				))
+			)
+		`, user.ID)
+	}
+
+	exists, err := query.Exists(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch document",
+		})
+	}
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Document not found",
+		})
+	}
+
+	items := make([]models.DocumentItem, 0)
+	err = database.DB.NewSelect().
+		Model(&items).
+		Where("document_id = ?", documentID).
+		Order("item_number ASC").
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch document items",
+		})
+	}
+
+	return c.JSON(items)
+}
+
+// SearchDocumentItems busca itens de documentos por NCM, CFOP ou código do produto
+// @Summary Buscar itens de documentos
+// @Description Busca itens de NF-e de todas as empresas visíveis ao usuário, com paginação e filtros
+// @Tags documents
+// @Produce json
+// @Param page query int false "Página (padrão: 1)"
+// @Param limit query int false "Itens por página (padrão: 50)"
+// @Param company_id query int false "Filtrar por empresa"
+// @Param ncm query string false "Filtrar por NCM (aceita prefixo, ex: 8471)"
+// @Param cfop query string false "Filtrar por CFOP"
+// @Param product_code query string false "Filtrar por código do produto (cProd)"
+// @Param description query string false "Filtrar por descrição do produto (contém)"
+// @Success 200 {object} DocumentItemsResponse "Itens encontrados"
+// @Failure 400 {object} fiber.Map "Parâmetros inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /documents/items [get]
+func (h *DocumentHandler) SearchDocumentItems(c *fiber.Ctx) error {
+	// Obter usuário do contexto
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	// Parse pagination parameters
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 50)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	// Parse filter parameters
+	ncm := c.Query("ncm")
+	cfop := c.Query("cfop")
+	productCode := c.Query("product_code")
+	description := c.Query("description")
+	companyIDStr := c.Query("company_id")
+
+	query := database.DB.NewSelect().
+		Model((*models.DocumentItem)(nil))
+
+	// Apply company visibility rules
+	if !user.IsAdmin() {
+		query = query.Where(`
+			di.company_id IN (
+				SELECT c.id FROM companies c
+				WHERE (c.restricted = false AND c.active = true) OR
+				(c.id IN (
+					SELECT cm.company_id FROM company_members cm
+					WHERE cm.user_id = ? AND cm.company_id = c.id
+				))
+			)
+		`, user.ID)
+	}
+
+	// Apply filters
+	if ncm != "" {
+		query = query.Where("di.ncm LIKE ?", ncm+"%")
+	}
+	if cfop != "" {
+		query = query.Where("di.cfop = ?", cfop)
+	}
+	if productCode != "" {
+		query = query.Where("di.product_code = ?", productCode)
+	}
+	if description != "" {
+		query = query.Where("di.description ILIKE ?", "%"+description+"%")
+	}
+	if companyIDStr != "" {
+		companyID, err := strconv.ParseInt(companyIDStr, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid company_id parameter",
+			})
+		}
+		query = query.Where("di.company_id = ?", companyID)
+	}
+
+	// Count total items
+	total, err := query.Count(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count document items",
+		})
+	}
+
+	items := make([]models.DocumentItem, 0)
+	err = query.
+		Order("di.document_id DESC", "di.item_number ASC").
+		Limit(limit).
+		Offset((page-1)*limit).
+		Scan(c.Context(), &items)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch document items",
+		})
+	}
+
+	response := DocumentItemsResponse{
+		Items: items,
+	}
+	response.Pagination.Page = page
+	response.Pagination.Limit = limit
+	response.Pagination.Total = total
+	response.Pagination.TotalPages = (total + limit - 1) / limit
+
+	return c.JSON(response)
+}
+
+// visibleDocument carrega o documento da rota quando ele é visível para o usuário, respondendo com erro caso contrário
+func (h *DocumentHandler) visibleDocument(c *fiber.Ctx) (*models.Document, error) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		return nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	documentID, err := c.ParamsInt("id")
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	document := &models.Document{}
+	query := database.DB.NewSelect().
+		Model(document).
+		Where("id = ?", documentID)
+
+	if !user.IsAdmin() {
+		query = query.Where(`
+			company_id IN (
+				SELECT c.id FROM companies c
+				WHERE (c.restricted = false AND c.active = true) OR
+				(c.id IN (
+					SELECT cm.company_id FROM company_members cm
+					WHERE cm.user_id = ? AND cm.company_id = c.id
+				))
+			)
+		`, user.ID)
+	}
+
+	err = query.Scan(c.Context())
+	if err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Document not found",
+		})
+	}
+
+	return document, nil
+}
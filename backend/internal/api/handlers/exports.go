@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/api/middleware"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/services"
+	"github.com/zoomxml/internal/storage"
+)
+
+// ExportHandler gerencia a exportação dos XMLs de documentos em ZIP
+type ExportHandler struct {
+	exporter *services.DocumentExporter
+}
+
+// NewExportHandler cria uma nova instância do handler de exportações
+func NewExportHandler() *ExportHandler {
+	return &ExportHandler{
+		exporter: services.NewDocumentExporter(),
+	}
+}
+
+// ExportRequest representa os filtros de uma exportação, os mesmos da listagem de documentos
+type ExportRequest struct {
+	services.DocumentFilter
+	Async bool `json:"async,omitempty"` // Gera em segundo plano mesmo abaixo de EXPORT_SYNC_LIMIT
+}
+
+// CreateExport exporta os XMLs dos documentos filtrados em um ZIP
+// @Summary Exportar documentos
+// @Description Gera um ZIP com os XMLs dos documentos que atendem aos filtros (os mesmos de GET /documents), organizados em pastas tipo/ano/competência/CNPJ, com um manifest.csv indicando documentos ausentes ou corrompidos no storage. Até EXPORT_SYNC_LIMIT documentos o ZIP é enviado na resposta; acima disso, ou com async, a exportação roda em segundo plano e o ZIP é baixado em /exports/{id}/download.
+// @Tags exports
+// @Accept json
+// @Produce application/zip
+// @Produce json
+// @Param request body ExportRequest true "Filtros da exportação"
+// @Success 200 {file} file "ZIP com os XMLs e o manifesto"
+// @Success 202 {object} models.ExportJob "Exportação em segundo plano"
+// @Failure 400 {object} fiber.Map "Filtros inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Nenhum documento encontrado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /exports [post]
+func (h *ExportHandler) CreateExport(c *fiber.Ctx) error {
+	// Obter usuário do contexto
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	var req ExportRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	if err := req.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if req.CompanyID != 0 && !filterCompanyAccess(c, user, req.CompanyID) {
+		return nil
+	}
+
+	total, err := h.exporter.Count(c.Context(), &req.DocumentFilter, user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count documents",
+		})
+	}
+	if total == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No documents match the filters",
+		})
+	}
+
+	if req.Async || total > h.exporter.SyncLimit() {
+		job, err := h.exporter.CreateJob(c.Context(), &req.DocumentFilter, user)
+		if err != nil {
+			logger.ErrorWithFields("Failed to create export job", err, map[string]any{
+				"operation": "export_documents",
+				"user_id":   user.ID,
+			})
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create export",
+			})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(job)
+	}
+
+	// O ZIP é escrito depois que o handler retorna, então usa cópias do filtro e do usuário
+	filter := req.DocumentFilter
+	exportUser := *user
+	c.Attachment(services.ExportFileName(time.Now()))
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		startTime := time.Now()
+		result, err := h.exporter.WriteZip(context.Background(), w, &filter, &exportUser)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			// O status já foi enviado; o cliente recebe um ZIP truncado
+			logger.ErrorWithFields("Failed to stream export", err, map[string]any{
+				"operation": "export_documents",
+				"user_id":   exportUser.ID,
+			})
+			return
+		}
+
+		logger.InfoWithFields("Streamed export", map[string]any{
+			"operation":  "export_documents",
+			"user_id":    exportUser.ID,
+			"documents":  result.Documents,
+			"missing":    result.Missing,
+			"mismatched": result.Mismatched,
+			"elapsed_ms": time.Since(startTime).Milliseconds(),
+		})
+	})
+
+	return nil
+}
+
+// GetExports lista as exportações em segundo plano
+// @Summary Listar exportações
+// @Description Lista as exportações em segundo plano do usuário, mais recentes primeiro (admins veem todas)
+// @Tags exports
+// @Produce json
+// @Param limit query int false "Quantidade de exportações (padrão: 20, máximo: 100)"
+// @Success 200 {array} models.ExportJob "Exportações"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /exports [get]
+func (h *ExportHandler) GetExports(c *fiber.Ctx) error {
+	// Obter usuário do contexto
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	jobs := make([]models.ExportJob, 0)
+	query := database.DB.NewSelect().
+		Model(&jobs).
+		Order("created_at DESC").
+		Limit(limit)
+	if !user.IsAdmin() {
+		query = query.Where("user_id = ?", user.ID)
+	}
+	if err := query.Scan(c.Context()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch exports",
+		})
+	}
+
+	return c.JSON(jobs)
+}
+
+// GetExport retorna uma exportação
+// @Summary Obter exportação
+// @Description Retorna o status e os totais de uma exportação em segundo plano
+// @Tags exports
+// @Produce json
+// @Param id path int true "ID da exportação"
+// @Success 200 {object} models.ExportJob "Exportação"
+// @Failure 400 {object} fiber.Map "ID inválido"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Exportação não encontrada"
+// @Security BearerAuth
+// @Router /exports/{id} [get]
+func (h *ExportHandler) GetExport(c *fiber.Ctx) error {
+	job, err := h.exportJob(c)
+	if job == nil {
+		return err
+	}
+
+	return c.JSON(job)
+}
+
+// DownloadExport baixa o ZIP de uma exportação
+// @Summary Baixar exportação
+// @Description Baixa o ZIP de uma exportação em segundo plano concluída
+// @Tags exports
+// @Produce application/zip
+// @Param id path int true "ID da exportação"
+// @Success 200 {file} file "ZIP com os XMLs e o manifesto"
+// @Failure 400 {object} fiber.Map "ID inválido"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Exportação não encontrada"
+// @Failure 409 {object} fiber.Map "Exportação não concluída"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /exports/{id}/download [get]
+func (h *ExportHandler) DownloadExport(c *fiber.Ctx) error {
+	job, err := h.exportJob(c)
+	if job == nil {
+		return err
+	}
+
+	if job.Status != services.ExportStatusCompleted {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  "Export is not completed",
+			"status": job.Status,
+		})
+	}
+
+	file, info, err := h.exporter.Open(c.Context(), job)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Export file not found",
+			})
+		}
+		logger.ErrorWithFields("Failed to open export", err, map[string]any{
+			"operation":   "download_export",
+			"job_id":      job.ID,
+			"storage_key": job.StorageKey,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch export",
+		})
+	}
+
+	c.Attachment(job.FileName)
+	c.Set(fiber.HeaderContentType, "application/zip")
+	return c.SendStream(file, int(info.Size))
+}
+
+// DeleteExport remove uma exportação
+// @Summary Remover exportação
+// @Description Remove uma exportação em segundo plano e o seu ZIP do storage. Exportações em andamento não podem ser removidas.
+// @Tags exports
+// @Produce json
+// @Param id path int true "ID da exportação"
+// @Success 200 {object} fiber.Map "Exportação removida com sucesso"
+// @Failure 400 {object} fiber.Map "ID inválido"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Exportação não encontrada"
+// @Failure 409 {object} fiber.Map "Exportação em andamento"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /exports/{id} [delete]
+func (h *ExportHandler) DeleteExport(c *fiber.Ctx) error {
+	job, err := h.exportJob(c)
+	if job == nil {
+		return err
+	}
+
+	if job.Status == services.ExportStatusPending || job.Status == services.ExportStatusRunning {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Export is still running",
+		})
+	}
+
+	if err := h.exporter.DeleteJob(c.Context(), job); err != nil {
+		logger.ErrorWithFields("Failed to delete export", err, map[string]any{
+			"operation": "delete_export",
+			"job_id":    job.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete export",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Export deleted successfully",
+	})
+}
+
+// exportJob carrega a exportação da rota, visível apenas para quem a criou e para admins
+func (h *ExportHandler) exportJob(c *fiber.Ctx) (*models.ExportJob, error) {
+	// Obter usuário do contexto
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		return nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	jobID, err := c.ParamsInt("id")
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid export ID",
+		})
+	}
+
+	var job models.ExportJob
+	query := database.DB.NewSelect().
+		Model(&job).
+		Where("id = ?", jobID)
+	if !user.IsAdmin() {
+		query = query.Where("user_id = ?", user.ID)
+	}
+	if err := query.Scan(c.Context()); err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Export not found",
+		})
+	}
+
+	return &job, nil
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/services"
+	"github.com/zoomxml/internal/storage"
+)
+
+// signedFileBucket é o único bucket servido por links assinados: o dos XMLs de documentos e dos ZIPs de exportação
+const signedFileBucket = "nfse-storage"
+
+// FileHandler serve os arquivos dos links de download assinados pela API
+type FileHandler struct {
+	signer *storage.URLSigner
+}
+
+// NewFileHandler cria uma nova instância do handler de arquivos
+func NewFileHandler() *FileHandler {
+	return &FileHandler{
+		signer: storage.NewURLSigner(),
+	}
+}
+
+// GetSignedFile baixa um arquivo por um link assinado
+// @Summary Baixar arquivo por link assinado
+// @Description Baixa um XML ou ZIP por um link gerado em /documents/{id}/links ou /exports/{id}/links, sem token. Usado pelos drivers de storage sem URLs pré-assinadas próprias (filesystem e memória) e por objetos comprimidos.
+// @Tags files
+// @Produce application/octet-stream
+// @Param bucket path string true "Bucket"
+// @Param object path string true "Nome do objeto"
+// @Param expires query int true "Expiração do link (Unix)"
+// @Param filename query string false "Nome do arquivo baixado"
+// @Param signature query string true "Assinatura HMAC-SHA256 do link"
+// @Success 200 {file} file "Arquivo"
+// @Failure 403 {object} fiber.Map "Link inválido ou expirado"
+// @Failure 404 {object} fiber.Map "Arquivo não encontrado"
+// @Failure 500 {object} fiber.Map "Erro interno ou arquivo corrompido"
+// @Failure 503 {object} fiber.Map "Links assinados desativados (sem STORAGE_SIGNING_KEY)"
+// @Router /files/{bucket}/{object} [get]
+func (h *FileHandler) GetSignedFile(c *fiber.Ctx) error {
+	if !h.signer.Enabled() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Download links are disabled",
+		})
+	}
+
+	bucketName, bucketErr := url.PathUnescape(c.Params("bucket"))
+	objectName, objectErr := url.PathUnescape(c.Params("*"))
+	if bucketErr != nil || objectErr != nil || bucketName != signedFileBucket {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Invalid download link",
+		})
+	}
+
+	fileName := c.Query("filename")
+	err := h.signer.Verify(bucketName, objectName, fileName, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		if errors.Is(err, storage.ErrSignedURLExpired) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Download link expired",
+			})
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Invalid download link",
+		})
+	}
+
+	// Só arquivos registrados por documentos, revisões ou exportações são servidos, conferidos com o hash gravado
+	file, info, err := services.OpenSignedFile(c.Context(), objectName)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "File not found",
+			})
+		}
+		if errors.Is(err, services.ErrIntegrityMismatch) {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Stored file failed integrity verification",
+			})
+		}
+		logger.ErrorWithFields("Failed to open signed file", err, map[string]any{
+			"operation":   "get_signed_file",
+			"storage_key": objectName,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch file",
+		})
+	}
+
+	if fileName != "" {
+		c.Attachment(fileName)
+	}
+	c.Set(fiber.HeaderContentType, info.ContentType)
+	return c.SendStream(file, int(info.Size))
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/api/middleware"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/services"
+	"github.com/zoomxml/internal/storage"
+)
+
+// Validade padrão dos links de download, em segundos
+const defaultLinkExpiry = 3600
+
+// LinkRequest representa a validade desejada de um link de download
+type LinkRequest struct {
+	ExpiresIn int `json:"expires_in,omitempty" example:"3600"` // Segundos até o link expirar (padrão: 3600, máximo: 604800)
+}
+
+// LinkResponse representa um link de download que dispensa autenticação
+type LinkResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateDocumentLink gera um link de download do XML de um documento
+// @Summary Gerar link do XML
+// @Description Gera um link pré-assinado e com validade para baixar o XML do documento sem token, para compartilhar com clientes. Cada link gerado é registrado na auditoria.
+// @Tags documents
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do documento"
+// @Param request body LinkRequest false "Validade do link"
+// @Success 201 {object} LinkResponse "Link gerado"
+// @Failure 400 {object} fiber.Map "Validade inválida"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Documento ou XML não encontrado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Failure 503 {object} fiber.Map "Links assinados desativados (sem STORAGE_SIGNING_KEY)"
+// @Security BearerAuth
+// @Router /documents/{id}/links [post]
+func (h *DocumentHandler) CreateDocumentLink(c *fiber.Ctx) error {
+	document, err := h.visibleDocument(c)
+	if document == nil {
+		return err
+	}
+
+	expiry, err := linkExpiry(c)
+	if expiry == 0 {
+		return err
+	}
+
+	link, err := h.files.PresignedURL(c.Context(), document, expiry)
+	if err != nil {
+		if errors.Is(err, storage.ErrSigningDisabled) {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "Download links are disabled",
+			})
+		}
+		if errors.Is(err, storage.ErrFileNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Document XML not found",
+			})
+		}
+		logger.ErrorWithFields("Failed to create document link", err, map[string]any{
+			"operation":   "create_document_link",
+			"document_id": document.ID,
+			"storage_key": document.StorageKey,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create document link",
+		})
+	}
+
+	return sharedLink(c, "Document", document.ID, document.StorageKey, link, expiry)
+}
+
+// CreateExportLink gera um link de download do ZIP de uma exportação
+// @Summary Gerar link da exportação
+// @Description Gera um link pré-assinado e com validade para baixar o ZIP de uma exportação concluída sem token. Cada link gerado é registrado na auditoria.
+// @Tags exports
+// @Accept json
+// @Produce json
+// @Param id path int true "ID da exportação"
+// @Param request body LinkRequest false "Validade do link"
+// @Success 201 {object} LinkResponse "Link gerado"
+// @Failure 400 {object} fiber.Map "Validade inválida"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Exportação não encontrada"
+// @Failure 409 {object} fiber.Map "Exportação não concluída"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Failure 503 {object} fiber.Map "Links assinados desativados (sem STORAGE_SIGNING_KEY)"
+// @Security BearerAuth
+// @Router /exports/{id}/links [post]
+func (h *ExportHandler) CreateExportLink(c *fiber.Ctx) error {
+	job, err := h.exportJob(c)
+	if job == nil {
+		return err
+	}
+
+	if job.Status != services.ExportStatusCompleted {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  "Export is not completed",
+			"status": job.Status,
+		})
+	}
+
+	expiry, err := linkExpiry(c)
+	if expiry == 0 {
+		return err
+	}
+
+	link, err := h.exporter.PresignedURL(c.Context(), job, expiry)
+	if err != nil {
+		if errors.Is(err, storage.ErrSigningDisabled) {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "Download links are disabled",
+			})
+		}
+		if errors.Is(err, storage.ErrFileNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Export file not found",
+			})
+		}
+		logger.ErrorWithFields("Failed to create export link", err, map[string]any{
+			"operation":   "create_export_link",
+			"job_id":      job.ID,
+			"storage_key": job.StorageKey,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create export link",
+		})
+	}
+
+	return sharedLink(c, "ExportJob", job.ID, job.StorageKey, link, expiry)
+}
+
+// linkExpiry lê a validade pedida para um link, respondendo com erro quando inválida
+func linkExpiry(c *fiber.Ctx) (time.Duration, error) {
+	req := LinkRequest{ExpiresIn: defaultLinkExpiry}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return 0, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	expiry := time.Duration(req.ExpiresIn) * time.Second
+	if expiry < time.Second || expiry > storage.MaxPresignExpiry {
+		return 0, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expires_in must be between 1 and 604800 seconds",
+		})
+	}
+
+	return expiry, nil
+}
+
+// sharedLink registra o link gerado na auditoria e o retorna
+func sharedLink(c *fiber.Ctx, entity string, entityID int64, storageKey, link string, expiry time.Duration) error {
+	expiresAt := time.Now().Add(expiry)
+
+	recordAuditLog(c, newAuditLog(c, middleware.GetUserFromContext(c), "SHARE", entity, entityID, map[string]any{
+		"storage_key": storageKey,
+		"expires_in":  int(expiry.Seconds()),
+		"expires_at":  expiresAt,
+	}))
+
+	return c.Status(fiber.StatusCreated).JSON(LinkResponse{
+		URL:       link,
+		ExpiresAt: expiresAt,
+	})
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/services"
+)
+
+// ProcessedFileHandler gerencia o histórico de arquivos XML recebidos pelas empresas
+type ProcessedFileHandler struct{}
+
+// NewProcessedFileHandler cria uma nova instância do handler de arquivos processados
+func NewProcessedFileHandler() *ProcessedFileHandler {
+	return &ProcessedFileHandler{}
+}
+
+// ProcessedFilesResponse representa a resposta da listagem de arquivos processados
+type ProcessedFilesResponse struct {
+	Files      []models.ProcessedFile `json:"files"`
+	Pagination struct {
+		Page       int `json:"page"`
+		Limit      int `json:"limit"`
+		Total      int `json:"total"`
+		TotalPages int `json:"total_pages"`
+	} `json:"pagination"`
+}
+
+// Resultados válidos para o filtro de status
+var processedFileStatuses = map[string]bool{
+	services.ProcessedFileStatusProcessed:   true,
+	services.ProcessedFileStatusDuplicate:   true,
+	services.ProcessedFileStatusRevised:     true,
+	services.ProcessedFileStatusError:       true,
+	services.ProcessedFileStatusQuarantined: true,
+}
+
+// GetProcessedFiles lista o histórico de arquivos processados de uma empresa
+// @Summary Listar arquivos processados da empresa
+// @Description Lista os arquivos XML recebidos pela empresa, identificados pelo SHA-256 do conteúdo, com o resultado do último processamento e o documento gerado
+// @Tags companies
+// @Produce json
+// @Param company_id path int true "ID da empresa"
+// @Param page query int false "Página (padrão: 1)"
+// @Param limit query int false "Itens por página (padrão: 50)"
+// @Param status query string false "Filtrar por resultado: 'processed', 'duplicate', 'revised', 'error' ou 'quarantined'"
+// @Param source query string false "Filtrar por provedor de origem"
+// @Param file_hash query string false "Filtrar pelo SHA-256 do arquivo"
+// @Param file_name query string false "Filtrar por nome do arquivo (contém)"
+// @Success 200 {object} ProcessedFilesResponse "Arquivos processados"
+// @Failure 400 {object} fiber.Map "Parâmetros inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Empresa não encontrada"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /companies/{company_id}/processed-files [get]
+func (h *ProcessedFileHandler) GetProcessedFiles(c *fiber.Ctx) error {
+	companyID, user, err := companyAccess(c)
+	if user == nil {
+		return err
+	}
+
+	// Parse pagination parameters
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 50)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	files := make([]models.ProcessedFile, 0)
+	query := database.DB.NewSelect().
+		Model(&files).
+		Where("pf.company_id = ?", companyID)
+
+	if status := c.Query("status"); status != "" {
+		if !processedFileStatuses[status] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid status parameter. Use 'processed', 'duplicate', 'revised', 'error' or 'quarantined'",
+			})
+		}
+		query = query.Where("pf.status = ?", status)
+	}
+
+	if source := c.Query("source"); source != "" {
+		query = query.Where("pf.source = ?", source)
+	}
+
+	if fileHash := c.Query("file_hash"); fileHash != "" {
+		query = query.Where("pf.file_hash = ?", fileHash)
+	}
+
+	if fileName := c.Query("file_name"); fileName != "" {
+		query = query.Where("pf.file_name ILIKE ?", "%"+fileName+"%")
+	}
+
+	// Count total files
+	total, err := query.Count(c.Context())
+	if err != nil {
+		logger.ErrorWithFields("Failed to count processed files", err, map[string]any{
+			"operation":  "get_processed_files",
+			"company_id": companyID,
+			"user_id":    user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count processed files",
+		})
+	}
+
+	err = query.
+		Order("pf.processed_at DESC", "pf.id DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Scan(c.Context())
+	if err != nil {
+		logger.ErrorWithFields("Failed to fetch processed files", err, map[string]any{
+			"operation":  "get_processed_files",
+			"company_id": companyID,
+			"user_id":    user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch processed files",
+		})
+	}
+
+	response := ProcessedFilesResponse{
+		Files: files,
+	}
+	response.Pagination.Page = page
+	response.Pagination.Limit = limit
+	response.Pagination.Total = total
+	response.Pagination.TotalPages = (total + limit - 1) / limit
+
+	return c.JSON(response)
+}
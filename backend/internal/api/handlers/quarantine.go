@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/uptrace/bun"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/services"
+)
+
+// Limite de arquivos por reprocessamento em lote
+const quarantineReprocessLimit = 500
+
+// QuarantineHandler gerencia a quarentena de XMLs que falharam na validação ou interpretação
+type QuarantineHandler struct {
+	manager    *services.NFSeXMLManager
+	quarantine *services.QuarantineStore
+}
+
+// NewQuarantineHandler cria uma nova instância do handler de quarentena
+func NewQuarantineHandler() *QuarantineHandler {
+	return &QuarantineHandler{
+		manager:    services.NewNFSeXMLManager(),
+		quarantine: services.NewQuarantineStore(),
+	}
+}
+
+// QuarantineResponse representa a resposta da listagem da quarentena
+type QuarantineResponse struct {
+	Files      []models.QuarantinedFile `json:"files"`
+	Pagination struct {
+		Page       int `json:"page"`
+		Limit      int `json:"limit"`
+		Total      int `json:"total"`
+		TotalPages int `json:"total_pages"`
+	} `json:"pagination"`
+}
+
+// QuarantineReprocessRequest representa o pedido de reprocessamento em lote
+type QuarantineReprocessRequest struct {
+	IDs []int64 `json:"ids,omitempty"` // Arquivos a reprocessar; vazio reprocessa todos os pendentes
+}
+
+// QuarantineReprocessResult representa o resultado do reprocessamento de um arquivo
+type QuarantineReprocessResult struct {
+	ID         int64  `json:"id"`
+	FileName   string `json:"file_name"`
+	Status     string `json:"status"` // 'reprocessed', 'duplicate' ou 'failed'
+	DocumentID int64  `json:"document_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// QuarantineReprocessResponse representa o resultado do reprocessamento em lote
+type QuarantineReprocessResponse struct {
+	Total       int                         `json:"total"`
+	Reprocessed int                         `json:"reprocessed"`
+	Duplicates  int                         `json:"duplicates"`
+	Failed      int                         `json:"failed"`
+	Results     []QuarantineReprocessResult `json:"results"`
+}
+
+// GetQuarantine lista os XMLs em quarentena de uma empresa
+// @Summary Listar quarentena da empresa
+// @Description Lista os XMLs recebidos que falharam na validação de schema ou na interpretação, com o erro, a origem e a data da última falha
+// @Tags quarantine
+// @Produce json
+// @Param company_id path int true "ID da empresa"
+// @Param page query int false "Página (padrão: 1)"
+// @Param limit query int false "Itens por página (padrão: 50)"
+// @Param status query string false "Filtrar por status: 'pending' ou 'reprocessed'"
+// @Param stage query string false "Filtrar por etapa da falha: 'validation' ou 'parse'"
+// @Param source query string false "Filtrar por provedor de origem"
+// @Success 200 {object} QuarantineResponse "Arquivos em quarentena"
+// @Failure 400 {object} fiber.Map "Parâmetros inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Empresa não encontrada"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /companies/{company_id}/quarantine [get]
+func (h *QuarantineHandler) GetQuarantine(c *fiber.Ctx) error {
+	companyID, user, err := companyAccess(c)
+	if user == nil {
+		return err
+	}
+
+	// Parse pagination parameters
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 50)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	files := make([]models.QuarantinedFile, 0)
+	query := database.DB.NewSelect().
+		Model(&files).
+		Where("qf.company_id = ?", companyID)
+
+	if status := c.Query("status"); status != "" {
+		if status != services.QuarantineStatusPending && status != services.QuarantineStatusReprocessed {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid status parameter. Use 'pending' or 'reprocessed'",
+			})
+		}
+		query = query.Where("qf.status = ?", status)
+	}
+
+	if stage := c.Query("stage"); stage != "" {
+		if stage != services.QuarantineStageValidation && stage != services.QuarantineStageParse {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid stage parameter. Use 'validation' or 'parse'",
+			})
+		}
+		query = query.Where("qf.stage = ?", stage)
+	}
+
+	if source := c.Query("source"); source != "" {
+		query = query.Where("qf.source = ?", source)
+	}
+
+	// Count total files
+	total, err := query.Count(c.Context())
+	if err != nil {
+		logger.ErrorWithFields("Failed to count quarantined files", err, map[string]any{
+			"operation":  "get_quarantine",
+			"company_id": companyID,
+			"user_id":    user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count quarantined files",
+		})
+	}
+
+	err = query.
+		Order("qf.failed_at DESC", "qf.id DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Scan(c.Context())
+	if err != nil {
+		logger.ErrorWithFields("Failed to fetch quarantined files", err, map[string]any{
+			"operation":  "get_quarantine",
+			"company_id": companyID,
+			"user_id":    user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch quarantined files",
+		})
+	}
+
+	response := QuarantineResponse{
+		Files: files,
+	}
+	response.Pagination.Page = page
+	response.Pagination.Limit = limit
+	response.Pagination.Total = total
+	response.Pagination.TotalPages = (total + limit - 1) / limit
+
+	return c.JSON(response)
+}
+
+// ReprocessQuarantinedFile reprocessa um XML em quarentena
+// @Summary Reprocessar arquivo da quarentena
+// @Description Submete novamente um XML em quarentena à validação e interpretação, tipicamente após uma correção do parser. Arquivos que geram documento (ou que já existem como documento) saem da quarentena; os que continuam falhando têm o erro atualizado.
+// @Tags quarantine
+// @Produce json
+// @Param company_id path int true "ID da empresa"
+// @Param quarantine_id path int true "ID do arquivo em quarentena"
+// @Success 200 {object} QuarantineReprocessResult "Resultado do reprocessamento"
+// @Failure 400 {object} fiber.Map "ID inválido"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Arquivo não encontrado"
+// @Failure 409 {object} fiber.Map "Arquivo já reprocessado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /companies/{company_id}/quarantine/{quarantine_id}/reprocess [post]
+func (h *QuarantineHandler) ReprocessQuarantinedFile(c *fiber.Ctx) error {
+	companyID, user, err := companyAccess(c)
+	if user == nil {
+		return err
+	}
+
+	file, err := h.quarantinedFile(c, companyID)
+	if file == nil {
+		return err
+	}
+
+	if file.Status == services.QuarantineStatusReprocessed {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "File already reprocessed",
+		})
+	}
+
+	return c.JSON(h.reprocess(c, user, file))
+}
+
+// ReprocessQuarantine reprocessa em lote os XMLs em quarentena
+// @Summary Reprocessar quarentena em lote
+// @Description Submete novamente os XMLs pendentes da quarentena, ou apenas os IDs informados, à validação e interpretação. Cada execução processa até 500 arquivos, dos mais antigos para os mais recentes.
+// @Tags quarantine
+// @Accept json
+// @Produce json
+// @Param company_id path int true "ID da empresa"
+// @Param request body QuarantineReprocessRequest false "Arquivos a reprocessar"
+// @Success 200 {object} QuarantineReprocessResponse "Resultado do reprocessamento"
+// @Failure 400 {object} fiber.Map "Requisição inválida"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Empresa não encontrada"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /companies/{company_id}/quarantine/reprocess [post]
+func (h *QuarantineHandler) ReprocessQuarantine(c *fiber.Ctx) error {
+	companyID, user, err := companyAccess(c)
+	if user == nil {
+		return err
+	}
+
+	var req QuarantineReprocessRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	if len(req.IDs) > quarantineReprocessLimit {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Too many files. Reprocess at most 500 per request",
+		})
+	}
+
+	files := make([]models.QuarantinedFile, 0)
+	query := database.DB.NewSelect().
+		Model(&files).
+		Where("qf.company_id = ?", companyID).
+		Where("qf.status = ?", services.QuarantineStatusPending)
+	if len(req.IDs) > 0 {
+		query = query.Where("qf.id IN (?)", bun.In(req.IDs))
+	}
+
+	err = query.
+		Order("qf.failed_at ASC", "qf.id ASC").
+		Limit(quarantineReprocessLimit).
+		Scan(c.Context())
+	if err != nil {
+		logger.ErrorWithFields("Failed to fetch quarantined files", err, map[string]any{
+			"operation":  "reprocess_quarantine",
+			"company_id": companyID,
+			"user_id":    user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch quarantined files",
+		})
+	}
+
+	response := QuarantineReprocessResponse{
+		Total:   len(files),
+		Results: make([]QuarantineReprocessResult, 0, len(files)),
+	}
+	for i := range files {
+		result := h.reprocess(c, user, &files[i])
+		switch result.Status {
+		case "reprocessed":
+			response.Reprocessed++
+		case "duplicate":
+			response.Duplicates++
+		default:
+			response.Failed++
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	logger.InfoWithFields("Reprocessed quarantine", map[string]any{
+		"operation":   "reprocess_quarantine",
+		"company_id":  companyID,
+		"user_id":     user.ID,
+		"total":       response.Total,
+		"reprocessed": response.Reprocessed,
+		"duplicates":  response.Duplicates,
+		"failed":      response.Failed,
+	})
+
+	return c.JSON(response)
+}
+
+// DeleteQuarantinedFile remove um XML da quarentena
+// @Summary Remover arquivo da quarentena
+// @Description Remove um XML da quarentena e do storage. A remoção é registrada no log de auditoria.
+// @Tags quarantine
+// @Produce json
+// @Param company_id path int true "ID da empresa"
+// @Param quarantine_id path int true "ID do arquivo em quarentena"
+// @Success 200 {object} fiber.Map "Arquivo removido"
+// @Failure 400 {object} fiber.Map "ID inválido"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Arquivo não encontrado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /companies/{company_id}/quarantine/{quarantine_id} [delete]
+func (h *QuarantineHandler) DeleteQuarantinedFile(c *fiber.Ctx) error {
+	companyID, user, err := companyAccess(c)
+	if user == nil {
+		return err
+	}
+
+	file, err := h.quarantinedFile(c, companyID)
+	if file == nil {
+		return err
+	}
+
+	audit := newAuditLog(c, user, "DELETE", "QuarantinedFile", file.ID, map[string]any{
+		"company_id":   file.CompanyID,
+		"file_name":    file.FileName,
+		"source":       file.Source,
+		"stage":        file.Stage,
+		"status":       file.Status,
+		"content_hash": file.ContentHash,
+		"storage_key":  file.StorageKey,
+		"error":        file.Error,
+	})
+
+	if err := h.quarantine.Remove(c.Context(), file, audit); err != nil {
+		logger.ErrorWithFields("Failed to delete quarantined file", err, map[string]any{
+			"operation":     "delete_quarantined_file",
+			"company_id":    companyID,
+			"quarantine_id": file.ID,
+			"user_id":       user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete quarantined file",
+		})
+	}
+
+	logger.InfoWithFields("Quarantined file deleted", map[string]any{
+		"operation":     "delete_quarantined_file",
+		"company_id":    companyID,
+		"quarantine_id": file.ID,
+		"file_name":     file.FileName,
+		"user_id":       user.ID,
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Quarantined file deleted successfully",
+	})
+}
+
+// reprocess reprocessa um arquivo da quarentena e registra a tentativa no log de auditoria
+func (h *QuarantineHandler) reprocess(c *fiber.Ctx, user *models.User, file *models.QuarantinedFile) QuarantineReprocessResult {
+	result := QuarantineReprocessResult{
+		ID:       file.ID,
+		FileName: file.FileName,
+		Status:   "failed",
+	}
+
+	processing, err := h.manager.ReprocessQuarantined(c.Context(), file)
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+		logger.ErrorWithFields("Failed to reprocess quarantined file", err, map[string]any{
+			"operation":     "reprocess_quarantined_file",
+			"company_id":    file.CompanyID,
+			"quarantine_id": file.ID,
+			"user_id":       user.ID,
+		})
+	case processing.Success:
+		result.Status = "reprocessed"
+		result.DocumentID = processing.DocumentID
+	case processing.IsDuplicate:
+		result.Status = "duplicate"
+		result.DocumentID = processing.DocumentID
+	case processing.Error != nil:
+		result.Error = processing.Error.Error()
+	}
+
+	recordAuditLog(c, newAuditLog(c, user, "REPROCESS", "QuarantinedFile", file.ID, map[string]any{
+		"company_id":   file.CompanyID,
+		"file_name":    file.FileName,
+		"content_hash": file.ContentHash,
+		"result":       result.Status,
+		"document_id":  result.DocumentID,
+		"error":        result.Error,
+	}))
+
+	return result
+}
+
+// quarantinedFile carrega o arquivo da rota, respondendo com erro quando não pertence à empresa
+func (h *QuarantineHandler) quarantinedFile(c *fiber.Ctx, companyID int64) (*models.QuarantinedFile, error) {
+	quarantineID, err := strconv.ParseInt(c.Params("quarantine_id"), 10, 64)
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid quarantine ID",
+		})
+	}
+
+	file := &models.QuarantinedFile{}
+	err = database.DB.NewSelect().
+		Model(file).
+		Where("qf.id = ? AND qf.company_id = ?", quarantineID, companyID).
+		Scan(c.Context())
+	if err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Quarantined file not found",
+		})
+	}
+
+	return file, nil
+}
@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/api/middleware"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/services"
+)
+
+// RetentionHandler gerencia as políticas de retenção e o expurgo de documentos vencidos
+type RetentionHandler struct {
+	policies *services.RetentionPolicies
+	purger   *services.RetentionPurger
+}
+
+// NewRetentionHandler cria uma nova instância do handler de retenção
+func NewRetentionHandler() *RetentionHandler {
+	return &RetentionHandler{
+		policies: services.NewRetentionPolicies(),
+		purger:   services.NewRetentionPurger(),
+	}
+}
+
+// RetentionPolicyRequest representa a requisição para criar ou alterar uma política de retenção
+type RetentionPolicyRequest struct {
+	CompanyID      int64  `json:"company_id,omitempty"`    // Vazio: todas as empresas
+	DocumentType   string `json:"document_type,omitempty"` // Vazio: todos os tipos (nfse, nfe, cte)
+	RetentionYears int    `json:"retention_years" example:"5"`
+	Description    string `json:"description,omitempty"`
+}
+
+// PurgeRequest representa a requisição para iniciar um expurgo
+type PurgeRequest struct {
+	DryRun *bool `json:"dry_run,omitempty"` // Padrão: true, apenas lista os documentos vencidos
+}
+
+// LegalHoldRequest representa a requisição para colocar ou retirar um documento de retenção legal
+type LegalHoldRequest struct {
+	Hold   bool   `json:"hold"`
+	Reason string `json:"reason,omitempty" validate:"max=500"`
+}
+
+// GetPolicies lista as políticas de retenção
+// @Summary Listar políticas de retenção
+// @Description Lista as políticas de retenção por empresa e tipo de documento, das mais específicas para as gerais (apenas admin)
+// @Tags retention
+// @Produce json
+// @Success 200 {array} models.RetentionPolicy "Políticas de retenção"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /retention/policies [get]
+func (h *RetentionHandler) GetPolicies(c *fiber.Ctx) error {
+	policies, err := h.policies.List(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch retention policies",
+		})
+	}
+
+	return c.JSON(policies)
+}
+
+// CreatePolicy cria uma política de retenção
+// @Summary Criar política de retenção
+// @Description Define por quantos anos (mínimo 5, contados do primeiro dia do exercício seguinte à emissão) os documentos de uma empresa e/ou tipo são guardados. Documentos com a política vencida são removidos pelo expurgo (apenas admin).
+// @Tags retention
+// @Accept json
+// @Produce json
+// @Param request body RetentionPolicyRequest true "Política de retenção"
+// @Success 201 {object} models.RetentionPolicy "Política criada"
+// @Failure 400 {object} fiber.Map "Dados inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Empresa não encontrada"
+// @Failure 409 {object} fiber.Map "Já existe política para a empresa e o tipo"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /retention/policies [post]
+func (h *RetentionHandler) CreatePolicy(c *fiber.Ctx) error {
+	var req RetentionPolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	policy := &models.RetentionPolicy{
+		CompanyID:      req.CompanyID,
+		DocumentType:   req.DocumentType,
+		RetentionYears: req.RetentionYears,
+		Description:    req.Description,
+	}
+	if !h.checkPolicy(c, policy) {
+		return nil
+	}
+
+	if _, err := database.DB.NewInsert().Model(policy).Exec(c.Context()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create retention policy",
+		})
+	}
+
+	recordAuditLog(c, newAuditLog(c, middleware.GetUserFromContext(c), "CREATE", "RetentionPolicy", policy.ID, map[string]any{
+		"company_id":      policy.CompanyID,
+		"document_type":   policy.DocumentType,
+		"retention_years": policy.RetentionYears,
+	}))
+
+	return c.Status(fiber.StatusCreated).JSON(policy)
+}
+
+// UpdatePolicy altera uma política de retenção
+// @Summary Alterar política de retenção
+// @Description Altera a empresa, o tipo de documento ou o prazo de uma política de retenção (apenas admin)
+// @Tags retention
+// @Accept json
+// @Produce json
+// @Param id path int true "ID da política"
+// @Param request body RetentionPolicyRequest true "Política de retenção"
+// @Success 200 {object} models.RetentionPolicy "Política alterada"
+// @Failure 400 {object} fiber.Map "Dados inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Política ou empresa não encontrada"
+// @Failure 409 {object} fiber.Map "Já existe política para a empresa e o tipo"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /retention/policies/{id} [put]
+func (h *RetentionHandler) UpdatePolicy(c *fiber.Ctx) error {
+	policy, err := h.policy(c)
+	if policy == nil {
+		return err
+	}
+
+	var req RetentionPolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	previousYears := policy.RetentionYears
+	policy.CompanyID = req.CompanyID
+	policy.DocumentType = req.DocumentType
+	policy.RetentionYears = req.RetentionYears
+	policy.Description = req.Description
+	if !h.checkPolicy(c, policy) {
+		return nil
+	}
+
+	_, err = database.DB.NewUpdate().
+		Model(policy).
+		Column("company_id", "document_type", "retention_years", "description", "updated_at").
+		WherePK().
+		Exec(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update retention policy",
+		})
+	}
+
+	recordAuditLog(c, newAuditLog(c, middleware.GetUserFromContext(c), "UPDATE", "RetentionPolicy", policy.ID, map[string]any{
+		"company_id":               policy.CompanyID,
+		"document_type":            policy.DocumentType,
+		"retention_years":          policy.RetentionYears,
+		"previous_retention_years": previousYears,
+	}))
+
+	return c.JSON(policy)
+}
+
+// DeletePolicy remove uma política de retenção
+// @Summary Remover política de retenção
+// @Description Remove uma política de retenção; os documentos que ela cobria passam para a próxima política aplicável ou para a retenção padrão, e deixam de ser expurgados se nenhuma política os cobrir (apenas admin)
+// @Tags retention
+// @Produce json
+// @Param id path int true "ID da política"
+// @Success 204 "Política removida"
+// @Failure 400 {object} fiber.Map "ID inválido"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Política não encontrada"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /retention/policies/{id} [delete]
+func (h *RetentionHandler) DeletePolicy(c *fiber.Ctx) error {
+	policy, err := h.policy(c)
+	if policy == nil {
+		return err
+	}
+
+	if _, err := database.DB.NewDelete().Model(policy).WherePK().Exec(c.Context()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete retention policy",
+		})
+	}
+
+	recordAuditLog(c, newAuditLog(c, middleware.GetUserFromContext(c), "DELETE", "RetentionPolicy", policy.ID, map[string]any{
+		"company_id":      policy.CompanyID,
+		"document_type":   policy.DocumentType,
+		"retention_years": policy.RetentionYears,
+	}))
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// GetPurgeRuns lista as execuções do expurgo
+// @Summary Listar expurgos
+// @Description Lista as execuções recentes do expurgo de documentos vencidos, com os totais (apenas admin). Os documentos de cada execução estão no detalhe.
+// @Tags retention
+// @Produce json
+// @Param limit query int false "Quantidade de execuções (padrão: 20, máximo: 100)"
+// @Success 200 {array} models.RetentionPurgeRun "Execuções do expurgo"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /retention/purges [get]
+func (h *RetentionHandler) GetPurgeRuns(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	runs := make([]models.RetentionPurgeRun, 0)
+	err := database.DB.NewSelect().
+		Model(&runs).
+		ExcludeColumn("documents").
+		Order("started_at DESC").
+		Limit(limit).
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch retention purges",
+		})
+	}
+
+	return c.JSON(runs)
+}
+
+// GetPurgeRun retorna uma execução do expurgo
+// @Summary Obter expurgo
+// @Description Retorna uma execução do expurgo com os documentos vencidos encontrados e o resultado de cada um. Em uma simulação, é o relatório do que seria removido (apenas admin).
+// @Tags retention
+// @Produce json
+// @Param id path int true "ID da execução"
+// @Success 200 {object} models.RetentionPurgeRun "Execução do expurgo"
+// @Failure 400 {object} fiber.Map "ID inválido"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Execução não encontrada"
+// @Security BearerAuth
+// @Router /retention/purges/{id} [get]
+func (h *RetentionHandler) GetPurgeRun(c *fiber.Ctx) error {
+	runID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid purge ID",
+		})
+	}
+
+	var run models.RetentionPurgeRun
+	err = database.DB.NewSelect().
+		Model(&run).
+		Where("id = ?", runID).
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Retention purge not found",
+		})
+	}
+
+	return c.JSON(run)
+}
+
+// StartPurge inicia um expurgo
+// @Summary Iniciar expurgo
+// @Description Inicia em segundo plano o expurgo dos documentos cuja política de retenção venceu, removendo-os com os XMLs do storage. Por padrão é uma simulação (dry_run) que apenas gera o relatório; documentos em legal hold nunca são removidos (apenas admin).
+// @Tags retention
+// @Accept json
+// @Produce json
+// @Param request body PurgeRequest false "Opções do expurgo"
+// @Success 202 {object} models.RetentionPurgeRun "Expurgo iniciado"
+// @Failure 400 {object} fiber.Map "Dados inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 409 {object} fiber.Map "Expurgo já em andamento"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /retention/purges [post]
+func (h *RetentionHandler) StartPurge(c *fiber.Ctx) error {
+	var req PurgeRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	dryRun := req.DryRun == nil || *req.DryRun
+
+	user := middleware.GetUserFromContext(c)
+	run, err := h.purger.Trigger(c.Context(), dryRun, user.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrPurgeRunning) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "A retention purge is already running",
+			})
+		}
+		logger.ErrorWithFields("Failed to start retention purge", err, map[string]any{
+			"operation": "purge_retention",
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start retention purge",
+		})
+	}
+
+	if !dryRun {
+		recordAuditLog(c, newAuditLog(c, user, "PURGE", "RetentionPurgeRun", run.ID, nil))
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(run)
+}
+
+// policy carrega a política de retenção da rota
+func (h *RetentionHandler) policy(c *fiber.Ctx) (*models.RetentionPolicy, error) {
+	policyID, err := c.ParamsInt("id")
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid policy ID",
+		})
+	}
+
+	policy := &models.RetentionPolicy{}
+	err = database.DB.NewSelect().
+		Model(policy).
+		Where("id = ?", policyID).
+		Scan(c.Context())
+	if err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Retention policy not found",
+		})
+	}
+
+	return policy, nil
+}
+
+// checkPolicy valida uma política, a empresa e a unicidade do escopo, respondendo com erro quando inválida
+func (h *RetentionHandler) checkPolicy(c *fiber.Ctx, policy *models.RetentionPolicy) bool {
+	if err := h.policies.Validate(policy); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+		return false
+	}
+
+	if policy.CompanyID != 0 {
+		exists, err := database.DB.NewSelect().
+			Model((*models.Company)(nil)).
+			Where("id = ?", policy.CompanyID).
+			Exists(c.Context())
+		if err != nil {
+			c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Database error",
+			})
+			return false
+		}
+		if !exists {
+			c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Company not found",
+			})
+			return false
+		}
+	}
+
+	exists, err := database.DB.NewSelect().
+		Model((*models.RetentionPolicy)(nil)).
+		Where("COALESCE(company_id, 0) = ?", policy.CompanyID).
+		Where("COALESCE(document_type, '') = ?", policy.DocumentType).
+		Where("id <> ?", policy.ID).
+		Exists(c.Context())
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Database error",
+		})
+		return false
+	}
+	if exists {
+		c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A retention policy already exists for this company and document type",
+		})
+		return false
+	}
+
+	return true
+}
+
+// GetDocumentRetention retorna a retenção de um documento
+// @Summary Retenção do documento
+// @Description Retorna a política de retenção aplicada ao documento, até quando ele deve ser guardado, se está em legal hold e se pode ser removido
+// @Tags documents
+// @Produce json
+// @Param id path int true "ID do documento"
+// @Success 200 {object} services.RetentionStatus "Retenção do documento"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 404 {object} fiber.Map "Documento não encontrado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /documents/{id}/retention [get]
+func (h *DocumentHandler) GetDocumentRetention(c *fiber.Ctx) error {
+	document, err := h.visibleDocument(c)
+	if document == nil {
+		return err
+	}
+
+	status, err := h.retention.Status(c.Context(), document)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check document retention",
+		})
+	}
+
+	return c.JSON(status)
+}
+
+// SetDocumentLegalHold coloca ou retira um documento de retenção legal
+// @Summary Legal hold do documento
+// @Description Coloca o documento em retenção legal (legal hold), impedindo a remoção e o expurgo mesmo após o prazo de retenção, ou o retira. A alteração é registrada na auditoria (apenas admin).
+// @Tags documents
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do documento"
+// @Param request body LegalHoldRequest true "Legal hold"
+// @Success 200 {object} services.RetentionStatus "Retenção do documento"
+// @Failure 400 {object} fiber.Map "Dados inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Documento não encontrado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /documents/{id}/legal-hold [put]
+func (h *DocumentHandler) SetDocumentLegalHold(c *fiber.Ctx) error {
+	document, err := h.visibleDocument(c)
+	if document == nil {
+		return err
+	}
+
+	var req LegalHoldRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if errs := validateStruct(req); errs != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": errs,
+		})
+	}
+
+	previous := document.LegalHold
+	if err := h.retention.SetLegalHold(c.Context(), document, req.Hold, req.Reason); err != nil {
+		logger.ErrorWithFields("Failed to update legal hold", err, map[string]any{
+			"operation":   "legal_hold",
+			"document_id": document.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update legal hold",
+		})
+	}
+
+	action := "HOLD"
+	if !req.Hold {
+		action = "RELEASE"
+	}
+	recordAuditLog(c, newAuditLog(c, middleware.GetUserFromContext(c), action, "Document", document.ID, map[string]any{
+		"company_id": document.CompanyID,
+		"reason":     req.Reason,
+		"previous":   previous,
+	}))
+
+	status, err := h.retention.Status(c.Context(), document)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check document retention",
+		})
+	}
+
+	return c.JSON(status)
+}
+
+// retentionConflict responde que o documento não pode ser removido por retenção ou legal hold
+func retentionConflict(c *fiber.Ctx, err error, retention *services.RetentionStatus) error {
+	message := "Document is under retention"
+	if errors.Is(err, services.ErrLegalHold) {
+		message = "Document is under legal hold"
+	}
+
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":     message,
+		"retention": retention,
+	})
+}
@@ -4,16 +4,25 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"github.com/zoomxml/internal/api/middleware"
 	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
 	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/permissions"
+	"github.com/zoomxml/internal/services"
 )
 
 // StatsHandler gerencia as rotas de estatísticas
-type StatsHandler struct{}
+type StatsHandler struct {
+	ingestion *services.IngestionStats
+}
 
 // NewStatsHandler cria uma nova instância do handler de estatísticas
 func NewStatsHandler() *StatsHandler {
-	return &StatsHandler{}
+	return &StatsHandler{
+		ingestion: services.NewIngestionStats(),
+	}
 }
 
 // DashboardStatsResponse representa a resposta das estatísticas do dashboard
@@ -26,11 +35,14 @@ type DashboardStatsResponse struct {
 		ThisWeek   int `json:"this_week"`
 	} `json:"companies"`
 	Documents struct {
-		Total     int `json:"total"`
-		Processed int `json:"processed"`
-		Pending   int `json:"pending"`
-		Errors    int `json:"errors"`
-		Today     int `json:"today"`
+		Total         int             `json:"total"`
+		Processed     int             `json:"processed"`
+		Pending       int             `json:"pending"`
+		Errors        int             `json:"errors"`
+		Today         int             `json:"today"`
+		TotalAmount   decimal.Decimal `json:"total_amount"`
+		TotalIss      decimal.Decimal `json:"total_iss"`
+		TotalNetValue decimal.Decimal `json:"total_net_value"`
 	} `json:"documents"`
 	Users struct {
 		Total  int `json:"total"`
@@ -38,9 +50,9 @@ type DashboardStatsResponse struct {
 		Admins int `json:"admins"`
 	} `json:"users"`
 	RecentActivity struct {
-		DocumentsToday     int `json:"documents_today"`
-		CompaniesThisWeek  int `json:"companies_this_week"`
-		LastSyncTime       *time.Time `json:"last_sync_time,omitempty"`
+		DocumentsToday    int        `json:"documents_today"`
+		CompaniesThisWeek int        `json:"companies_this_week"`
+		LastSyncTime      *time.Time `json:"last_sync_time,omitempty"`
 	} `json:"recent_activity"`
 }
 
@@ -58,61 +70,68 @@ func (h *StatsHandler) GetDashboardStats(c *fiber.Ctx) error {
 	var stats DashboardStatsResponse
 
 	// Estatísticas de empresas
-	var companies []models.Company
-	err := database.DB.NewSelect().Model(&companies).Scan(c.Context())
+	weekAgo := time.Now().AddDate(0, 0, -7)
+	var companyStats struct {
+		Total      int `bun:"total"`
+		Active     int `bun:"active"`
+		Restricted int `bun:"restricted"`
+		AutoFetch  int `bun:"auto_fetch"`
+		ThisWeek   int `bun:"this_week"`
+	}
+	err := database.DB.NewSelect().
+		Model((*models.Company)(nil)).
+		ColumnExpr("COUNT(*) AS total").
+		ColumnExpr("COUNT(*) FILTER (WHERE active = true) AS active").
+		ColumnExpr("COUNT(*) FILTER (WHERE restricted = true) AS restricted").
+		ColumnExpr("COUNT(*) FILTER (WHERE auto_fetch = true) AS auto_fetch").
+		ColumnExpr("COUNT(*) FILTER (WHERE created_at > ?) AS this_week", weekAgo).
+		Scan(c.Context(), &companyStats)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch companies",
 		})
 	}
 
-	stats.Companies.Total = len(companies)
-	weekAgo := time.Now().AddDate(0, 0, -7)
-	
-	for _, company := range companies {
-		if company.Active {
-			stats.Companies.Active++
-		}
-		if company.Restricted {
-			stats.Companies.Restricted++
-		}
-		if company.AutoFetch {
-			stats.Companies.AutoFetch++
-		}
-		if company.CreatedAt.After(weekAgo) {
-			stats.Companies.ThisWeek++
-		}
-	}
+	stats.Companies.Total = companyStats.Total
+	stats.Companies.Active = companyStats.Active
+	stats.Companies.Restricted = companyStats.Restricted
+	stats.Companies.AutoFetch = companyStats.AutoFetch
+	stats.Companies.ThisWeek = companyStats.ThisWeek
 
-	// Estatísticas de documentos
-	var documents []models.Document
-	err = database.DB.NewSelect().Model(&documents).Scan(c.Context())
-	if err != nil {
-		// Se não conseguir buscar documentos, continuar com zeros
-		stats.Documents.Total = 0
-		stats.Documents.Processed = 0
-		stats.Documents.Pending = 0
-		stats.Documents.Errors = 0
-		stats.Documents.Today = 0
-	} else {
-		stats.Documents.Total = len(documents)
-		today := time.Now().Truncate(24 * time.Hour)
-		
-		for _, doc := range documents {
-			switch doc.Status {
-			case "processed":
-				stats.Documents.Processed++
-			case "pending":
-				stats.Documents.Pending++
-			case "error":
-				stats.Documents.Errors++
-			}
-			
-			if doc.CreatedAt.After(today) {
-				stats.Documents.Today++
-			}
-		}
+	// Estatísticas de documentos (somas monetárias calculadas no banco para evitar drift de centavos)
+	today := time.Now().Truncate(24 * time.Hour)
+	var documentStats struct {
+		Total         int             `bun:"total"`
+		Processed     int             `bun:"processed"`
+		Pending       int             `bun:"pending"`
+		Errors        int             `bun:"errors"`
+		Today         int             `bun:"today"`
+		TotalAmount   decimal.Decimal `bun:"total_amount"`
+		TotalIss      decimal.Decimal `bun:"total_iss"`
+		TotalNetValue decimal.Decimal `bun:"total_net_value"`
 	}
+	err = database.DB.NewSelect().
+		Model((*models.Document)(nil)).
+		ColumnExpr("COUNT(*) AS total").
+		ColumnExpr("COUNT(*) FILTER (WHERE status = 'processed') AS processed").
+		ColumnExpr("COUNT(*) FILTER (WHERE status = 'pending') AS pending").
+		ColumnExpr("COUNT(*) FILTER (WHERE status = 'error') AS errors").
+		ColumnExpr("COUNT(*) FILTER (WHERE created_at > ?) AS today", today).
+		ColumnExpr("COALESCE(SUM(amount), 0) AS total_amount").
+		ColumnExpr("COALESCE(SUM(iss_value), 0) AS total_iss").
+		ColumnExpr("COALESCE(SUM(net_value), 0) AS total_net_value").
+		Scan(c.Context(), &documentStats)
+	if err == nil {
+		stats.Documents.Total = documentStats.Total
+		stats.Documents.Processed = documentStats.Processed
+		stats.Documents.Pending = documentStats.Pending
+		stats.Documents.Errors = documentStats.Errors
+		stats.Documents.Today = documentStats.Today
+		stats.Documents.TotalAmount = documentStats.TotalAmount
+		stats.Documents.TotalIss = documentStats.TotalIss
+		stats.Documents.TotalNetValue = documentStats.TotalNetValue
+	}
+	// Se não conseguir buscar documentos, continuar com zeros
 
 	// Estatísticas de usuários (apenas para admins)
 	user, ok := c.Locals("user").(*models.User)
@@ -188,12 +207,48 @@ func (h *StatsHandler) GetCompanyStats(c *fiber.Ctx) error {
 		})
 	}
 
-	// Buscar documentos da empresa
-	var documents []models.Document
+	// Agregar documentos da empresa no banco (notas canceladas não entram nos totais)
+	thisMonth := time.Now().AddDate(0, -1, 0)
+	var documentStats struct {
+		Total             int             `bun:"total"`
+		Processed         int             `bun:"processed"`
+		Pending           int             `bun:"pending"`
+		Errors            int             `bun:"errors"`
+		ThisMonth         int             `bun:"this_month"`
+		IssOutside        int             `bun:"iss_outside_provider"`
+		TotalAmount       decimal.Decimal `bun:"total_amount"`
+		TotalServiceValue decimal.Decimal `bun:"total_service_value"`
+		TotalDeductions   decimal.Decimal `bun:"total_deductions"`
+		TotalIss          decimal.Decimal `bun:"total_iss"`
+		TotalIssWithheld  decimal.Decimal `bun:"total_iss_withheld"`
+		TotalPis          decimal.Decimal `bun:"total_pis"`
+		TotalCofins       decimal.Decimal `bun:"total_cofins"`
+		TotalInss         decimal.Decimal `bun:"total_inss"`
+		TotalIr           decimal.Decimal `bun:"total_ir"`
+		TotalCsll         decimal.Decimal `bun:"total_csll"`
+		TotalNetValue     decimal.Decimal `bun:"total_net_value"`
+	}
 	err = database.DB.NewSelect().
-		Model(&documents).
+		Model((*models.Document)(nil)).
+		ColumnExpr("COUNT(*) AS total").
+		ColumnExpr("COUNT(*) FILTER (WHERE status = 'processed') AS processed").
+		ColumnExpr("COUNT(*) FILTER (WHERE status = 'pending') AS pending").
+		ColumnExpr("COUNT(*) FILTER (WHERE status = 'error') AS errors").
+		ColumnExpr("COUNT(*) FILTER (WHERE created_at > ?) AS this_month", thisMonth).
+		ColumnExpr("COUNT(*) FILTER (WHERE iss_due_outside_provider = true AND is_cancelled = false) AS iss_outside_provider").
+		ColumnExpr("COALESCE(SUM(amount) FILTER (WHERE is_cancelled = false), 0) AS total_amount").
+		ColumnExpr("COALESCE(SUM(service_value) FILTER (WHERE is_cancelled = false), 0) AS total_service_value").
+		ColumnExpr("COALESCE(SUM(deductions_value) FILTER (WHERE is_cancelled = false), 0) AS total_deductions").
+		ColumnExpr("COALESCE(SUM(iss_value) FILTER (WHERE is_cancelled = false), 0) AS total_iss").
+		ColumnExpr("COALESCE(SUM(iss_value) FILTER (WHERE iss_withheld = true AND is_cancelled = false), 0) AS total_iss_withheld").
+		ColumnExpr("COALESCE(SUM(pis_value) FILTER (WHERE is_cancelled = false), 0) AS total_pis").
+		ColumnExpr("COALESCE(SUM(cofins_value) FILTER (WHERE is_cancelled = false), 0) AS total_cofins").
+		ColumnExpr("COALESCE(SUM(inss_value) FILTER (WHERE is_cancelled = false), 0) AS total_inss").
+		ColumnExpr("COALESCE(SUM(ir_value) FILTER (WHERE is_cancelled = false), 0) AS total_ir").
+		ColumnExpr("COALESCE(SUM(csll_value) FILTER (WHERE is_cancelled = false), 0) AS total_csll").
+		ColumnExpr("COALESCE(SUM(net_value) FILTER (WHERE is_cancelled = false), 0) AS total_net_value").
 		Where("company_id = ?", companyID).
-		Scan(c.Context())
+		Scan(c.Context(), &documentStats)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch company documents",
@@ -204,31 +259,249 @@ func (h *StatsHandler) GetCompanyStats(c *fiber.Ctx) error {
 	stats := map[string]interface{}{
 		"company": company,
 		"documents": map[string]interface{}{
-			"total":     len(documents),
-			"processed": 0,
-			"pending":   0,
-			"errors":    0,
-			"this_month": 0,
+			"total":                documentStats.Total,
+			"processed":            documentStats.Processed,
+			"pending":              documentStats.Pending,
+			"errors":               documentStats.Errors,
+			"this_month":           documentStats.ThisMonth,
+			"iss_outside_provider": documentStats.IssOutside,
+		},
+		"values": map[string]interface{}{
+			"amount":        documentStats.TotalAmount,
+			"service_value": documentStats.TotalServiceValue,
+			"deductions":    documentStats.TotalDeductions,
+			"iss":           documentStats.TotalIss,
+			"iss_withheld":  documentStats.TotalIssWithheld,
+			"pis":           documentStats.TotalPis,
+			"cofins":        documentStats.TotalCofins,
+			"inss":          documentStats.TotalInss,
+			"ir":            documentStats.TotalIr,
+			"csll":          documentStats.TotalCsll,
+			"net_value":     documentStats.TotalNetValue,
 		},
 	}
 
-	thisMonth := time.Now().AddDate(0, -1, 0)
-	docStats := stats["documents"].(map[string]interface{})
-	
-	for _, doc := range documents {
-		switch doc.Status {
-		case "processed":
-			docStats["processed"] = docStats["processed"].(int) + 1
-		case "pending":
-			docStats["pending"] = docStats["pending"].(int) + 1
-		case "error":
-			docStats["errors"] = docStats["errors"].(int) + 1
+	return c.JSON(stats)
+}
+
+// ServiceItemStats representa os totais de um subitem da lista de serviços
+type ServiceItemStats struct {
+	ServiceItem  string          `bun:"service_item" json:"service_item"` // Vazio para códigos fora da LC 116/2003
+	Description  string          `bun:"description" json:"description,omitempty"`
+	Documents    int             `bun:"documents" json:"documents"`
+	ServiceValue decimal.Decimal `bun:"service_value" json:"service_value"`
+	IssValue     decimal.Decimal `bun:"iss_value" json:"iss_value"`
+	IssWithheld  decimal.Decimal `bun:"iss_withheld" json:"iss_withheld"`
+	NetValue     decimal.Decimal `bun:"net_value" json:"net_value"`
+}
+
+// ServiceItemStatsResponse representa a resposta do relatório por item de serviço
+type ServiceItemStatsResponse struct {
+	CompanyID      int64              `json:"company_id"`
+	CatalogVersion string             `json:"catalog_version,omitempty"`
+	ServiceItems   []ServiceItemStats `json:"service_items"`
+}
+
+// GetCompanyServiceItems retorna os totais de uma empresa agrupados por item da lista de serviços
+// @Summary Estatísticas por item de serviço
+// @Description Agrupa as notas de serviço da empresa pelo subitem da LC 116/2003. Notas canceladas não entram nos totais; notas com código fora da lista ficam no grupo de item vazio.
+// @Tags stats
+// @Produce json
+// @Param id path int true "ID da empresa"
+// @Param start_date query string false "Data inicial de emissão (YYYY-MM-DD)"
+// @Param end_date query string false "Data final de emissão (YYYY-MM-DD)"
+// @Success 200 {object} ServiceItemStatsResponse "Totais por item de serviço"
+// @Failure 400 {object} SwaggerError "Parâmetros inválidos"
+// @Failure 401 {object} SwaggerError "Token inválido"
+// @Failure 403 {object} SwaggerError "Acesso negado"
+// @Failure 404 {object} SwaggerError "Empresa não encontrada"
+// @Failure 500 {object} SwaggerError "Erro interno"
+// @Security BearerAuth
+// @Router /stats/companies/{id}/service-items [get]
+func (h *StatsHandler) GetCompanyServiceItems(c *fiber.Ctx) error {
+	companyID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid company ID",
+		})
+	}
+
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	// Verificar permissões
+	err = permissions.CanAccessCompany(c.Context(), user, int64(companyID))
+	if err != nil {
+		if err == permissions.ErrCompanyNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Company not found",
+			})
 		}
-		
-		if doc.CreatedAt.After(thisMonth) {
-			docStats["this_month"] = docStats["this_month"].(int) + 1
+		if err == permissions.ErrAccessDenied {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied to this company",
+			})
 		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to validate permissions",
+		})
 	}
 
-	return c.JSON(stats)
+	query := database.DB.NewSelect().
+		Model((*models.Document)(nil)).
+		ColumnExpr("COALESCE(service_item, '') AS service_item").
+		ColumnExpr("MAX(service_item_description) AS description").
+		ColumnExpr("COUNT(*) AS documents").
+		ColumnExpr("COALESCE(SUM(service_value), 0) AS service_value").
+		ColumnExpr("COALESCE(SUM(iss_value), 0) AS iss_value").
+		ColumnExpr("COALESCE(SUM(iss_value) FILTER (WHERE iss_withheld = true), 0) AS iss_withheld").
+		ColumnExpr("COALESCE(SUM(net_value), 0) AS net_value").
+		Where("company_id = ?", companyID).
+		Where("is_cancelled = false").
+		Where("COALESCE(service_code, '') <> ''")
+
+	if startDate := c.Query("start_date"); startDate != "" {
+		date, err := time.Parse("2006-01-02", startDate)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid start_date format. Use YYYY-MM-DD",
+			})
+		}
+		query = query.Where("issue_date >= ?", date)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		date, err := time.Parse("2006-01-02", endDate)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid end_date format. Use YYYY-MM-DD",
+			})
+		}
+		query = query.Where("issue_date < ?", date.AddDate(0, 0, 1))
+	}
+
+	serviceItems := make([]ServiceItemStats, 0)
+	err = query.
+		GroupExpr("COALESCE(service_item, '')").
+		OrderExpr("service_value DESC").
+		Scan(c.Context(), &serviceItems)
+	if err != nil {
+		logger.ErrorWithFields("Failed to fetch service item stats", err, map[string]any{
+			"operation":  "get_company_service_items",
+			"company_id": companyID,
+			"user_id":    user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch service item stats",
+		})
+	}
+
+	response := ServiceItemStatsResponse{
+		CompanyID:    int64(companyID),
+		ServiceItems: serviceItems,
+	}
+	if catalogs := services.LoadServiceCatalogs(); catalogs != nil {
+		response.CatalogVersion = catalogs.ServiceList.Version
+	}
+
+	return c.JSON(response)
+}
+
+// IngestionRankingResponse representa o ranking de empresas pela qualidade da ingestão
+type IngestionRankingResponse struct {
+	PeriodDays int                         `json:"period_days"`
+	Companies  []services.CompanyIngestion `json:"companies"`
+}
+
+// GetCompanyIngestionStats retorna a qualidade da ingestão de XMLs de uma empresa
+// @Summary Estatísticas de ingestão da empresa
+// @Description Série diária de arquivos recebidos como documento novo, duplicata, revisão, erro ou quarentena, duplicatas por critério de identificação (código de verificação, chave composta, hash) e as mensagens de erro mais frequentes. Cada arquivo conta uma vez, no dia do último recebimento.
+// @Tags stats
+// @Produce json
+// @Param company_id path int true "ID da empresa"
+// @Param days query int false "Período em dias, incluindo hoje (padrão: 30, máximo: 365)"
+// @Param top_errors query int false "Quantidade de mensagens de erro (padrão: 10, máximo: 100)"
+// @Success 200 {object} services.IngestionReport "Estatísticas de ingestão"
+// @Failure 400 {object} SwaggerError "Parâmetros inválidos"
+// @Failure 401 {object} SwaggerError "Token inválido"
+// @Failure 403 {object} SwaggerError "Acesso negado"
+// @Failure 404 {object} SwaggerError "Empresa não encontrada"
+// @Failure 500 {object} SwaggerError "Erro interno"
+// @Security BearerAuth
+// @Router /companies/{company_id}/ingestion-stats [get]
+func (h *StatsHandler) GetCompanyIngestionStats(c *fiber.Ctx) error {
+	companyID, user, err := companyAccess(c)
+	if user == nil {
+		return err
+	}
+
+	days := c.QueryInt("days", 30)
+	if days < 1 || days > 365 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid days parameter. Use a value between 1 and 365",
+		})
+	}
+	topErrors := c.QueryInt("top_errors", 10)
+	if topErrors < 1 || topErrors > 100 {
+		topErrors = 10
+	}
+
+	report, err := h.ingestion.CompanyReport(c.Context(), companyID, days, topErrors)
+	if err != nil {
+		logger.ErrorWithFields("Failed to fetch ingestion stats", err, map[string]any{
+			"operation":  "get_company_ingestion_stats",
+			"company_id": companyID,
+			"user_id":    user.ID,
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch ingestion stats",
+		})
+	}
+
+	return c.JSON(report)
+}
+
+// GetIngestionStats retorna as empresas ordenadas pela taxa de erro da ingestão
+// @Summary Ranking de ingestão por empresa
+// @Description Lista as empresas que receberam arquivos no período com os totais por resultado, ordenadas pela taxa de erro (arquivos com erro ou em quarentena). Apenas administradores.
+// @Tags stats
+// @Produce json
+// @Param days query int false "Período em dias, incluindo hoje (padrão: 30, máximo: 365)"
+// @Param limit query int false "Quantidade de empresas (padrão: 20, máximo: 200)"
+// @Success 200 {object} IngestionRankingResponse "Empresas por taxa de erro"
+// @Failure 400 {object} SwaggerError "Parâmetros inválidos"
+// @Failure 401 {object} SwaggerError "Token inválido"
+// @Failure 403 {object} SwaggerError "Acesso negado"
+// @Failure 500 {object} SwaggerError "Erro interno"
+// @Security BearerAuth
+// @Router /stats/ingestion [get]
+func (h *StatsHandler) GetIngestionStats(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days < 1 || days > 365 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid days parameter. Use a value between 1 and 365",
+		})
+	}
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 200 {
+		limit = 20
+	}
+
+	companies, err := h.ingestion.CompanyRanking(c.Context(), days, limit)
+	if err != nil {
+		logger.ErrorWithFields("Failed to rank companies by ingestion", err, map[string]any{
+			"operation": "get_ingestion_stats",
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch ingestion stats",
+		})
+	}
+
+	return c.JSON(IngestionRankingResponse{
+		PeriodDays: days,
+		Companies:  companies,
+	})
 }
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zoomxml/internal/api/middleware"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/services"
+)
+
+// StorageHandler gerencia a verificação de integridade e a reconciliação do storage
+type StorageHandler struct {
+	scrubber   *services.StorageScrubber
+	reconciler *services.StorageReconciler
+}
+
+// ReconcileRequest representa a requisição para iniciar uma reconciliação do storage
+type ReconcileRequest struct {
+	Policy string `json:"policy,omitempty" validate:"omitempty,oneof=report reimport delete"` // Padrão: report, apenas lista os órfãos
+}
+
+// NewStorageHandler cria uma nova instância do handler de storage
+func NewStorageHandler() *StorageHandler {
+	return &StorageHandler{
+		scrubber:   services.NewStorageScrubber(),
+		reconciler: services.NewStorageReconciler(),
+	}
+}
+
+// GetScrubRuns lista as verificações de integridade do storage
+// @Summary Listar verificações do storage
+// @Description Lista as verificações de integridade do storage mais recentes com os totais de objetos verificados, ausentes, corrompidos e órfãos (apenas admin). Os problemas de cada verificação estão no detalhe.
+// @Tags storage
+// @Produce json
+// @Param limit query int false "Quantidade de verificações (padrão: 20, máximo: 100)"
+// @Success 200 {array} models.StorageScrubRun "Verificações do storage"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /storage/scrubs [get]
+func (h *StorageHandler) GetScrubRuns(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	runs := make([]models.StorageScrubRun, 0)
+	err := database.DB.NewSelect().
+		Model(&runs).
+		ExcludeColumn("issues").
+		Order("started_at DESC").
+		Limit(limit).
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch storage scrubs",
+		})
+	}
+
+	return c.JSON(runs)
+}
+
+// GetLatestScrubRun retorna a última verificação concluída do storage
+// @Summary Última verificação do storage
+// @Description Retorna a última verificação de integridade concluída, com os objetos ausentes, corrompidos (SHA-256 diferente do registrado na ingestão) e órfãos (apenas admin)
+// @Tags storage
+// @Produce json
+// @Success 200 {object} models.StorageScrubRun "Última verificação"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Nenhuma verificação concluída"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /storage/scrubs/latest [get]
+func (h *StorageHandler) GetLatestScrubRun(c *fiber.Ctx) error {
+	run, err := h.scrubber.LatestRun(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch storage scrub",
+		})
+	}
+	if run == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Storage was never scrubbed",
+		})
+	}
+
+	return c.JSON(run)
+}
+
+// GetScrubRun retorna uma verificação do storage
+// @Summary Obter verificação do storage
+// @Description Retorna uma verificação de integridade do storage com os problemas encontrados (apenas admin)
+// @Tags storage
+// @Produce json
+// @Param id path int true "ID da verificação"
+// @Success 200 {object} models.StorageScrubRun "Verificação"
+// @Failure 400 {object} fiber.Map "ID inválido"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Verificação não encontrada"
+// @Security BearerAuth
+// @Router /storage/scrubs/{id} [get]
+func (h *StorageHandler) GetScrubRun(c *fiber.Ctx) error {
+	runID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid scrub ID",
+		})
+	}
+
+	var run models.StorageScrubRun
+	err = database.DB.NewSelect().
+		Model(&run).
+		Where("id = ?", runID).
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Storage scrub not found",
+		})
+	}
+
+	return c.JSON(run)
+}
+
+// StartScrub inicia uma verificação do storage
+// @Summary Iniciar verificação do storage
+// @Description Inicia em segundo plano uma verificação de integridade do storage, sem esperar o próximo agendamento (apenas admin). Acompanhe o resultado pelo ID retornado.
+// @Tags storage
+// @Produce json
+// @Success 202 {object} models.StorageScrubRun "Verificação iniciada"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 409 {object} fiber.Map "Verificação já em andamento"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /storage/scrubs [post]
+func (h *StorageHandler) StartScrub(c *fiber.Ctx) error {
+	run, err := h.scrubber.Trigger(c.Context())
+	if err != nil {
+		if errors.Is(err, services.ErrScrubRunning) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "A storage scrub is already running",
+			})
+		}
+		logger.ErrorWithFields("Failed to start storage scrub", err, map[string]any{
+			"operation": "start_storage_scrub",
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start storage scrub",
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(run)
+}
+
+// GetReconcileRuns lista as reconciliações do storage
+// @Summary Listar reconciliações do storage
+// @Description Lista as reconciliações do storage com o banco mais recentes, com os totais de objetos órfãos reimportados, removidos ou relatados e de documentos sem XML (apenas admin). Os itens de cada reconciliação estão no detalhe.
+// @Tags storage
+// @Produce json
+// @Param limit query int false "Quantidade de reconciliações (padrão: 20, máximo: 100)"
+// @Success 200 {array} models.StorageReconcileRun "Reconciliações do storage"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /storage/reconciliations [get]
+func (h *StorageHandler) GetReconcileRuns(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	runs := make([]models.StorageReconcileRun, 0)
+	err := database.DB.NewSelect().
+		Model(&runs).
+		ExcludeColumn("items").
+		Order("started_at DESC").
+		Limit(limit).
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch storage reconciliations",
+		})
+	}
+
+	return c.JSON(runs)
+}
+
+// GetReconcileRun retorna uma reconciliação do storage
+// @Summary Obter reconciliação do storage
+// @Description Retorna uma reconciliação do storage com os objetos órfãos e os documentos sem XML encontrados, e o que foi feito com cada um (apenas admin)
+// @Tags storage
+// @Produce json
+// @Param id path int true "ID da reconciliação"
+// @Success 200 {object} models.StorageReconcileRun "Reconciliação"
+// @Failure 400 {object} fiber.Map "ID inválido"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 404 {object} fiber.Map "Reconciliação não encontrada"
+// @Security BearerAuth
+// @Router /storage/reconciliations/{id} [get]
+func (h *StorageHandler) GetReconcileRun(c *fiber.Ctx) error {
+	runID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid reconciliation ID",
+		})
+	}
+
+	var run models.StorageReconcileRun
+	err = database.DB.NewSelect().
+		Model(&run).
+		Where("id = ?", runID).
+		Scan(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Storage reconciliation not found",
+		})
+	}
+
+	return c.JSON(run)
+}
+
+// StartReconcile inicia uma reconciliação do storage
+// @Summary Iniciar reconciliação do storage
+// @Description Inicia em segundo plano a comparação dos XMLs do bucket com os documentos (apenas admin). Objetos sem documento são relatados (report, padrão), importados como documentos da empresa do prestador ou do tomador e removidos do storage (reimport), ou removidos (delete); objetos gravados há menos de STORAGE_RECONCILE_MIN_AGE são ignorados. Documentos cujo XML não está no storage são marcados em storage_missing_at.
+// @Tags storage
+// @Accept json
+// @Produce json
+// @Param request body ReconcileRequest false "Opções da reconciliação"
+// @Success 202 {object} models.StorageReconcileRun "Reconciliação iniciada"
+// @Failure 400 {object} fiber.Map "Dados inválidos"
+// @Failure 401 {object} fiber.Map "Token inválido"
+// @Failure 403 {object} fiber.Map "Acesso negado"
+// @Failure 409 {object} fiber.Map "Reconciliação já em andamento"
+// @Failure 500 {object} fiber.Map "Erro interno"
+// @Security BearerAuth
+// @Router /storage/reconciliations [post]
+func (h *StorageHandler) StartReconcile(c *fiber.Ctx) error {
+	var req ReconcileRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	if errs := validateStruct(req); errs != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": errs,
+		})
+	}
+	if req.Policy == "" {
+		req.Policy = services.ReconcilePolicyReport
+	}
+
+	user := middleware.GetUserFromContext(c)
+	run, err := h.reconciler.Trigger(c.Context(), req.Policy, user.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrReconcileRunning) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "A storage reconciliation is already running",
+			})
+		}
+		logger.ErrorWithFields("Failed to start storage reconciliation", err, map[string]any{
+			"operation": "reconcile_storage",
+		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start storage reconciliation",
+		})
+	}
+
+	if req.Policy != services.ReconcilePolicyReport {
+		recordAuditLog(c, newAuditLog(c, user, "RECONCILE", "StorageReconcileRun", run.ID, map[string]any{
+			"policy": req.Policy,
+		}))
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(run)
+}
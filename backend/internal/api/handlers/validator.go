@@ -11,7 +11,7 @@ var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
-	
+
 	// Usar nome do campo JSON em vez do nome da struct
 	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
 		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
@@ -33,7 +33,7 @@ func validateStruct(s interface{}) map[string]string {
 	for _, err := range err.(validator.ValidationErrors) {
 		field := err.Field()
 		tag := err.Tag()
-		
+
 		switch tag {
 		case "required":
 			errors[field] = field + " is required"
@@ -101,8 +101,7 @@ func HealthCheckSkipper(c *fiber.Ctx) bool {
 // StaticFileSkipper skips logging for static files
 func StaticFileSkipper(c *fiber.Ctx) bool {
 	path := c.Path()
-	return len(path) > 4 && (
-		path[len(path)-4:] == ".css" ||
+	return len(path) > 4 && (path[len(path)-4:] == ".css" ||
 		path[len(path)-3:] == ".js" ||
 		path[len(path)-4:] == ".png" ||
 		path[len(path)-4:] == ".jpg" ||
@@ -38,6 +38,21 @@ func SetupRoutes(app *fiber.App) {
 
 	// Configurar rotas de estatísticas
 	setupStatsRoutes(api)
+
+	// Configurar rotas de catálogos
+	setupCatalogRoutes(api)
+
+	// Configurar rotas de storage
+	setupStorageRoutes(api)
+
+	// Configurar rotas de exportação
+	setupExportRoutes(api)
+
+	// Configurar rotas de links assinados
+	setupFileRoutes(api)
+
+	// Configurar rotas de retenção
+	setupRetentionRoutes(api)
 }
 
 // setupUserRoutes configura as rotas de gerenciamento de usuários
@@ -77,6 +92,18 @@ func setupCompanyRoutes(api fiber.Router, handler *handlers.CompanyHandler) {
 
 	// Rotas para NFSe
 	setupNFSeRoutes(companies)
+
+	// Rotas para contrapartes (clientes e fornecedores)
+	setupCounterpartyRoutes(companies)
+
+	// Rotas para a quarentena de XMLs
+	setupQuarantineRoutes(companies)
+
+	// Rotas para o histórico de arquivos processados
+	setupProcessedFileRoutes(companies)
+
+	// Rotas para a qualidade da ingestão
+	setupIngestionStatsRoutes(companies)
 }
 
 // setupCompanyMemberRoutes configura as rotas de membros de empresas
@@ -124,6 +151,36 @@ func setupNFSeRoutes(companies fiber.Router) {
 	nfse.Get("/", nfseHandler.GetNFSeDocuments)         // Listar documentos NFSe armazenados
 }
 
+// setupCounterpartyRoutes configura as rotas do cadastro de contrapartes
+func setupCounterpartyRoutes(companies fiber.Router) {
+	counterpartyHandler := handlers.NewCounterpartyHandler()
+	companies.Get("/:company_id/counterparties", middleware.AuthMiddleware(), counterpartyHandler.GetCounterparties) // Clientes e fornecedores com faturamento e gastos
+}
+
+// setupQuarantineRoutes configura as rotas da quarentena de XMLs que falharam na ingestão
+func setupQuarantineRoutes(companies fiber.Router) {
+	quarantine := companies.Group("/:company_id/quarantine")
+	quarantine.Use(middleware.AuthMiddleware()) // Requer autenticação
+
+	quarantineHandler := handlers.NewQuarantineHandler()
+	quarantine.Get("/", quarantineHandler.GetQuarantine)                                     // Listar arquivos em quarentena
+	quarantine.Post("/reprocess", quarantineHandler.ReprocessQuarantine)                     // Reprocessar pendentes em lote
+	quarantine.Post("/:quarantine_id/reprocess", quarantineHandler.ReprocessQuarantinedFile) // Reprocessar um arquivo
+	quarantine.Delete("/:quarantine_id", quarantineHandler.DeleteQuarantinedFile)            // Remover arquivo da quarentena
+}
+
+// setupProcessedFileRoutes configura as rotas do histórico de arquivos XML recebidos
+func setupProcessedFileRoutes(companies fiber.Router) {
+	processedFileHandler := handlers.NewProcessedFileHandler()
+	companies.Get("/:company_id/processed-files", middleware.AuthMiddleware(), processedFileHandler.GetProcessedFiles) // Arquivos recebidos com o resultado do processamento
+}
+
+// setupIngestionStatsRoutes configura as rotas de estatísticas de ingestão da empresa
+func setupIngestionStatsRoutes(companies fiber.Router) {
+	statsHandler := handlers.NewStatsHandler()
+	companies.Get("/:company_id/ingestion-stats", middleware.AuthMiddleware(), statsHandler.GetCompanyIngestionStats) // Novos, duplicatas e erros por dia
+}
+
 // setupCNPJRoutes configura as rotas de consulta de CNPJ
 func setupCNPJRoutes(api fiber.Router, handler *handlers.CNPJHandler) {
 	// Rota para consultar CNPJ (requer autenticação)
@@ -147,9 +204,19 @@ func setupDocumentRoutes(api fiber.Router, handler *handlers.DocumentHandler) {
 	documents.Use(middleware.AuthMiddleware()) // Requer autenticação
 
 	// CRUD de documentos
-	documents.Get("/", handler.GetDocuments)         // GET /api/documents - Listar documentos
-	documents.Get("/:id", handler.GetDocument)       // GET /api/documents/:id - Obter documento
-	documents.Delete("/:id", handler.DeleteDocument) // DELETE /api/documents/:id - Remover documento
+	documents.Get("/", handler.GetDocuments)                              // GET /api/documents - Listar documentos
+	documents.Get("/items", handler.SearchDocumentItems)                  // GET /api/documents/items - Buscar itens (NCM, CFOP, produto)
+	documents.Get("/:id", handler.GetDocument)                            // GET /api/documents/:id - Obter documento
+	documents.Get("/:id/xml", handler.GetDocumentXML)                     // GET /api/documents/:id/xml - Baixar XML do documento
+	documents.Post("/:id/links", handler.CreateDocumentLink)              // POST /api/documents/:id/links - Link de download com validade
+	documents.Get("/:id/items", handler.GetDocumentItems)                 // GET /api/documents/:id/items - Itens do documento
+	documents.Get("/:id/revisions", handler.GetDocumentRevisions)         // GET /api/documents/:id/revisions - Revisões do documento
+	documents.Get("/:id/revisions/diff", handler.GetDocumentRevisionDiff) // GET /api/documents/:id/revisions/diff - Comparar revisões
+	documents.Get("/:id/retention", handler.GetDocumentRetention)         // GET /api/documents/:id/retention - Retenção do documento
+	documents.Delete("/:id", handler.DeleteDocument)                      // DELETE /api/documents/:id - Remover documento
+
+	// Legal hold (apenas admin)
+	documents.Put("/:id/legal-hold", middleware.AdminOnlyMiddleware(), handler.SetDocumentLegalHold) // PUT /api/documents/:id/legal-hold - Colocar ou retirar legal hold
 }
 
 // setupStatsRoutes configura as rotas de estatísticas
@@ -159,6 +226,77 @@ func setupStatsRoutes(api fiber.Router) {
 
 	// Rotas de estatísticas (requer autenticação)
 	stats.Use(middleware.AuthMiddleware())
-	stats.Get("/dashboard", statsHandler.GetDashboardStats)   // Estatísticas do dashboard
-	stats.Get("/companies/:id", statsHandler.GetCompanyStats) // Estatísticas de empresa específica
+	stats.Get("/dashboard", statsHandler.GetDashboardStats)                                   // Estatísticas do dashboard
+	stats.Get("/companies/:id", statsHandler.GetCompanyStats)                                 // Estatísticas de empresa específica
+	stats.Get("/companies/:id/service-items", statsHandler.GetCompanyServiceItems)            // Totais por item da lista de serviços
+	stats.Get("/ingestion", middleware.AdminOnlyMiddleware(), statsHandler.GetIngestionStats) // Empresas por taxa de erro da ingestão (apenas admin)
+}
+
+// setupCatalogRoutes configura as rotas dos catálogos de serviços (LC 116/2003), CNAE e municípios do IBGE
+func setupCatalogRoutes(api fiber.Router) {
+	catalogs := api.Group("/catalog")
+	catalogHandler := handlers.NewCatalogHandler()
+
+	// Rotas de catálogos (requer autenticação)
+	catalogs.Use(middleware.AuthMiddleware())
+	catalogs.Get("/municipalities", catalogHandler.SearchMunicipalities)  // Municípios do IBGE por nome e UF
+	catalogs.Get("/municipalities/:code", catalogHandler.GetMunicipality) // Nome e UF de um código IBGE
+	catalogs.Get("/:catalog", catalogHandler.SearchCatalog)               // Autocomplete por código ou descrição
+	catalogs.Get("/:catalog/:code", catalogHandler.GetCatalogEntry)       // Descrição e códigos filhos
+}
+
+// setupStorageRoutes configura as rotas de verificação de integridade e de reconciliação do storage
+func setupStorageRoutes(api fiber.Router) {
+	storage := api.Group("/storage")
+	storageHandler := handlers.NewStorageHandler()
+
+	// Rotas de storage (apenas admin)
+	storage.Use(middleware.AuthMiddleware(), middleware.AdminOnlyMiddleware())
+	storage.Get("/scrubs", storageHandler.GetScrubRuns)             // Verificações de integridade recentes
+	storage.Post("/scrubs", storageHandler.StartScrub)              // Iniciar verificação em segundo plano
+	storage.Get("/scrubs/latest", storageHandler.GetLatestScrubRun) // Última verificação concluída
+	storage.Get("/scrubs/:id", storageHandler.GetScrubRun)          // Verificação com os problemas encontrados
+
+	storage.Get("/reconciliations", storageHandler.GetReconcileRuns)    // Reconciliações com o banco recentes
+	storage.Post("/reconciliations", storageHandler.StartReconcile)     // Iniciar reconciliação em segundo plano
+	storage.Get("/reconciliations/:id", storageHandler.GetReconcileRun) // Reconciliação com os órfãos e documentos sem XML
+}
+
+// setupExportRoutes configura as rotas de exportação de documentos
+func setupExportRoutes(api fiber.Router) {
+	exports := api.Group("/exports")
+	exportHandler := handlers.NewExportHandler()
+
+	exports.Use(middleware.AuthMiddleware())
+	exports.Post("/", exportHandler.CreateExport)              // Exportar XMLs filtrados em ZIP
+	exports.Get("/", exportHandler.GetExports)                 // Exportações em segundo plano
+	exports.Get("/:id", exportHandler.GetExport)               // Status da exportação
+	exports.Get("/:id/download", exportHandler.DownloadExport) // Baixar o ZIP da exportação
+	exports.Post("/:id/links", exportHandler.CreateExportLink) // Link de download com validade
+	exports.Delete("/:id", exportHandler.DeleteExport)         // Remover a exportação e o ZIP
+}
+
+// setupFileRoutes configura as rotas dos links de download assinados pela API
+func setupFileRoutes(api fiber.Router) {
+	files := api.Group("/files")
+	fileHandler := handlers.NewFileHandler()
+
+	// Sem autenticação: o acesso é autorizado pela assinatura e pela validade do link
+	files.Get("/:bucket/*", fileHandler.GetSignedFile)
+}
+
+// setupRetentionRoutes configura as rotas de políticas de retenção e expurgo
+func setupRetentionRoutes(api fiber.Router) {
+	retention := api.Group("/retention")
+	retentionHandler := handlers.NewRetentionHandler()
+
+	// Rotas de retenção (apenas admin)
+	retention.Use(middleware.AuthMiddleware(), middleware.AdminOnlyMiddleware())
+	retention.Get("/policies", retentionHandler.GetPolicies)         // Políticas de retenção
+	retention.Post("/policies", retentionHandler.CreatePolicy)       // Criar política
+	retention.Put("/policies/:id", retentionHandler.UpdatePolicy)    // Alterar política
+	retention.Delete("/policies/:id", retentionHandler.DeletePolicy) // Remover política
+	retention.Get("/purges", retentionHandler.GetPurgeRuns)          // Expurgos recentes
+	retention.Post("/purges", retentionHandler.StartPurge)           // Iniciar expurgo (simulação por padrão)
+	retention.Get("/purges/:id", retentionHandler.GetPurgeRun)       // Expurgo com o relatório dos documentos
 }
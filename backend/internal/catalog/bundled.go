@@ -0,0 +1,170 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed data/*.csv
+var bundledData embed.FS
+
+// Catalog files, bundled under data/ and optionally overridden by files with the same name
+const (
+	ServiceListFile  = "lc116.csv"
+	CNAEFile         = "cnae.csv"
+	MunicipalityFile = "municipios.csv"
+)
+
+// cnaePrefixes are the CNAE levels below the subclass: class, group and division
+var cnaePrefixes = []int{5, 3, 2}
+
+// Catalogs holds the service list, CNAE and municipality tables used to classify documents
+type Catalogs struct {
+	ServiceList    *Catalog // Lista de serviços da LC 116/2003
+	CNAE           *Catalog
+	Municipalities *Municipalities // Tabela de municípios do IBGE
+}
+
+// Load reads the bundled catalogs. A file in dir with the name of a bundled catalog replaces it
+// entirely, so a newer list or the full CNAE and IBGE municipality tables can be deployed without a build.
+func Load(dir string) (*Catalogs, error) {
+	serviceList, err := loadCatalog(dir, ServiceListFile, "lc116", NormalizeServiceCode, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cnae, err := loadCatalog(dir, CNAEFile, "cnae", NormalizeCNAE, cnaePrefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readFile(dir, MunicipalityFile)
+	if err != nil {
+		return nil, err
+	}
+	municipalities, err := parseMunicipalities(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %v", MunicipalityFile, err)
+	}
+
+	return &Catalogs{
+		ServiceList:    serviceList,
+		CNAE:           cnae,
+		Municipalities: municipalities,
+	}, nil
+}
+
+// loadCatalog reads a catalog file from dir, falling back to the bundled copy
+func loadCatalog(dir, file, name string, normalize func(string) string, prefixes []int) (*Catalog, error) {
+	data, err := readFile(dir, file)
+	if err != nil {
+		return nil, err
+	}
+
+	version, entries, err := parseCatalog(data, normalize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %v", file, err)
+	}
+
+	return newCatalog(name, version, entries, normalize, prefixes), nil
+}
+
+// readFile reads a catalog file from dir, falling back to the bundled copy
+func readFile(dir, file string) ([]byte, error) {
+	if dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+	}
+
+	data, err := bundledData.ReadFile("data/" + file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundled %s: %v", file, err)
+	}
+	return data, nil
+}
+
+// newReader returns the version and a CSV reader positioned after the expected header.
+// Lines starting with # are comments; a "# version: <version>" comment names the catalog version.
+func newReader(data []byte, header ...string) (string, *csv.Reader, error) {
+	version := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if value, ok := strings.CutPrefix(strings.TrimSpace(strings.TrimPrefix(line, "#")), "version:"); ok {
+			version = strings.TrimSpace(value)
+		}
+	}
+	if version == "" {
+		return "", nil, fmt.Errorf("missing version comment")
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = len(header)
+
+	record, err := reader.Read()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	if strings.Join(record, ",") != strings.Join(header, ",") {
+		return "", nil, fmt.Errorf("unexpected header %v", record)
+	}
+
+	return version, reader, nil
+}
+
+// parseCatalog reads a catalog CSV with a "code,description,parent,status" header
+func parseCatalog(data []byte, normalize func(string) string) (string, []Entry, error) {
+	version, reader, err := newReader(data, "code", "description", "parent", "status")
+	if err != nil {
+		return "", nil, err
+	}
+
+	var entries []Entry
+	seen := make(map[string]bool)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		entry := Entry{
+			Code:        normalize(record[0]),
+			Description: strings.TrimSpace(record[1]),
+			Parent:      normalize(record[2]),
+			Vetoed:      strings.TrimSpace(record[3]) == "vetoed",
+		}
+		if entry.Code == "" {
+			return "", nil, fmt.Errorf("empty code for %q", entry.Description)
+		}
+		if seen[entry.Code] {
+			return "", nil, fmt.Errorf("duplicate code %s", entry.Code)
+		}
+		if entry.Parent != "" && !seen[entry.Parent] {
+			return "", nil, fmt.Errorf("code %s listed before its parent %s", entry.Code, entry.Parent)
+		}
+		seen[entry.Code] = true
+		entries = append(entries, entry)
+	}
+
+	return version, entries, nil
+}
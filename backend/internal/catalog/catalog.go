@@ -0,0 +1,250 @@
+package catalog
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Entry is a single code of a catalog
+type Entry struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Parent      string `json:"parent,omitempty"`
+	Vetoed      bool   `json:"vetoed,omitempty"` // Subitem vetoed from the law, kept so historical documents still resolve
+}
+
+// Catalog is a versioned, read-only list of codes with their descriptions
+type Catalog struct {
+	Name    string
+	Version string
+
+	entries   []Entry
+	byCode    map[string]int
+	folded    []string // accent-folded, lowercase descriptions used by Search
+	normalize func(string) string
+	prefixes  []int // lengths of the ancestor codes tried by Resolve, most specific first
+}
+
+// newCatalog indexes the entries of a catalog
+func newCatalog(name, version string, entries []Entry, normalize func(string) string, prefixes []int) *Catalog {
+	c := &Catalog{
+		Name:      name,
+		Version:   version,
+		entries:   entries,
+		byCode:    make(map[string]int, len(entries)),
+		folded:    make([]string, len(entries)),
+		normalize: normalize,
+		prefixes:  prefixes,
+	}
+	for i, entry := range entries {
+		c.byCode[entry.Code] = i
+		c.folded[i] = fold(entry.Description)
+	}
+	return c
+}
+
+// Len returns the number of entries in the catalog
+func (c *Catalog) Len() int {
+	return len(c.entries)
+}
+
+// Normalize returns the canonical form of a code as written in a document
+func (c *Catalog) Normalize(code string) string {
+	return c.normalize(code)
+}
+
+// Lookup returns the entry with exactly the given code, after normalization
+func (c *Catalog) Lookup(code string) (Entry, bool) {
+	i, ok := c.byCode[c.normalize(code)]
+	if !ok {
+		return Entry{}, false
+	}
+	return c.entries[i], true
+}
+
+// Resolve returns the most specific entry for a code, falling back to its ancestors
+// when the catalog does not list the code itself (e.g. a CNAE subclass resolves to its division)
+func (c *Catalog) Resolve(code string) (Entry, bool) {
+	code = c.normalize(code)
+	if i, ok := c.byCode[code]; ok {
+		return c.entries[i], true
+	}
+	for _, length := range c.prefixes {
+		if length >= len(code) {
+			continue
+		}
+		if i, ok := c.byCode[code[:length]]; ok {
+			return c.entries[i], true
+		}
+	}
+	return Entry{}, false
+}
+
+// Search returns up to limit entries for autocomplete. Queries made only of digits and
+// separators match code prefixes; other queries match every word against the description,
+// ignoring case and accents. Results keep the catalog order, with an exact code match first.
+func (c *Catalog) Search(query string, limit int) []Entry {
+	results := make([]Entry, 0)
+	query = strings.TrimSpace(query)
+	if limit <= 0 {
+		return results
+	}
+
+	if query == "" {
+		for i := 0; i < len(c.entries) && i < limit; i++ {
+			results = append(results, c.entries[i])
+		}
+		return results
+	}
+
+	if isCodeQuery(query) {
+		prefix := codePrefix(query)
+		exact := -1
+		if i, ok := c.byCode[c.normalize(query)]; ok {
+			exact = i
+			results = append(results, c.entries[i])
+		}
+		for i, entry := range c.entries {
+			if len(results) >= limit {
+				break
+			}
+			if i != exact && strings.HasPrefix(digitsOnly(entry.Code), prefix) {
+				results = append(results, entry)
+			}
+		}
+		return results
+	}
+
+	words := strings.Fields(fold(query))
+	for i, description := range c.folded {
+		if len(results) >= limit {
+			break
+		}
+		if containsAll(description, words) {
+			results = append(results, c.entries[i])
+		}
+	}
+	return results
+}
+
+// Children returns the entries directly under a code, in catalog order
+func (c *Catalog) Children(code string) []Entry {
+	code = c.normalize(code)
+	children := make([]Entry, 0)
+	for _, entry := range c.entries {
+		if entry.Parent == code {
+			children = append(children, entry)
+		}
+	}
+	return children
+}
+
+// NormalizeServiceCode converts the ways municipalities write an LC 116/2003 subitem
+// ("1.07", "01.07", "0107", "107") and the national cTribNac ("010701") to "01.07".
+// Codes that do not look like a subitem are returned trimmed and unchanged.
+func NormalizeServiceCode(code string) string {
+	code = strings.TrimSpace(code)
+
+	if item, subitem, ok := strings.Cut(code, "."); ok {
+		item, subitem = digitsOnly(item), digitsOnly(subitem)
+		if len(item) >= 1 && len(item) <= 2 && len(subitem) >= 1 {
+			if len(subitem) > 2 {
+				subitem = subitem[:2] // "01.07.01": desdobramento nacional
+			}
+			return leftPad(item, 2) + "." + leftPad(subitem, 2)
+		}
+		return code
+	}
+
+	digits := digitsOnly(code)
+	if digits != code {
+		return code
+	}
+	switch len(digits) {
+	case 3:
+		return "0" + digits[:1] + "." + digits[1:]
+	case 4, 6:
+		return digits[:2] + "." + digits[2:4]
+	}
+	return code
+}
+
+// NormalizeCNAE strips the punctuation of a CNAE code ("6201-5/01" becomes "6201501").
+// Section letters are kept upper case.
+func NormalizeCNAE(code string) string {
+	var builder strings.Builder
+	for _, r := range strings.TrimSpace(code) {
+		switch {
+		case r >= '0' && r <= '9':
+			builder.WriteRune(r)
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			builder.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return builder.String()
+}
+
+// isCodeQuery reports whether a search query is a code rather than words
+func isCodeQuery(query string) bool {
+	hasDigit := false
+	for _, r := range query {
+		switch {
+		case r >= '0' && r <= '9':
+			hasDigit = true
+		case r == '.' || r == '-' || r == '/' || r == ' ':
+		default:
+			return false
+		}
+	}
+	return hasDigit
+}
+
+// codePrefix returns the digits a code query must start with, padding a single-digit
+// LC 116/2003 item typed before a dot ("1.0" searches 01.0x)
+func codePrefix(query string) string {
+	if item, rest, ok := strings.Cut(query, "."); ok {
+		return leftPad(digitsOnly(item), 2) + digitsOnly(rest)
+	}
+	return digitsOnly(query)
+}
+
+// digitsOnly removes every character that is not a digit
+func digitsOnly(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, value)
+}
+
+// leftPad pads a numeric string with zeros up to length
+func leftPad(value string, length int) string {
+	if len(value) >= length {
+		return value
+	}
+	return strings.Repeat("0", length-len(value)) + value
+}
+
+// fold lowercases a text and removes its accents
+func fold(text string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), text)
+	if err != nil {
+		folded = text
+	}
+	return strings.ToLower(folded)
+}
+
+// containsAll reports whether text contains every word
+func containsAll(text string, words []string) bool {
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,219 @@
+package catalog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// UF is a Brazilian state with its IBGE code
+type UF struct {
+	Code         string `json:"code"`         // Código IBGE (dois primeiros dígitos do código do município)
+	Abbreviation string `json:"abbreviation"` // Sigla
+	Name         string `json:"name"`
+}
+
+// ufs lists the states by IBGE code
+var ufs = []UF{
+	{"11", "RO", "Rondônia"},
+	{"12", "AC", "Acre"},
+	{"13", "AM", "Amazonas"},
+	{"14", "RR", "Roraima"},
+	{"15", "PA", "Pará"},
+	{"16", "AP", "Amapá"},
+	{"17", "TO", "Tocantins"},
+	{"21", "MA", "Maranhão"},
+	{"22", "PI", "Piauí"},
+	{"23", "CE", "Ceará"},
+	{"24", "RN", "Rio Grande do Norte"},
+	{"25", "PB", "Paraíba"},
+	{"26", "PE", "Pernambuco"},
+	{"27", "AL", "Alagoas"},
+	{"28", "SE", "Sergipe"},
+	{"29", "BA", "Bahia"},
+	{"31", "MG", "Minas Gerais"},
+	{"32", "ES", "Espírito Santo"},
+	{"33", "RJ", "Rio de Janeiro"},
+	{"35", "SP", "São Paulo"},
+	{"41", "PR", "Paraná"},
+	{"42", "SC", "Santa Catarina"},
+	{"43", "RS", "Rio Grande do Sul"},
+	{"50", "MS", "Mato Grosso do Sul"},
+	{"51", "MT", "Mato Grosso"},
+	{"52", "GO", "Goiás"},
+	{"53", "DF", "Distrito Federal"},
+}
+
+// checkDigitExceptions are municipality codes created with a check digit that does not follow the IBGE rule
+var checkDigitExceptions = map[string]bool{
+	"2201919": true,
+	"2201988": true,
+	"2202251": true,
+	"2611533": true,
+	"3117836": true,
+	"3152131": true,
+	"4305871": true,
+	"5203939": true,
+	"5203962": true,
+}
+
+// UFs returns every state ordered by IBGE code
+func UFs() []UF {
+	return append([]UF(nil), ufs...)
+}
+
+// UFByCode returns the state of a two-digit IBGE state code or of a municipality code
+func UFByCode(code string) (UF, bool) {
+	code = digitsOnly(code)
+	if len(code) < 2 {
+		return UF{}, false
+	}
+	for _, uf := range ufs {
+		if uf.Code == code[:2] {
+			return uf, true
+		}
+	}
+	return UF{}, false
+}
+
+// UFByAbbreviation returns the state with the given abbreviation, ignoring case
+func UFByAbbreviation(abbreviation string) (UF, bool) {
+	abbreviation = strings.ToUpper(strings.TrimSpace(abbreviation))
+	for _, uf := range ufs {
+		if uf.Abbreviation == abbreviation {
+			return uf, true
+		}
+	}
+	return UF{}, false
+}
+
+// ValidMunicipalityCode reports whether a code has seven digits, a known state and a valid check digit
+func ValidMunicipalityCode(code string) bool {
+	if len(code) != 7 || digitsOnly(code) != code {
+		return false
+	}
+	if _, ok := UFByCode(code); !ok {
+		return false
+	}
+	if checkDigitExceptions[code] {
+		return true
+	}
+
+	// Pesos 1 e 2 alternados; produtos com dois dígitos têm os dígitos somados
+	sum := 0
+	for i, r := range code[:6] {
+		product := int(r-'0') * (1 + i%2)
+		sum += product/10 + product%10
+	}
+	return int(code[6]-'0') == (10-sum%10)%10
+}
+
+// Municipality is a municipality of the IBGE table
+type Municipality struct {
+	Code string `json:"code"` // Código IBGE de 7 dígitos
+	Name string `json:"name,omitempty"`
+	UF   string `json:"uf"`
+}
+
+// Municipalities is a versioned IBGE municipality table
+type Municipalities struct {
+	Version string
+
+	list   []Municipality
+	byCode map[string]int
+	folded []string
+}
+
+// parseMunicipalities reads a municipality CSV with a "code,name,uf" header
+func parseMunicipalities(data []byte) (*Municipalities, error) {
+	version, reader, err := newReader(data, "code", "name", "uf")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Municipalities{
+		Version: version,
+		byCode:  make(map[string]int),
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		municipality := Municipality{
+			Code: strings.TrimSpace(record[0]),
+			Name: strings.TrimSpace(record[1]),
+			UF:   strings.ToUpper(strings.TrimSpace(record[2])),
+		}
+		// The check digit is not enforced: the table is the authority for the codes it lists
+		uf, ok := UFByCode(municipality.Code)
+		if len(municipality.Code) != 7 || digitsOnly(municipality.Code) != municipality.Code || !ok {
+			return nil, fmt.Errorf("invalid municipality code %q", municipality.Code)
+		}
+		if uf.Abbreviation != municipality.UF {
+			return nil, fmt.Errorf("municipality %s belongs to %s, not %s", municipality.Code, uf.Abbreviation, municipality.UF)
+		}
+		if _, ok := m.byCode[municipality.Code]; ok {
+			return nil, fmt.Errorf("duplicate municipality code %s", municipality.Code)
+		}
+
+		m.byCode[municipality.Code] = len(m.list)
+		m.list = append(m.list, municipality)
+		m.folded = append(m.folded, fold(municipality.Name))
+	}
+
+	return m, nil
+}
+
+// Len returns the number of municipalities with a name in the table
+func (m *Municipalities) Len() int {
+	return len(m.list)
+}
+
+// Lookup returns the named municipality with the given code
+func (m *Municipalities) Lookup(code string) (Municipality, bool) {
+	i, ok := m.byCode[strings.TrimSpace(code)]
+	if !ok {
+		return Municipality{}, false
+	}
+	return m.list[i], true
+}
+
+// Resolve returns the municipality of a code. Valid codes missing from the table
+// still resolve to their state, with an empty name.
+func (m *Municipalities) Resolve(code string) (Municipality, bool) {
+	code = strings.TrimSpace(code)
+	if municipality, ok := m.Lookup(code); ok {
+		return municipality, true
+	}
+	if !ValidMunicipalityCode(code) {
+		return Municipality{}, false
+	}
+	uf, _ := UFByCode(code)
+	return Municipality{Code: code, UF: uf.Abbreviation}, true
+}
+
+// Search returns up to limit municipalities whose name contains every word of query,
+// ignoring case and accents, optionally restricted to a state
+func (m *Municipalities) Search(query, uf string, limit int) []Municipality {
+	results := make([]Municipality, 0)
+	words := strings.Fields(fold(query))
+	uf = strings.ToUpper(strings.TrimSpace(uf))
+
+	for i, municipality := range m.list {
+		if len(results) >= limit {
+			break
+		}
+		if uf != "" && municipality.UF != uf {
+			continue
+		}
+		if containsAll(m.folded[i], words) {
+			results = append(results, municipality)
+		}
+	}
+	return results
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/uptrace/bun"
+)
+
+// documentUniqueKey is a document identity that must be unique per company
+type documentUniqueKey struct {
+	Name      string // Nome usado no relatório
+	Index     string
+	Columns   string
+	Predicate string // Documentos sem a chave preenchida ficam fora do índice
+}
+
+// documentUniqueKeys lists the unique document keys in the order duplicates are merged
+var documentUniqueKeys = []documentUniqueKey{
+	{"verification_code", "idx_documents_company_verification_code", "company_id, verification_code", "verification_code <> ''"},
+	{"document_hash", "idx_documents_company_document_hash", "company_id, document_hash", "document_hash <> ''"},
+	{"access_key", "idx_documents_company_type_key", "company_id, type, key", "key <> ''"},
+}
+
+// duplicateDocument is a document that repeats the unique key of an older document
+type duplicateDocument struct {
+	ID         int64  `bun:"id"`
+	KeeperID   int64  `bun:"keeper_id"`
+	CompanyID  int64  `bun:"company_id"`
+	StorageKey string `bun:"storage_key"`
+}
+
+// DuplicateMerge is a duplicate document folded into the document kept for its key
+type DuplicateMerge struct {
+	Key        string
+	CompanyID  int64
+	DocumentID int64
+	KeeperID   int64
+	StorageKey string
+}
+
+// DuplicateMergeReport summarizes a merge of duplicate documents
+type DuplicateMergeReport struct {
+	DryRun              bool
+	Merges              []DuplicateMerge
+	Merged              map[string]int // Documentos removidos por chave
+	Companies           map[int64]int  // Documentos removidos por empresa
+	OrphanedStorageKeys []string       // XMLs dos documentos removidos que nenhum documento restante referencia
+}
+
+// errDuplicateMergeDryRun rolls back the transaction of a dry run
+var errDuplicateMergeDryRun = errors.New("duplicate merge dry run")
+
+// RunDuplicateMerge merges duplicate documents in a single transaction. A dry run performs the same merge and
+// rolls it back, so its report lists exactly what the merge would do.
+func RunDuplicateMerge(ctx context.Context, db *bun.DB, dryRun bool) (*DuplicateMergeReport, error) {
+	var report *DuplicateMergeReport
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		report, err = MergeDuplicateDocuments(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return errDuplicateMergeDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDuplicateMergeDryRun) {
+		return nil, err
+	}
+
+	report.DryRun = dryRun
+	return report, nil
+}
+
+// CountDuplicateDocuments returns the number of documents that repeat a unique key of an older document of the
+// same company, which MergeDuplicateDocuments would remove
+func CountDuplicateDocuments(ctx context.Context, db bun.IDB) (int, error) {
+	queries := make([]string, 0, len(documentUniqueKeys))
+	for _, key := range documentUniqueKeys {
+		queries = append(queries, fmt.Sprintf(`
+			SELECT id FROM (
+				SELECT id, MIN(id) OVER (PARTITION BY %s) AS keeper_id
+				FROM documents
+				WHERE %s
+			) ranked
+			WHERE id <> keeper_id`, key.Columns, key.Predicate))
+	}
+
+	var count int
+	err := db.NewRaw(fmt.Sprintf("SELECT COUNT(DISTINCT id) FROM (%s) duplicates", strings.Join(queries, " UNION ALL "))).
+		Scan(ctx, &count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count duplicate documents: %v", err)
+	}
+	return count, nil
+}
+
+// MergeDuplicateDocuments merges documents that share a unique key with an older document of the same company.
+// The oldest document is kept, inheriting the cancelled and substituted flags of its duplicates; references from
+// quarantined files move to it, the counterparty counts are corrected and the duplicates are deleted with their items.
+// The XMLs left without a document are only reported; the storage reconciliation removes them.
+func MergeDuplicateDocuments(ctx context.Context, db bun.IDB) (*DuplicateMergeReport, error) {
+	report := &DuplicateMergeReport{
+		Merged:    make(map[string]int),
+		Companies: make(map[int64]int),
+	}
+
+	for _, key := range documentUniqueKeys {
+		var duplicates []duplicateDocument
+		err := db.NewRaw(fmt.Sprintf(`
+			SELECT id, keeper_id, company_id, COALESCE(storage_key, '') AS storage_key
+			FROM (
+				SELECT id, company_id, storage_key, MIN(id) OVER (PARTITION BY %s) AS keeper_id
+				FROM documents
+				WHERE %s
+			) ranked
+			WHERE id <> keeper_id
+			ORDER BY id`, key.Columns, key.Predicate)).
+			Scan(ctx, &duplicates)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find duplicates by %s: %v", key.Name, err)
+		}
+
+		for _, duplicate := range duplicates {
+			if err := mergeDuplicateDocument(ctx, db, duplicate); err != nil {
+				return nil, fmt.Errorf("failed to merge document %d into %d: %v", duplicate.ID, duplicate.KeeperID, err)
+			}
+
+			report.Merges = append(report.Merges, DuplicateMerge{
+				Key:        key.Name,
+				CompanyID:  duplicate.CompanyID,
+				DocumentID: duplicate.ID,
+				KeeperID:   duplicate.KeeperID,
+				StorageKey: duplicate.StorageKey,
+			})
+			report.Merged[key.Name]++
+			report.Companies[duplicate.CompanyID]++
+
+			if duplicate.StorageKey != "" {
+				referenced, err := db.NewSelect().
+					Table("documents").
+					Where("storage_key = ?", duplicate.StorageKey).
+					Exists(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("failed to check storage key %s: %v", duplicate.StorageKey, err)
+				}
+				if !referenced {
+					report.OrphanedStorageKeys = append(report.OrphanedStorageKeys, duplicate.StorageKey)
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// mergeDuplicateDocument folds a duplicate document into the document kept for its key
+func mergeDuplicateDocument(ctx context.Context, db bun.IDB, duplicate duplicateDocument) error {
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{
+			`UPDATE documents AS d
+				SET is_cancelled = d.is_cancelled OR dup.is_cancelled,
+					is_substituted = d.is_substituted OR dup.is_substituted
+				FROM documents AS dup
+				WHERE d.id = ? AND dup.id = ?`,
+			[]any{duplicate.KeeperID, duplicate.ID},
+		},
+		{
+			"UPDATE quarantined_files SET document_id = ? WHERE document_id = ?",
+			[]any{duplicate.KeeperID, duplicate.ID},
+		},
+		{
+			`UPDATE parties SET document_count = GREATEST(document_count - 1, 0)
+				WHERE id = (SELECT provider_party_id FROM documents WHERE id = ?)`,
+			[]any{duplicate.ID},
+		},
+		{
+			`UPDATE parties SET document_count = GREATEST(document_count - 1, 0)
+				WHERE id = (SELECT taker_party_id FROM documents WHERE id = ?)`,
+			[]any{duplicate.ID},
+		},
+		{
+			"DELETE FROM documents WHERE id = ?",
+			[]any{duplicate.ID},
+		},
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
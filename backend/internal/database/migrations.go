@@ -2,8 +2,10 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/xmltree"
 
 	"github.com/uptrace/bun"
 )
@@ -57,6 +59,94 @@ func GetMigrations() []MigrationItem {
 			Name: "007_create_indexes",
 			Up:   createIndexes,
 		},
+		{
+			Name: "008_add_document_tax_columns",
+			Up:   addDocumentTaxColumns,
+		},
+		{
+			Name: "009_convert_money_columns_to_numeric",
+			Up:   convertMoneyColumnsToNumeric,
+		},
+		{
+			Name: "010_add_document_validation_columns",
+			Up:   addDocumentValidationColumns,
+		},
+		{
+			Name: "011_add_document_authenticity_columns",
+			Up:   addDocumentAuthenticityColumns,
+		},
+		{
+			Name: "012_create_document_items_table",
+			Up:   createDocumentItemsTable,
+		},
+		{
+			Name: "013_create_parties_table",
+			Up:   createPartiesTable,
+		},
+		{
+			Name: "014_add_document_service_item_columns",
+			Up:   addDocumentServiceItemColumns,
+		},
+		{
+			Name: "015_add_document_location_columns",
+			Up:   addDocumentLocationColumns,
+		},
+		{
+			Name: "016_create_quarantined_files_table",
+			Up:   createQuarantinedFilesTable,
+		},
+		{
+			Name: "017_add_document_unique_indexes",
+			Up:   addDocumentUniqueIndexes,
+		},
+		{
+			Name: "018_create_document_revisions_table",
+			Up:   createDocumentRevisionsTable,
+		},
+		{
+			Name: "019_create_processed_files_table",
+			Up:   createProcessedFilesTable,
+		},
+		{
+			Name: "020_add_processed_file_duplicate_method",
+			Up:   addProcessedFileDuplicateMethod,
+		},
+		{
+			Name: "021_create_storage_scrub_runs_table",
+			Up:   createStorageScrubRunsTable,
+		},
+		{
+			Name: "022_create_export_jobs_table",
+			Up:   createExportJobsTable,
+		},
+		{
+			Name: "023_add_retention_policies_and_legal_hold",
+			Up:   addRetentionPoliciesAndLegalHold,
+		},
+		{
+			Name: "024_create_data_keys_table",
+			Up:   createDataKeysTable,
+		},
+		{
+			Name: "025_create_storage_reconcile_runs_table",
+			Up:   createStorageReconcileRunsTable,
+		},
+		{
+			Name: "026_convert_document_metadata_to_json",
+			Up:   convertDocumentMetadataToJSON,
+		},
+		{
+			Name: "027_reverify_invalid_signatures",
+			Up:   reverifyInvalidSignatures,
+		},
+		{
+			Name: "028_create_storage_deletions_table",
+			Up:   createStorageDeletionsTable,
+		},
+		{
+			Name: "029_restrict_data_key_deletion",
+			Up:   restrictDataKeyDeletion,
+		},
 	}
 }
 
@@ -255,3 +345,740 @@ func createIndexes(ctx context.Context, db *bun.DB) error {
 
 	return nil
 }
+
+func addDocumentTaxColumns(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS deductions_value DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS pis_value DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS cofins_value DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS inss_value DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS ir_value DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS csll_value DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS iss_withheld BOOLEAN DEFAULT false,
+			ADD COLUMN IF NOT EXISTS iss_value DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS other_withholdings DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS calculation_base DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS iss_rate DECIMAL(7,4),
+			ADD COLUMN IF NOT EXISTS net_value DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS conditional_discount DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS unconditional_discount DECIMAL(15,2),
+			ADD COLUMN IF NOT EXISTS cnae_code VARCHAR(20),
+			ADD COLUMN IF NOT EXISTS operation_nature VARCHAR(10),
+			ADD COLUMN IF NOT EXISTS simples_nacional_optant BOOLEAN DEFAULT false,
+			ADD COLUMN IF NOT EXISTS service_description TEXT,
+			ADD COLUMN IF NOT EXISTS service_municipality_code VARCHAR(10)`,
+		"CREATE INDEX IF NOT EXISTS idx_documents_cnae_code ON documents(cnae_code)",
+		"CREATE INDEX IF NOT EXISTS idx_documents_service_municipality_code ON documents(service_municipality_code)",
+		"CREATE INDEX IF NOT EXISTS idx_documents_iss_withheld ON documents(iss_withheld)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func convertMoneyColumnsToNumeric(ctx context.Context, db *bun.DB) error {
+	moneyColumns := []string{
+		"amount",
+		"service_value",
+		"deductions_value",
+		"pis_value",
+		"cofins_value",
+		"inss_value",
+		"ir_value",
+		"csll_value",
+		"iss_value",
+		"other_withholdings",
+		"calculation_base",
+		"net_value",
+		"conditional_discount",
+		"unconditional_discount",
+	}
+
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, column := range moneyColumns {
+			// Valores antigos em float são arredondados para centavos e NULL vira zero
+			statements := []string{
+				fmt.Sprintf("ALTER TABLE documents ALTER COLUMN %[1]s TYPE NUMERIC(15,2) USING ROUND(%[1]s::numeric, 2)", column),
+				fmt.Sprintf("UPDATE documents SET %[1]s = 0 WHERE %[1]s IS NULL", column),
+				fmt.Sprintf("ALTER TABLE documents ALTER COLUMN %s SET DEFAULT 0", column),
+			}
+			for _, statement := range statements {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Alíquota mantém quatro casas decimais
+		rateStatements := []string{
+			"ALTER TABLE documents ALTER COLUMN iss_rate TYPE NUMERIC(7,4) USING ROUND(iss_rate::numeric, 4)",
+			"UPDATE documents SET iss_rate = 0 WHERE iss_rate IS NULL",
+			"ALTER TABLE documents ALTER COLUMN iss_rate SET DEFAULT 0",
+		}
+		for _, statement := range rateStatements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func addDocumentValidationColumns(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS validation_status VARCHAR(20),
+			ADD COLUMN IF NOT EXISTS validation_errors JSONB`,
+		"CREATE INDEX IF NOT EXISTS idx_documents_validation_status ON documents(validation_status)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addDocumentAuthenticityColumns(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS authenticity_status VARCHAR(20),
+			ADD COLUMN IF NOT EXISTS signer_cnpj VARCHAR(14),
+			ADD COLUMN IF NOT EXISTS signer_certificate_serial VARCHAR(64)`,
+		"CREATE INDEX IF NOT EXISTS idx_documents_authenticity_status ON documents(authenticity_status)",
+		"CREATE INDEX IF NOT EXISTS idx_documents_signer_cnpj ON documents(signer_cnpj)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createDocumentItemsTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS document_items (
+			id BIGSERIAL PRIMARY KEY,
+			document_id BIGINT NOT NULL,
+			company_id BIGINT NOT NULL,
+			item_number INTEGER NOT NULL,
+			product_code VARCHAR(60),
+			gtin VARCHAR(14),
+			description TEXT,
+			ncm VARCHAR(8),
+			cest VARCHAR(7),
+			cfop VARCHAR(4),
+			unit VARCHAR(6),
+			quantity NUMERIC(15,4) NOT NULL DEFAULT 0,
+			unit_price NUMERIC(21,10) NOT NULL DEFAULT 0,
+			total_value NUMERIC(15,2) NOT NULL DEFAULT 0,
+			discount NUMERIC(15,2) NOT NULL DEFAULT 0,
+			freight NUMERIC(15,2) NOT NULL DEFAULT 0,
+			insurance NUMERIC(15,2) NOT NULL DEFAULT 0,
+			other_costs NUMERIC(15,2) NOT NULL DEFAULT 0,
+			icms_origin VARCHAR(1),
+			icms_cst VARCHAR(3),
+			icms_base NUMERIC(15,2) NOT NULL DEFAULT 0,
+			icms_rate NUMERIC(7,4) NOT NULL DEFAULT 0,
+			icms_value NUMERIC(15,2) NOT NULL DEFAULT 0,
+			icms_st_value NUMERIC(15,2) NOT NULL DEFAULT 0,
+			ipi_cst VARCHAR(2),
+			ipi_base NUMERIC(15,2) NOT NULL DEFAULT 0,
+			ipi_rate NUMERIC(7,4) NOT NULL DEFAULT 0,
+			ipi_value NUMERIC(15,2) NOT NULL DEFAULT 0,
+			pis_cst VARCHAR(2),
+			pis_base NUMERIC(15,2) NOT NULL DEFAULT 0,
+			pis_rate NUMERIC(7,4) NOT NULL DEFAULT 0,
+			pis_value NUMERIC(15,2) NOT NULL DEFAULT 0,
+			cofins_cst VARCHAR(2),
+			cofins_base NUMERIC(15,2) NOT NULL DEFAULT 0,
+			cofins_rate NUMERIC(7,4) NOT NULL DEFAULT 0,
+			cofins_value NUMERIC(15,2) NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// The table may already exist from AutoMigrate, which does not create foreign keys
+		"ALTER TABLE document_items DROP CONSTRAINT IF EXISTS fk_document_items_document",
+		`ALTER TABLE document_items
+			ADD CONSTRAINT fk_document_items_document
+			FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE`,
+		"CREATE INDEX IF NOT EXISTS idx_document_items_document_id ON document_items(document_id)",
+		"CREATE INDEX IF NOT EXISTS idx_document_items_company_ncm ON document_items(company_id, ncm)",
+		"CREATE INDEX IF NOT EXISTS idx_document_items_company_cfop ON document_items(company_id, cfop)",
+		"CREATE INDEX IF NOT EXISTS idx_document_items_company_product_code ON document_items(company_id, product_code)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createPartiesTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS parties (
+			id BIGSERIAL PRIMARY KEY,
+			company_id BIGINT NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
+			tax_id VARCHAR(14) NOT NULL,
+			person_type VARCHAR(2) NOT NULL,
+			name VARCHAR(255),
+			trade_name VARCHAR(255),
+			municipal_registration VARCHAR(50),
+			state_registration VARCHAR(50),
+			address VARCHAR(255),
+			number VARCHAR(60),
+			complement VARCHAR(255),
+			district VARCHAR(120),
+			municipality_code VARCHAR(7),
+			city VARCHAR(120),
+			state VARCHAR(2),
+			zip_code VARCHAR(8),
+			phone VARCHAR(30),
+			email VARCHAR(255),
+			first_seen_at TIMESTAMP,
+			last_seen_at TIMESTAMP,
+			document_count BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// Upserts during ingestion rely on this index for ON CONFLICT
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_parties_company_tax_id ON parties(company_id, tax_id)",
+		"CREATE INDEX IF NOT EXISTS idx_parties_company_name ON parties(company_id, name)",
+		`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS provider_party_id BIGINT,
+			ADD COLUMN IF NOT EXISTS taker_party_id BIGINT`,
+		"ALTER TABLE documents DROP CONSTRAINT IF EXISTS fk_documents_provider_party",
+		`ALTER TABLE documents
+			ADD CONSTRAINT fk_documents_provider_party
+			FOREIGN KEY (provider_party_id) REFERENCES parties(id) ON DELETE SET NULL`,
+		"ALTER TABLE documents DROP CONSTRAINT IF EXISTS fk_documents_taker_party",
+		`ALTER TABLE documents
+			ADD CONSTRAINT fk_documents_taker_party
+			FOREIGN KEY (taker_party_id) REFERENCES parties(id) ON DELETE SET NULL`,
+		"CREATE INDEX IF NOT EXISTS idx_documents_provider_party_id ON documents(provider_party_id)",
+		"CREATE INDEX IF NOT EXISTS idx_documents_taker_party_id ON documents(taker_party_id)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addDocumentServiceItemColumns(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS service_item VARCHAR(5),
+			ADD COLUMN IF NOT EXISTS service_item_description TEXT,
+			ADD COLUMN IF NOT EXISTS cnae_description TEXT`,
+		"CREATE INDEX IF NOT EXISTS idx_documents_company_service_item ON documents(company_id, service_item)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addDocumentLocationColumns(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`ALTER TABLE documents
+			ADD COLUMN IF NOT EXISTS service_municipality_name VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS service_uf VARCHAR(2),
+			ADD COLUMN IF NOT EXISTS iss_municipality_code VARCHAR(7),
+			ADD COLUMN IF NOT EXISTS provider_municipality_code VARCHAR(7),
+			ADD COLUMN IF NOT EXISTS provider_municipality_name VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS provider_uf VARCHAR(2),
+			ADD COLUMN IF NOT EXISTS taker_municipality_code VARCHAR(7),
+			ADD COLUMN IF NOT EXISTS taker_municipality_name VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS taker_uf VARCHAR(2),
+			ADD COLUMN IF NOT EXISTS iss_due_outside_provider BOOLEAN DEFAULT false`,
+		"CREATE INDEX IF NOT EXISTS idx_documents_iss_due_outside_provider ON documents(company_id) WHERE iss_due_outside_provider = true",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createQuarantinedFilesTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS quarantined_files (
+			id BIGSERIAL PRIMARY KEY,
+			company_id BIGINT NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
+			file_name VARCHAR(255) NOT NULL,
+			source VARCHAR(50),
+			stage VARCHAR(20) NOT NULL,
+			error TEXT NOT NULL,
+			content_hash VARCHAR(64) NOT NULL,
+			storage_key VARCHAR(500) NOT NULL,
+			size BIGINT NOT NULL DEFAULT 0,
+			attempts BIGINT NOT NULL DEFAULT 1,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			document_id BIGINT REFERENCES documents(id) ON DELETE SET NULL,
+			failed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			reprocessed_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// Repeated failures of the same file update a single row through ON CONFLICT
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_quarantined_files_company_hash ON quarantined_files(company_id, content_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_quarantined_files_company_status ON quarantined_files(company_id, status, failed_at)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addDocumentUniqueIndexes(ctx context.Context, db *bun.DB) error {
+	// Existing duplicates would make the unique indexes fail. Merging them deletes documents, so it is left to
+	// an operator who can review the dry run first.
+	duplicates, err := CountDuplicateDocuments(ctx, db)
+	if err != nil {
+		return err
+	}
+	if duplicates > 0 {
+		return fmt.Errorf("%d duplicate documents must be merged before the unique document indexes are created: "+
+			"review them with `go run ./cmd/mergeduplicates`, merge them with `go run ./cmd/mergeduplicates -apply` and restart", duplicates)
+	}
+
+	// Partial indexes: documents without the key are not constrained. Inserts rely on them for ON CONFLICT.
+	for _, key := range documentUniqueKeys {
+		statement := fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON documents(%s) WHERE %s", key.Index, key.Columns, key.Predicate)
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createDocumentRevisionsTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS document_revisions (
+			id BIGSERIAL PRIMARY KEY,
+			document_id BIGINT NOT NULL,
+			company_id BIGINT NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
+			revision INTEGER NOT NULL,
+			content_hash VARCHAR(64),
+			storage_key VARCHAR(500),
+			file_name VARCHAR(255),
+			snapshot JSONB,
+			changed_fields JSONB,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// The table may already exist from AutoMigrate, which does not create foreign keys
+		"ALTER TABLE document_revisions DROP CONSTRAINT IF EXISTS fk_document_revisions_document",
+		`ALTER TABLE document_revisions
+			ADD CONSTRAINT fk_document_revisions_document
+			FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE`,
+		// Concurrent revisions of the same document conflict here instead of sharing a number
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_document_revisions_document_revision ON document_revisions(document_id, revision)",
+		"CREATE INDEX IF NOT EXISTS idx_document_revisions_company_id ON document_revisions(company_id)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createProcessedFilesTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS processed_files (
+			id BIGSERIAL PRIMARY KEY,
+			company_id BIGINT NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
+			file_name VARCHAR(255) NOT NULL,
+			file_hash VARCHAR(64) NOT NULL,
+			source VARCHAR(50),
+			size BIGINT NOT NULL DEFAULT 0,
+			status VARCHAR(20) NOT NULL DEFAULT 'processed',
+			error TEXT,
+			attempts BIGINT NOT NULL DEFAULT 1,
+			document_id BIGINT,
+			processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// The table may already exist from AutoMigrate, which does not create foreign keys.
+		// A deleted document frees its files to be ingested again.
+		"ALTER TABLE processed_files DROP CONSTRAINT IF EXISTS fk_processed_files_document",
+		`ALTER TABLE processed_files
+			ADD CONSTRAINT fk_processed_files_document
+			FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE SET NULL`,
+		// The ingestion pre-filter looks files up by hash; receiving the same file again updates its row through ON CONFLICT
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_processed_files_company_hash ON processed_files(company_id, file_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_processed_files_company_processed_at ON processed_files(company_id, processed_at)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addProcessedFileDuplicateMethod(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		"ALTER TABLE processed_files ADD COLUMN IF NOT EXISTS duplicate_method VARCHAR(30)",
+		// Ingestion statistics aggregate the outcomes of a period by status
+		"CREATE INDEX IF NOT EXISTS idx_processed_files_processed_at_status ON processed_files(processed_at, status)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createStorageScrubRunsTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS storage_scrub_runs (
+			id BIGSERIAL PRIMARY KEY,
+			status VARCHAR(20) NOT NULL DEFAULT 'running',
+			trigger VARCHAR(20) NOT NULL,
+			checked BIGINT NOT NULL DEFAULT 0,
+			verified BIGINT NOT NULL DEFAULT 0,
+			unverified BIGINT NOT NULL DEFAULT 0,
+			missing BIGINT NOT NULL DEFAULT 0,
+			mismatched BIGINT NOT NULL DEFAULT 0,
+			orphaned BIGINT NOT NULL DEFAULT 0,
+			failed BIGINT NOT NULL DEFAULT 0,
+			issues JSONB,
+			error TEXT,
+			started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_storage_scrub_runs_started_at ON storage_scrub_runs(started_at)",
+		// The scrubber and the integrity checks look documents up by storage key
+		"CREATE INDEX IF NOT EXISTS idx_documents_storage_key ON documents(storage_key)",
+		"CREATE INDEX IF NOT EXISTS idx_document_revisions_storage_key ON document_revisions(storage_key)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createExportJobsTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS export_jobs (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			company_id BIGINT REFERENCES companies(id) ON DELETE CASCADE,
+			filter JSONB,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			documents BIGINT NOT NULL DEFAULT 0,
+			missing BIGINT NOT NULL DEFAULT 0,
+			mismatched BIGINT NOT NULL DEFAULT 0,
+			size BIGINT NOT NULL DEFAULT 0,
+			file_name VARCHAR(255) NOT NULL,
+			storage_key VARCHAR(500),
+			content_hash VARCHAR(64),
+			error TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			started_at TIMESTAMP,
+			completed_at TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// The table may already exist from AutoMigrate, which does not create foreign keys
+		"ALTER TABLE export_jobs DROP CONSTRAINT IF EXISTS fk_export_jobs_user",
+		`ALTER TABLE export_jobs
+			ADD CONSTRAINT fk_export_jobs_user
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE`,
+		"CREATE INDEX IF NOT EXISTS idx_export_jobs_user_created_at ON export_jobs(user_id, created_at)",
+		"CREATE INDEX IF NOT EXISTS idx_export_jobs_storage_key ON export_jobs(storage_key)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addRetentionPoliciesAndLegalHold(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT false",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS legal_hold_reason TEXT",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS legal_hold_at TIMESTAMP",
+		"CREATE INDEX IF NOT EXISTS idx_documents_legal_hold ON documents(company_id) WHERE legal_hold",
+		`CREATE TABLE IF NOT EXISTS retention_policies (
+			id BIGSERIAL PRIMARY KEY,
+			company_id BIGINT REFERENCES companies(id) ON DELETE CASCADE,
+			document_type VARCHAR(20),
+			retention_years INTEGER NOT NULL,
+			description TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// One policy per scope; NULL company and empty type stand for all companies and all types
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_retention_policies_scope
+			ON retention_policies(COALESCE(company_id, 0), COALESCE(document_type, ''))`,
+		`CREATE TABLE IF NOT EXISTS retention_purge_runs (
+			id BIGSERIAL PRIMARY KEY,
+			status VARCHAR(20) NOT NULL DEFAULT 'running',
+			trigger VARCHAR(20) NOT NULL,
+			dry_run BOOLEAN NOT NULL DEFAULT true,
+			user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+			eligible BIGINT NOT NULL DEFAULT 0,
+			purged BIGINT NOT NULL DEFAULT 0,
+			held BIGINT NOT NULL DEFAULT 0,
+			failed BIGINT NOT NULL DEFAULT 0,
+			documents JSONB,
+			error TEXT,
+			started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_retention_purge_runs_started_at ON retention_purge_runs(started_at)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createDataKeysTable creates the table of the per-company data keys that encrypt stored XMLs
+func createDataKeysTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS data_keys (
+			id BIGSERIAL PRIMARY KEY,
+			key_id VARCHAR(64) NOT NULL UNIQUE,
+			company_id BIGINT REFERENCES companies(id) ON DELETE CASCADE,
+			wrapped_key BYTEA NOT NULL,
+			master_key_id VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			rewrapped_at TIMESTAMP
+		)`,
+		// One data key per company; NULL company is the shared key
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_data_keys_company ON data_keys(COALESCE(company_id, 0))",
+		"CREATE INDEX IF NOT EXISTS idx_data_keys_master_key_id ON data_keys(master_key_id)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createStorageReconcileRunsTable creates the storage reconciliation history and the flag of documents whose
+// XML is missing from storage
+func createStorageReconcileRunsTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS storage_missing_at TIMESTAMP",
+		"CREATE INDEX IF NOT EXISTS idx_documents_storage_missing ON documents(company_id) WHERE storage_missing_at IS NOT NULL",
+		`CREATE TABLE IF NOT EXISTS storage_reconcile_runs (
+			id BIGSERIAL PRIMARY KEY,
+			status VARCHAR(20) NOT NULL DEFAULT 'running',
+			trigger VARCHAR(20) NOT NULL,
+			policy VARCHAR(20) NOT NULL,
+			user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+			listed BIGINT NOT NULL DEFAULT 0,
+			orphaned BIGINT NOT NULL DEFAULT 0,
+			recent BIGINT NOT NULL DEFAULT 0,
+			reimported BIGINT NOT NULL DEFAULT 0,
+			deleted BIGINT NOT NULL DEFAULT 0,
+			unresolved BIGINT NOT NULL DEFAULT 0,
+			missing BIGINT NOT NULL DEFAULT 0,
+			restored BIGINT NOT NULL DEFAULT 0,
+			failed BIGINT NOT NULL DEFAULT 0,
+			items JSONB,
+			error TEXT,
+			started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_storage_reconcile_runs_started_at ON storage_reconcile_runs(started_at)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// convertDocumentMetadataToJSON replaces the raw XML kept as a JSON string in documents.metadata with the
+// normalized JSON tree of the document content, and indexes it for queries on any field. XMLs that cannot be
+// converted are dropped from the column; the original stays in storage.
+func convertDocumentMetadataToJSON(ctx context.Context, db *bun.DB) error {
+	type storedMetadata struct {
+		ID  int64  `bun:"id"`
+		XML string `bun:"xml"`
+	}
+
+	var lastID int64
+	converted, dropped := 0, 0
+	for {
+		var rows []storedMetadata
+		err := db.NewSelect().
+			Table("documents").
+			ColumnExpr("id, metadata #>> '{}' AS xml").
+			Where("jsonb_typeof(metadata) = 'string'").
+			Where("id > ?", lastID).
+			Order("id ASC").
+			Limit(500).
+			Scan(ctx, &rows)
+		if err != nil {
+			return err
+		}
+
+		if len(rows) == 0 {
+			break
+		}
+
+		for _, row := range rows {
+			lastID = row.ID
+
+			var metadata any
+			if tree, err := xmltree.Convert(row.XML, xmltree.InfoElements...); err == nil {
+				encoded, err := json.Marshal(tree)
+				if err != nil {
+					return err
+				}
+				metadata = string(encoded)
+				converted++
+			} else {
+				dropped++
+			}
+
+			_, err := db.NewUpdate().
+				Table("documents").
+				Set("metadata = ?::jsonb", metadata).
+				Where("id = ?", row.ID).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.InfoWithFields("Converted document metadata to JSON", map[string]any{
+		"operation": "convert_document_metadata",
+		"converted": converted,
+		"dropped":   dropped,
+	})
+
+	statements := []string{
+		"CREATE INDEX IF NOT EXISTS idx_documents_metadata ON documents USING GIN (metadata jsonb_path_ops)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reverifyInvalidSignatures clears the authenticity of documents marked invalid, so the authenticity backfill
+// verifies them again. Signed documents verified without a trust store were marked invalid instead of unverified.
+func reverifyInvalidSignatures(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		"UPDATE documents SET authenticity_status = NULL WHERE authenticity_status = 'invalid'",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createStorageDeletionsTable creates the XMLs of deleted documents still to be removed from storage.
+// Rows outlive their document and company, so there are no foreign keys.
+func createStorageDeletionsTable(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS storage_deletions (
+			id BIGSERIAL PRIMARY KEY,
+			storage_key VARCHAR(500) NOT NULL UNIQUE,
+			company_id BIGINT,
+			document_id BIGINT,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restrictDataKeyDeletion keeps a company with a data key from being deleted by the database alone, which would
+// leave its encrypted objects in storage with no key to read them. Company deletion removes the key itself,
+// after recording the company's XMLs for removal.
+func restrictDataKeyDeletion(ctx context.Context, db *bun.DB) error {
+	statements := []string{
+		"ALTER TABLE data_keys DROP CONSTRAINT IF EXISTS data_keys_company_id_fkey",
+		`ALTER TABLE data_keys
+			ADD CONSTRAINT data_keys_company_id_fkey
+			FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE RESTRICT`,
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+// Package dates parses the date and date-time values found in fiscal XML documents
+// (ABRASF, NFS-e Nacional and SEFAZ layouts) and normalizes them to Brazilian time zones.
+package dates
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // Brazilian zones must resolve even in images without /usr/share/zoneinfo
+)
+
+// DefaultTimezone is used when the municipality of a document is unknown
+const DefaultTimezone = "America/Sao_Paulo"
+
+// ErrEmpty is returned when the value to parse is blank
+var ErrEmpty = errors.New("empty date value")
+
+// zonedLayouts carry an explicit offset and are converted to the target location
+var zonedLayouts = []string{
+	time.RFC3339Nano,                  // 2024-01-15T10:30:00-03:00, 2024-01-15T10:30:00.123Z
+	"2006-01-02T15:04:05-0700",        // offset without colon
+	"2006-01-02 15:04:05-07:00",       // space separator with offset
+	"2006-01-02T15:04:05.999999-0700", // fractional seconds, offset without colon
+	"2006-01-02-07:00",                // xs:date with offset
+}
+
+// localLayouts have no offset and are interpreted in the target location
+var localLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+	"02/01/2006",
+	"20060102150405",
+	"20060102",
+}
+
+// ufTimezones maps the IBGE state code (first two digits of the municipality code) to its time zone
+var ufTimezones = map[string]string{
+	"11": "America/Porto_Velho",  // RO
+	"12": "America/Rio_Branco",   // AC
+	"13": "America/Manaus",       // AM
+	"14": "America/Boa_Vista",    // RR
+	"15": "America/Belem",        // PA
+	"16": "America/Belem",        // AP
+	"17": "America/Araguaina",    // TO
+	"21": "America/Fortaleza",    // MA
+	"22": "America/Fortaleza",    // PI
+	"23": "America/Fortaleza",    // CE
+	"24": "America/Fortaleza",    // RN
+	"25": "America/Fortaleza",    // PB
+	"26": "America/Recife",       // PE
+	"27": "America/Maceio",       // AL
+	"28": "America/Maceio",       // SE
+	"29": "America/Bahia",        // BA
+	"50": "America/Campo_Grande", // MS
+	"51": "America/Cuiaba",       // MT
+}
+
+// municipalityTimezones lists municipalities whose zone differs from the rest of their state
+var municipalityTimezones = map[string]string{
+	"2605459": "America/Noronha", // Fernando de Noronha (PE)
+}
+
+// Default returns the default location (America/Sao_Paulo)
+func Default() *time.Location {
+	return load(DefaultTimezone)
+}
+
+// LocationForMunicipality returns the time zone of an IBGE municipality code,
+// falling back to America/Sao_Paulo when the code is empty or unknown
+func LocationForMunicipality(code string) *time.Location {
+	code = strings.TrimSpace(code)
+	if name, ok := municipalityTimezones[code]; ok {
+		return load(name)
+	}
+	if len(code) >= 2 {
+		if name, ok := ufTimezones[code[:2]]; ok {
+			return load(name)
+		}
+	}
+	return Default()
+}
+
+// Parse parses a date or date-time in any of the supported layouts.
+// Values with an offset are converted to loc; values without one are interpreted in loc.
+func Parse(raw string, loc *time.Location) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, ErrEmpty
+	}
+	if loc == nil {
+		loc = Default()
+	}
+
+	for _, layout := range zonedLayouts {
+		if value, err := time.Parse(layout, raw); err == nil {
+			return value.In(loc), nil
+		}
+	}
+
+	for _, layout := range localLayouts {
+		if value, err := time.ParseInLocation(layout, raw, loc); err == nil {
+			return value, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognized date value %q", raw)
+}
+
+// load resolves a zone name, using a fixed UTC-3 offset if the zone database is unavailable
+func load(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.FixedZone("-03", -3*60*60)
+	}
+	return loc
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Labels identifica uma série de uma métrica
+type Labels map[string]string
+
+// Tipos de métrica do formato de exposição do Prometheus
+const (
+	typeGauge   = "gauge"
+	typeCounter = "counter"
+)
+
+// family agrupa as séries de uma métrica
+type family struct {
+	help   string
+	kind   string
+	series map[string]float64 // Valor por conjunto de labels já formatado
+}
+
+var (
+	mu       sync.Mutex
+	families = make(map[string]*family)
+)
+
+// SetGauge define o valor de uma série de um gauge
+func SetGauge(name, help string, labels Labels, value float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	metric(name, help, typeGauge).series[formatLabels(labels)] = value
+}
+
+// AddCounter soma delta a uma série de um contador
+func AddCounter(name, help string, labels Labels, delta float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	metric(name, help, typeCounter).series[formatLabels(labels)] += delta
+}
+
+// WriteText escreve as métricas no formato de texto do Prometheus, em ordem alfabética
+func WriteText(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := bufio.NewWriter(w)
+	for _, name := range names {
+		f := families[name]
+		fmt.Fprintf(out, "# HELP %s %s\n", name, f.help)
+		fmt.Fprintf(out, "# TYPE %s %s\n", name, f.kind)
+
+		labels := make([]string, 0, len(f.series))
+		for label := range f.series {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			fmt.Fprintf(out, "%s%s %s\n", name, label, strconv.FormatFloat(f.series[label], 'g', -1, 64))
+		}
+	}
+	return out.Flush()
+}
+
+// metric retorna a família com o nome informado, criando-a se necessário; mu deve estar bloqueado
+func metric(name, help, kind string) *family {
+	f, ok := families[name]
+	if !ok {
+		f = &family{help: help, kind: kind, series: make(map[string]float64)}
+		families[name] = f
+	}
+	return f
+}
+
+// formatLabels formata as labels como {a="1",b="2"}, em ordem alfabética
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, escaper.Replace(labels[key])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// DataKey representa a chave de dados de uma empresa, usada para criptografar os XMLs dela no storage.
+// A chave fica guardada cifrada (wrapped) pela chave mestra identificada em MasterKeyID; sem empresa, é a
+// chave compartilhada dos objetos que não pertencem a uma empresa.
+type DataKey struct {
+	bun.BaseModel `bun:"table:data_keys,alias:dk"`
+
+	ID          int64  `bun:"id,pk,autoincrement" json:"id"`
+	KeyID       string `bun:"key_id,notnull,unique" json:"key_id"` // Gravado nos metadados dos objetos
+	CompanyID   int64  `bun:"company_id,nullzero" json:"company_id,omitempty"`
+	WrappedKey  []byte `bun:"wrapped_key,notnull" json:"-"`
+	MasterKeyID string `bun:"master_key_id,notnull" json:"master_key_id"` // Impressão digital da chave mestra
+
+	CreatedAt   time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	RewrappedAt time.Time `bun:"rewrapped_at,nullzero" json:"rewrapped_at,omitempty"`
+}
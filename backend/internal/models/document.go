@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/uptrace/bun"
 )
 
@@ -11,31 +12,31 @@ import (
 type Document struct {
 	bun.BaseModel `bun:"table:documents,alias:d"`
 
-	ID         int64     `bun:"id,pk,autoincrement" json:"id"`
-	CompanyID  int64     `bun:"company_id,notnull" json:"company_id"`
-	Type       string    `bun:"type,notnull" json:"type"` // ex: 'NFSe', 'NFe', 'CTe'
-	Key        string    `bun:"key" json:"key,omitempty"` // Chave de acesso do documento
-	Number     string    `bun:"number" json:"number,omitempty"`
-	Series     string    `bun:"series" json:"series,omitempty"`
-	IssueDate  time.Time `bun:"issue_date" json:"issue_date,omitempty"`
-	DueDate    time.Time `bun:"due_date" json:"due_date,omitempty"`
-	Amount     float64   `bun:"amount" json:"amount,omitempty"`
-	Status     string    `bun:"status,notnull,default:'pending'" json:"status"` // 'pending', 'processed', 'error'
-	StorageKey string    `bun:"storage_key" json:"storage_key,omitempty"`       // Chave no MinIO/S3
-	Hash       string    `bun:"hash" json:"hash,omitempty"`                     // Hash do arquivo para verificação de integridade
-	Metadata   string    `bun:"metadata,type:jsonb" json:"metadata,omitempty"`  // Metadados adicionais em JSON
+	ID         int64           `bun:"id,pk,autoincrement" json:"id"`
+	CompanyID  int64           `bun:"company_id,notnull" json:"company_id"`
+	Type       string          `bun:"type,notnull" json:"type"` // ex: 'NFSe', 'NFe', 'CTe'
+	Key        string          `bun:"key" json:"key,omitempty"` // Chave de acesso do documento
+	Number     string          `bun:"number" json:"number,omitempty"`
+	Series     string          `bun:"series" json:"series,omitempty"`
+	IssueDate  time.Time       `bun:"issue_date" json:"issue_date,omitempty"`
+	DueDate    time.Time       `bun:"due_date" json:"due_date,omitempty"`
+	Amount     decimal.Decimal `bun:"amount,type:numeric(15,2)" json:"amount"`
+	Status     string          `bun:"status,notnull,default:'pending'" json:"status"`         // 'pending', 'processed', 'error'
+	StorageKey string          `bun:"storage_key" json:"storage_key,omitempty"`               // Chave no MinIO/S3
+	Hash       string          `bun:"hash" json:"hash,omitempty"`                             // Hash do arquivo para verificação de integridade
+	Metadata   map[string]any  `bun:"metadata,type:jsonb,nullzero" json:"metadata,omitempty"` // Conteúdo do InfNfse (ou infNFe/infCte) normalizado em JSON
 
 	// NFSe specific fields for intelligent deduplication
-	VerificationCode      string    `bun:"verification_code" json:"verification_code,omitempty"`
-	ProviderCNPJ          string    `bun:"provider_cnpj" json:"provider_cnpj,omitempty"`
-	TakerCNPJ             string    `bun:"taker_cnpj" json:"taker_cnpj,omitempty"`
-	ServiceValue          float64   `bun:"service_value" json:"service_value,omitempty"`
-	ServiceCode           string    `bun:"service_code" json:"service_code,omitempty"`
-	MunicipalRegistration string    `bun:"municipal_registration" json:"municipal_registration,omitempty"`
-	DocumentHash          string    `bun:"document_hash" json:"document_hash,omitempty"`
-	IsCancelled           bool      `bun:"is_cancelled,default:false" json:"is_cancelled"`
-	IsSubstituted         bool      `bun:"is_substituted,default:false" json:"is_substituted"`
-	ProcessingDate        time.Time `bun:"processing_date" json:"processing_date,omitempty"`
+	VerificationCode      string          `bun:"verification_code" json:"verification_code,omitempty"`
+	ProviderCNPJ          string          `bun:"provider_cnpj" json:"provider_cnpj,omitempty"`
+	TakerCNPJ             string          `bun:"taker_cnpj" json:"taker_cnpj,omitempty"`
+	ServiceValue          decimal.Decimal `bun:"service_value,type:numeric(15,2)" json:"service_value"`
+	ServiceCode           string          `bun:"service_code" json:"service_code,omitempty"`
+	MunicipalRegistration string          `bun:"municipal_registration" json:"municipal_registration,omitempty"`
+	DocumentHash          string          `bun:"document_hash" json:"document_hash,omitempty"`
+	IsCancelled           bool            `bun:"is_cancelled,default:false" json:"is_cancelled"`
+	IsSubstituted         bool            `bun:"is_substituted,default:false" json:"is_substituted"`
+	ProcessingDate        time.Time       `bun:"processing_date" json:"processing_date,omitempty"`
 
 	// Additional important NFSe fields
 	Competence        string    `bun:"competence" json:"competence,omitempty"`
@@ -44,24 +45,101 @@ type Document struct {
 	ProviderName      string    `bun:"provider_name" json:"provider_name,omitempty"`
 	ProviderTradeName string    `bun:"provider_trade_name" json:"provider_trade_name,omitempty"`
 
+	// Localização (códigos IBGE)
+	ServiceMunicipalityName  string `bun:"service_municipality_name" json:"service_municipality_name,omitempty"`
+	ServiceUF                string `bun:"service_uf" json:"service_uf,omitempty"`
+	IssMunicipalityCode      string `bun:"iss_municipality_code" json:"iss_municipality_code,omitempty"` // Município de incidência do ISS
+	ProviderMunicipalityCode string `bun:"provider_municipality_code" json:"provider_municipality_code,omitempty"`
+	ProviderMunicipalityName string `bun:"provider_municipality_name" json:"provider_municipality_name,omitempty"`
+	ProviderUF               string `bun:"provider_uf" json:"provider_uf,omitempty"`
+	TakerMunicipalityCode    string `bun:"taker_municipality_code" json:"taker_municipality_code,omitempty"`
+	TakerMunicipalityName    string `bun:"taker_municipality_name" json:"taker_municipality_name,omitempty"`
+	TakerUF                  string `bun:"taker_uf" json:"taker_uf,omitempty"`
+	IssDueOutsideProvider    bool   `bun:"iss_due_outside_provider,default:false" json:"iss_due_outside_provider"` // ISS devido fora do município do prestador
+
+	// Participantes no cadastro de contrapartes
+	ProviderPartyID *int64 `bun:"provider_party_id" json:"provider_party_id,omitempty"`
+	TakerPartyID    *int64 `bun:"taker_party_id" json:"taker_party_id,omitempty"`
+
+	// Tax values (ABRASF Servico/Valores)
+	DeductionsValue       decimal.Decimal `bun:"deductions_value,type:numeric(15,2)" json:"deductions_value"`
+	PisValue              decimal.Decimal `bun:"pis_value,type:numeric(15,2)" json:"pis_value"`
+	CofinsValue           decimal.Decimal `bun:"cofins_value,type:numeric(15,2)" json:"cofins_value"`
+	InssValue             decimal.Decimal `bun:"inss_value,type:numeric(15,2)" json:"inss_value"`
+	IrValue               decimal.Decimal `bun:"ir_value,type:numeric(15,2)" json:"ir_value"`
+	CsllValue             decimal.Decimal `bun:"csll_value,type:numeric(15,2)" json:"csll_value"`
+	IssWithheld           bool            `bun:"iss_withheld,default:false" json:"iss_withheld"`
+	IssValue              decimal.Decimal `bun:"iss_value,type:numeric(15,2)" json:"iss_value"`
+	OtherWithholdings     decimal.Decimal `bun:"other_withholdings,type:numeric(15,2)" json:"other_withholdings"`
+	CalculationBase       decimal.Decimal `bun:"calculation_base,type:numeric(15,2)" json:"calculation_base"`
+	IssRate               decimal.Decimal `bun:"iss_rate,type:numeric(7,4)" json:"iss_rate"` // Alíquota do ISS
+	NetValue              decimal.Decimal `bun:"net_value,type:numeric(15,2)" json:"net_value"`
+	ConditionalDiscount   decimal.Decimal `bun:"conditional_discount,type:numeric(15,2)" json:"conditional_discount"`
+	UnconditionalDiscount decimal.Decimal `bun:"unconditional_discount,type:numeric(15,2)" json:"unconditional_discount"`
+
+	// Service classification
+	CnaeCode                string `bun:"cnae_code" json:"cnae_code,omitempty"`
+	OperationNature         string `bun:"operation_nature" json:"operation_nature,omitempty"` // Natureza da operação
+	SimplesNacionalOptant   bool   `bun:"simples_nacional_optant,default:false" json:"simples_nacional_optant"`
+	ServiceDescription      string `bun:"service_description" json:"service_description,omitempty"` // Discriminação do serviço
+	ServiceMunicipalityCode string `bun:"service_municipality_code" json:"service_municipality_code,omitempty"`
+	ServiceItem             string `bun:"service_item" json:"service_item,omitempty"` // Subitem da LC 116/2003 normalizado (ex: 01.07)
+	ServiceItemDescription  string `bun:"service_item_description" json:"service_item_description,omitempty"`
+	CnaeDescription         string `bun:"cnae_description" json:"cnae_description,omitempty"`
+
+	// Validação estrutural (XSD)
+	ValidationStatus string            `bun:"validation_status" json:"validation_status,omitempty"` // 'valid', 'invalid', 'skipped'
+	ValidationErrors []ValidationIssue `bun:"validation_errors,type:jsonb,nullzero" json:"validation_errors,omitempty"`
+
+	// Autenticidade (assinatura XMLDSig)
+	AuthenticityStatus      string `bun:"authenticity_status" json:"authenticity_status,omitempty"` // 'valid', 'invalid', 'unsigned', 'unverified'
+	SignerCNPJ              string `bun:"signer_cnpj" json:"signer_cnpj,omitempty"`
+	SignerCertificateSerial string `bun:"signer_certificate_serial" json:"signer_certificate_serial,omitempty"`
+
+	// Retenção legal (legal hold): impede a remoção do documento, inclusive pelo expurgo
+	LegalHold       bool      `bun:"legal_hold,notnull,default:false" json:"legal_hold"`
+	LegalHoldReason string    `bun:"legal_hold_reason" json:"legal_hold_reason,omitempty"`
+	LegalHoldAt     time.Time `bun:"legal_hold_at,nullzero" json:"legal_hold_at,omitempty"`
+
+	// Marcado pela reconciliação do storage quando o XML do documento não está no bucket
+	StorageMissingAt time.Time `bun:"storage_missing_at,nullzero" json:"storage_missing_at,omitempty"`
+
 	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
 	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
 
 	// Relacionamentos
-	Company *Company `bun:"rel:belongs-to,join:company_id=id" json:"company,omitempty"`
+	Company       *Company        `bun:"rel:belongs-to,join:company_id=id" json:"company,omitempty"`
+	Items         []*DocumentItem `bun:"rel:has-many,join:id=document_id" json:"items,omitempty"`
+	ProviderParty *Party          `bun:"rel:belongs-to,join:provider_party_id=id" json:"provider_party,omitempty"`
+	TakerParty    *Party          `bun:"rel:belongs-to,join:taker_party_id=id" json:"taker_party,omitempty"`
+}
+
+// ValidationIssue representa um erro de validação estrutural do XML de um documento
+type ValidationIssue struct {
+	Code    string `json:"code"`
+	Path    string `json:"path,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
 }
 
 // ProcessedFile tracks files that have been processed to avoid reprocessing
 type ProcessedFile struct {
-	ID          int64     `bun:",pk,autoincrement" json:"id"`
-	CompanyID   int64     `bun:",notnull" json:"company_id"`
-	FileName    string    `bun:",notnull" json:"file_name"`
-	FileHash    string    `bun:",notnull" json:"file_hash"`
-	ProcessedAt time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"processed_at"`
-	Status      string    `bun:",notnull,default:'processed'" json:"status"` // processed, duplicate, error
-	DocumentID  *int64    `bun:"" json:"document_id,omitempty"`              // Reference to created document if any
-	CreatedAt   time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
-	UpdatedAt   time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+	bun.BaseModel `bun:"table:processed_files,alias:pf"`
+
+	ID              int64     `bun:"id,pk,autoincrement" json:"id"`
+	CompanyID       int64     `bun:"company_id,notnull" json:"company_id"`
+	FileName        string    `bun:"file_name,notnull" json:"file_name"`
+	FileHash        string    `bun:"file_hash,notnull" json:"file_hash"`                 // SHA-256 do XML
+	Source          string    `bun:"source" json:"source,omitempty"`                     // Provedor de origem (ex: 'prefeitura_moderna')
+	Size            int64     `bun:"size,notnull,default:0" json:"size"`                 // Tamanho do XML em bytes
+	Status          string    `bun:"status,notnull,default:'processed'" json:"status"`   // processed, duplicate, revised, error, quarantined
+	Error           string    `bun:"error" json:"error,omitempty"`                       // Erro do último processamento
+	DuplicateMethod string    `bun:"duplicate_method" json:"duplicate_method,omitempty"` // Critério que identificou a duplicata
+	Attempts        int64     `bun:"attempts,notnull,default:1" json:"attempts"`         // Quantidade de vezes que o arquivo foi recebido
+	DocumentID      int64     `bun:"document_id,nullzero" json:"document_id,omitempty"`  // Reference to created document if any
+	ProcessedAt     time.Time `bun:"processed_at,nullzero,notnull,default:current_timestamp" json:"processed_at"`
+	CreatedAt       time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt       time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
 
 	// Relacionamentos
 	Company  *Company  `bun:"rel:belongs-to,join:company_id=id" json:"company,omitempty"`
@@ -94,3 +172,16 @@ func (d *Document) BeforeAppendModel(ctx context.Context, query bun.Query) error
 	}
 	return nil
 }
+
+// BeforeAppendModel hook para atualizar timestamps
+func (pf *ProcessedFile) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		pf.ProcessedAt = time.Now()
+		pf.CreatedAt = time.Now()
+		pf.UpdatedAt = time.Now()
+	case *bun.UpdateQuery:
+		pf.UpdatedAt = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/uptrace/bun"
+)
+
+// DocumentItem representa um item (det) de uma NF-e, com produto, classificação fiscal e tributos
+type DocumentItem struct {
+	bun.BaseModel `bun:"table:document_items,alias:di"`
+
+	ID         int64 `bun:"id,pk,autoincrement" json:"id"`
+	DocumentID int64 `bun:"document_id,notnull" json:"document_id"`
+	CompanyID  int64 `bun:"company_id,notnull" json:"company_id"`
+	ItemNumber int   `bun:"item_number,notnull" json:"item_number"` // nItem
+
+	// Produto
+	ProductCode string          `bun:"product_code" json:"product_code,omitempty"` // cProd
+	GTIN        string          `bun:"gtin" json:"gtin,omitempty"`                 // cEAN
+	Description string          `bun:"description" json:"description,omitempty"`   // xProd
+	NCM         string          `bun:"ncm" json:"ncm,omitempty"`
+	CEST        string          `bun:"cest" json:"cest,omitempty"`
+	CFOP        string          `bun:"cfop" json:"cfop,omitempty"`
+	Unit        string          `bun:"unit" json:"unit,omitempty"` // uCom
+	Quantity    decimal.Decimal `bun:"quantity,type:numeric(15,4),notnull,default:0" json:"quantity"`
+	UnitPrice   decimal.Decimal `bun:"unit_price,type:numeric(21,10),notnull,default:0" json:"unit_price"`
+	TotalValue  decimal.Decimal `bun:"total_value,type:numeric(15,2),notnull,default:0" json:"total_value"` // vProd
+	Discount    decimal.Decimal `bun:"discount,type:numeric(15,2),notnull,default:0" json:"discount"`
+	Freight     decimal.Decimal `bun:"freight,type:numeric(15,2),notnull,default:0" json:"freight"`
+	Insurance   decimal.Decimal `bun:"insurance,type:numeric(15,2),notnull,default:0" json:"insurance"`
+	OtherCosts  decimal.Decimal `bun:"other_costs,type:numeric(15,2),notnull,default:0" json:"other_costs"`
+
+	// ICMS
+	ICMSOrigin  string          `bun:"icms_origin" json:"icms_origin,omitempty"`
+	ICMSCST     string          `bun:"icms_cst" json:"icms_cst,omitempty"` // CST ou CSOSN (Simples Nacional)
+	ICMSBase    decimal.Decimal `bun:"icms_base,type:numeric(15,2),notnull,default:0" json:"icms_base"`
+	ICMSRate    decimal.Decimal `bun:"icms_rate,type:numeric(7,4),notnull,default:0" json:"icms_rate"`
+	ICMSValue   decimal.Decimal `bun:"icms_value,type:numeric(15,2),notnull,default:0" json:"icms_value"`
+	ICMSSTValue decimal.Decimal `bun:"icms_st_value,type:numeric(15,2),notnull,default:0" json:"icms_st_value"`
+
+	// IPI
+	IPICST   string          `bun:"ipi_cst" json:"ipi_cst,omitempty"`
+	IPIBase  decimal.Decimal `bun:"ipi_base,type:numeric(15,2),notnull,default:0" json:"ipi_base"`
+	IPIRate  decimal.Decimal `bun:"ipi_rate,type:numeric(7,4),notnull,default:0" json:"ipi_rate"`
+	IPIValue decimal.Decimal `bun:"ipi_value,type:numeric(15,2),notnull,default:0" json:"ipi_value"`
+
+	// PIS
+	PISCST   string          `bun:"pis_cst" json:"pis_cst,omitempty"`
+	PISBase  decimal.Decimal `bun:"pis_base,type:numeric(15,2),notnull,default:0" json:"pis_base"`
+	PISRate  decimal.Decimal `bun:"pis_rate,type:numeric(7,4),notnull,default:0" json:"pis_rate"`
+	PISValue decimal.Decimal `bun:"pis_value,type:numeric(15,2),notnull,default:0" json:"pis_value"`
+
+	// COFINS
+	COFINSCST   string          `bun:"cofins_cst" json:"cofins_cst,omitempty"`
+	COFINSBase  decimal.Decimal `bun:"cofins_base,type:numeric(15,2),notnull,default:0" json:"cofins_base"`
+	COFINSRate  decimal.Decimal `bun:"cofins_rate,type:numeric(7,4),notnull,default:0" json:"cofins_rate"`
+	COFINSValue decimal.Decimal `bun:"cofins_value,type:numeric(15,2),notnull,default:0" json:"cofins_value"`
+
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+
+	// Relacionamentos
+	Document *Document `bun:"rel:belongs-to,join:document_id=id" json:"document,omitempty"`
+}
+
+// BeforeAppendModel hook para atualizar timestamps
+func (di *DocumentItem) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		di.CreatedAt = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// DocumentRevision representa uma versão recebida de um documento cujo conteúdo difere das anteriores
+// (cancelamento, substituição, correção de dados do tomador etc.)
+type DocumentRevision struct {
+	bun.BaseModel `bun:"table:document_revisions,alias:dr"`
+
+	ID            int64             `bun:"id,pk,autoincrement" json:"id"`
+	DocumentID    int64             `bun:"document_id,notnull" json:"document_id"`
+	CompanyID     int64             `bun:"company_id,notnull" json:"company_id"`
+	Revision      int               `bun:"revision,notnull" json:"revision"`           // Sequencial por documento, a partir de 1
+	ContentHash   string            `bun:"content_hash" json:"content_hash,omitempty"` // SHA-256 do XML
+	StorageKey    string            `bun:"storage_key" json:"storage_key,omitempty"`   // Chave do XML desta versão no MinIO/S3
+	FileName      string            `bun:"file_name" json:"file_name,omitempty"`
+	Snapshot      map[string]string `bun:"snapshot,type:jsonb" json:"snapshot"`                                // Campos do documento nesta versão
+	ChangedFields []string          `bun:"changed_fields,type:jsonb,nullzero" json:"changed_fields,omitempty"` // Campos alterados em relação à versão anterior
+	CreatedAt     time.Time         `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+
+	// Relacionamentos
+	Document *Document `bun:"rel:belongs-to,join:document_id=id" json:"document,omitempty"`
+}
+
+// BeforeAppendModel hook para definir timestamp
+func (dr *DocumentRevision) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		dr.CreatedAt = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// ExportJob representa uma exportação em ZIP dos XMLs de documentos, gerada em segundo plano
+type ExportJob struct {
+	bun.BaseModel `bun:"table:export_jobs,alias:ej"`
+
+	ID          int64           `bun:"id,pk,autoincrement" json:"id"`
+	UserID      int64           `bun:"user_id,notnull" json:"user_id"`
+	CompanyID   int64           `bun:"company_id,nullzero" json:"company_id,omitempty"` // Empresa do filtro, quando informada
+	Filter      json.RawMessage `bun:"filter,type:jsonb" json:"filter" swaggertype:"object"`
+	Status      string          `bun:"status,notnull,default:'pending'" json:"status"` // 'pending', 'running', 'completed' ou 'failed'
+	Documents   int64           `bun:"documents,notnull,default:0" json:"documents"`   // Documentos incluídos no ZIP
+	Missing     int64           `bun:"missing,notnull,default:0" json:"missing"`       // Documentos cujo XML não foi encontrado no storage
+	Mismatched  int64           `bun:"mismatched,notnull,default:0" json:"mismatched"` // XMLs que não conferem com o hash registrado
+	Size        int64           `bun:"size,notnull,default:0" json:"size"`             // Tamanho do ZIP em bytes
+	FileName    string          `bun:"file_name,notnull" json:"file_name"`
+	StorageKey  string          `bun:"storage_key" json:"storage_key,omitempty"`   // Chave do ZIP no storage
+	ContentHash string          `bun:"content_hash" json:"content_hash,omitempty"` // SHA-256 do ZIP
+	Error       string          `bun:"error" json:"error,omitempty"`
+
+	CreatedAt   time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	StartedAt   time.Time `bun:"started_at,nullzero" json:"started_at,omitempty"`
+	CompletedAt time.Time `bun:"completed_at,nullzero" json:"completed_at,omitempty"`
+	UpdatedAt   time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+
+	// Relacionamentos
+	User *User `bun:"rel:belongs-to,join:user_id=id" json:"user,omitempty"`
+}
+
+// BeforeAppendModel hook para atualizar timestamps
+func (ej *ExportJob) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		ej.CreatedAt = time.Now()
+		ej.UpdatedAt = time.Now()
+	case *bun.UpdateQuery:
+		ej.UpdatedAt = time.Now()
+	}
+	return nil
+}
@@ -1,9 +1,15 @@
 package models
 
 import (
+	"github.com/shopspring/decimal"
 	"github.com/uptrace/bun"
 )
 
+func init() {
+	// Serializar valores monetários como números JSON, mantendo o formato da API
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
 // RegisterModels registra todos os modelos no banco de dados
 func RegisterModels(db *bun.DB) {
 	db.RegisterModel(
@@ -12,6 +18,18 @@ func RegisterModels(db *bun.DB) {
 		(*CompanyMember)(nil),
 		(*CompanyCredential)(nil),
 		(*Document)(nil),
+		(*DocumentItem)(nil),
+		(*Party)(nil),
+		(*QuarantinedFile)(nil),
+		(*DocumentRevision)(nil),
+		(*ProcessedFile)(nil),
+		(*StorageScrubRun)(nil),
+		(*ExportJob)(nil),
+		(*RetentionPolicy)(nil),
+		(*RetentionPurgeRun)(nil),
+		(*DataKey)(nil),
+		(*StorageReconcileRun)(nil),
+		(*StorageDeletion)(nil),
 		(*AuditLog)(nil),
 	)
 }
@@ -24,6 +42,18 @@ func GetAllModels() []interface{} {
 		(*CompanyMember)(nil),
 		(*CompanyCredential)(nil),
 		(*Document)(nil),
+		(*DocumentItem)(nil),
+		(*Party)(nil),
+		(*QuarantinedFile)(nil),
+		(*DocumentRevision)(nil),
+		(*ProcessedFile)(nil),
+		(*StorageScrubRun)(nil),
+		(*ExportJob)(nil),
+		(*RetentionPolicy)(nil),
+		(*RetentionPurgeRun)(nil),
+		(*DataKey)(nil),
+		(*StorageReconcileRun)(nil),
+		(*StorageDeletion)(nil),
 		(*AuditLog)(nil),
 	}
 }
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Party representa um participante (prestador, tomador, emitente ou destinatário) visto nos documentos de uma empresa
+type Party struct {
+	bun.BaseModel `bun:"table:parties,alias:pt"`
+
+	ID         int64  `bun:"id,pk,autoincrement" json:"id"`
+	CompanyID  int64  `bun:"company_id,notnull,unique:parties_company_tax_id" json:"company_id"`
+	TaxID      string `bun:"tax_id,notnull,unique:parties_company_tax_id" json:"tax_id"` // CNPJ ou CPF, apenas dígitos
+	PersonType string `bun:"person_type,notnull" json:"person_type"`                     // 'pj' ou 'pf'
+	Name       string `bun:"name" json:"name,omitempty"`
+	TradeName  string `bun:"trade_name" json:"trade_name,omitempty"`
+
+	// Inscrições
+	MunicipalRegistration string `bun:"municipal_registration" json:"municipal_registration,omitempty"`
+	StateRegistration     string `bun:"state_registration" json:"state_registration,omitempty"`
+
+	// Endereço
+	Address          string `bun:"address" json:"address,omitempty"`
+	Number           string `bun:"number" json:"number,omitempty"`
+	Complement       string `bun:"complement" json:"complement,omitempty"`
+	District         string `bun:"district" json:"district,omitempty"`
+	MunicipalityCode string `bun:"municipality_code" json:"municipality_code,omitempty"` // Código IBGE
+	City             string `bun:"city" json:"city,omitempty"`
+	State            string `bun:"state" json:"state,omitempty"`
+	ZipCode          string `bun:"zip_code" json:"zip_code,omitempty"`
+
+	// Contato
+	Phone string `bun:"phone" json:"phone,omitempty"`
+	Email string `bun:"email" json:"email,omitempty"`
+
+	// Histórico nos documentos
+	FirstSeenAt   time.Time `bun:"first_seen_at,nullzero" json:"first_seen_at,omitempty"` // Data de emissão do documento mais antigo
+	LastSeenAt    time.Time `bun:"last_seen_at,nullzero" json:"last_seen_at,omitempty"`   // Data de emissão do documento mais recente
+	DocumentCount int64     `bun:"document_count,notnull,default:0" json:"document_count"`
+
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+
+	// Relacionamentos
+	Company *Company `bun:"rel:belongs-to,join:company_id=id" json:"company,omitempty"`
+}
+
+// BeforeAppendModel hook para atualizar timestamps
+func (p *Party) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		p.CreatedAt = time.Now()
+		p.UpdatedAt = time.Now()
+	case *bun.UpdateQuery:
+		p.UpdatedAt = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// QuarantinedFile representa um XML recebido que não pôde ser validado ou interpretado,
+// guardado na área de quarentena do storage para reprocessamento
+type QuarantinedFile struct {
+	bun.BaseModel `bun:"table:quarantined_files,alias:qf"`
+
+	ID          int64  `bun:"id,pk,autoincrement" json:"id"`
+	CompanyID   int64  `bun:"company_id,notnull" json:"company_id"`
+	FileName    string `bun:"file_name,notnull" json:"file_name"`
+	Source      string `bun:"source" json:"source,omitempty"`                    // Provedor de origem (ex: 'prefeitura_moderna')
+	Stage       string `bun:"stage,notnull" json:"stage"`                        // Etapa da falha: 'validation' ou 'parse'
+	Error       string `bun:"error,notnull" json:"error"`                        // Erro da última tentativa
+	ContentHash string `bun:"content_hash,notnull" json:"content_hash"`          // SHA-256 do XML
+	StorageKey  string `bun:"storage_key,notnull" json:"storage_key"`            // Chave do XML na área de quarentena
+	Size        int64  `bun:"size,notnull,default:0" json:"size"`                // Tamanho do XML em bytes
+	Attempts    int64  `bun:"attempts,notnull,default:1" json:"attempts"`        // Quantidade de vezes que o arquivo falhou
+	Status      string `bun:"status,notnull,default:'pending'" json:"status"`    // 'pending' ou 'reprocessed'
+	DocumentID  int64  `bun:"document_id,nullzero" json:"document_id,omitempty"` // Documento gerado pelo reprocessamento
+
+	FailedAt      time.Time `bun:"failed_at,nullzero,notnull,default:current_timestamp" json:"failed_at"` // Data da última falha
+	ReprocessedAt time.Time `bun:"reprocessed_at,nullzero" json:"reprocessed_at,omitempty"`
+	CreatedAt     time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt     time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+
+	// Relacionamentos
+	Company  *Company  `bun:"rel:belongs-to,join:company_id=id" json:"company,omitempty"`
+	Document *Document `bun:"rel:belongs-to,join:document_id=id" json:"document,omitempty"`
+}
+
+// BeforeAppendModel hook para atualizar timestamps
+func (qf *QuarantinedFile) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		qf.CreatedAt = time.Now()
+		qf.UpdatedAt = time.Now()
+	case *bun.UpdateQuery:
+		qf.UpdatedAt = time.Now()
+	}
+	return nil
+}
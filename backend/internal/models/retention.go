@@ -0,0 +1,82 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// RetentionPolicy define por quantos anos os documentos de uma empresa e de um tipo são guardados.
+// Sem empresa a política vale para todas as empresas; sem tipo, para todos os tipos.
+type RetentionPolicy struct {
+	bun.BaseModel `bun:"table:retention_policies,alias:rp"`
+
+	ID             int64  `bun:"id,pk,autoincrement" json:"id"`
+	CompanyID      int64  `bun:"company_id,nullzero" json:"company_id,omitempty"`
+	DocumentType   string `bun:"document_type" json:"document_type,omitempty"` // ex: 'nfse', 'nfe', 'cte'
+	RetentionYears int    `bun:"retention_years,notnull" json:"retention_years"`
+	Description    string `bun:"description" json:"description,omitempty"`
+
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+
+	// Relacionamentos
+	Company *Company `bun:"rel:belongs-to,join:company_id=id" json:"company,omitempty"`
+}
+
+// BeforeAppendModel hook para atualizar timestamps
+func (rp *RetentionPolicy) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		rp.CreatedAt = time.Now()
+		rp.UpdatedAt = time.Now()
+	case *bun.UpdateQuery:
+		rp.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// RetentionPurgeRun representa uma execução do expurgo de documentos com a retenção vencida.
+// Em uma simulação (dry run) nada é removido e Documents lista o que seria expurgado.
+type RetentionPurgeRun struct {
+	bun.BaseModel `bun:"table:retention_purge_runs,alias:rpr"`
+
+	ID        int64                `bun:"id,pk,autoincrement" json:"id"`
+	Status    string               `bun:"status,notnull,default:'running'" json:"status"` // 'running', 'completed' ou 'failed'
+	Trigger   string               `bun:"trigger,notnull" json:"trigger"`                 // 'scheduled' ou 'manual'
+	DryRun    bool                 `bun:"dry_run,notnull,default:true" json:"dry_run"`
+	UserID    int64                `bun:"user_id,nullzero" json:"user_id,omitempty"`  // Quem iniciou o expurgo manual
+	Eligible  int64                `bun:"eligible,notnull,default:0" json:"eligible"` // Documentos com a retenção vencida
+	Purged    int64                `bun:"purged,notnull,default:0" json:"purged"`     // Documentos removidos com os XMLs
+	Held      int64                `bun:"held,notnull,default:0" json:"held"`         // Vencidos, mas em retenção legal (legal hold)
+	Failed    int64                `bun:"failed,notnull,default:0" json:"failed"`     // Documentos que não puderam ser removidos
+	Documents []RetentionPurgeItem `bun:"documents,type:jsonb,nullzero" json:"documents,omitempty"`
+	Error     string               `bun:"error" json:"error,omitempty"`
+
+	StartedAt  time.Time `bun:"started_at,nullzero,notnull,default:current_timestamp" json:"started_at"`
+	FinishedAt time.Time `bun:"finished_at,nullzero" json:"finished_at,omitempty"`
+}
+
+// RetentionPurgeItem representa um documento vencido encontrado pelo expurgo
+type RetentionPurgeItem struct {
+	DocumentID    int64     `json:"document_id"`
+	CompanyID     int64     `json:"company_id"`
+	Type          string    `json:"type"`
+	Number        string    `json:"number,omitempty"`
+	IssueDate     time.Time `json:"issue_date,omitempty"`
+	PolicyID      int64     `json:"policy_id"`
+	RetainedUntil time.Time `json:"retained_until"`
+	StorageKey    string    `json:"storage_key,omitempty"`
+	Result        string    `json:"result"` // 'eligible', 'purged', 'held' ou 'failed'
+	Error         string    `json:"error,omitempty"`
+}
+
+// BeforeAppendModel hook para definir timestamp
+func (rpr *RetentionPurgeRun) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		rpr.StartedAt = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// StorageDeletion representa um XML de documento removido cuja exclusão do storage ainda não foi confirmada.
+// É gravado na mesma transação da remoção do documento; a reconciliação do storage tenta removê-lo de novo e
+// nunca o reimporta.
+type StorageDeletion struct {
+	bun.BaseModel `bun:"table:storage_deletions,alias:sd"`
+
+	ID         int64     `bun:"id,pk,autoincrement" json:"id"`
+	StorageKey string    `bun:"storage_key,notnull,unique" json:"storage_key"`
+	CompanyID  int64     `bun:"company_id" json:"company_id"`
+	DocumentID int64     `bun:"document_id" json:"document_id"` // Documento removido
+	Attempts   int       `bun:"attempts,notnull,default:0" json:"attempts"`
+	LastError  string    `bun:"last_error" json:"last_error,omitempty"`
+	CreatedAt  time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// BeforeAppendModel hook para definir timestamp
+func (sd *StorageDeletion) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		sd.CreatedAt = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// StorageReconcileRun representa uma reconciliação do storage com o banco: XMLs no bucket sem documento,
+// reimportados, removidos ou apenas relatados conforme a política, e documentos cujo XML sumiu do storage
+type StorageReconcileRun struct {
+	bun.BaseModel `bun:"table:storage_reconcile_runs,alias:srr"`
+
+	ID         int64                  `bun:"id,pk,autoincrement" json:"id"`
+	Status     string                 `bun:"status,notnull,default:'running'" json:"status"` // 'running', 'completed' ou 'failed'
+	Trigger    string                 `bun:"trigger,notnull" json:"trigger"`                 // 'scheduled' ou 'manual'
+	Policy     string                 `bun:"policy,notnull" json:"policy"`                   // 'report', 'reimport' ou 'delete'
+	UserID     int64                  `bun:"user_id,nullzero" json:"user_id,omitempty"`      // Quem iniciou a reconciliação manual
+	Listed     int64                  `bun:"listed,notnull,default:0" json:"listed"`         // Objetos listados nos prefixos de documentos
+	Orphaned   int64                  `bun:"orphaned,notnull,default:0" json:"orphaned"`     // Objetos sem documento, revisão, quarentena ou exportação
+	Recent     int64                  `bun:"recent,notnull,default:0" json:"recent"`         // Órfãos gravados há pouco, ignorados até a próxima execução
+	Reimported int64                  `bun:"reimported,notnull,default:0" json:"reimported"` // Órfãos importados como documentos
+	Deleted    int64                  `bun:"deleted,notnull,default:0" json:"deleted"`       // Órfãos e XMLs de documentos removidos apagados do storage
+	Unresolved int64                  `bun:"unresolved,notnull,default:0" json:"unresolved"` // Órfãos sem empresa identificada, mantidos
+	Missing    int64                  `bun:"missing,notnull,default:0" json:"missing"`       // Documentos cujo XML não está no storage
+	Restored   int64                  `bun:"restored,notnull,default:0" json:"restored"`     // Documentos marcados como ausentes cujo XML voltou
+	Failed     int64                  `bun:"failed,notnull,default:0" json:"failed"`         // Objetos ou documentos que não puderam ser tratados
+	Items      []StorageReconcileItem `bun:"items,type:jsonb,nullzero" json:"items,omitempty"`
+	Error      string                 `bun:"error" json:"error,omitempty"`
+
+	StartedAt  time.Time `bun:"started_at,nullzero,notnull,default:current_timestamp" json:"started_at"`
+	FinishedAt time.Time `bun:"finished_at,nullzero" json:"finished_at,omitempty"`
+}
+
+// StorageReconcileItem representa um objeto órfão, um documento sem XML ou o XML de um documento removido
+// tratado na reconciliação
+type StorageReconcileItem struct {
+	Kind       string `json:"kind"`   // 'orphaned', 'missing' ou 'deletion' (XML de documento removido)
+	Action     string `json:"action"` // 'reported', 'reimported', 'duplicate', 'quarantined', 'deleted', 'unresolved', 'flagged' ou 'failed'
+	StorageKey string `json:"storage_key"`
+	DocumentID int64  `json:"document_id,omitempty"` // Documento sem XML, ou criado/existente na reimportação
+	CompanyID  int64  `json:"company_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// BeforeAppendModel hook para definir timestamp
+func (srr *StorageReconcileRun) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		srr.StartedAt = time.Now()
+	}
+	return nil
+}
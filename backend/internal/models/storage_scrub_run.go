@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// StorageScrubRun representa uma verificação do storage contra o banco: objetos referenciados que
+// sumiram, conteúdo que não confere com o SHA-256 registrado e objetos que nada referencia
+type StorageScrubRun struct {
+	bun.BaseModel `bun:"table:storage_scrub_runs,alias:ssr"`
+
+	ID         int64               `bun:"id,pk,autoincrement" json:"id"`
+	Status     string              `bun:"status,notnull,default:'running'" json:"status"` // 'running', 'completed' ou 'failed'
+	Trigger    string              `bun:"trigger,notnull" json:"trigger"`                 // 'scheduled' ou 'manual'
+	Checked    int64               `bun:"checked,notnull,default:0" json:"checked"`       // Objetos referenciados lidos do storage
+	Verified   int64               `bun:"verified,notnull,default:0" json:"verified"`     // Conteúdo confere com o hash registrado
+	Unverified int64               `bun:"unverified,notnull,default:0" json:"unverified"` // Sem hash registrado para comparar
+	Missing    int64               `bun:"missing,notnull,default:0" json:"missing"`       // Referenciados mas ausentes do storage
+	Mismatched int64               `bun:"mismatched,notnull,default:0" json:"mismatched"` // Conteúdo diferente do hash registrado
+	Orphaned   int64               `bun:"orphaned,notnull,default:0" json:"orphaned"`     // No storage sem documento, revisão ou quarentena
+	Failed     int64               `bun:"failed,notnull,default:0" json:"failed"`         // Objetos que não puderam ser lidos
+	Issues     []StorageScrubIssue `bun:"issues,type:jsonb,nullzero" json:"issues,omitempty"`
+	Error      string              `bun:"error" json:"error,omitempty"`
+
+	StartedAt  time.Time `bun:"started_at,nullzero,notnull,default:current_timestamp" json:"started_at"`
+	FinishedAt time.Time `bun:"finished_at,nullzero" json:"finished_at,omitempty"`
+}
+
+// StorageScrubIssue representa um problema encontrado na verificação do storage
+type StorageScrubIssue struct {
+	Kind         string `json:"kind"` // 'missing', 'mismatch' ou 'orphaned'
+	StorageKey   string `json:"storage_key"`
+	Source       string `json:"source,omitempty"`    // Registro que referencia o objeto: 'document', 'revision' ou 'quarantine'
+	RecordID     int64  `json:"record_id,omitempty"` // ID do registro na tabela da origem
+	CompanyID    int64  `json:"company_id,omitempty"`
+	ExpectedHash string `json:"expected_hash,omitempty"`
+	ActualHash   string `json:"actual_hash,omitempty"`
+}
+
+// BeforeAppendModel hook para definir timestamp
+func (ssr *StorageScrubRun) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		ssr.StartedAt = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zoomxml/internal/logger"
+)
+
+// abrasfRootElements lists the ABRASF root elements that carry CompNfse documents
+var abrasfRootElements = map[string]bool{
+	"CompNfse":                             true,
+	"ConsultarNfseResposta":                true,
+	"ConsultarNfseServicoPrestadoResposta": true,
+	"ConsultarNfseServicoTomadoResposta":   true,
+	"ConsultarNfseFaixaResposta":           true,
+	"ConsultarNfseRpsResposta":             true,
+	"ConsultarLoteRpsResposta":             true,
+	"GerarNfseResposta":                    true,
+	"EnviarLoteRpsSincronoResposta":        true,
+}
+
+// ErrMultipleCompNfse is returned for ABRASF documents carrying more than one NFS-e, such as batch query
+// responses, which are stored one note per file
+var ErrMultipleCompNfse = errors.New("ABRASF document contains several NFS-e")
+
+// ABRASFCompNfse represents a CompNfse element in the ABRASF 1.0 and 2.x layouts
+type ABRASFCompNfse struct {
+	Nfse             ABRASFNfse              `xml:"Nfse"`
+	NfseCancelamento *ABRASFNfseCancelamento `xml:"NfseCancelamento"`
+	NfseSubstituicao ABRASFNfseSubstituicao  `xml:"NfseSubstituicao"`
+}
+
+type ABRASFNfse struct {
+	InfNfse ABRASFInfNfse `xml:"InfNfse"`
+}
+
+// ABRASFInfNfse holds the 1.0 fields (shared with InfNfse) and the 2.x additions
+type ABRASFInfNfse struct {
+	InfNfse
+	ValoresNfse                Valores                   `xml:"ValoresNfse"`
+	DeclaracaoPrestacaoServico ABRASFDeclaracaoPrestacao `xml:"DeclaracaoPrestacaoServico>InfDeclaracaoPrestacaoServico"`
+}
+
+// ABRASFDeclaracaoPrestacao represents InfDeclaracaoPrestacaoServico (ABRASF 2.x)
+type ABRASFDeclaracaoPrestacao struct {
+	Rps struct {
+		IdentificacaoRps IdentificacaoRps `xml:"IdentificacaoRps"`
+		DataEmissao      string           `xml:"DataEmissao"`
+	} `xml:"Rps"`
+	Competencia            string          `xml:"Competencia"`
+	Servico                Servico         `xml:"Servico"`
+	Prestador              ABRASFPrestador `xml:"Prestador"`
+	TomadorServico         TomadorServico  `xml:"TomadorServico"`
+	Tomador                TomadorServico  `xml:"Tomador"` // ABRASF 2.01
+	OptanteSimplesNacional string          `xml:"OptanteSimplesNacional"`
+}
+
+type ABRASFPrestador struct {
+	CpfCnpj            CpfCnpj `xml:"CpfCnpj"`
+	InscricaoMunicipal string  `xml:"InscricaoMunicipal"`
+}
+
+type ABRASFNfseCancelamento struct {
+	Confirmacao struct {
+		DataHora                   string                     `xml:"DataHora"`
+		InfConfirmacaoCancelamento InfConfirmacaoCancelamento `xml:"InfConfirmacaoCancelamento"`
+	} `xml:"Confirmacao"`
+}
+
+type ABRASFNfseSubstituicao struct {
+	SubstituicaoNfse struct {
+		NfseSubstituidora string `xml:"NfseSubstituidora"`
+	} `xml:"SubstituicaoNfse"`
+}
+
+// ABRASFParser parses NFSe documents in the ABRASF 1.0 and 2.x layouts
+type ABRASFParser struct {
+	values *NFSeParser
+}
+
+// NewABRASFParser creates a new ABRASF parser instance
+func NewABRASFParser() *ABRASFParser {
+	return &ABRASFParser{
+		values: NewNFSeParser(),
+	}
+}
+
+// Layout returns the layout identifier handled by the parser
+func (p *ABRASFParser) Layout() string {
+	return LayoutABRASF
+}
+
+// Matches reports whether the root element is an ABRASF CompNfse or a response wrapping it
+func (p *ABRASFParser) Matches(root xml.Name) bool {
+	return abrasfRootElements[root.Local]
+}
+
+// ParseXML parses the CompNfse of an ABRASF document. Documents with several CompNfse are rejected with
+// ErrMultipleCompNfse, so that no note of the file is silently dropped.
+func (p *ABRASFParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
+	compNfse, count, xmlContent, err := p.decodeCompNfse(xmlContent)
+	if err == nil && count > 1 {
+		err = fmt.Errorf("%w: found %d CompNfse, send one note per file", ErrMultipleCompNfse, count)
+	}
+	if err != nil {
+		logger.ErrorWithFields("Failed to parse ABRASF XML", err, map[string]any{
+			"operation": "parse_abrasf_xml",
+			"count":     count,
+		})
+		return nil, err
+	}
+
+	infNfse := compNfse.Nfse.InfNfse
+	declaracao := infNfse.DeclaracaoPrestacaoServico
+
+	// ABRASF 2.x moves the service and parties into the declaration; 1.0 keeps them in InfNfse
+	servico := infNfse.Servico
+	if declaracao.Servico.Valores.ValorServicos != "" {
+		servico = declaracao.Servico
+	}
+	valores := servico.Valores
+	valoresNfse := infNfse.ValoresNfse
+
+	tomador := infNfse.TomadorServico
+	if declaracao.TomadorServico.RazaoSocial != "" || declaracao.TomadorServico.IdentificacaoTomador.CpfCnpj != (CpfCnpj{}) {
+		tomador = declaracao.TomadorServico
+	} else if declaracao.Tomador.RazaoSocial != "" || declaracao.Tomador.IdentificacaoTomador.CpfCnpj != (CpfCnpj{}) {
+		tomador = declaracao.Tomador
+	}
+
+	identificacaoPrestador := infNfse.PrestadorServico.IdentificacaoPrestador
+	providerCNPJ := firstNonEmpty(
+		declaracao.Prestador.CpfCnpj.Cnpj,
+		declaracao.Prestador.CpfCnpj.Cpf,
+		identificacaoPrestador.Cnpj,
+		identificacaoPrestador.CpfCnpj.Cnpj,
+		identificacaoPrestador.CpfCnpj.Cpf,
+	)
+	municipalRegistration := firstNonEmpty(declaracao.Prestador.InscricaoMunicipal, identificacaoPrestador.InscricaoMunicipal)
+
+	cancelled := false
+	if cancelamento := compNfse.NfseCancelamento; cancelamento != nil {
+		// 1.0 reports Sucesso; 2.x only sends the confirmation when the cancellation happened
+		cancelled = strings.TrimSpace(cancelamento.Confirmacao.InfConfirmacaoCancelamento.Sucesso) != "false"
+	}
+
+	issueDateRaw := strings.TrimSpace(infNfse.DataEmissao)
+	documentDates := newDocumentDates(p.values.serviceMunicipalityCode(servico))
+
+	parsedData := &ParsedNFSeData{
+		DocumentType:          DocumentTypeNFSe,
+		Layout:                LayoutABRASF,
+		Number:                strings.TrimSpace(infNfse.Numero),
+		VerificationCode:      strings.TrimSpace(infNfse.CodigoVerificacao),
+		ProviderCNPJ:          providerCNPJ,
+		TakerCNPJ:             firstNonEmpty(tomador.IdentificacaoTomador.CpfCnpj.Cnpj, tomador.IdentificacaoTomador.CpfCnpj.Cpf),
+		ServiceValue:          p.values.parseMoney("ValorServicos", valores.ValorServicos),
+		ServiceCode:           strings.TrimSpace(servico.ItemListaServico),
+		IssueDate:             documentDates.parse("InfNfse/DataEmissao", issueDateRaw, true),
+		MunicipalRegistration: municipalRegistration,
+		IsCancelled:           cancelled,
+		IsSubstituted:         strings.TrimSpace(compNfse.NfseSubstituicao.SubstituicaoNfse.NfseSubstituidora) != "",
+		DocumentHash:          p.values.generateDocumentHash(infNfse.CodigoVerificacao, infNfse.Numero, providerCNPJ, issueDateRaw),
+		FullXML:               xmlContent,
+
+		Competence:        firstNonEmpty(declaracao.Competencia, infNfse.Competencia),
+		RpsIssueDate:      documentDates.parse("Rps/DataEmissao", firstNonEmpty(declaracao.Rps.DataEmissao, infNfse.DataEmissaoRps), false),
+		TakerName:         strings.TrimSpace(tomador.RazaoSocial),
+		ProviderName:      strings.TrimSpace(infNfse.PrestadorServico.RazaoSocial),
+		ProviderTradeName: strings.TrimSpace(infNfse.PrestadorServico.NomeFantasia),
+
+		// 2.x reports the computed ISS values in ValoresNfse
+		DeductionsValue:       p.values.parseMoney("ValorDeducoes", valores.ValorDeducoes),
+		PisValue:              p.values.parseMoney("ValorPis", valores.ValorPis),
+		CofinsValue:           p.values.parseMoney("ValorCofins", valores.ValorCofins),
+		InssValue:             p.values.parseMoney("ValorInss", valores.ValorInss),
+		IrValue:               p.values.parseMoney("ValorIr", valores.ValorIr),
+		CsllValue:             p.values.parseMoney("ValorCsll", valores.ValorCsll),
+		IssWithheld:           p.values.parseFlag(firstNonEmpty(valores.IssRetido, servico.IssRetido)),
+		IssValue:              p.values.parseMoney("ValorIss", firstNonEmpty(valoresNfse.ValorIss, valores.ValorIss)),
+		OtherWithholdings:     p.values.parseMoney("OutrasRetencoes", valores.OutrasRetencoes),
+		CalculationBase:       p.values.parseMoney("BaseCalculo", firstNonEmpty(valoresNfse.BaseCalculo, valores.BaseCalculo)),
+		IssRate:               p.values.parseRate("Aliquota", firstNonEmpty(valoresNfse.Aliquota, valores.Aliquota)),
+		NetValue:              p.values.parseMoney("ValorLiquidoNfse", firstNonEmpty(valoresNfse.ValorLiquidoNfse, valores.ValorLiquidoNfse)),
+		ConditionalDiscount:   p.values.parseMoney("DescontoCondicionado", valores.DescontoCondicionado),
+		UnconditionalDiscount: p.values.parseMoney("DescontoIncondicionado", valores.DescontoIncondicionado),
+
+		CnaeCode:                strings.TrimSpace(servico.CodigoCnae),
+		OperationNature:         strings.TrimSpace(infNfse.NaturezaOperacao),
+		SimplesNacionalOptant:   p.values.parseFlag(firstNonEmpty(declaracao.OptanteSimplesNacional, infNfse.OptanteSimplesNacional)),
+		ServiceDescription:      strings.TrimSpace(servico.Discriminacao),
+		ServiceMunicipalityCode: p.values.serviceMunicipalityCode(servico),
+		IssMunicipalityCode:     strings.TrimSpace(servico.MunicipioIncidencia),
+	}
+	prestador := infNfse.PrestadorServico
+	parsedData.Provider = p.values.abrasfParty(providerCNPJ, municipalRegistration, prestador.RazaoSocial, prestador.NomeFantasia, prestador.Endereco, prestador.Contato)
+	parsedData.Taker = p.values.abrasfParty(parsedData.TakerCNPJ, tomador.IdentificacaoTomador.InscricaoMunicipal, tomador.RazaoSocial, "", tomador.Endereco, tomador.Contato)
+	parsedData.ValidationIssues = documentDates.issues
+
+	logger.InfoWithFields("Successfully parsed ABRASF XML", map[string]any{
+		"operation":         "parse_abrasf_xml",
+		"number":            parsedData.Number,
+		"verification_code": parsedData.VerificationCode,
+		"provider_cnpj":     parsedData.ProviderCNPJ,
+		"service_value":     parsedData.ServiceValue.String(),
+	})
+
+	return parsedData, nil
+}
+
+// decodeCompNfse decodes the first CompNfse element and counts the remaining ones
+func (p *ABRASFParser) decodeCompNfse(xmlContent string) (*ABRASFCompNfse, int, string, error) {
+	decoder, xmlContent := p.values.newDecoder(xmlContent)
+
+	var compNfse *ABRASFCompNfse
+	count := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, xmlContent, fmt.Errorf("failed to parse XML: %v", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "CompNfse" {
+			continue
+		}
+
+		count++
+		if compNfse != nil {
+			if err := decoder.Skip(); err != nil {
+				return nil, 0, xmlContent, fmt.Errorf("failed to parse XML: %v", err)
+			}
+			continue
+		}
+
+		compNfse = &ABRASFCompNfse{}
+		if err := decoder.DecodeElement(compNfse, &start); err != nil {
+			return nil, 0, xmlContent, fmt.Errorf("failed to parse XML: %v", err)
+		}
+	}
+
+	if compNfse == nil {
+		return nil, 0, xmlContent, fmt.Errorf("no CompNfse element found")
+	}
+
+	return compNfse, count, xmlContent, nil
+}
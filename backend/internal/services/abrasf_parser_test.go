@@ -0,0 +1,24 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A batch response with several notes is rejected as a whole instead of keeping only its first note
+func TestABRASFParserRejectsSeveralCompNfse(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "parsing", "abrasf-two-notes.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := NewParserRegistry().ParseXML(string(content))
+	if !errors.Is(err, ErrMultipleCompNfse) {
+		t.Fatalf("ParseXML error = %v; want ErrMultipleCompNfse", err)
+	}
+	if parsed != nil {
+		t.Fatalf("ParseXML returned note %s of a file with several notes", parsed.Number)
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+// CompanyDeletion removes companies together with their stored XMLs
+type CompanyDeletion struct{}
+
+// NewCompanyDeletion creates a new company deletion instance
+func NewCompanyDeletion() *CompanyDeletion {
+	return &CompanyDeletion{}
+}
+
+// Delete removes a company, its rows and the XMLs of its documents, revisions, quarantined files and exports.
+// In one transaction the XMLs are recorded in storage_deletions, the company's data key is deleted and the
+// company is removed, cascading to its rows; the XMLs are removed from storage once it commits. A file that
+// cannot be removed is left in storage_deletions for the storage reconciliation, which deletes it without
+// needing the key. It returns sql.ErrNoRows when the company does not exist.
+func (d *CompanyDeletion) Delete(ctx context.Context, companyID int64) error {
+	deletions := make([]*models.StorageDeletion, 0)
+	err := database.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, source := range scrubSources {
+			var storageKeys []string
+			err := tx.NewSelect().
+				Model(source.model).
+				Column("storage_key").
+				Where("company_id = ?", companyID).
+				Where("storage_key <> ''").
+				Scan(ctx, &storageKeys)
+			if err != nil {
+				return fmt.Errorf("failed to list %s storage keys: %v", source.name, err)
+			}
+			for _, storageKey := range storageKeys {
+				deletions = append(deletions, &models.StorageDeletion{
+					StorageKey: storageKey,
+					CompanyID:  companyID,
+				})
+			}
+		}
+
+		if len(deletions) > 0 {
+			_, err := tx.NewInsert().
+				Model(&deletions).
+				On("CONFLICT (storage_key) DO NOTHING").
+				Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to record stored XMLs to delete: %v", err)
+			}
+		}
+
+		// The data key is only deleted here: the foreign key keeps the database from dropping it with the company
+		if _, err := tx.NewDelete().Model((*models.DataKey)(nil)).Where("company_id = ?", companyID).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to delete company data key: %v", err)
+		}
+
+		result, err := tx.NewDelete().Model((*models.Company)(nil)).Where("id = ?", companyID).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete company: %v", err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pending := make([]string, 0)
+	for _, deletion := range deletions {
+		if err := removeStorageDeletion(ctx, deletion); err != nil {
+			pending = append(pending, deletion.StorageKey)
+			logger.WarnWithFields("Failed to delete stored XML of deleted company, left for the storage reconciliation", map[string]any{
+				"operation":   "delete_company",
+				"company_id":  companyID,
+				"storage_key": deletion.StorageKey,
+				"error":       err.Error(),
+			})
+		}
+	}
+
+	logger.InfoWithFields("Deleted company", map[string]any{
+		"operation":            "delete_company",
+		"company_id":           companyID,
+		"storage_keys":         len(deletions),
+		"pending_storage_keys": pending,
+	})
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// Deleting a company removes its XMLs from storage before its data key, and the database alone cannot drop the key
+func TestCompanyDeletionRemovesStoredXMLs(t *testing.T) {
+	requireDatabase(t)
+	ctx := context.Background()
+	company := createTestCompany(t)
+
+	document := &models.Document{
+		CompanyID:  company.ID,
+		Type:       DocumentTypeNFSe,
+		Number:     "789",
+		Status:     "processed",
+		StorageKey: "nfse/2025/022025/34194865000158/nota.xml",
+		Hash:       hashContent("nota"),
+	}
+	if _, err := database.DB.NewInsert().Model(document).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Storage.UploadFileWithMetadata(ctx, "nfse-storage", document.StorageKey, []byte("nota"), "application/xml", storage.CompanyMetadata(company.ID)); err != nil {
+		t.Fatal(err)
+	}
+	dataKey := &models.DataKey{
+		KeyID:       "test-" + company.CNPJ,
+		CompanyID:   company.ID,
+		WrappedKey:  []byte("wrapped"),
+		MasterKeyID: "test",
+	}
+	if _, err := database.DB.NewInsert().Model(dataKey).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := database.DB.NewDelete().Model(company).WherePK().Exec(ctx); err == nil {
+		t.Fatal("the database deleted a company that still has a data key")
+	}
+
+	if err := NewCompanyDeletion().Delete(ctx, company.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := storage.Storage.DownloadFile(ctx, "nfse-storage", document.StorageKey); !errors.Is(err, storage.ErrFileNotFound) {
+		t.Errorf("stored XML after company deletion: %v; want ErrFileNotFound", err)
+	}
+	exists, err := database.DB.NewSelect().Model((*models.DataKey)(nil)).Where("company_id = ?", company.ID).Exists(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("data key kept after company deletion")
+	}
+}
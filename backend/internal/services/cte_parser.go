@@ -0,0 +1,195 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+// CTeParticipante represents a party of a CT-e (remetente, expedidor, recebedor, destinatário, tomador)
+type CTeParticipante struct {
+	CNPJ  string `xml:"CNPJ"`
+	CPF   string `xml:"CPF"`
+	IE    string `xml:"IE"`
+	XNome string `xml:"xNome"`
+	XFant string `xml:"xFant"`
+	Fone  string `xml:"fone"`
+	Email string `xml:"email"`
+
+	// The address group is named after the role of the party
+	EnderReme  SEFAZEndereco `xml:"enderReme"`
+	EnderExped SEFAZEndereco `xml:"enderExped"`
+	EnderReceb SEFAZEndereco `xml:"enderReceb"`
+	EnderDest  SEFAZEndereco `xml:"enderDest"`
+	EnderToma  SEFAZEndereco `xml:"enderToma"`
+}
+
+// endereco returns the address group present for the party's role
+func (c CTeParticipante) endereco() SEFAZEndereco {
+	for _, endereco := range []SEFAZEndereco{c.EnderReme, c.EnderExped, c.EnderReceb, c.EnderDest, c.EnderToma} {
+		if endereco != (SEFAZEndereco{}) {
+			return endereco
+		}
+	}
+	return SEFAZEndereco{}
+}
+
+// CTeInfCte represents the infCte element of a CT-e (modelo 57)
+type CTeInfCte struct {
+	ID  string `xml:"Id,attr"`
+	Ide struct {
+		NatOp   string `xml:"natOp"`
+		Serie   string `xml:"serie"`
+		NCT     string `xml:"nCT"`
+		DhEmi   string `xml:"dhEmi"`
+		CMunEnv string `xml:"cMunEnv"`
+		Toma3   string `xml:"toma3>toma"`
+		Toma    string `xml:"toma03>toma"` // Layout 2.00
+		Toma4   struct {
+			CTeParticipante
+			Toma string `xml:"toma"`
+		} `xml:"toma4"`
+	} `xml:"ide"`
+	Emit struct {
+		CNPJ      string        `xml:"CNPJ"`
+		IE        string        `xml:"IE"`
+		XNome     string        `xml:"xNome"`
+		XFant     string        `xml:"xFant"`
+		EnderEmit SEFAZEndereco `xml:"enderEmit"`
+		CRT       string        `xml:"CRT"`
+	} `xml:"emit"`
+	Rem    CTeParticipante `xml:"rem"`
+	Exped  CTeParticipante `xml:"exped"`
+	Receb  CTeParticipante `xml:"receb"`
+	Dest   CTeParticipante `xml:"dest"`
+	VPrest struct {
+		VTPrest string `xml:"vTPrest"`
+		VRec    string `xml:"vRec"`
+	} `xml:"vPrest"`
+}
+
+// CTeXML covers both the cteProc envelope and a bare CTe root
+type CTeXML struct {
+	InfCte    CTeInfCte `xml:"infCte"`
+	ProcCte   CTeInfCte `xml:"CTe>infCte"`
+	ChCTe     string    `xml:"protCTe>infProt>chCTe"`
+	StatusCTe string    `xml:"protCTe>infProt>cStat"`
+}
+
+// CTeParser parses CT-e documents (cteProc or CTe roots)
+type CTeParser struct {
+	values *NFSeParser
+}
+
+// NewCTeParser creates a new CT-e parser instance
+func NewCTeParser() *CTeParser {
+	return &CTeParser{
+		values: NewNFSeParser(),
+	}
+}
+
+// Layout returns the layout identifier handled by the parser
+func (p *CTeParser) Layout() string {
+	return LayoutCTe
+}
+
+// Matches reports whether the root element is a CT-e or its authorization envelope
+func (p *CTeParser) Matches(root xml.Name) bool {
+	return (root.Local == "cteProc" || root.Local == "CTe") && (root.Space == namespaceCTe || root.Space == "")
+}
+
+// ParseXML parses a CT-e document
+func (p *CTeParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
+	var cte CTeXML
+	decoder, xmlContent := p.values.newDecoder(xmlContent)
+	if err := decoder.Decode(&cte); err != nil {
+		logger.ErrorWithFields("Failed to parse CTe XML", err, map[string]any{
+			"operation": "parse_cte_xml",
+		})
+		return nil, fmt.Errorf("failed to parse XML: %v", err)
+	}
+
+	inf := cte.InfCte
+	if inf.ID == "" {
+		inf = cte.ProcCte
+	}
+	if inf.ID == "" && inf.Ide.NCT == "" {
+		return nil, fmt.Errorf("no infCte element found")
+	}
+
+	accessKey := firstNonEmpty(cte.ChCTe, strings.TrimPrefix(strings.TrimSpace(inf.ID), "CTe"))
+	providerCNPJ := strings.TrimSpace(inf.Emit.CNPJ)
+	issueDateRaw := strings.TrimSpace(inf.Ide.DhEmi)
+	taker := p.taker(inf)
+	documentDates := newDocumentDates(inf.Ide.CMunEnv)
+
+	parsedData := &ParsedNFSeData{
+		DocumentType:     DocumentTypeCTe,
+		Layout:           LayoutCTe,
+		AccessKey:        accessKey,
+		Number:           strings.TrimSpace(inf.Ide.NCT),
+		VerificationCode: accessKey,
+		ProviderCNPJ:     providerCNPJ,
+		TakerCNPJ:        firstNonEmpty(taker.CNPJ, taker.CPF),
+		ServiceValue:     p.values.parseMoney("vTPrest", inf.VPrest.VTPrest),
+		IssueDate:        documentDates.parse("ide/dhEmi", issueDateRaw, true),
+		DocumentHash:     p.values.generateDocumentHash(accessKey, inf.Ide.NCT, providerCNPJ, issueDateRaw),
+		FullXML:          xmlContent,
+
+		TakerName:         strings.TrimSpace(taker.XNome),
+		ProviderName:      strings.TrimSpace(inf.Emit.XNome),
+		ProviderTradeName: strings.TrimSpace(inf.Emit.XFant),
+
+		NetValue:              p.values.parseMoney("vRec", inf.VPrest.VRec),
+		OperationNature:       strings.TrimSpace(inf.Ide.NatOp),
+		SimplesNacionalOptant: strings.TrimSpace(inf.Emit.CRT) == "1",
+	}
+	parsedData.Provider = sefazParty(models.Party{
+		TaxID:             providerCNPJ,
+		Name:              inf.Emit.XNome,
+		TradeName:         inf.Emit.XFant,
+		StateRegistration: inf.Emit.IE,
+	}, inf.Emit.EnderEmit)
+	parsedData.Taker = sefazParty(models.Party{
+		TaxID:             parsedData.TakerCNPJ,
+		Name:              taker.XNome,
+		TradeName:         taker.XFant,
+		StateRegistration: taker.IE,
+		Phone:             taker.Fone,
+		Email:             taker.Email,
+	}, taker.endereco())
+	parsedData.ValidationIssues = documentDates.issues
+
+	logger.InfoWithFields("Successfully parsed CTe XML", map[string]any{
+		"operation":     "parse_cte_xml",
+		"number":        parsedData.Number,
+		"access_key":    parsedData.AccessKey,
+		"provider_cnpj": parsedData.ProviderCNPJ,
+		"total_value":   parsedData.ServiceValue.String(),
+		"status":        cte.StatusCTe,
+	})
+
+	return parsedData, nil
+}
+
+// taker resolves the tomador do serviço indicated in ide/toma3 or ide/toma4
+func (p *CTeParser) taker(inf CTeInfCte) CTeParticipante {
+	// toma: 0 = remetente, 1 = expedidor, 2 = recebedor, 3 = destinatário, 4 = outros (toma4)
+	switch firstNonEmpty(inf.Ide.Toma3, inf.Ide.Toma, inf.Ide.Toma4.Toma) {
+	case "0":
+		return inf.Rem
+	case "1":
+		return inf.Exped
+	case "2":
+		return inf.Receb
+	case "3":
+		return inf.Dest
+	case "4":
+		return inf.Ide.Toma4.CTeParticipante
+	default:
+		return inf.Dest
+	}
+}
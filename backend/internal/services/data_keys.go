@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// masterKey is a key wrapping data keys, identified by its fingerprint
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// RewrapResult summarizes a data key rewrap run
+type RewrapResult struct {
+	Total     int
+	Rewrapped int
+	Current   int // Already wrapped by the current master key
+	Failed    int
+	MasterKey string
+}
+
+// DataKeys stores the per-company data keys that encrypt stored XMLs, wrapped by the master key from
+// STORAGE_MASTER_KEY. Unwrapped keys are cached in memory; they never change once created.
+type DataKeys struct {
+	current *masterKey
+	masters map[string]*masterKey
+
+	mu        sync.RWMutex
+	companies map[int64]string
+	keys      map[string][]byte
+}
+
+// NewDataKeys loads the master keys from the configuration. It returns nil when STORAGE_MASTER_KEY is empty,
+// which disables storage encryption.
+func NewDataKeys() (*DataKeys, error) {
+	cfg := config.Get().Storage
+	if cfg.MasterKey == "" {
+		return nil, nil
+	}
+
+	current, err := parseMasterKey(cfg.MasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_MASTER_KEY: %v", err)
+	}
+
+	k := &DataKeys{
+		current:   current,
+		masters:   map[string]*masterKey{current.id: current},
+		companies: make(map[int64]string),
+		keys:      make(map[string][]byte),
+	}
+	for _, encoded := range cfg.OldMasterKeys {
+		if strings.TrimSpace(encoded) == "" {
+			continue
+		}
+		old, err := parseMasterKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STORAGE_OLD_MASTER_KEYS entry: %v", err)
+		}
+		k.masters[old.id] = old
+	}
+	return k, nil
+}
+
+// StorageKeyStore returns the data keys as the storage key store, or nil when storage encryption is disabled
+func StorageKeyStore() (storage.KeyStore, error) {
+	keys, err := NewDataKeys()
+	if err != nil || keys == nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// MasterKeyID returns the fingerprint of the current master key
+func (k *DataKeys) MasterKeyID() string {
+	return k.current.id
+}
+
+// CompanyKey returns the data key of a company, creating it on first use. Company 0 is the shared key.
+func (k *DataKeys) CompanyKey(ctx context.Context, companyID int64) (string, []byte, error) {
+	k.mu.RLock()
+	keyID, ok := k.companies[companyID]
+	key := k.keys[keyID]
+	k.mu.RUnlock()
+	if ok {
+		return keyID, key, nil
+	}
+
+	dataKey, err := k.loadCompanyKey(ctx, companyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		dataKey, err = k.createCompanyKey(ctx, companyID)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, err = k.unwrap(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	k.remember(dataKey, key)
+	return dataKey.KeyID, key, nil
+}
+
+// Key returns the data key with the given ID
+func (k *DataKeys) Key(ctx context.Context, keyID string) ([]byte, error) {
+	k.mu.RLock()
+	key, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	dataKey := &models.DataKey{}
+	err := database.DB.NewSelect().
+		Model(dataKey).
+		Where("key_id = ?", keyID).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data key: %v", err)
+	}
+
+	key, err = k.unwrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	k.remember(dataKey, key)
+	return key, nil
+}
+
+// Rewrap wraps every data key not yet under the current master key with it, so the old master keys can be
+// retired from STORAGE_OLD_MASTER_KEYS. Stored objects are not touched: they reference the data keys, which
+// keep their IDs and values.
+func (k *DataKeys) Rewrap(ctx context.Context, dryRun bool) (*RewrapResult, error) {
+	var dataKeys []models.DataKey
+	if err := database.DB.NewSelect().Model(&dataKeys).Order("id ASC").Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load data keys: %v", err)
+	}
+
+	result := &RewrapResult{Total: len(dataKeys), MasterKey: k.current.id}
+	for i := range dataKeys {
+		dataKey := &dataKeys[i]
+		if dataKey.MasterKeyID == k.current.id {
+			result.Current++
+			continue
+		}
+
+		key, err := k.unwrap(dataKey)
+		if err == nil && !dryRun {
+			err = k.rewrap(ctx, dataKey, key)
+		}
+		if err != nil {
+			result.Failed++
+			logger.WarnWithFields("Failed to rewrap data key", map[string]any{
+				"operation":     "rewrap_data_keys",
+				"key_id":        dataKey.KeyID,
+				"master_key_id": dataKey.MasterKeyID,
+				"error":         err.Error(),
+			})
+			continue
+		}
+		result.Rewrapped++
+	}
+
+	logger.InfoWithFields("Completed data key rewrap", map[string]any{
+		"operation":     "rewrap_data_keys",
+		"master_key_id": k.current.id,
+		"dry_run":       dryRun,
+		"total":         result.Total,
+		"rewrapped":     result.Rewrapped,
+		"current":       result.Current,
+		"failed":        result.Failed,
+	})
+
+	return result, nil
+}
+
+// rewrap stores a data key wrapped by the current master key, unless another run already rewrapped it
+func (k *DataKeys) rewrap(ctx context.Context, dataKey *models.DataKey, key []byte) error {
+	wrapped, err := k.wrap(dataKey.KeyID, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.DB.NewUpdate().
+		Model((*models.DataKey)(nil)).
+		Set("wrapped_key = ?", wrapped).
+		Set("master_key_id = ?", k.current.id).
+		Set("rewrapped_at = ?", time.Now()).
+		Where("id = ?", dataKey.ID).
+		Where("master_key_id = ?", dataKey.MasterKeyID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update data key: %v", err)
+	}
+	return nil
+}
+
+// loadCompanyKey loads the data key of a company
+func (k *DataKeys) loadCompanyKey(ctx context.Context, companyID int64) (*models.DataKey, error) {
+	dataKey := &models.DataKey{}
+	err := database.DB.NewSelect().
+		Model(dataKey).
+		Where("COALESCE(company_id, 0) = ?", companyID).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dataKey, nil
+}
+
+// createCompanyKey generates and stores the data key of a company. When another process creates it first,
+// its key is returned instead.
+func (k *DataKeys) createCompanyKey(ctx context.Context, companyID int64) (*models.DataKey, error) {
+	keyID, key, err := storage.NewDataKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	wrapped, err := k.wrap(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := &models.DataKey{
+		KeyID:       keyID,
+		CompanyID:   companyID,
+		WrappedKey:  wrapped,
+		MasterKeyID: k.current.id,
+	}
+	result, err := database.DB.NewInsert().
+		Model(dataKey).
+		On("CONFLICT ((COALESCE(company_id, 0))) DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store data key: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return k.loadCompanyKey(ctx, companyID)
+	}
+
+	logger.InfoWithFields("Created data key", map[string]any{
+		"operation":     "create_data_key",
+		"company_id":    companyID,
+		"key_id":        keyID,
+		"master_key_id": k.current.id,
+	})
+	return dataKey, nil
+}
+
+// remember caches an unwrapped data key
+func (k *DataKeys) remember(dataKey *models.DataKey, key []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.companies[dataKey.CompanyID] = dataKey.KeyID
+	k.keys[dataKey.KeyID] = key
+}
+
+// wrap encrypts a data key with the current master key, bound to its ID
+func (k *DataKeys) wrap(keyID string, key []byte) ([]byte, error) {
+	nonce := make([]byte, k.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.current.aead.Seal(nonce, nonce, key, []byte(keyID)), nil
+}
+
+// unwrap decrypts a data key with the master key that wrapped it
+func (k *DataKeys) unwrap(dataKey *models.DataKey) ([]byte, error) {
+	master, ok := k.masters[dataKey.MasterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("data key %s is wrapped by unknown master key %s", dataKey.KeyID, dataKey.MasterKeyID)
+	}
+
+	nonceSize := master.aead.NonceSize()
+	if len(dataKey.WrappedKey) < nonceSize {
+		return nil, fmt.Errorf("data key %s is truncated", dataKey.KeyID)
+	}
+	key, err := master.aead.Open(nil, dataKey.WrappedKey[:nonceSize], dataKey.WrappedKey[nonceSize:], []byte(dataKey.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key %s: %v", dataKey.KeyID, err)
+	}
+	return key, nil
+}
+
+// parseMasterKey decodes a base64 AES-256 master key and identifies it by the first bytes of its SHA-256
+func parseMasterKey(encoded string) (*masterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key must be base64: %v", err)
+	}
+	if len(key) != storage.DataKeySize {
+		return nil, fmt.Errorf("master key must have %d bytes, got %d", storage.DataKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := sha256.Sum256(key)
+	return &masterKey{id: "mk-" + hex.EncodeToString(fingerprint[:8]), aead: aead}, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+var (
+	testDatabaseOnce sync.Once
+	testDatabaseErr  error
+)
+
+// requireDatabase connects to the PostgreSQL database configured in DB_*, runs the migrations and replaces the
+// storage with an in-memory one. Tests using it are skipped unless SERVICES_TEST_DATABASE is set:
+//
+//	SERVICES_TEST_DATABASE=1 DB_NAME=zoomxml_test go test ./internal/services
+func requireDatabase(t *testing.T) {
+	t.Helper()
+
+	if os.Getenv("SERVICES_TEST_DATABASE") == "" {
+		t.Skip("set SERVICES_TEST_DATABASE to run the tests that need PostgreSQL")
+	}
+
+	testDatabaseOnce.Do(func() {
+		config.Load()
+		if testDatabaseErr = database.Connect(); testDatabaseErr != nil {
+			return
+		}
+		testDatabaseErr = database.RunMigrations(context.Background())
+	})
+	if testDatabaseErr != nil {
+		t.Fatalf("failed to prepare the test database: %v", testDatabaseErr)
+	}
+
+	previous := storage.Storage
+	memory := storage.NewMemoryService()
+	if err := memory.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	storage.Storage = memory
+	t.Cleanup(func() { storage.Storage = previous })
+}
+
+// createTestCompany creates a company removed with its documents and stored XMLs when the test ends
+func createTestCompany(t *testing.T) *models.Company {
+	t.Helper()
+
+	company := &models.Company{
+		Name: "Empresa de teste",
+		CNPJ: fmt.Sprintf("%014d", time.Now().UnixNano()%1e14),
+	}
+	ctx := context.Background()
+	if _, err := database.DB.NewInsert().Model(company).Exec(ctx); err != nil {
+		t.Fatalf("failed to create test company: %v", err)
+	}
+	t.Cleanup(func() {
+		NewCompanyDeletion().Delete(ctx, company.ID)
+	})
+	return company
+}
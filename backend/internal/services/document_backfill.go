@@ -0,0 +1,325 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+	"github.com/zoomxml/internal/xmldsig"
+)
+
+// backfillBatchSize is the number of documents loaded per backfill iteration
+const backfillBatchSize = 100
+
+// taxColumns lists the document columns filled from ABRASF Valores and Servico
+var taxColumns = []string{
+	"deductions_value",
+	"pis_value",
+	"cofins_value",
+	"inss_value",
+	"ir_value",
+	"csll_value",
+	"iss_withheld",
+	"iss_value",
+	"other_withholdings",
+	"calculation_base",
+	"iss_rate",
+	"net_value",
+	"conditional_discount",
+	"unconditional_discount",
+	"cnae_code",
+	"operation_nature",
+	"simples_nacional_optant",
+	"service_description",
+}
+
+// issueDateColumns lists the document columns filled from the XML dates
+var issueDateColumns = []string{
+	"issue_date",
+	"rps_issue_date",
+}
+
+// authenticityColumns lists the document columns filled from XMLDSig verification
+var authenticityColumns = []string{
+	"authenticity_status",
+	"signer_cnpj",
+	"signer_certificate_serial",
+}
+
+// partyColumns lists the document columns linking it to the counterparty registry
+var partyColumns = []string{
+	"provider_party_id",
+	"taker_party_id",
+}
+
+// serviceItemColumns lists the document columns filled from the service catalogs
+var serviceItemColumns = []string{
+	"service_item",
+	"service_item_description",
+	"cnae_description",
+	"validation_status",
+	"validation_errors",
+}
+
+// locationColumns lists the document columns filled from the IBGE municipality codes
+var locationColumns = []string{
+	"service_municipality_code",
+	"service_municipality_name",
+	"service_uf",
+	"iss_municipality_code",
+	"provider_municipality_code",
+	"provider_municipality_name",
+	"provider_uf",
+	"taker_municipality_code",
+	"taker_municipality_name",
+	"taker_uf",
+	"iss_due_outside_provider",
+}
+
+// backfillColumns lists the document columns loaded for backfills that re-parse the stored XML
+var backfillColumns = []string{"id", "company_id", "storage_key", "hash", "issue_date"}
+
+// BackfillResult summarizes a backfill run
+type BackfillResult struct {
+	Scanned int
+	Updated int
+	Failed  int
+	Elapsed time.Duration
+}
+
+// DocumentBackfiller re-parses stored XML to fill columns added after documents were ingested
+type DocumentBackfiller struct {
+	parser     *NFSeParser
+	parsers    *ParserRegistry
+	signatures *SignatureVerifier
+	parties    *PartyRegistry
+	classifier *ServiceClassifier
+	locations  *MunicipalityResolver
+}
+
+// NewDocumentBackfiller creates a new document backfiller instance
+func NewDocumentBackfiller() *DocumentBackfiller {
+	return &DocumentBackfiller{
+		parser:     NewNFSeParser(),
+		parsers:    NewParserRegistry(),
+		signatures: NewSignatureVerifier(),
+		parties:    NewPartyRegistry(),
+		classifier: NewServiceClassifier(),
+		locations:  NewMunicipalityResolver(),
+	}
+}
+
+// BackfillTaxFields fills the tax columns of NFSe documents ingested before they existed.
+// Documents still pending are recognized by a NULL service_description, so the run is idempotent.
+func (b *DocumentBackfiller) BackfillTaxFields(ctx context.Context) (*BackfillResult, error) {
+	return b.backfill(ctx, "backfill_tax_fields", "type = 'nfse' AND service_description IS NULL", taxColumns,
+		func(document *models.Document, xmlContent string) error {
+			parsedData, err := b.parsers.ParseXML(xmlContent)
+			if err != nil {
+				return err
+			}
+			b.parser.ApplyTaxFields(document, parsedData)
+			return nil
+		})
+}
+
+// BackfillAuthenticity verifies the signatures of documents ingested before authenticity was tracked. Once a
+// trust store is loaded, documents left unverified for lack of trusted roots are verified again.
+func (b *DocumentBackfiller) BackfillAuthenticity(ctx context.Context) (*BackfillResult, error) {
+	pending := "authenticity_status IS NULL"
+	if b.signatures.HasTrustAnchors() {
+		pending = fmt.Sprintf("(authenticity_status IS NULL OR authenticity_status = '%s')", xmldsig.StatusUnverified)
+	}
+	return b.backfill(ctx, "backfill_authenticity", pending, authenticityColumns,
+		func(document *models.Document, xmlContent string) error {
+			b.signatures.ApplySignature(document, b.signatures.Verify(xmlContent, document.IssueDate))
+			return nil
+		})
+}
+
+// BackfillIssueDates re-parses the dates of documents stored with a zero issue date,
+// which happened when the original parser did not recognize the date layout or timezone
+func (b *DocumentBackfiller) BackfillIssueDates(ctx context.Context) (*BackfillResult, error) {
+	return b.backfill(ctx, "backfill_issue_dates", "COALESCE(issue_date, '0001-01-01') < '0001-01-02'", issueDateColumns,
+		func(document *models.Document, xmlContent string) error {
+			parsedData, err := b.parsers.ParseXML(xmlContent)
+			if err != nil {
+				return err
+			}
+			if parsedData.IssueDate.IsZero() {
+				return fmt.Errorf("issue date could not be parsed")
+			}
+			document.IssueDate = parsedData.IssueDate
+			document.RpsIssueDate = parsedData.RpsIssueDate
+			return nil
+		})
+}
+
+// BackfillParties registers the providers and takers of documents ingested before the counterparty registry existed.
+// Documents whose only identified party is the company itself stay pending, but are not counted again.
+func (b *DocumentBackfiller) BackfillParties(ctx context.Context) (*BackfillResult, error) {
+	return b.backfill(ctx, "backfill_parties", "provider_party_id IS NULL AND taker_party_id IS NULL", partyColumns,
+		func(document *models.Document, xmlContent string) error {
+			parsedData, err := b.parsers.ParseXML(xmlContent)
+			if err != nil {
+				return err
+			}
+			document.ProviderParty = parsedData.Provider
+			document.TakerParty = parsedData.Taker
+			return b.parties.LinkDocuments(ctx, database.DB, []*models.Document{document})
+		})
+}
+
+// BackfillServiceItems classifies the service and CNAE codes of documents ingested before the catalogs existed.
+// Only stored columns are needed, so the XML is not loaded.
+func (b *DocumentBackfiller) BackfillServiceItems(ctx context.Context) (*BackfillResult, error) {
+	load := []string{"id", "company_id", "service_code", "cnae_code", "validation_status", "validation_errors"}
+	return b.backfillRows(ctx, "backfill_service_items", "service_item IS NULL", load,
+		func(document *models.Document) error {
+			ApplyParseIssues(document, b.classifier.Classify(document))
+			return b.updateColumns(ctx, document, serviceItemColumns)
+		})
+}
+
+// BackfillLocations resolves the place of service, ISS incidence and party municipalities
+// of documents ingested before they were stored
+func (b *DocumentBackfiller) BackfillLocations(ctx context.Context) (*BackfillResult, error) {
+	return b.backfill(ctx, "backfill_locations", "provider_municipality_code IS NULL", locationColumns,
+		func(document *models.Document, xmlContent string) error {
+			parsedData, err := b.parsers.ParseXML(xmlContent)
+			if err != nil {
+				return err
+			}
+			b.parser.ApplyLocation(document, parsedData)
+			b.locations.Resolve(document)
+			return nil
+		})
+}
+
+// BackfillContentHashes records the SHA-256 of the stored XML of documents ingested before hashes were recorded,
+// so that downloads and the storage scrubber can verify them from now on. The current content is taken as the reference.
+func (b *DocumentBackfiller) BackfillContentHashes(ctx context.Context) (*BackfillResult, error) {
+	load := []string{"id", "company_id", "storage_key", "hash"}
+	return b.backfillRows(ctx, "backfill_content_hashes", "COALESCE(hash, '') = '' AND COALESCE(storage_key, '') <> ''", load,
+		func(document *models.Document) error {
+			data, err := storage.Storage.DownloadFile(ctx, "nfse-storage", document.StorageKey)
+			if err != nil {
+				return err
+			}
+			document.Hash = hashContent(string(data))
+			return b.updateColumns(ctx, document, []string{"hash"})
+		})
+}
+
+// backfill pages through pending documents, applies fill to each stored XML and updates the given columns
+func (b *DocumentBackfiller) backfill(ctx context.Context, operation, pending string, columns []string, fill func(document *models.Document, xmlContent string) error) (*BackfillResult, error) {
+	return b.backfillRows(ctx, operation, pending, backfillColumns,
+		func(document *models.Document) error {
+			return b.backfillDocument(ctx, document, columns, fill)
+		})
+}
+
+// backfillRows pages through pending documents, loading the given columns, and applies update to each one
+func (b *DocumentBackfiller) backfillRows(ctx context.Context, operation, pending string, load []string, update func(document *models.Document) error) (*BackfillResult, error) {
+	startTime := time.Now()
+	result := &BackfillResult{}
+
+	logger.InfoWithFields("Starting document backfill", map[string]any{
+		"operation": operation,
+	})
+
+	var lastID int64
+	for {
+		var documents []models.Document
+		err := database.DB.NewSelect().
+			Model(&documents).
+			Column(load...).
+			Where(pending).
+			Where("id > ?", lastID).
+			Order("id ASC").
+			Limit(backfillBatchSize).
+			Scan(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load documents for backfill: %v", err)
+		}
+
+		if len(documents) == 0 {
+			break
+		}
+
+		for i := range documents {
+			document := &documents[i]
+			lastID = document.ID
+			result.Scanned++
+
+			if err := update(document); err != nil {
+				result.Failed++
+				logger.WarnWithFields("Failed to backfill document", map[string]any{
+					"operation":   operation,
+					"document_id": document.ID,
+					"company_id":  document.CompanyID,
+					"error":       err.Error(),
+				})
+				continue
+			}
+			result.Updated++
+		}
+	}
+
+	result.Elapsed = time.Since(startTime)
+
+	logger.InfoWithFields("Completed document backfill", map[string]any{
+		"operation":  operation,
+		"scanned":    result.Scanned,
+		"updated":    result.Updated,
+		"failed":     result.Failed,
+		"elapsed_ms": result.Elapsed.Milliseconds(),
+	})
+
+	return result, nil
+}
+
+// backfillDocument loads the stored XML of a single document, fills it and updates the given columns
+func (b *DocumentBackfiller) backfillDocument(ctx context.Context, document *models.Document, columns []string, fill func(document *models.Document, xmlContent string) error) error {
+	xmlContent, err := loadStoredXML(ctx, document)
+	if err != nil {
+		return err
+	}
+
+	if err := fill(document, xmlContent); err != nil {
+		return err
+	}
+
+	return b.updateColumns(ctx, document, columns)
+}
+
+// updateColumns writes the given columns of a backfilled document
+func (b *DocumentBackfiller) updateColumns(ctx context.Context, document *models.Document, columns []string) error {
+	_, err := database.DB.NewUpdate().
+		Model(document).
+		Column(columns...).
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update document: %v", err)
+	}
+
+	return nil
+}
+
+// loadStoredXML reads the original XML from storage, verified against the document hash
+func loadStoredXML(ctx context.Context, document *models.Document) (string, error) {
+	if document.StorageKey == "" || storage.Storage == nil {
+		return "", fmt.Errorf("no stored XML available for document %d", document.ID)
+	}
+
+	data, err := downloadVerified(ctx, document.StorageKey, document.Hash)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,479 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// Status of an export job
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// Status of a document in the export manifest
+const (
+	ExportEntryOK       = "ok"
+	ExportEntryMissing  = "missing"
+	ExportEntryMismatch = "hash_mismatch"
+)
+
+const (
+	// exportPrefix is the storage prefix of the ZIPs generated by export jobs
+	exportPrefix = "exports"
+	// exportBatchSize is the number of documents loaded per export iteration
+	exportBatchSize = 200
+	// exportManifestName is the name of the CSV manifest inside the ZIP
+	exportManifestName = "manifest.csv"
+	// exportConcurrency is the number of export jobs generated at the same time; the others wait as pending
+	exportConcurrency = 2
+)
+
+// exportSlots limits the export jobs running at the same time in the process
+var exportSlots = make(chan struct{}, exportConcurrency)
+
+// exportManifestHeader are the columns of the export manifest
+var exportManifestHeader = []string{
+	"document_id", "company_id", "type", "number", "issue_date", "competence",
+	"provider_cnpj", "is_cancelled", "file", "sha256", "status",
+}
+
+// ExportResult counts the documents written to an export
+type ExportResult struct {
+	Documents  int64
+	Missing    int64
+	Mismatched int64
+}
+
+// exportDocument holds the document columns needed to export its XML
+type exportDocument struct {
+	ID           int64     `bun:"id"`
+	CompanyID    int64     `bun:"company_id"`
+	Type         string    `bun:"type"`
+	Number       string    `bun:"number"`
+	IssueDate    time.Time `bun:"issue_date"`
+	Competence   string    `bun:"competence"`
+	ProviderCNPJ string    `bun:"provider_cnpj"`
+	IsCancelled  bool      `bun:"is_cancelled"`
+	StorageKey   string    `bun:"storage_key"`
+	Hash         string    `bun:"hash"`
+}
+
+// DocumentExporter builds ZIP files with the stored XMLs of the documents matching a filter
+type DocumentExporter struct {
+	config *config.Config
+}
+
+// NewDocumentExporter creates a new document exporter
+func NewDocumentExporter() *DocumentExporter {
+	return &DocumentExporter{
+		config: config.Get(),
+	}
+}
+
+// SyncLimit returns the number of documents up to which an export is streamed directly in the response
+func (e *DocumentExporter) SyncLimit() int {
+	return e.config.Export.SyncLimit
+}
+
+// Count returns the number of documents visible to the user that match the filter
+func (e *DocumentExporter) Count(ctx context.Context, filter *DocumentFilter, user *models.User) (int, error) {
+	query := database.DB.NewSelect().Model((*models.Document)(nil))
+	query = VisibleDocuments(query, user)
+	return filter.Apply(query).Count(ctx)
+}
+
+// WriteZip writes a ZIP with the XMLs of the documents visible to the user that match the filter, organized in
+// folders like the storage keys (type/year/competence/cnpj), followed by a CSV manifest. The XMLs are streamed
+// from storage one at a time, so memory use does not grow with the size of the export.
+func (e *DocumentExporter) WriteZip(ctx context.Context, w io.Writer, filter *DocumentFilter, user *models.User) (*ExportResult, error) {
+	if storage.Storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+
+	result := &ExportResult{}
+	archive := zip.NewWriter(w)
+
+	var manifestBuffer bytes.Buffer
+	manifest := csv.NewWriter(&manifestBuffer)
+	manifest.Comma = ';'
+	manifest.Write(exportManifestHeader)
+
+	names := make(map[string]bool)
+	var lastID int64
+	for {
+		var documents []exportDocument
+		query := database.DB.NewSelect().
+			Model((*models.Document)(nil)).
+			Column("d.id", "d.company_id", "d.type", "d.number", "d.issue_date", "d.competence",
+				"d.provider_cnpj", "d.is_cancelled", "d.storage_key", "d.hash").
+			Where("d.id > ?", lastID).
+			Order("d.id ASC").
+			Limit(exportBatchSize)
+		query = VisibleDocuments(query, user)
+		if err := filter.Apply(query).Scan(ctx, &documents); err != nil {
+			return nil, fmt.Errorf("failed to load documents: %v", err)
+		}
+
+		if len(documents) == 0 {
+			break
+		}
+
+		for _, document := range documents {
+			lastID = document.ID
+
+			name, status, actualHash, err := e.writeDocument(ctx, archive, document, names)
+			if err != nil {
+				return nil, err
+			}
+
+			switch status {
+			case ExportEntryMissing:
+				result.Missing++
+			case ExportEntryMismatch:
+				result.Mismatched++
+				result.Documents++
+			default:
+				result.Documents++
+			}
+
+			issueDate := ""
+			if !document.IssueDate.IsZero() {
+				issueDate = document.IssueDate.Format(time.DateOnly)
+			}
+			manifest.Write([]string{
+				strconv.FormatInt(document.ID, 10),
+				strconv.FormatInt(document.CompanyID, 10),
+				document.Type,
+				document.Number,
+				issueDate,
+				document.Competence,
+				document.ProviderCNPJ,
+				strconv.FormatBool(document.IsCancelled),
+				name,
+				actualHash,
+				status,
+			})
+		}
+	}
+
+	manifest.Flush()
+	if err := manifest.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     exportManifestName,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %v", err)
+	}
+	if _, err := manifestBuffer.WriteTo(entry); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish ZIP: %v", err)
+	}
+
+	return result, nil
+}
+
+// writeDocument copies the stored XML of a document into the ZIP, returning the entry name, the manifest status
+// and the SHA-256 of the copied content. A document without a stored XML gets no entry.
+func (e *DocumentExporter) writeDocument(ctx context.Context, archive *zip.Writer, document exportDocument, names map[string]bool) (string, string, string, error) {
+	if document.StorageKey == "" {
+		return "", ExportEntryMissing, "", nil
+	}
+
+	reader, _, err := storage.Storage.OpenFile(ctx, "nfse-storage", document.StorageKey)
+	if errors.Is(err, storage.ErrFileNotFound) {
+		return "", ExportEntryMissing, "", nil
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to open stored XML %s: %v", document.StorageKey, err)
+	}
+	defer reader.Close()
+
+	name := exportEntryName(document, names)
+	modified := document.IssueDate
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to add %s to ZIP: %v", name, err)
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(entry, io.TeeReader(reader, hasher)); err != nil {
+		return "", "", "", fmt.Errorf("failed to copy stored XML %s: %v", document.StorageKey, err)
+	}
+
+	actualHash := fmt.Sprintf("%x", hasher.Sum(nil))
+	if document.Hash != "" && actualHash != document.Hash {
+		reportIntegrityMismatch(document.StorageKey, document.Hash, actualHash)
+		return name, ExportEntryMismatch, actualHash, nil
+	}
+
+	return name, ExportEntryOK, actualHash, nil
+}
+
+// exportEntryName returns the path of a document inside the ZIP. Documents that would share a path, such as
+// XMLs with the same file name from different companies, get their ID appended.
+func exportEntryName(document exportDocument, names map[string]bool) string {
+	fileName := path.Base(document.StorageKey)
+	name := organizedPath(document.Type, document.IssueDate, document.Competence, document.ProviderCNPJ, fileName)
+	if names[name] {
+		extension := path.Ext(name)
+		name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, extension), document.ID, extension)
+	}
+	names[name] = true
+	return name
+}
+
+// ExportFileName returns the name of an export ZIP generated at the given time
+func ExportFileName(generatedAt time.Time) string {
+	return fmt.Sprintf("documentos-%s.zip", generatedAt.Format("20060102-150405"))
+}
+
+// CreateJob records an export job and generates its ZIP in the background, uploading it to storage
+func (e *DocumentExporter) CreateJob(ctx context.Context, filter *DocumentFilter, user *models.User) (*models.ExportJob, error) {
+	filterJSON, err := json.Marshal(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode export filter: %v", err)
+	}
+
+	job := &models.ExportJob{
+		UserID:    user.ID,
+		CompanyID: filter.CompanyID,
+		Filter:    filterJSON,
+		Status:    ExportStatusPending,
+		FileName:  ExportFileName(time.Now()),
+	}
+	if _, err := database.DB.NewInsert().Model(job).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record export job: %v", err)
+	}
+
+	logger.InfoWithFields("Created export job", map[string]any{
+		"operation":  "export_documents",
+		"job_id":     job.ID,
+		"user_id":    user.ID,
+		"company_id": filter.CompanyID,
+	})
+
+	// The job outlives the request, so it gets its own copies of the filter and the user
+	jobFilter := *filter
+	jobUser := *user
+	go func() {
+		exportSlots <- struct{}{}
+		defer func() { <-exportSlots }()
+		e.runJob(context.Background(), job, &jobFilter, &jobUser)
+	}()
+
+	return job, nil
+}
+
+// runJob generates the ZIP of an export job and records the outcome
+func (e *DocumentExporter) runJob(ctx context.Context, job *models.ExportJob, filter *DocumentFilter, user *models.User) {
+	startTime := time.Now()
+
+	job.Status = ExportStatusRunning
+	job.StartedAt = startTime
+	_, err := database.DB.NewUpdate().
+		Model(job).
+		Column("status", "started_at", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorWithFields("Failed to start export job", err, map[string]any{
+			"operation": "export_documents",
+			"job_id":    job.ID,
+		})
+		return
+	}
+
+	storageKey := path.Join(exportPrefix, strconv.FormatInt(job.ID, 10), job.FileName)
+	result, size, contentHash, err := e.upload(ctx, storageKey, filter, user)
+
+	job.CompletedAt = time.Now()
+	if err != nil {
+		job.Status = ExportStatusFailed
+		job.Error = err.Error()
+		logger.ErrorWithFields("Export job failed", err, map[string]any{
+			"operation": "export_documents",
+			"job_id":    job.ID,
+		})
+	} else {
+		job.Status = ExportStatusCompleted
+		job.StorageKey = storageKey
+		job.ContentHash = contentHash
+		job.Size = size
+		job.Documents = result.Documents
+		job.Missing = result.Missing
+		job.Mismatched = result.Mismatched
+	}
+
+	_, err = database.DB.NewUpdate().
+		Model(job).
+		ExcludeColumn("id", "user_id", "company_id", "filter", "file_name", "created_at", "started_at").
+		WherePK().
+		Exec(context.Background())
+	if err != nil {
+		logger.ErrorWithFields("Failed to record export job result", err, map[string]any{
+			"operation": "export_documents",
+			"job_id":    job.ID,
+		})
+		return
+	}
+
+	logger.InfoWithFields("Completed export job", map[string]any{
+		"operation":  "export_documents",
+		"job_id":     job.ID,
+		"status":     job.Status,
+		"documents":  job.Documents,
+		"missing":    job.Missing,
+		"mismatched": job.Mismatched,
+		"size":       job.Size,
+		"elapsed_ms": time.Since(startTime).Milliseconds(),
+	})
+}
+
+// upload streams the ZIP of an export to storage through a pipe, returning its size and SHA-256
+func (e *DocumentExporter) upload(ctx context.Context, storageKey string, filter *DocumentFilter, user *models.User) (*ExportResult, int64, string, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	counter := &countingHasher{hash: sha256.New()}
+
+	type zipOutcome struct {
+		result *ExportResult
+		err    error
+	}
+	done := make(chan zipOutcome, 1)
+	go func() {
+		result, err := e.WriteZip(ctx, io.MultiWriter(pipeWriter, counter), filter, user)
+		pipeWriter.CloseWithError(err)
+		done <- zipOutcome{result, err}
+	}()
+
+	// Exports of a single company are encrypted with its data key, the others with the shared key
+	var metadata map[string]string
+	if filter.CompanyID != 0 {
+		metadata = storage.CompanyMetadata(filter.CompanyID)
+	}
+	uploadErr := storage.Storage.UploadStream(ctx, "nfse-storage", storageKey, pipeReader, -1, "application/zip", metadata)
+	// Unblocks the ZIP writer when the upload stopped reading early
+	pipeReader.CloseWithError(uploadErr)
+
+	outcome := <-done
+	if outcome.err != nil {
+		if uploadErr == nil {
+			storage.Storage.DeleteFile(context.Background(), "nfse-storage", storageKey)
+		}
+		return nil, 0, "", outcome.err
+	}
+	if uploadErr != nil {
+		return nil, 0, "", fmt.Errorf("failed to upload export: %v", uploadErr)
+	}
+
+	return outcome.result, counter.size, fmt.Sprintf("%x", counter.hash.Sum(nil)), nil
+}
+
+// countingHasher hashes and counts the bytes written to it
+type countingHasher struct {
+	hash hash.Hash
+	size int64
+}
+
+func (c *countingHasher) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	return c.hash.Write(p)
+}
+
+// Open opens the ZIP of a completed export job. The caller must close the reader.
+// The ZIP is checked against the job hash as it is read; a corrupted file fails with ErrIntegrityMismatch
+// at its end.
+func (e *DocumentExporter) Open(ctx context.Context, job *models.ExportJob) (io.ReadCloser, *storage.FileInfo, error) {
+	if job.StorageKey == "" {
+		return nil, nil, storage.ErrFileNotFound
+	}
+
+	reader, info, err := storage.Storage.OpenFile(ctx, "nfse-storage", job.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newVerifyingReader(reader, job.StorageKey, job.ContentHash), info, nil
+}
+
+// PresignedURL returns a download link for the ZIP of a completed export job that expires after expiry
+func (e *DocumentExporter) PresignedURL(ctx context.Context, job *models.ExportJob, expiry time.Duration) (string, error) {
+	if job.StorageKey == "" {
+		return "", storage.ErrFileNotFound
+	}
+	return storage.Storage.PresignedURL(ctx, "nfse-storage", job.StorageKey, expiry, job.FileName)
+}
+
+// DeleteJob removes an export job and its ZIP
+func (e *DocumentExporter) DeleteJob(ctx context.Context, job *models.ExportJob) error {
+	if job.StorageKey != "" {
+		err := storage.Storage.DeleteFile(ctx, "nfse-storage", job.StorageKey)
+		if err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+			return fmt.Errorf("failed to delete export %s: %v", job.StorageKey, err)
+		}
+	}
+
+	if _, err := database.DB.NewDelete().Model(job).WherePK().Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete export job: %v", err)
+	}
+	return nil
+}
+
+// FailInterrupted marks the export jobs left pending or running by a previous process as failed
+func (e *DocumentExporter) FailInterrupted(ctx context.Context) error {
+	result, err := database.DB.NewUpdate().
+		Model((*models.ExportJob)(nil)).
+		Set("status = ?", ExportStatusFailed).
+		Set("error = ?", "interrupted by a server restart").
+		Set("completed_at = ?", time.Now()).
+		Set("updated_at = ?", time.Now()).
+		Where("status IN (?)", bun.In([]string{ExportStatusPending, ExportStatusRunning})).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fail interrupted export jobs: %v", err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		logger.WarnWithFields("Failed export jobs interrupted by a restart", map[string]any{
+			"operation": "export_documents",
+			"jobs":      affected,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// DocumentFiles manages the stored XML files of documents
+type DocumentFiles struct{}
+
+// NewDocumentFiles creates a new document files instance
+func NewDocumentFiles() *DocumentFiles {
+	return &DocumentFiles{}
+}
+
+// Open opens the stored XML of a document. The caller must close the reader.
+// The XML is read and checked against the document hash before anything is returned, so a corrupted
+// file fails with ErrIntegrityMismatch instead of being sent partially.
+func (f *DocumentFiles) Open(ctx context.Context, document *models.Document) (io.ReadCloser, *storage.FileInfo, error) {
+	if document.StorageKey == "" {
+		return nil, nil, storage.ErrFileNotFound
+	}
+
+	reader, info, err := storage.Storage.OpenFile(ctx, "nfse-storage", document.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read stored XML: %v", err)
+	}
+	if err := verifyContent(document.StorageKey, document.Hash, data); err != nil {
+		return nil, nil, err
+	}
+
+	info.Size = int64(len(data))
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+// PresignedURL returns a download link for the stored XML of a document that expires after expiry
+func (f *DocumentFiles) PresignedURL(ctx context.Context, document *models.Document, expiry time.Duration) (string, error) {
+	if document.StorageKey == "" {
+		return "", storage.ErrFileNotFound
+	}
+	return storage.Storage.PresignedURL(ctx, "nfse-storage", document.StorageKey, expiry, path.Base(document.StorageKey))
+}
+
+// Delete removes a document with its items and revisions, and the XML files no other document uses.
+// The rows are deleted first, recording the files in storage_deletions in the same transaction, and the files
+// are removed once it commits. A file that cannot be removed is logged and left in storage_deletions for the
+// storage reconciliation. A document under legal hold is kept and ErrLegalHold returned.
+func (f *DocumentFiles) Delete(ctx context.Context, document *models.Document) error {
+	deletions := make([]*models.StorageDeletion, 0)
+	err := database.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		storageKeys, err := f.storageKeys(ctx, tx, document)
+		if err != nil {
+			return err
+		}
+
+		// The legal hold is checked in the delete itself, so a hold placed concurrently is never bypassed
+		result, err := tx.NewDelete().Model(document).WherePK().Where("legal_hold = false").Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete document: %v", err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return ErrLegalHold
+		}
+
+		if len(storageKeys) == 0 {
+			return nil
+		}
+		for _, storageKey := range storageKeys {
+			deletions = append(deletions, &models.StorageDeletion{
+				StorageKey: storageKey,
+				CompanyID:  document.CompanyID,
+				DocumentID: document.ID,
+			})
+		}
+		_, err = tx.NewInsert().
+			Model(&deletions).
+			On("CONFLICT (storage_key) DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to record stored XMLs to delete: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	storageKeys := make([]string, 0, len(deletions))
+	pending := make([]string, 0)
+	for _, deletion := range deletions {
+		storageKeys = append(storageKeys, deletion.StorageKey)
+		if err := removeStorageDeletion(ctx, deletion); err != nil {
+			pending = append(pending, deletion.StorageKey)
+			logger.WarnWithFields("Failed to delete stored XML of deleted document, left for the storage reconciliation", map[string]any{
+				"operation":   "delete_document",
+				"company_id":  document.CompanyID,
+				"document_id": document.ID,
+				"storage_key": deletion.StorageKey,
+				"error":       err.Error(),
+			})
+		}
+	}
+
+	logger.InfoWithFields("Deleted document", map[string]any{
+		"operation":            "delete_document",
+		"company_id":           document.CompanyID,
+		"document_id":          document.ID,
+		"storage_keys":         storageKeys,
+		"pending_storage_keys": pending,
+	})
+
+	return nil
+}
+
+// removeStorageDeletion removes the XML of a deleted document from storage and then its storage_deletions row.
+// A file referenced again, such as the same XML ingested after the deletion, is kept. A failure is counted in
+// the row, which stays for the next attempt.
+func removeStorageDeletion(ctx context.Context, deletion *models.StorageDeletion) error {
+	unreferenced, err := unreferencedStorageKeys(ctx, []string{deletion.StorageKey})
+	if err != nil {
+		return err
+	}
+
+	if len(unreferenced) > 0 {
+		err = storage.Storage.DeleteFile(ctx, "nfse-storage", deletion.StorageKey)
+		if err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+			_, updateErr := database.DB.NewUpdate().
+				Model((*models.StorageDeletion)(nil)).
+				Set("attempts = attempts + 1").
+				Set("last_error = ?", err.Error()).
+				Where("storage_key = ?", deletion.StorageKey).
+				Exec(ctx)
+			if updateErr != nil {
+				logger.ErrorWithFields("Failed to record stored XML deletion failure", updateErr, map[string]any{
+					"operation":   "delete_stored_xml",
+					"storage_key": deletion.StorageKey,
+				})
+			}
+			return fmt.Errorf("failed to delete stored XML %s: %v", deletion.StorageKey, err)
+		}
+	}
+
+	_, err = database.DB.NewDelete().
+		Model((*models.StorageDeletion)(nil)).
+		Where("storage_key = ?", deletion.StorageKey).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to clear stored XML deletion %s: %v", deletion.StorageKey, err)
+	}
+	return nil
+}
+
+// storageKeys returns the XML files of a document and its revisions that no other document references
+func (f *DocumentFiles) storageKeys(ctx context.Context, tx bun.Tx, document *models.Document) ([]string, error) {
+	var revisionKeys []string
+	err := tx.NewSelect().
+		Model((*models.DocumentRevision)(nil)).
+		Column("storage_key").
+		Where("document_id = ?", document.ID).
+		Where("storage_key <> ''").
+		Scan(ctx, &revisionKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revision files: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	storageKeys := make([]string, 0, len(revisionKeys)+1)
+	for _, storageKey := range append([]string{document.StorageKey}, revisionKeys...) {
+		if storageKey == "" || seen[storageKey] {
+			continue
+		}
+		seen[storageKey] = true
+
+		shared, err := tx.NewSelect().
+			Model((*models.Document)(nil)).
+			Where("storage_key = ? AND id <> ?", storageKey, document.ID).
+			Exists(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check stored XML %s: %v", storageKey, err)
+		}
+		if !shared {
+			shared, err = tx.NewSelect().
+				Model((*models.DocumentRevision)(nil)).
+				Where("storage_key = ? AND document_id <> ?", storageKey, document.ID).
+				Exists(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check stored XML %s: %v", storageKey, err)
+			}
+		}
+		if !shared {
+			storageKeys = append(storageKeys, storageKey)
+		}
+	}
+
+	return storageKeys, nil
+}
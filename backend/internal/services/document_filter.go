@@ -0,0 +1,130 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/internal/catalog"
+	"github.com/zoomxml/internal/models"
+)
+
+// DocumentFilter selects documents by the filters shared by the document listing and the exports
+type DocumentFilter struct {
+	CompanyID          int64  `json:"company_id,omitempty"`
+	Type               string `json:"type,omitempty"`
+	Status             string `json:"status,omitempty"`
+	ValidationStatus   string `json:"validation_status,omitempty"`
+	Authenticity       string `json:"authenticity,omitempty"`
+	SignerCNPJ         string `json:"signer_cnpj,omitempty"`
+	ServiceItem        string `json:"service_item,omitempty"`
+	ServiceUF          string `json:"service_uf,omitempty"`
+	IssOutsideProvider *bool  `json:"iss_outside_provider,omitempty"`
+	Cancelled          *bool  `json:"cancelled,omitempty"`
+	StorageMissing     *bool  `json:"storage_missing,omitempty"` // Documents flagged by the storage reconciliation
+	IssueDateFrom      string `json:"issue_date_from,omitempty"` // YYYY-MM-DD, inclusive
+	IssueDateTo        string `json:"issue_date_to,omitempty"`   // YYYY-MM-DD, inclusive
+	Competence         string `json:"competence,omitempty"`      // YYYY-MM
+}
+
+// Validate checks the format of the date and competence filters
+func (f *DocumentFilter) Validate() error {
+	if f.IssueDateFrom != "" {
+		if _, err := time.Parse(time.DateOnly, f.IssueDateFrom); err != nil {
+			return fmt.Errorf("invalid issue_date_from, use YYYY-MM-DD")
+		}
+	}
+	if f.IssueDateTo != "" {
+		if _, err := time.Parse(time.DateOnly, f.IssueDateTo); err != nil {
+			return fmt.Errorf("invalid issue_date_to, use YYYY-MM-DD")
+		}
+	}
+	if f.IssueDateFrom != "" && f.IssueDateTo != "" && f.IssueDateFrom > f.IssueDateTo {
+		return fmt.Errorf("issue_date_from must not be after issue_date_to")
+	}
+	if f.Competence != "" {
+		if _, err := time.Parse("2006-01", f.Competence); err != nil {
+			return fmt.Errorf("invalid competence, use YYYY-MM")
+		}
+	}
+	return nil
+}
+
+// Apply adds the filters to a query over documents. The filter must have been validated.
+func (f *DocumentFilter) Apply(query *bun.SelectQuery) *bun.SelectQuery {
+	if f.CompanyID != 0 {
+		query = query.Where("d.company_id = ?", f.CompanyID)
+	}
+	if f.Type != "" {
+		query = query.Where("d.type = ?", f.Type)
+	}
+	if f.Status != "" {
+		query = query.Where("d.status = ?", f.Status)
+	}
+	if f.ValidationStatus != "" {
+		query = query.Where("d.validation_status = ?", f.ValidationStatus)
+	}
+	if f.Authenticity != "" {
+		query = query.Where("d.authenticity_status = ?", f.Authenticity)
+	}
+	if f.SignerCNPJ != "" {
+		query = query.Where("d.signer_cnpj = ?", f.SignerCNPJ)
+	}
+	if f.ServiceItem != "" {
+		query = query.Where("d.service_item = ?", catalog.NormalizeServiceCode(f.ServiceItem))
+	}
+	if f.ServiceUF != "" {
+		query = query.Where("d.service_uf = ?", strings.ToUpper(f.ServiceUF))
+	}
+	if f.IssOutsideProvider != nil {
+		query = query.Where("d.iss_due_outside_provider = ?", *f.IssOutsideProvider)
+	}
+	if f.Cancelled != nil {
+		query = query.Where("d.is_cancelled = ?", *f.Cancelled)
+	}
+	if f.StorageMissing != nil {
+		if *f.StorageMissing {
+			query = query.Where("d.storage_missing_at IS NOT NULL")
+		} else {
+			query = query.Where("d.storage_missing_at IS NULL")
+		}
+	}
+	if from, err := time.Parse(time.DateOnly, f.IssueDateFrom); err == nil {
+		query = query.Where("d.issue_date >= ?", from)
+	}
+	if to, err := time.Parse(time.DateOnly, f.IssueDateTo); err == nil {
+		query = query.Where("d.issue_date < ?", to.AddDate(0, 0, 1))
+	}
+	if month, err := time.Parse("2006-01", f.Competence); err == nil {
+		// The competence is stored as received: ISO dates (2026-09-01), Brazilian dates (01/09/2026) or empty,
+		// in which case the issue date stands for it
+		query = query.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.
+				Where("d.competence LIKE ?", month.Format("2006-01")+"%").
+				WhereOr("d.competence LIKE ?", "__/"+month.Format("01/2006")+"%").
+				WhereOr("COALESCE(d.competence, '') = '' AND d.issue_date >= ? AND d.issue_date < ?", month, month.AddDate(0, 1, 0))
+		})
+	}
+	return query
+}
+
+// VisibleDocuments restricts a query over documents to the companies the user can see:
+// admins see all companies, other users the active unrestricted ones and those they are members of
+func VisibleDocuments(query *bun.SelectQuery, user *models.User) *bun.SelectQuery {
+	if user.IsAdmin() {
+		return query
+	}
+
+	return query.Where(`
+		d.company_id IN (
+			SELECT c.id FROM companies c
+			WHERE (c.restricted = false AND c.active = true) OR
+			(c.id IN (
+				SELECT cm.company_id FROM company_members cm
+				WHERE cm.user_id = ? AND cm.company_id = c.id
+			))
+		)
+	`, user.ID)
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+
+	"github.com/zoomxml/internal/dates"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+// Document types stored in documents.type
+const (
+	DocumentTypeNFSe = "nfse"
+	DocumentTypeNFe  = "nfe"
+	DocumentTypeCTe  = "cte"
+)
+
+// Supported XML layouts
+const (
+	LayoutPrefeituraModerna = "prefeitura_moderna"
+	LayoutABRASF            = "abrasf"
+	LayoutNFSeNacional      = "nfse_nacional"
+	LayoutNFe               = "nfe"
+	LayoutCTe               = "cte"
+)
+
+// Namespaces used to recognize the national layouts
+const (
+	namespaceNFSeNacional = "http://www.sped.fazenda.gov.br/nfse"
+	namespaceNFe          = "http://www.portalfiscal.inf.br/nfe"
+	namespaceCTe          = "http://www.portalfiscal.inf.br/cte"
+)
+
+// DocumentParser extracts normalized data from one XML layout
+type DocumentParser interface {
+	// Layout returns the layout identifier handled by the parser
+	Layout() string
+	// Matches reports whether the parser handles documents with the given root element
+	Matches(root xml.Name) bool
+	// ParseXML parses the XML content into the normalized result
+	ParseXML(xmlContent string) (*ParsedNFSeData, error)
+}
+
+// ParserRegistry routes XML documents to the parser of their layout based on the root element
+type ParserRegistry struct {
+	parsers []DocumentParser
+}
+
+// NewParserRegistry creates a registry with all supported layouts registered
+func NewParserRegistry() *ParserRegistry {
+	registry := &ParserRegistry{}
+	registry.Register(NewNFSeParser())
+	registry.Register(NewABRASFParser())
+	registry.Register(NewNFSeNacionalParser())
+	registry.Register(NewNFeParser())
+	registry.Register(NewCTeParser())
+	return registry
+}
+
+// Register adds a parser to the registry; parsers registered first take precedence
+func (r *ParserRegistry) Register(parser DocumentParser) {
+	r.parsers = append(r.parsers, parser)
+}
+
+// ParserFor returns the parser able to handle the given XML content
+func (r *ParserRegistry) ParserFor(xmlContent string) (DocumentParser, error) {
+	root, err := sniffRootElement(xmlContent)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, parser := range r.parsers {
+		if parser.Matches(root) {
+			return parser, nil
+		}
+	}
+
+	if root.Space != "" {
+		return nil, fmt.Errorf("unsupported document layout: root element %s (%s)", root.Local, root.Space)
+	}
+	return nil, fmt.Errorf("unsupported document layout: root element %s", root.Local)
+}
+
+// ParseXML detects the layout of the XML content and parses it with the matching parser
+func (r *ParserRegistry) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
+	if strings.TrimSpace(xmlContent) == "" {
+		return nil, fmt.Errorf("empty XML content")
+	}
+
+	parser, err := r.ParserFor(xmlContent)
+	if err != nil {
+		logger.WarnWithFields("Could not detect XML layout", map[string]any{
+			"operation": "detect_xml_layout",
+			"error":     err.Error(),
+		})
+		return nil, err
+	}
+
+	logger.DebugWithFields("Detected XML layout", map[string]any{
+		"operation": "detect_xml_layout",
+		"layout":    parser.Layout(),
+	})
+
+	return parser.ParseXML(xmlContent)
+}
+
+// sniffRootElement reads the XML content up to its root element
+func sniffRootElement(xmlContent string) (xml.Name, error) {
+	decoder := xml.NewDecoder(strings.NewReader(xmlContent))
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return xml.Name{}, fmt.Errorf("XML content has no root element")
+		}
+		if err != nil {
+			return xml.Name{}, fmt.Errorf("failed to read XML: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name, nil
+		}
+	}
+}
+
+// firstNonEmpty returns the first value that is not blank
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// documentDates parses the dates of one document in its municipality's time zone,
+// collecting the values that could not be parsed as validation issues
+type documentDates struct {
+	location *time.Location
+	issues   []models.ValidationIssue
+}
+
+// newDocumentDates creates a date parser for a document issued in the given IBGE municipality
+func newDocumentDates(municipalityCode string) *documentDates {
+	return &documentDates{
+		location: dates.LocationForMunicipality(municipalityCode),
+	}
+}
+
+// parse parses a date field, recording an issue when it is invalid or when a required field is missing
+func (d *documentDates) parse(path, raw string, required bool) time.Time {
+	value, err := dates.Parse(raw, d.location)
+	if err == nil {
+		return value
+	}
+
+	if errors.Is(err, dates.ErrEmpty) {
+		if required {
+			d.issues = append(d.issues, models.ValidationIssue{
+				Code:    ValidationCodeInvalidDate,
+				Path:    path,
+				Message: "required date is missing",
+			})
+		}
+		return time.Time{}
+	}
+
+	logger.WarnWithFields("Failed to parse date", map[string]any{
+		"operation": "parse_document_xml",
+		"path":      path,
+		"value":     strings.TrimSpace(raw),
+	})
+	d.issues = append(d.issues, models.ValidationIssue{
+		Code:    ValidationCodeInvalidDate,
+		Path:    path,
+		Message: err.Error(),
+	})
+	return time.Time{}
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// revisionPrefix keeps the XML of each document revision apart from the organized document paths in the bucket
+const revisionPrefix = "revisions"
+
+// revisionColumns are the document columns a new revision replaces: the fields read from the XML, its storage key
+// and hash, and the links to the parties it names. Columns set by users and other jobs, such as the legal hold and the storage reconciliation mark, are
+// left as they are.
+var revisionColumns = []string{
+	"type", "key", "number", "series", "issue_date", "due_date", "amount", "storage_key", "hash", "metadata",
+	"verification_code", "provider_cnpj", "taker_cnpj", "service_value", "service_code", "municipal_registration",
+	"document_hash", "is_cancelled", "is_substituted", "competence", "rps_issue_date", "taker_name", "provider_name",
+	"provider_trade_name", "service_municipality_name", "service_uf", "iss_municipality_code",
+	"provider_municipality_code", "provider_municipality_name", "provider_uf", "taker_municipality_code",
+	"taker_municipality_name", "taker_uf", "iss_due_outside_provider", "deductions_value", "pis_value",
+	"cofins_value", "inss_value", "ir_value", "csll_value", "iss_withheld", "iss_value", "other_withholdings",
+	"calculation_base", "iss_rate", "net_value", "conditional_discount", "unconditional_discount", "cnae_code",
+	"operation_nature", "simples_nacional_optant", "service_description", "service_municipality_code",
+	"service_item", "service_item_description", "cnae_description", "validation_status", "validation_errors",
+	"authenticity_status", "signer_cnpj", "signer_certificate_serial", "provider_party_id", "taker_party_id",
+	"updated_at",
+}
+
+// RevisionChange is a field that differs between two revisions of a document
+type RevisionChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// RevisionTracker keeps the materially different versions of a document received more than once
+type RevisionTracker struct {
+	parties *PartyRegistry
+}
+
+// NewRevisionTracker creates a new revision tracker instance
+func NewRevisionTracker() *RevisionTracker {
+	return &RevisionTracker{
+		parties: NewPartyRegistry(),
+	}
+}
+
+// Track compares an incoming version of a stored document with it. When the fields differ and the version is not
+// already known, the XML is stored as a new revision and the document is updated to it. The first revision of a
+// document records the version it had before. It returns nil when the incoming version is not a new revision.
+func (t *RevisionTracker) Track(ctx context.Context, existing, incoming *models.Document, xmlDoc XMLDocument) (*models.DocumentRevision, error) {
+	// A note of one company is never a revision of another company's document
+	if existing.CompanyID != incoming.CompanyID {
+		return nil, fmt.Errorf("document %d belongs to company %d, not to company %d", existing.ID, existing.CompanyID, incoming.CompanyID)
+	}
+
+	contentHash := hashContent(xmlDoc.Content)
+	if existing.Hash == contentHash {
+		return nil, nil
+	}
+
+	current := revisionSnapshot(existing)
+	next := revisionSnapshot(incoming)
+	changed := changedFields(current, next)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	revisions := make([]models.DocumentRevision, 0)
+	err := database.DB.NewSelect().
+		Model(&revisions).
+		Column("revision", "content_hash", "snapshot").
+		Where("document_id = ?", existing.ID).
+		Order("revision ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load document revisions: %v", err)
+	}
+
+	for _, revision := range revisions {
+		// A version already seen, such as the original note arriving again after its cancellation
+		if revision.ContentHash == contentHash || len(changedFields(revision.Snapshot, next)) == 0 {
+			return nil, nil
+		}
+	}
+
+	var baseline *models.DocumentRevision
+	if len(revisions) == 0 {
+		baseline = &models.DocumentRevision{
+			DocumentID:  existing.ID,
+			CompanyID:   existing.CompanyID,
+			Revision:    1,
+			ContentHash: existing.Hash,
+			StorageKey:  existing.StorageKey,
+			FileName:    path.Base(existing.StorageKey),
+			Snapshot:    current,
+		}
+		if baseline.ContentHash == "" {
+			if xmlContent, err := loadStoredXML(ctx, existing); err == nil {
+				baseline.ContentHash = hashContent(xmlContent)
+			}
+		}
+		revisions = append(revisions, *baseline)
+	}
+
+	revision := &models.DocumentRevision{
+		DocumentID:    existing.ID,
+		CompanyID:     existing.CompanyID,
+		Revision:      revisions[len(revisions)-1].Revision + 1,
+		ContentHash:   contentHash,
+		StorageKey:    fmt.Sprintf("%s/%d/%d/%s.xml", revisionPrefix, existing.CompanyID, existing.ID, contentHash),
+		FileName:      xmlDoc.FileName,
+		Snapshot:      next,
+		ChangedFields: changed,
+	}
+
+	if err := storage.Storage.UploadFileWithMetadata(ctx, "nfse-storage", revision.StorageKey, []byte(xmlDoc.Content), "application/xml", storage.CompanyMetadata(revision.CompanyID)); err != nil {
+		return nil, fmt.Errorf("failed to store revision XML: %v", err)
+	}
+
+	// The document takes the state of the new revision, keeping its identity. Its party links are resolved again,
+	// since the revision may correct the provider or taker.
+	incoming.ID = existing.ID
+	incoming.CompanyID = existing.CompanyID
+	incoming.CreatedAt = existing.CreatedAt
+	incoming.StorageKey = revision.StorageKey
+	incoming.Hash = contentHash
+
+	err = database.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if baseline != nil {
+			if _, err := tx.NewInsert().Model(baseline).Exec(ctx); err != nil {
+				return fmt.Errorf("failed to save baseline revision: %v", err)
+			}
+		}
+
+		if _, err := tx.NewInsert().Model(revision).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to save revision: %v", err)
+		}
+
+		if err := t.parties.RelinkDocument(ctx, tx, existing, incoming); err != nil {
+			return err
+		}
+
+		_, err := tx.NewUpdate().
+			Model(incoming).
+			Column(revisionColumns...).
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to update document: %v", err)
+		}
+
+		if _, err := tx.NewDelete().Model((*models.DocumentItem)(nil)).Where("document_id = ?", incoming.ID).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to replace document items: %v", err)
+		}
+		if len(incoming.Items) == 0 {
+			return nil
+		}
+		for _, item := range incoming.Items {
+			item.ID = 0
+			item.DocumentID = incoming.ID
+			item.CompanyID = incoming.CompanyID
+		}
+		if _, err := tx.NewInsert().Model(&incoming.Items).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to replace document items: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.InfoWithFields("Stored document revision", map[string]any{
+		"operation":      "track_revision",
+		"company_id":     existing.CompanyID,
+		"document_id":    existing.ID,
+		"revision":       revision.Revision,
+		"changed_fields": changed,
+		"storage_key":    revision.StorageKey,
+	})
+
+	return revision, nil
+}
+
+// DiffRevisions returns the fields that differ between two revisions of a document
+func DiffRevisions(from, to *models.DocumentRevision) []RevisionChange {
+	changes := make([]RevisionChange, 0)
+	for _, field := range changedFields(from.Snapshot, to.Snapshot) {
+		changes = append(changes, RevisionChange{
+			Field: field,
+			From:  from.Snapshot[field],
+			To:    to.Snapshot[field],
+		})
+	}
+	return changes
+}
+
+// changedFields returns the sorted names of the fields that differ between two snapshots
+func changedFields(from, to map[string]string) []string {
+	fields := make([]string, 0)
+	for field, value := range to {
+		if from[field] != value {
+			fields = append(fields, field)
+		}
+	}
+	for field := range from {
+		if _, ok := to[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// revisionSnapshot returns the document fields read from the XML that define a version of the document.
+// Values are normalized to strings so that a stored document and a freshly parsed one compare equal.
+func revisionSnapshot(document *models.Document) map[string]string {
+	return map[string]string{
+		"number":                     document.Number,
+		"series":                     document.Series,
+		"issue_date":                 snapshotTime(document.IssueDate),
+		"rps_issue_date":             snapshotTime(document.RpsIssueDate),
+		"competence":                 document.Competence,
+		"verification_code":          document.VerificationCode,
+		"is_cancelled":               strconv.FormatBool(document.IsCancelled),
+		"is_substituted":             strconv.FormatBool(document.IsSubstituted),
+		"provider_cnpj":              document.ProviderCNPJ,
+		"provider_name":              document.ProviderName,
+		"provider_trade_name":        document.ProviderTradeName,
+		"provider_municipality_code": document.ProviderMunicipalityCode,
+		"municipal_registration":     document.MunicipalRegistration,
+		"taker_cnpj":                 document.TakerCNPJ,
+		"taker_name":                 document.TakerName,
+		"taker_municipality_code":    document.TakerMunicipalityCode,
+		"amount":                     document.Amount.String(),
+		"service_value":              document.ServiceValue.String(),
+		"service_code":               document.ServiceCode,
+		"service_description":        document.ServiceDescription,
+		"service_municipality_code":  document.ServiceMunicipalityCode,
+		"iss_municipality_code":      document.IssMunicipalityCode,
+		"cnae_code":                  document.CnaeCode,
+		"operation_nature":           document.OperationNature,
+		"simples_nacional_optant":    strconv.FormatBool(document.SimplesNacionalOptant),
+		"deductions_value":           document.DeductionsValue.String(),
+		"pis_value":                  document.PisValue.String(),
+		"cofins_value":               document.CofinsValue.String(),
+		"inss_value":                 document.InssValue.String(),
+		"ir_value":                   document.IrValue.String(),
+		"csll_value":                 document.CsllValue.String(),
+		"iss_withheld":               strconv.FormatBool(document.IssWithheld),
+		"iss_value":                  document.IssValue.String(),
+		"iss_rate":                   document.IssRate.String(),
+		"other_withholdings":         document.OtherWithholdings.String(),
+		"calculation_base":           document.CalculationBase.String(),
+		"net_value":                  document.NetValue.String(),
+		"conditional_discount":       document.ConditionalDiscount.String(),
+		"unconditional_discount":     document.UnconditionalDiscount.String(),
+	}
+}
+
+// snapshotTime formats a time in UTC, the zone timestamps are read back from the database in
+func snapshotTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// hashContent returns the hex SHA-256 of a file content
+func hashContent(content string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+}
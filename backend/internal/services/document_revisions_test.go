@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/models"
+)
+
+// A revision replaces the fields read from the XML, never the legal hold or the storage reconciliation mark
+func TestRevisionTrackerKeepsLegalHold(t *testing.T) {
+	requireDatabase(t)
+	ctx := context.Background()
+	company := createTestCompany(t)
+
+	heldAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	existing := &models.Document{
+		CompanyID:        company.ID,
+		Type:             DocumentTypeNFSe,
+		Number:           "123",
+		VerificationCode: "A1B2C3D4E",
+		ProviderCNPJ:     "34194865000158",
+		ServiceValue:     decimal.NewFromInt(1500),
+		Amount:           decimal.NewFromInt(1500),
+		Status:           "processed",
+		StorageKey:       "nfse/2025/022025/34194865000158/original.xml",
+		Hash:             hashContent("original"),
+		LegalHold:        true,
+		LegalHoldReason:  "Fiscalização 2025/0042",
+		LegalHoldAt:      heldAt,
+		StorageMissingAt: heldAt,
+	}
+	if _, err := database.DB.NewInsert().Model(existing).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The note fetched again, with the service value corrected
+	incoming := &models.Document{
+		CompanyID:        company.ID,
+		Type:             DocumentTypeNFSe,
+		Number:           "123",
+		VerificationCode: "A1B2C3D4E",
+		ProviderCNPJ:     "34194865000158",
+		ServiceValue:     decimal.NewFromInt(2500),
+		Amount:           decimal.NewFromInt(2500),
+		Status:           "processed",
+	}
+	revision, err := NewRevisionTracker().Track(ctx, existing, incoming, XMLDocument{FileName: "revised.xml", Content: "revised"})
+	if err != nil {
+		t.Fatalf("Track: %v", err)
+	}
+	if revision == nil {
+		t.Fatal("Track stored no revision for a changed note")
+	}
+
+	stored := new(models.Document)
+	if err := database.DB.NewSelect().Model(stored).Where("id = ?", existing.ID).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !stored.ServiceValue.Equal(decimal.NewFromInt(2500)) || stored.StorageKey != revision.StorageKey {
+		t.Errorf("document service value, storage key = %s, %s; want 2500, %s", stored.ServiceValue, stored.StorageKey, revision.StorageKey)
+	}
+	if !stored.LegalHold || stored.LegalHoldReason != existing.LegalHoldReason || !stored.LegalHoldAt.Equal(heldAt) {
+		t.Errorf("legal hold = %v, %q, %s; want true, %q, %s", stored.LegalHold, stored.LegalHoldReason, stored.LegalHoldAt, existing.LegalHoldReason, heldAt)
+	}
+	if !stored.StorageMissingAt.Equal(heldAt) {
+		t.Errorf("storage_missing_at = %s; want %s", stored.StorageMissingAt, heldAt)
+	}
+}
+
+// A revision that corrects the provider moves the document to the corrected party
+func TestRevisionTrackerRelinksParties(t *testing.T) {
+	requireDatabase(t)
+	ctx := context.Background()
+	company := createTestCompany(t)
+	parties := NewPartyRegistry()
+
+	existing := &models.Document{
+		CompanyID:        company.ID,
+		Type:             DocumentTypeNFSe,
+		Number:           "456",
+		VerificationCode: "K9L8M7N6P",
+		ProviderCNPJ:     "11222333000181",
+		ServiceValue:     decimal.NewFromInt(800),
+		Amount:           decimal.NewFromInt(800),
+		Status:           "processed",
+		StorageKey:       "nfse/2025/022025/11222333000181/original.xml",
+		Hash:             hashContent("original"),
+		ProviderParty:    newParty(models.Party{TaxID: "11.222.333/0001-81", Name: "Prestador Errado Ltda"}),
+	}
+	if _, err := database.DB.NewInsert().Model(existing).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := parties.LinkDocuments(ctx, database.DB, []*models.Document{existing}); err != nil {
+		t.Fatal(err)
+	}
+	previousPartyID := *existing.ProviderPartyID
+
+	incoming := &models.Document{
+		CompanyID:        company.ID,
+		Type:             DocumentTypeNFSe,
+		Number:           "456",
+		VerificationCode: "K9L8M7N6P",
+		ProviderCNPJ:     "11444777000161",
+		ServiceValue:     decimal.NewFromInt(800),
+		Amount:           decimal.NewFromInt(800),
+		Status:           "processed",
+		ProviderParty:    newParty(models.Party{TaxID: "11.444.777/0001-61", Name: "Prestador Correto Ltda"}),
+	}
+	if _, err := NewRevisionTracker().Track(ctx, existing, incoming, XMLDocument{FileName: "revised.xml", Content: "revised"}); err != nil {
+		t.Fatalf("Track: %v", err)
+	}
+
+	stored := new(models.Document)
+	if err := database.DB.NewSelect().Model(stored).Where("id = ?", existing.ID).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stored.ProviderPartyID == nil || *stored.ProviderPartyID == previousPartyID {
+		t.Fatalf("provider_party_id = %v; want the corrected party", stored.ProviderPartyID)
+	}
+
+	counts := map[int64]int64{previousPartyID: 1, *stored.ProviderPartyID: 0}
+	for id := range counts {
+		party := new(models.Party)
+		if err := database.DB.NewSelect().Model(party).Where("id = ?", id).Scan(ctx); err != nil {
+			t.Fatal(err)
+		}
+		counts[id] = party.DocumentCount
+	}
+	if counts[previousPartyID] != 0 || counts[*stored.ProviderPartyID] != 1 {
+		t.Errorf("document counts of previous, corrected party = %d, %d; want 0, 1", counts[previousPartyID], counts[*stored.ProviderPartyID])
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/models"
+)
+
+// IngestionDay is the outcome of the files received on one day
+type IngestionDay struct {
+	Date        string `bun:"date" json:"date"` // YYYY-MM-DD
+	New         int64  `bun:"new" json:"new"`
+	Duplicate   int64  `bun:"duplicate" json:"duplicate"`
+	Revised     int64  `bun:"revised" json:"revised"`
+	Error       int64  `bun:"error" json:"error"`
+	Quarantined int64  `bun:"quarantined" json:"quarantined"`
+}
+
+// IngestionTotals sums the outcomes of the files received in a period
+type IngestionTotals struct {
+	Files       int64   `bun:"files" json:"files"`
+	New         int64   `bun:"new" json:"new"`
+	Duplicate   int64   `bun:"duplicate" json:"duplicate"`
+	Revised     int64   `bun:"revised" json:"revised"`
+	Error       int64   `bun:"error" json:"error"`
+	Quarantined int64   `bun:"quarantined" json:"quarantined"`
+	ErrorRate   float64 `bun:"error_rate" json:"error_rate"` // Percentual de arquivos com erro ou em quarentena
+}
+
+// IngestionError is an error message and how many files failed with it
+type IngestionError struct {
+	Error    string    `bun:"error" json:"error"`
+	Files    int64     `bun:"files" json:"files"`
+	LastSeen time.Time `bun:"last_seen" json:"last_seen"`
+}
+
+// IngestionReport describes the ingestion quality of a company in a period
+type IngestionReport struct {
+	CompanyID        int64            `json:"company_id"`
+	PeriodDays       int              `json:"period_days"`
+	Since            time.Time        `json:"since"`
+	Totals           IngestionTotals  `json:"totals"`
+	Daily            []IngestionDay   `json:"daily"`
+	DuplicateReasons map[string]int64 `json:"duplicate_reasons"` // Duplicatas por critério de identificação
+	TopErrors        []IngestionError `json:"top_errors"`
+	Documents        map[string]any   `json:"documents"` // Estatísticas de documentos e deduplicação da empresa
+}
+
+// CompanyIngestion ranks a company by the quality of its ingestion
+type CompanyIngestion struct {
+	CompanyID   int64  `bun:"company_id" json:"company_id"`
+	CompanyName string `bun:"company_name" json:"company_name"`
+	CNPJ        string `bun:"cnpj" json:"cnpj"`
+	IngestionTotals
+	LastProcessedAt time.Time `bun:"last_processed_at" json:"last_processed_at"`
+}
+
+// Aggregations of the outcomes recorded in processed_files
+const (
+	ingestionOutcomeColumns = `
+		COUNT(*) FILTER (WHERE pf.status = 'processed') AS new,
+		COUNT(*) FILTER (WHERE pf.status = 'duplicate') AS duplicate,
+		COUNT(*) FILTER (WHERE pf.status = 'revised') AS revised,
+		COUNT(*) FILTER (WHERE pf.status = 'error') AS error,
+		COUNT(*) FILTER (WHERE pf.status = 'quarantined') AS quarantined`
+	ingestionErrorRate = "ROUND(100.0 * COUNT(*) FILTER (WHERE pf.status IN ('error', 'quarantined')) / NULLIF(COUNT(*), 0), 2)::float8"
+)
+
+// IngestionStats reports the outcome of the files received by the companies, from the processed files registry.
+// Each file counts once, on the day it was last received.
+type IngestionStats struct {
+	manager *NFSeXMLManager
+}
+
+// NewIngestionStats creates a new ingestion statistics instance
+func NewIngestionStats() *IngestionStats {
+	return &IngestionStats{
+		manager: NewNFSeXMLManager(),
+	}
+}
+
+// CompanyReport returns the daily outcomes, duplicate reasons and most frequent errors of a company
+func (s *IngestionStats) CompanyReport(ctx context.Context, companyID int64, days, topErrors int) (*IngestionReport, error) {
+	since := ingestionSince(days)
+	report := &IngestionReport{
+		CompanyID:        companyID,
+		PeriodDays:       days,
+		Since:            since,
+		Daily:            make([]IngestionDay, 0),
+		DuplicateReasons: make(map[string]int64),
+		TopErrors:        make([]IngestionError, 0),
+	}
+
+	err := database.DB.NewSelect().
+		Model((*models.ProcessedFile)(nil)).
+		ColumnExpr("COUNT(*) AS files").
+		ColumnExpr(ingestionOutcomeColumns).
+		ColumnExpr("COALESCE("+ingestionErrorRate+", 0) AS error_rate").
+		Where("pf.company_id = ? AND pf.processed_at >= ?", companyID, since).
+		Scan(ctx, &report.Totals)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingestion totals: %v", err)
+	}
+
+	err = database.DB.NewSelect().
+		Model((*models.ProcessedFile)(nil)).
+		ColumnExpr("TO_CHAR(DATE(pf.processed_at), 'YYYY-MM-DD') AS date").
+		ColumnExpr(ingestionOutcomeColumns).
+		Where("pf.company_id = ? AND pf.processed_at >= ?", companyID, since).
+		GroupExpr("DATE(pf.processed_at)").
+		OrderExpr("DATE(pf.processed_at)").
+		Scan(ctx, &report.Daily)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get daily ingestion: %v", err)
+	}
+
+	var reasons []struct {
+		Method string `bun:"method"`
+		Files  int64  `bun:"files"`
+	}
+	err = database.DB.NewSelect().
+		Model((*models.ProcessedFile)(nil)).
+		ColumnExpr("COALESCE(NULLIF(pf.duplicate_method, ''), 'unknown') AS method").
+		ColumnExpr("COUNT(*) AS files").
+		Where("pf.company_id = ? AND pf.processed_at >= ?", companyID, since).
+		Where("pf.status IN ('duplicate', 'revised')").
+		GroupExpr("1").
+		Scan(ctx, &reasons)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get duplicate reasons: %v", err)
+	}
+	for _, reason := range reasons {
+		report.DuplicateReasons[reason.Method] = reason.Files
+	}
+
+	err = database.DB.NewSelect().
+		Model((*models.ProcessedFile)(nil)).
+		ColumnExpr("pf.error").
+		ColumnExpr("COUNT(*) AS files").
+		ColumnExpr("MAX(pf.processed_at) AS last_seen").
+		Where("pf.company_id = ? AND pf.processed_at >= ?", companyID, since).
+		Where("pf.status IN ('error', 'quarantined')").
+		GroupExpr("pf.error").
+		OrderExpr("files DESC, last_seen DESC").
+		Limit(topErrors).
+		Scan(ctx, &report.TopErrors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top errors: %v", err)
+	}
+
+	report.Documents, err = s.manager.GetProcessingStatistics(ctx, companyID, days)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// CompanyRanking returns the companies that received files in the period, highest error rate first
+func (s *IngestionStats) CompanyRanking(ctx context.Context, days, limit int) ([]CompanyIngestion, error) {
+	ranking := make([]CompanyIngestion, 0)
+	err := database.DB.NewSelect().
+		Model((*models.ProcessedFile)(nil)).
+		ColumnExpr("pf.company_id").
+		ColumnExpr("c.name AS company_name").
+		ColumnExpr("c.cnpj").
+		ColumnExpr("COUNT(*) AS files").
+		ColumnExpr(ingestionOutcomeColumns).
+		ColumnExpr(ingestionErrorRate+" AS error_rate").
+		ColumnExpr("MAX(pf.processed_at) AS last_processed_at").
+		Join("JOIN companies AS c ON c.id = pf.company_id").
+		Where("pf.processed_at >= ?", ingestionSince(days)).
+		GroupExpr("pf.company_id, c.name, c.cnpj").
+		OrderExpr("error_rate DESC, files DESC").
+		Limit(limit).
+		Scan(ctx, &ranking)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank companies by ingestion errors: %v", err)
+	}
+
+	return ranking, nil
+}
+
+// ingestionSince returns the start of the period covering today and the days before it
+func ingestionSince(days int) time.Time {
+	today := time.Now().Truncate(24 * time.Hour)
+	return today.AddDate(0, 0, -(days - 1))
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"github.com/zoomxml/internal/catalog"
+	"github.com/zoomxml/internal/models"
+)
+
+// MunicipalityResolver resolves the IBGE municipality codes of a document to names and states
+// and checks whether the ISS was due outside the provider's municipality
+type MunicipalityResolver struct {
+	municipalities *catalog.Municipalities
+}
+
+// NewMunicipalityResolver creates a new municipality resolver backed by the loaded IBGE table.
+// Without the table, valid codes still resolve to their state.
+func NewMunicipalityResolver() *MunicipalityResolver {
+	municipalities := &catalog.Municipalities{}
+	if catalogs := LoadServiceCatalogs(); catalogs != nil {
+		municipalities = catalogs.Municipalities
+	}
+
+	return &MunicipalityResolver{
+		municipalities: municipalities,
+	}
+}
+
+// Resolve fills the municipality names, states and ISS jurisdiction flag of a document.
+// Parties without city or state get them from their municipality code.
+func (r *MunicipalityResolver) Resolve(document *models.Document) {
+	service, _ := r.municipalities.Resolve(document.ServiceMunicipalityCode)
+	document.ServiceMunicipalityName = service.Name
+	document.ServiceUF = service.UF
+
+	provider, providerOK := r.municipalities.Resolve(document.ProviderMunicipalityCode)
+	document.ProviderMunicipalityName = provider.Name
+	document.ProviderUF = provider.UF
+	r.completeParty(document.ProviderParty, provider)
+
+	taker, _ := r.municipalities.Resolve(document.TakerMunicipalityCode)
+	document.TakerMunicipalityName = taker.Name
+	document.TakerUF = taker.UF
+	r.completeParty(document.TakerParty, taker)
+
+	// The ISS is due where the layout says it is; otherwise the place of service decides
+	incidence, incidenceOK := r.municipalities.Resolve(firstNonEmpty(document.IssMunicipalityCode, document.ServiceMunicipalityCode))
+	document.IssDueOutsideProvider = incidenceOK && providerOK && incidence.Code != provider.Code
+}
+
+// completeParty fills the city and state of a party that did not report them
+func (r *MunicipalityResolver) completeParty(party *models.Party, municipality catalog.Municipality) {
+	if party == nil {
+		return
+	}
+	if party.City == "" {
+		party.City = municipality.Name
+	}
+	if party.State == "" {
+		party.State = municipality.UF
+	}
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+// ValidationCodeTotalsMismatch flags NF-e headers whose ICMSTot does not match the sum of the items
+const ValidationCodeTotalsMismatch = "totals_mismatch"
+
+// itemTotalsTolerance absorbs per-item rounding when reconciling item sums with ICMSTot
+var itemTotalsTolerance = decimal.New(1, -2)
+
+// NFeInfNFe represents the infNFe element of an NF-e (modelo 55/65)
+type NFeInfNFe struct {
+	ID  string `xml:"Id,attr"`
+	Ide struct {
+		NatOp  string `xml:"natOp"`
+		Serie  string `xml:"serie"`
+		NNF    string `xml:"nNF"`
+		DhEmi  string `xml:"dhEmi"`
+		DEmi   string `xml:"dEmi"` // Layouts anteriores à versão 3.10
+		CMunFG string `xml:"cMunFG"`
+	} `xml:"ide"`
+	Emit struct {
+		CNPJ      string        `xml:"CNPJ"`
+		CPF       string        `xml:"CPF"`
+		XNome     string        `xml:"xNome"`
+		XFant     string        `xml:"xFant"`
+		EnderEmit SEFAZEndereco `xml:"enderEmit"`
+		IE        string        `xml:"IE"`
+		IM        string        `xml:"IM"`
+		CRT       string        `xml:"CRT"`
+	} `xml:"emit"`
+	Dest struct {
+		CNPJ      string        `xml:"CNPJ"`
+		CPF       string        `xml:"CPF"`
+		XNome     string        `xml:"xNome"`
+		EnderDest SEFAZEndereco `xml:"enderDest"`
+		IE        string        `xml:"IE"`
+		IM        string        `xml:"IM"`
+		Email     string        `xml:"email"`
+	} `xml:"dest"`
+	Det     []NFeDet `xml:"det"`
+	ICMSTot struct {
+		VBC     string `xml:"vBC"`
+		VICMS   string `xml:"vICMS"`
+		VST     string `xml:"vST"`
+		VProd   string `xml:"vProd"`
+		VFrete  string `xml:"vFrete"`
+		VSeg    string `xml:"vSeg"`
+		VDesc   string `xml:"vDesc"`
+		VIPI    string `xml:"vIPI"`
+		VPIS    string `xml:"vPIS"`
+		VCOFINS string `xml:"vCOFINS"`
+		VOutro  string `xml:"vOutro"`
+		VNF     string `xml:"vNF"`
+	} `xml:"total>ICMSTot"`
+}
+
+// SEFAZEndereco represents the address groups of the NF-e and CT-e layouts (enderEmit, enderDest, enderReme, ...)
+type SEFAZEndereco struct {
+	XLgr    string `xml:"xLgr"`
+	Nro     string `xml:"nro"`
+	XCpl    string `xml:"xCpl"`
+	XBairro string `xml:"xBairro"`
+	CMun    string `xml:"cMun"`
+	XMun    string `xml:"xMun"`
+	UF      string `xml:"UF"`
+	CEP     string `xml:"CEP"`
+	Fone    string `xml:"fone"`
+}
+
+// sefazParty completes a party of an NF-e or CT-e with its address group
+func sefazParty(party models.Party, endereco SEFAZEndereco) *models.Party {
+	party.Address = endereco.XLgr
+	party.Number = endereco.Nro
+	party.Complement = endereco.XCpl
+	party.District = endereco.XBairro
+	party.MunicipalityCode = endereco.CMun
+	party.City = endereco.XMun
+	party.State = endereco.UF
+	party.ZipCode = endereco.CEP
+	party.Phone = firstNonEmpty(party.Phone, endereco.Fone)
+	return newParty(party)
+}
+
+// NFeDet represents an item (det) of an NF-e
+type NFeDet struct {
+	NItem string `xml:"nItem,attr"`
+	Prod  struct {
+		CProd  string `xml:"cProd"`
+		CEAN   string `xml:"cEAN"`
+		XProd  string `xml:"xProd"`
+		NCM    string `xml:"NCM"`
+		CEST   string `xml:"CEST"`
+		CFOP   string `xml:"CFOP"`
+		UCom   string `xml:"uCom"`
+		QCom   string `xml:"qCom"`
+		VUnCom string `xml:"vUnCom"`
+		VProd  string `xml:"vProd"`
+		VFrete string `xml:"vFrete"`
+		VSeg   string `xml:"vSeg"`
+		VDesc  string `xml:"vDesc"`
+		VOutro string `xml:"vOutro"`
+	} `xml:"prod"`
+	Imposto struct {
+		ICMS struct {
+			Groups []NFeTaxGroup `xml:",any"` // ICMS00, ICMS10, ..., ICMSSN102, ...
+		} `xml:"ICMS"`
+		IPI struct {
+			Trib NFeTaxGroup `xml:"IPITrib"`
+			NT   NFeTaxGroup `xml:"IPINT"`
+		} `xml:"IPI"`
+		PIS struct {
+			Groups []NFeTaxGroup `xml:",any"` // PISAliq, PISQtde, PISNT, PISOutr
+		} `xml:"PIS"`
+		COFINS struct {
+			Groups []NFeTaxGroup `xml:",any"` // COFINSAliq, COFINSQtde, COFINSNT, COFINSOutr
+		} `xml:"COFINS"`
+	} `xml:"imposto"`
+}
+
+// NFeTaxGroup holds the fields shared by the ICMS, IPI, PIS and COFINS groups of an item
+type NFeTaxGroup struct {
+	Orig    string `xml:"orig"`
+	CST     string `xml:"CST"`
+	CSOSN   string `xml:"CSOSN"`
+	VBC     string `xml:"vBC"`
+	PICMS   string `xml:"pICMS"`
+	VICMS   string `xml:"vICMS"`
+	VICMSST string `xml:"vICMSST"`
+	PIPI    string `xml:"pIPI"`
+	VIPI    string `xml:"vIPI"`
+	PPIS    string `xml:"pPIS"`
+	VPIS    string `xml:"vPIS"`
+	PCOFINS string `xml:"pCOFINS"`
+	VCOFINS string `xml:"vCOFINS"`
+}
+
+// NFeXML covers both the nfeProc envelope and a bare NFe root
+type NFeXML struct {
+	InfNFe    NFeInfNFe `xml:"infNFe"`
+	ProcNFe   NFeInfNFe `xml:"NFe>infNFe"`
+	ChNFe     string    `xml:"protNFe>infProt>chNFe"`
+	StatusNFe string    `xml:"protNFe>infProt>cStat"`
+}
+
+// NFeParser parses NF-e documents (nfeProc or NFe roots)
+type NFeParser struct {
+	values *NFSeParser
+}
+
+// NewNFeParser creates a new NF-e parser instance
+func NewNFeParser() *NFeParser {
+	return &NFeParser{
+		values: NewNFSeParser(),
+	}
+}
+
+// Layout returns the layout identifier handled by the parser
+func (p *NFeParser) Layout() string {
+	return LayoutNFe
+}
+
+// Matches reports whether the root element is an NF-e or its authorization envelope
+func (p *NFeParser) Matches(root xml.Name) bool {
+	return (root.Local == "nfeProc" || root.Local == "NFe") && (root.Space == namespaceNFe || root.Space == "")
+}
+
+// ParseXML parses an NF-e document
+func (p *NFeParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
+	var nfe NFeXML
+	decoder, xmlContent := p.values.newDecoder(xmlContent)
+	if err := decoder.Decode(&nfe); err != nil {
+		logger.ErrorWithFields("Failed to parse NFe XML", err, map[string]any{
+			"operation": "parse_nfe_xml",
+		})
+		return nil, fmt.Errorf("failed to parse XML: %v", err)
+	}
+
+	inf := nfe.InfNFe
+	if inf.ID == "" {
+		inf = nfe.ProcNFe
+	}
+	if inf.ID == "" && inf.Ide.NNF == "" {
+		return nil, fmt.Errorf("no infNFe element found")
+	}
+
+	accessKey := firstNonEmpty(nfe.ChNFe, strings.TrimPrefix(strings.TrimSpace(inf.ID), "NFe"))
+	providerCNPJ := firstNonEmpty(inf.Emit.CNPJ, inf.Emit.CPF)
+	issueDateRaw := firstNonEmpty(inf.Ide.DhEmi, inf.Ide.DEmi)
+	documentDates := newDocumentDates(inf.Ide.CMunFG)
+
+	// CRT: 1 = Simples Nacional, 2 = excesso de sublimite, 3 = regime normal, 4 = MEI
+	crt := strings.TrimSpace(inf.Emit.CRT)
+
+	parsedData := &ParsedNFSeData{
+		DocumentType:          DocumentTypeNFe,
+		Layout:                LayoutNFe,
+		AccessKey:             accessKey,
+		Number:                strings.TrimSpace(inf.Ide.NNF),
+		VerificationCode:      accessKey,
+		ProviderCNPJ:          providerCNPJ,
+		TakerCNPJ:             firstNonEmpty(inf.Dest.CNPJ, inf.Dest.CPF),
+		ServiceValue:          p.values.parseMoney("vNF", inf.ICMSTot.VNF),
+		IssueDate:             documentDates.parse("ide/dhEmi", issueDateRaw, true),
+		MunicipalRegistration: strings.TrimSpace(inf.Emit.IM),
+		DocumentHash:          p.values.generateDocumentHash(accessKey, inf.Ide.NNF, providerCNPJ, issueDateRaw),
+		FullXML:               xmlContent,
+
+		TakerName:         strings.TrimSpace(inf.Dest.XNome),
+		ProviderName:      strings.TrimSpace(inf.Emit.XNome),
+		ProviderTradeName: strings.TrimSpace(inf.Emit.XFant),
+
+		PisValue:              p.values.parseMoney("vPIS", inf.ICMSTot.VPIS),
+		CofinsValue:           p.values.parseMoney("vCOFINS", inf.ICMSTot.VCOFINS),
+		NetValue:              p.values.parseMoney("vNF", inf.ICMSTot.VNF),
+		UnconditionalDiscount: p.values.parseMoney("vDesc", inf.ICMSTot.VDesc),
+
+		OperationNature:       strings.TrimSpace(inf.Ide.NatOp),
+		SimplesNacionalOptant: crt == "1" || crt == "4",
+	}
+	parsedData.Provider = sefazParty(models.Party{
+		TaxID:                 providerCNPJ,
+		Name:                  inf.Emit.XNome,
+		TradeName:             inf.Emit.XFant,
+		MunicipalRegistration: inf.Emit.IM,
+		StateRegistration:     inf.Emit.IE,
+	}, inf.Emit.EnderEmit)
+	parsedData.Taker = sefazParty(models.Party{
+		TaxID:                 parsedData.TakerCNPJ,
+		Name:                  inf.Dest.XNome,
+		MunicipalRegistration: inf.Dest.IM,
+		StateRegistration:     inf.Dest.IE,
+		Email:                 inf.Dest.Email,
+	}, inf.Dest.EnderDest)
+	parsedData.Items = p.parseItems(inf.Det)
+	parsedData.ValidationIssues = append(documentDates.issues, p.reconcileTotals(inf, parsedData.Items)...)
+
+	logger.InfoWithFields("Successfully parsed NFe XML", map[string]any{
+		"operation":     "parse_nfe_xml",
+		"number":        parsedData.Number,
+		"access_key":    parsedData.AccessKey,
+		"provider_cnpj": parsedData.ProviderCNPJ,
+		"total_value":   parsedData.ServiceValue.String(),
+		"items_count":   len(parsedData.Items),
+		"status":        nfe.StatusNFe,
+	})
+
+	return parsedData, nil
+}
+
+// parseItems converts the det elements of an NF-e into document items
+func (p *NFeParser) parseItems(dets []NFeDet) []*models.DocumentItem {
+	items := make([]*models.DocumentItem, 0, len(dets))
+	for i, det := range dets {
+		itemNumber, err := strconv.Atoi(strings.TrimSpace(det.NItem))
+		if err != nil {
+			itemNumber = i + 1
+		}
+
+		prod := det.Prod
+		icms := firstTaxGroup(det.Imposto.ICMS.Groups)
+		ipi := det.Imposto.IPI.Trib
+		if ipi.CST == "" {
+			ipi = det.Imposto.IPI.NT
+		}
+		pis := firstTaxGroup(det.Imposto.PIS.Groups)
+		cofins := firstTaxGroup(det.Imposto.COFINS.Groups)
+
+		items = append(items, &models.DocumentItem{
+			ItemNumber:  itemNumber,
+			ProductCode: strings.TrimSpace(prod.CProd),
+			GTIN:        strings.TrimSpace(prod.CEAN),
+			Description: strings.TrimSpace(prod.XProd),
+			NCM:         strings.TrimSpace(prod.NCM),
+			CEST:        strings.TrimSpace(prod.CEST),
+			CFOP:        strings.TrimSpace(prod.CFOP),
+			Unit:        strings.TrimSpace(prod.UCom),
+			Quantity:    p.values.parseDecimal("qCom", prod.QCom).Round(4),
+			UnitPrice:   p.values.parseDecimal("vUnCom", prod.VUnCom).Round(10),
+			TotalValue:  p.values.parseMoney("vProd", prod.VProd),
+			Discount:    p.values.parseMoney("vDesc", prod.VDesc),
+			Freight:     p.values.parseMoney("vFrete", prod.VFrete),
+			Insurance:   p.values.parseMoney("vSeg", prod.VSeg),
+			OtherCosts:  p.values.parseMoney("vOutro", prod.VOutro),
+
+			ICMSOrigin:  strings.TrimSpace(icms.Orig),
+			ICMSCST:     firstNonEmpty(icms.CST, icms.CSOSN),
+			ICMSBase:    p.values.parseMoney("ICMS/vBC", icms.VBC),
+			ICMSRate:    p.values.parseRate("pICMS", icms.PICMS),
+			ICMSValue:   p.values.parseMoney("vICMS", icms.VICMS),
+			ICMSSTValue: p.values.parseMoney("vICMSST", icms.VICMSST),
+
+			IPICST:   strings.TrimSpace(ipi.CST),
+			IPIBase:  p.values.parseMoney("IPI/vBC", ipi.VBC),
+			IPIRate:  p.values.parseRate("pIPI", ipi.PIPI),
+			IPIValue: p.values.parseMoney("vIPI", ipi.VIPI),
+
+			PISCST:   strings.TrimSpace(pis.CST),
+			PISBase:  p.values.parseMoney("PIS/vBC", pis.VBC),
+			PISRate:  p.values.parseRate("pPIS", pis.PPIS),
+			PISValue: p.values.parseMoney("vPIS", pis.VPIS),
+
+			COFINSCST:   strings.TrimSpace(cofins.CST),
+			COFINSBase:  p.values.parseMoney("COFINS/vBC", cofins.VBC),
+			COFINSRate:  p.values.parseRate("pCOFINS", cofins.PCOFINS),
+			COFINSValue: p.values.parseMoney("vCOFINS", cofins.VCOFINS),
+		})
+	}
+	return items
+}
+
+// reconcileTotals compares the sum of the items with the ICMSTot header, returning an issue per divergent total
+func (p *NFeParser) reconcileTotals(inf NFeInfNFe, items []*models.DocumentItem) []models.ValidationIssue {
+	if len(items) == 0 {
+		return nil
+	}
+
+	var products, discounts, freight, insurance, others, icms, icmsST, ipi, pis, cofins decimal.Decimal
+	for _, item := range items {
+		products = products.Add(item.TotalValue)
+		discounts = discounts.Add(item.Discount)
+		freight = freight.Add(item.Freight)
+		insurance = insurance.Add(item.Insurance)
+		others = others.Add(item.OtherCosts)
+		icms = icms.Add(item.ICMSValue)
+		icmsST = icmsST.Add(item.ICMSSTValue)
+		ipi = ipi.Add(item.IPIValue)
+		pis = pis.Add(item.PISValue)
+		cofins = cofins.Add(item.COFINSValue)
+	}
+
+	totals := inf.ICMSTot
+	checks := []struct {
+		field  string
+		header string
+		sum    decimal.Decimal
+	}{
+		{"vProd", totals.VProd, products},
+		{"vDesc", totals.VDesc, discounts},
+		{"vFrete", totals.VFrete, freight},
+		{"vSeg", totals.VSeg, insurance},
+		{"vOutro", totals.VOutro, others},
+		{"vICMS", totals.VICMS, icms},
+		{"vST", totals.VST, icmsST},
+		{"vIPI", totals.VIPI, ipi},
+		{"vPIS", totals.VPIS, pis},
+		{"vCOFINS", totals.VCOFINS, cofins},
+	}
+
+	var issues []models.ValidationIssue
+	for _, check := range checks {
+		if strings.TrimSpace(check.header) == "" {
+			continue
+		}
+		header := p.values.parseMoney(check.field, check.header)
+		if header.Sub(check.sum).Abs().GreaterThan(itemTotalsTolerance) {
+			issues = append(issues, models.ValidationIssue{
+				Code:    ValidationCodeTotalsMismatch,
+				Path:    "total/ICMSTot/" + check.field,
+				Message: fmt.Sprintf("sum of items %s differs from header %s", check.sum.StringFixed(2), header.StringFixed(2)),
+			})
+		}
+	}
+
+	if len(issues) > 0 {
+		logger.WarnWithFields("NFe item totals do not match ICMSTot", map[string]any{
+			"operation":    "parse_nfe_xml",
+			"number":       strings.TrimSpace(inf.Ide.NNF),
+			"issues_count": len(issues),
+		})
+	}
+
+	return issues
+}
+
+// firstTaxGroup returns the tax group present in an item; each tax has exactly one
+func firstTaxGroup(groups []NFeTaxGroup) NFeTaxGroup {
+	if len(groups) == 0 {
+		return NFeTaxGroup{}
+	}
+	return groups[0]
+}
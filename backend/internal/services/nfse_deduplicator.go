@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/uptrace/bun"
+
 	"github.com/zoomxml/internal/database"
 	"github.com/zoomxml/internal/logger"
 	"github.com/zoomxml/internal/models"
@@ -129,17 +131,34 @@ func (d *NFSeDeduplicator) checkByVerificationCode(ctx context.Context, companyI
 	}, nil
 }
 
-// checkByCompositeKey checks for duplicates using NFSe number + provider CNPJ + issue date
+// checkByCompositeKey checks for duplicates using NFSe number + provider CNPJ + issue date.
+// A note whose issue date could not be parsed is never matched this way: every such note would share the zero
+// date, and different notes with the same number and provider would be taken as one.
 func (d *NFSeDeduplicator) checkByCompositeKey(ctx context.Context, companyID int64, parsedData *ParsedNFSeData) (*DuplicateCheckResult, error) {
+	if parsedData.IssueDate.IsZero() {
+		return &DuplicateCheckResult{
+			IsDuplicate: false,
+			CheckMethod: "composite_key",
+			Reason:      "issue date unknown",
+		}, nil
+	}
+
 	var existingDoc models.Document
 	
 	// Format issue date for comparison (ignore time component for date matching)
 	issueDate := parsedData.IssueDate.Format("2006-01-02")
 	
+	// NF-e, CT-e and NFSe numbering sequences are independent
+	documentType := parsedData.DocumentType
+	if documentType == "" {
+		documentType = DocumentTypeNFSe
+	}
+
 	err := database.DB.NewSelect().
 		Model(&existingDoc).
 		Where("company_id = ? AND number = ? AND provider_cnpj = ? AND DATE(issue_date) = ?", 
 			companyID, parsedData.Number, parsedData.ProviderCNPJ, issueDate).
+		Where("type = ?", documentType).
 		Scan(ctx)
 
 	if err != nil {
@@ -189,6 +208,46 @@ func (d *NFSeDeduplicator) checkByDocumentHash(ctx context.Context, companyID in
 	}, nil
 }
 
+// FindConflicting returns the stored document holding one of the unique keys (verification code,
+// document hash or access key) of a document whose insert conflicted
+func (d *NFSeDeduplicator) FindConflicting(ctx context.Context, document *models.Document) (*DuplicateCheckResult, error) {
+	var existingDoc models.Document
+
+	err := database.DB.NewSelect().
+		Model(&existingDoc).
+		Where("company_id = ?", document.CompanyID).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.
+				WhereOr("verification_code = ? AND verification_code <> ''", document.VerificationCode).
+				WhereOr("document_hash = ? AND document_hash <> ''", document.DocumentHash).
+				WhereOr("type = ? AND key = ? AND key <> ''", document.Type, document.Key)
+		}).
+		Order("id ASC").
+		Limit(1).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find conflicting document: %v", err)
+	}
+
+	result := &DuplicateCheckResult{
+		IsDuplicate:      true,
+		ExistingDocument: &existingDoc,
+	}
+	switch {
+	case document.VerificationCode != "" && existingDoc.VerificationCode == document.VerificationCode:
+		result.CheckMethod = "verification_code"
+		result.Reason = fmt.Sprintf("matching verification code: %s", document.VerificationCode)
+	case document.DocumentHash != "" && existingDoc.DocumentHash == document.DocumentHash:
+		result.CheckMethod = "document_hash"
+		result.Reason = fmt.Sprintf("matching document hash: %s", document.DocumentHash)
+	default:
+		result.CheckMethod = "access_key"
+		result.Reason = fmt.Sprintf("matching access key: %s", document.Key)
+	}
+
+	return result, nil
+}
+
 // BatchCheckForDuplicates performs duplicate detection for multiple documents efficiently
 func (d *NFSeDeduplicator) BatchCheckForDuplicates(ctx context.Context, companyID int64, parsedDataList []*ParsedNFSeData) (map[int]*DuplicateCheckResult, error) {
 	results := make(map[int]*DuplicateCheckResult)
@@ -220,23 +279,29 @@ func (d *NFSeDeduplicator) BatchCheckForDuplicates(ctx context.Context, companyI
 		}
 	}
 
-	// Batch query for existing documents
+	// Batch query for existing documents. The alternatives are grouped so the company filter applies to all of
+	// them: a note of one company must never match the documents of another.
 	var existingDocs []models.Document
 	query := database.DB.NewSelect().
 		Model(&existingDocs).
-		Where("company_id = ?", companyID)
+		Where("company_id = ?", companyID).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			if len(verificationCodes) > 0 {
+				q = q.WhereOr("verification_code IN (?)", bun.In(verificationCodes))
+			}
+			if len(numbers) > 0 {
+				q = q.WhereOr("number IN (?)", bun.In(numbers))
+			}
+			if len(documentHashes) > 0 {
+				q = q.WhereOr("document_hash IN (?)", bun.In(documentHashes))
+			}
+			return q
+		})
 
-	if len(verificationCodes) > 0 {
-		query = query.WhereOr("verification_code IN (?)", verificationCodes)
-	}
-	if len(numbers) > 0 {
-		query = query.WhereOr("number IN (?)", numbers)
+	var err error
+	if len(verificationCodes) > 0 || len(numbers) > 0 || len(documentHashes) > 0 {
+		err = query.Scan(ctx)
 	}
-	if len(documentHashes) > 0 {
-		query = query.WhereOr("document_hash IN (?)", documentHashes)
-	}
-
-	err := query.Scan(ctx)
 	if err != nil && err.Error() != "sql: no rows in result set" {
 		return nil, fmt.Errorf("failed to batch check duplicates: %v", err)
 	}
@@ -251,8 +316,8 @@ func (d *NFSeDeduplicator) BatchCheckForDuplicates(ctx context.Context, companyI
 		if doc.VerificationCode != "" {
 			verificationCodeMap[doc.VerificationCode] = doc
 		}
-		if doc.Number != "" && doc.ProviderCNPJ != "" {
-			compositeKey := fmt.Sprintf("%s|%s|%s", doc.Number, doc.ProviderCNPJ, doc.IssueDate.Format("2006-01-02"))
+		if doc.Number != "" && doc.ProviderCNPJ != "" && !doc.IssueDate.IsZero() {
+			compositeKey := fmt.Sprintf("%s|%s|%s|%s", doc.Type, doc.Number, doc.ProviderCNPJ, doc.IssueDate.Format("2006-01-02"))
 			compositeKeyMap[compositeKey] = doc
 		}
 		if doc.DocumentHash != "" {
@@ -275,16 +340,22 @@ func (d *NFSeDeduplicator) BatchCheckForDuplicates(ctx context.Context, companyI
 			}
 		}
 
-		// Check by composite key
-		compositeKey := fmt.Sprintf("%s|%s|%s", data.Number, data.ProviderCNPJ, data.IssueDate.Format("2006-01-02"))
-		if existingDoc, exists := compositeKeyMap[compositeKey]; exists {
-			results[i] = &DuplicateCheckResult{
-				IsDuplicate:      true,
-				ExistingDocument: existingDoc,
-				CheckMethod:      "composite_key",
-				Reason:           fmt.Sprintf("matching composite key: %s", compositeKey),
+		// Check by composite key, unless the issue date could not be parsed
+		if !data.IssueDate.IsZero() {
+			documentType := data.DocumentType
+			if documentType == "" {
+				documentType = DocumentTypeNFSe
+			}
+			compositeKey := fmt.Sprintf("%s|%s|%s|%s", documentType, data.Number, data.ProviderCNPJ, data.IssueDate.Format("2006-01-02"))
+			if existingDoc, exists := compositeKeyMap[compositeKey]; exists {
+				results[i] = &DuplicateCheckResult{
+					IsDuplicate:      true,
+					ExistingDocument: existingDoc,
+					CheckMethod:      "composite_key",
+					Reason:           fmt.Sprintf("matching composite key: %s", compositeKey),
+				}
+				continue
 			}
-			continue
 		}
 
 		// Check by document hash
@@ -0,0 +1,22 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+// Notes whose issue date could not be parsed all share the zero date, so the composite key never matches them
+func TestCompositeKeyIgnoresUnknownIssueDate(t *testing.T) {
+	parsedData := &ParsedNFSeData{
+		Number:       "123",
+		ProviderCNPJ: "34194865000158",
+	}
+
+	result, err := NewNFSeDeduplicator().checkByCompositeKey(context.Background(), 1, parsedData)
+	if err != nil {
+		t.Fatalf("checkByCompositeKey: %v", err)
+	}
+	if result.IsDuplicate {
+		t.Fatal("a note without issue date matched by composite key")
+	}
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+// NFSeNacionalXML represents the NFSe element of the national NFS-e layout (Sistema Nacional NFS-e)
+type NFSeNacionalXML struct {
+	XMLName xml.Name            `xml:"NFSe"`
+	InfNFSe NFSeNacionalInfNFSe `xml:"infNFSe"`
+}
+
+type NFSeNacionalInfNFSe struct {
+	ID        string               `xml:"Id,attr"`
+	NNFSe     string               `xml:"nNFSe"`
+	CLocIncid string               `xml:"cLocIncid"`
+	DhProc    string               `xml:"dhProc"`
+	Emit      NFSeNacionalEmitente `xml:"emit"`
+	Valores   NFSeNacionalValores  `xml:"valores"`
+	DPS       NFSeNacionalInfDPS   `xml:"DPS>infDPS"`
+}
+
+type NFSeNacionalEmitente struct {
+	CNPJ     string               `xml:"CNPJ"`
+	CPF      string               `xml:"CPF"`
+	IM       string               `xml:"IM"`
+	XNome    string               `xml:"xNome"`
+	XFant    string               `xml:"xFant"`
+	EnderNac NFSeNacionalEndereco `xml:"enderNac"`
+	Fone     string               `xml:"fone"`
+	Email    string               `xml:"email"`
+}
+
+// NFSeNacionalEndereco covers emit/enderNac and the end group of the DPS participants,
+// which nests the municipality and CEP in endNac
+type NFSeNacionalEndereco struct {
+	XLgr    string `xml:"xLgr"`
+	Nro     string `xml:"nro"`
+	XCpl    string `xml:"xCpl"`
+	XBairro string `xml:"xBairro"`
+	CMun    string `xml:"cMun"`
+	UF      string `xml:"UF"`
+	CEP     string `xml:"CEP"`
+	EndNac  struct {
+		CMun string `xml:"cMun"`
+		CEP  string `xml:"CEP"`
+	} `xml:"endNac"`
+}
+
+type NFSeNacionalValores struct {
+	VBC        string `xml:"vBC"`
+	PAliqAplic string `xml:"pAliqAplic"`
+	VISSQN     string `xml:"vISSQN"`
+	VTotalRet  string `xml:"vTotalRet"`
+	VLiq       string `xml:"vLiq"`
+}
+
+type NFSeNacionalInfDPS struct {
+	DhEmi   string `xml:"dhEmi"`
+	CLocEmi string `xml:"cLocEmi"`
+	DCompet string `xml:"dCompet"`
+	Prest   struct {
+		CNPJ    string `xml:"CNPJ"`
+		CPF     string `xml:"CPF"`
+		IM      string `xml:"IM"`
+		Fone    string `xml:"fone"`
+		Email   string `xml:"email"`
+		RegTrib struct {
+			OpSimpNac string `xml:"opSimpNac"`
+		} `xml:"regTrib"`
+	} `xml:"prest"`
+	Toma struct {
+		CNPJ  string               `xml:"CNPJ"`
+		CPF   string               `xml:"CPF"`
+		IM    string               `xml:"IM"`
+		XNome string               `xml:"xNome"`
+		End   NFSeNacionalEndereco `xml:"end"`
+		Fone  string               `xml:"fone"`
+		Email string               `xml:"email"`
+	} `xml:"toma"`
+	Serv struct {
+		LocPrest struct {
+			CLocPrestacao string `xml:"cLocPrestacao"`
+		} `xml:"locPrest"`
+		CServ struct {
+			CTribNac  string `xml:"cTribNac"`
+			XDescServ string `xml:"xDescServ"`
+		} `xml:"cServ"`
+	} `xml:"serv"`
+	Valores struct {
+		VServ       string `xml:"vServPrest>vServ"`
+		VDescIncond string `xml:"vDescCondIncond>vDescIncond"`
+		VDescCond   string `xml:"vDescCondIncond>vDescCond"`
+		VDR         string `xml:"vDedRed>vDR"`
+		TpRetISSQN  string `xml:"trib>tribMun>tpRetISSQN"`
+		PAliq       string `xml:"trib>tribMun>pAliq"`
+		VPis        string `xml:"trib>tribFed>piscofins>vPis"`
+		VCofins     string `xml:"trib>tribFed>piscofins>vCofins"`
+		VRetCP      string `xml:"trib>tribFed>vRetCP"`
+		VRetIRRF    string `xml:"trib>tribFed>vRetIRRF"`
+		VRetCSLL    string `xml:"trib>tribFed>vRetCSLL"`
+	} `xml:"valores"`
+}
+
+// NFSeNacionalParser parses documents in the national NFS-e layout
+type NFSeNacionalParser struct {
+	values *NFSeParser
+}
+
+// NewNFSeNacionalParser creates a new national NFS-e parser instance
+func NewNFSeNacionalParser() *NFSeNacionalParser {
+	return &NFSeNacionalParser{
+		values: NewNFSeParser(),
+	}
+}
+
+// Layout returns the layout identifier handled by the parser
+func (p *NFSeNacionalParser) Layout() string {
+	return LayoutNFSeNacional
+}
+
+// Matches reports whether the root element is a national NFSe
+func (p *NFSeNacionalParser) Matches(root xml.Name) bool {
+	return root.Local == "NFSe" && (root.Space == namespaceNFSeNacional || root.Space == "")
+}
+
+// ParseXML parses a national NFS-e document
+func (p *NFSeNacionalParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
+	var nfse NFSeNacionalXML
+	decoder, xmlContent := p.values.newDecoder(xmlContent)
+	if err := decoder.Decode(&nfse); err != nil {
+		logger.ErrorWithFields("Failed to parse national NFSe XML", err, map[string]any{
+			"operation": "parse_nfse_nacional_xml",
+		})
+		return nil, fmt.Errorf("failed to parse XML: %v", err)
+	}
+
+	inf := nfse.InfNFSe
+	dps := inf.DPS
+	accessKey := strings.TrimPrefix(strings.TrimSpace(inf.ID), "NFS")
+	providerCNPJ := firstNonEmpty(inf.Emit.CNPJ, inf.Emit.CPF, dps.Prest.CNPJ, dps.Prest.CPF)
+	issueDateRaw := firstNonEmpty(dps.DhEmi, inf.DhProc)
+	documentDates := newDocumentDates(firstNonEmpty(dps.CLocEmi, inf.CLocIncid))
+
+	// tpRetISSQN: 1 = não retido, 2 = retido pelo tomador, 3 = retido pelo intermediário
+	retention := strings.TrimSpace(dps.Valores.TpRetISSQN)
+
+	// opSimpNac: 1 = não optante, 2 = MEI, 3 = ME/EPP
+	simplesNacional := strings.TrimSpace(dps.Prest.RegTrib.OpSimpNac)
+
+	parsedData := &ParsedNFSeData{
+		DocumentType:          DocumentTypeNFSe,
+		Layout:                LayoutNFSeNacional,
+		AccessKey:             accessKey,
+		Number:                strings.TrimSpace(inf.NNFSe),
+		VerificationCode:      accessKey,
+		ProviderCNPJ:          providerCNPJ,
+		TakerCNPJ:             firstNonEmpty(dps.Toma.CNPJ, dps.Toma.CPF),
+		ServiceValue:          p.values.parseMoney("vServ", dps.Valores.VServ),
+		ServiceCode:           strings.TrimSpace(dps.Serv.CServ.CTribNac),
+		IssueDate:             documentDates.parse("infDPS/dhEmi", issueDateRaw, true),
+		MunicipalRegistration: firstNonEmpty(inf.Emit.IM, dps.Prest.IM),
+		DocumentHash:          p.values.generateDocumentHash(accessKey, inf.NNFSe, providerCNPJ, issueDateRaw),
+		FullXML:               xmlContent,
+
+		Competence:        strings.TrimSpace(dps.DCompet),
+		TakerName:         strings.TrimSpace(dps.Toma.XNome),
+		ProviderName:      strings.TrimSpace(inf.Emit.XNome),
+		ProviderTradeName: strings.TrimSpace(inf.Emit.XFant),
+
+		DeductionsValue:       p.values.parseMoney("vDR", dps.Valores.VDR),
+		PisValue:              p.values.parseMoney("vPis", dps.Valores.VPis),
+		CofinsValue:           p.values.parseMoney("vCofins", dps.Valores.VCofins),
+		InssValue:             p.values.parseMoney("vRetCP", dps.Valores.VRetCP),
+		IrValue:               p.values.parseMoney("vRetIRRF", dps.Valores.VRetIRRF),
+		CsllValue:             p.values.parseMoney("vRetCSLL", dps.Valores.VRetCSLL),
+		IssWithheld:           retention == "2" || retention == "3",
+		IssValue:              p.values.parseMoney("vISSQN", inf.Valores.VISSQN),
+		CalculationBase:       p.values.parseMoney("vBC", inf.Valores.VBC),
+		IssRate:               p.values.parseRate("pAliqAplic", firstNonEmpty(inf.Valores.PAliqAplic, dps.Valores.PAliq)),
+		NetValue:              p.values.parseMoney("vLiq", inf.Valores.VLiq),
+		ConditionalDiscount:   p.values.parseMoney("vDescCond", dps.Valores.VDescCond),
+		UnconditionalDiscount: p.values.parseMoney("vDescIncond", dps.Valores.VDescIncond),
+
+		SimplesNacionalOptant:   simplesNacional == "2" || simplesNacional == "3",
+		ServiceDescription:      strings.TrimSpace(dps.Serv.CServ.XDescServ),
+		ServiceMunicipalityCode: firstNonEmpty(dps.Serv.LocPrest.CLocPrestacao, inf.CLocIncid),
+		IssMunicipalityCode:     strings.TrimSpace(inf.CLocIncid),
+	}
+	parsedData.Provider = p.party(models.Party{
+		TaxID:                 providerCNPJ,
+		Name:                  inf.Emit.XNome,
+		TradeName:             inf.Emit.XFant,
+		MunicipalRegistration: parsedData.MunicipalRegistration,
+		Phone:                 firstNonEmpty(inf.Emit.Fone, dps.Prest.Fone),
+		Email:                 firstNonEmpty(inf.Emit.Email, dps.Prest.Email),
+	}, inf.Emit.EnderNac)
+	parsedData.Taker = p.party(models.Party{
+		TaxID:                 parsedData.TakerCNPJ,
+		Name:                  dps.Toma.XNome,
+		MunicipalRegistration: dps.Toma.IM,
+		Phone:                 dps.Toma.Fone,
+		Email:                 dps.Toma.Email,
+	}, dps.Toma.End)
+	parsedData.ValidationIssues = documentDates.issues
+
+	logger.InfoWithFields("Successfully parsed national NFSe XML", map[string]any{
+		"operation":     "parse_nfse_nacional_xml",
+		"number":        parsedData.Number,
+		"access_key":    parsedData.AccessKey,
+		"provider_cnpj": parsedData.ProviderCNPJ,
+		"service_value": parsedData.ServiceValue.String(),
+	})
+
+	return parsedData, nil
+}
+
+// party completes a party with its national layout address
+func (p *NFSeNacionalParser) party(party models.Party, endereco NFSeNacionalEndereco) *models.Party {
+	party.Address = endereco.XLgr
+	party.Number = endereco.Nro
+	party.Complement = endereco.XCpl
+	party.District = endereco.XBairro
+	party.MunicipalityCode = firstNonEmpty(endereco.CMun, endereco.EndNac.CMun)
+	party.State = endereco.UF
+	party.ZipCode = firstNonEmpty(endereco.CEP, endereco.EndNac.CEP)
+	return newParty(party)
+}
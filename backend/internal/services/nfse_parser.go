@@ -5,15 +5,16 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/transform"
 
 	"github.com/zoomxml/internal/logger"
 	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/xmltree"
 )
 
 // NFSeXMLStructure represents the complete NFSe XML structure
@@ -59,13 +60,15 @@ type IdentificacaoRps struct {
 }
 
 type Servico struct {
-	Valores          Valores `xml:"Valores"`
-	ItemListaServico string  `xml:"ItemListaServico"`
-	CodigoCnae       string  `xml:"CodigoCnae"`
-	Discriminacao    string  `xml:"Discriminacao"`
-	CodigoMunicipio  string  `xml:"CodigoMunicipio"`
-	IBGE             string  `xml:"IBGE"`
-	TOM              string  `xml:"TOM"`
+	Valores             Valores `xml:"Valores"`
+	ItemListaServico    string  `xml:"ItemListaServico"`
+	CodigoCnae          string  `xml:"CodigoCnae"`
+	Discriminacao       string  `xml:"Discriminacao"`
+	CodigoMunicipio     string  `xml:"CodigoMunicipio"`
+	IBGE                string  `xml:"IBGE"`
+	TOM                 string  `xml:"TOM"`
+	IssRetido           string  `xml:"IssRetido"`
+	MunicipioIncidencia string  `xml:"MunicipioIncidencia"` // ABRASF 2.x
 }
 
 type Valores struct {
@@ -91,21 +94,25 @@ type PrestadorServico struct {
 	RazaoSocial            string                 `xml:"RazaoSocial"`
 	NomeFantasia           string                 `xml:"NomeFantasia"`
 	Endereco               Endereco               `xml:"Endereco"`
+	Contato                Contato                `xml:"Contato"`
 }
 
 type IdentificacaoPrestador struct {
-	Cnpj               string `xml:"Cnpj"`
-	InscricaoMunicipal string `xml:"InscricaoMunicipal"`
+	Cnpj               string  `xml:"Cnpj"`
+	CpfCnpj            CpfCnpj `xml:"CpfCnpj"`
+	InscricaoMunicipal string  `xml:"InscricaoMunicipal"`
 }
 
 type TomadorServico struct {
 	IdentificacaoTomador IdentificacaoTomador `xml:"IdentificacaoTomador"`
 	RazaoSocial          string               `xml:"RazaoSocial"`
 	Endereco             Endereco             `xml:"Endereco"`
+	Contato              Contato              `xml:"Contato"`
 }
 
 type IdentificacaoTomador struct {
-	CpfCnpj CpfCnpj `xml:"CpfCnpj"`
+	CpfCnpj            CpfCnpj `xml:"CpfCnpj"`
+	InscricaoMunicipal string  `xml:"InscricaoMunicipal"`
 }
 
 type CpfCnpj struct {
@@ -121,9 +128,15 @@ type Endereco struct {
 	CodigoMunicipio string `xml:"CodigoMunicipio"`
 	IBGE            string `xml:"IBGE"`
 	TOM             string `xml:"TOM"`
+	Uf              string `xml:"Uf"`
 	Cep             string `xml:"Cep"`
 }
 
+type Contato struct {
+	Telefone string `xml:"Telefone"`
+	Email    string `xml:"Email"`
+}
+
 type NfseCancelamento struct {
 	Confirmacao Confirmacao `xml:"Confirmacao"`
 }
@@ -150,13 +163,17 @@ type NfseSubstituicao struct {
 	SubstituicaoNfse string `xml:"SubstituicaoNfse"`
 }
 
-// ParsedNFSeData represents the extracted and parsed NFSe data
+// ParsedNFSeData represents the normalized data extracted from a fiscal document of any supported layout
 type ParsedNFSeData struct {
+	DocumentType string
+	Layout       string
+	AccessKey    string // Chave de acesso (NF-e, CT-e e NFS-e Nacional)
+
 	Number                string
 	VerificationCode      string
 	ProviderCNPJ          string
 	TakerCNPJ             string
-	ServiceValue          float64
+	ServiceValue          decimal.Decimal
 	ServiceCode           string
 	IssueDate             time.Time
 	MunicipalRegistration string
@@ -171,9 +188,44 @@ type ParsedNFSeData struct {
 	TakerName         string
 	ProviderName      string
 	ProviderTradeName string
+
+	// Tax values from Servico/Valores
+	DeductionsValue       decimal.Decimal
+	PisValue              decimal.Decimal
+	CofinsValue           decimal.Decimal
+	InssValue             decimal.Decimal
+	IrValue               decimal.Decimal
+	CsllValue             decimal.Decimal
+	IssWithheld           bool
+	IssValue              decimal.Decimal
+	OtherWithholdings     decimal.Decimal
+	CalculationBase       decimal.Decimal
+	IssRate               decimal.Decimal
+	NetValue              decimal.Decimal
+	ConditionalDiscount   decimal.Decimal
+	UnconditionalDiscount decimal.Decimal
+
+	// Service classification
+	CnaeCode                string
+	OperationNature         string
+	SimplesNacionalOptant   bool
+	ServiceDescription      string
+	ServiceMunicipalityCode string
+	IssMunicipalityCode     string // Município de incidência do ISS, when the layout reports it
+
+	// Parties with address and contact, for the counterparty registry
+	Provider *models.Party
+	Taker    *models.Party
+
+	// Line items (NF-e det)
+	Items []*models.DocumentItem
+
+	// Problems found while parsing, such as unparseable dates
+	ValidationIssues []models.ValidationIssue
 }
 
-// NFSeParser handles intelligent parsing and deduplication of NFSe XML documents
+// NFSeParser handles intelligent parsing and deduplication of NFSe XML documents.
+// It parses the Prefeitura Moderna layout and provides the value helpers shared by the other parsers.
 type NFSeParser struct{}
 
 // NewNFSeParser creates a new NFSe parser instance
@@ -181,6 +233,16 @@ func NewNFSeParser() *NFSeParser {
 	return &NFSeParser{}
 }
 
+// Layout returns the layout identifier handled by the parser
+func (p *NFSeParser) Layout() string {
+	return LayoutPrefeituraModerna
+}
+
+// Matches reports whether the root element is a Prefeitura Moderna response
+func (p *NFSeParser) Matches(root xml.Name) bool {
+	return root.Local == "consultarNotaResponse"
+}
+
 // ParseXML parses NFSe XML content and extracts key fields
 func (p *NFSeParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
 	// Validate XML structure
@@ -188,12 +250,8 @@ func (p *NFSeParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
 		return nil, fmt.Errorf("empty XML content")
 	}
 
-	// Handle ISO-8859-1 encoding
-	xmlContent = p.convertEncoding(xmlContent)
-
 	var nfseXML NFSeXMLStructure
-	decoder := xml.NewDecoder(strings.NewReader(xmlContent))
-	decoder.CharsetReader = p.charsetReader
+	decoder, xmlContent := p.newDecoder(xmlContent)
 
 	err := decoder.Decode(&nfseXML)
 	if err != nil {
@@ -207,24 +265,12 @@ func (p *NFSeParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
 	infNfse := nfseXML.ListaNfse.ComplNfse.Nfse.InfNfse
 
 	// Parse service value
-	serviceValue, err := strconv.ParseFloat(infNfse.Servico.Valores.ValorServicos, 64)
-	if err != nil {
-		logger.WarnWithFields("Failed to parse service value", map[string]any{
-			"operation":     "parse_nfse_xml",
-			"service_value": infNfse.Servico.Valores.ValorServicos,
-		})
-		serviceValue = 0
-	}
+	valores := infNfse.Servico.Valores
+	serviceValue := p.parseMoney("ValorServicos", valores.ValorServicos)
 
-	// Parse issue date
-	issueDate, err := time.Parse("2006-01-02 15:04:05", infNfse.DataEmissao)
-	if err != nil {
-		logger.WarnWithFields("Failed to parse issue date", map[string]any{
-			"operation":  "parse_nfse_xml",
-			"issue_date": infNfse.DataEmissao,
-		})
-		issueDate = time.Time{}
-	}
+	// Parse issue date in the time zone of the municipality where the service was rendered
+	documentDates := newDocumentDates(p.serviceMunicipalityCode(infNfse.Servico))
+	issueDate := documentDates.parse("InfNfse/DataEmissao", infNfse.DataEmissao, true)
 
 	// Get taker CNPJ (could be CNPJ or CPF)
 	takerCNPJ := infNfse.TomadorServico.IdentificacaoTomador.CpfCnpj.Cnpj
@@ -239,15 +285,14 @@ func (p *NFSeParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
 	isSubstituted := nfseXML.ListaNfse.ComplNfse.NfseSubstituicao.SubstituicaoNfse != ""
 
 	// Parse RPS issue date
-	rpsIssueDate := time.Time{}
-	if infNfse.DataEmissaoRps != "" && strings.TrimSpace(infNfse.DataEmissaoRps) != "" {
-		rpsIssueDate, _ = time.Parse("2006-01-02 15:04:05", strings.TrimSpace(infNfse.DataEmissaoRps))
-	}
+	rpsIssueDate := documentDates.parse("InfNfse/DataEmissaoRps", infNfse.DataEmissaoRps, false)
 
 	// Generate document hash for additional validation
 	documentHash := p.generateDocumentHash(infNfse.CodigoVerificacao, infNfse.Numero, infNfse.PrestadorServico.IdentificacaoPrestador.Cnpj, infNfse.DataEmissao)
 
 	parsedData := &ParsedNFSeData{
+		DocumentType:          DocumentTypeNFSe,
+		Layout:                LayoutPrefeituraModerna,
 		Number:                infNfse.Numero,
 		VerificationCode:      infNfse.CodigoVerificacao,
 		ProviderCNPJ:          infNfse.PrestadorServico.IdentificacaoPrestador.Cnpj,
@@ -267,14 +312,40 @@ func (p *NFSeParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
 		TakerName:         infNfse.TomadorServico.RazaoSocial,
 		ProviderName:      infNfse.PrestadorServico.RazaoSocial,
 		ProviderTradeName: infNfse.PrestadorServico.NomeFantasia,
+
+		// Tax values
+		DeductionsValue:       p.parseMoney("ValorDeducoes", valores.ValorDeducoes),
+		PisValue:              p.parseMoney("ValorPis", valores.ValorPis),
+		CofinsValue:           p.parseMoney("ValorCofins", valores.ValorCofins),
+		InssValue:             p.parseMoney("ValorInss", valores.ValorInss),
+		IrValue:               p.parseMoney("ValorIr", valores.ValorIr),
+		CsllValue:             p.parseMoney("ValorCsll", valores.ValorCsll),
+		IssWithheld:           p.parseFlag(valores.IssRetido),
+		IssValue:              p.parseMoney("ValorIss", valores.ValorIss),
+		OtherWithholdings:     p.parseMoney("OutrasRetencoes", valores.OutrasRetencoes),
+		CalculationBase:       p.parseMoney("BaseCalculo", valores.BaseCalculo),
+		IssRate:               p.parseRate("Aliquota", valores.Aliquota),
+		NetValue:              p.parseMoney("ValorLiquidoNfse", valores.ValorLiquidoNfse),
+		ConditionalDiscount:   p.parseMoney("DescontoCondicionado", valores.DescontoCondicionado),
+		UnconditionalDiscount: p.parseMoney("DescontoIncondicionado", valores.DescontoIncondicionado),
+
+		// Service classification
+		CnaeCode:                strings.TrimSpace(infNfse.Servico.CodigoCnae),
+		OperationNature:         strings.TrimSpace(infNfse.NaturezaOperacao),
+		SimplesNacionalOptant:   p.parseFlag(infNfse.OptanteSimplesNacional),
+		ServiceDescription:      strings.TrimSpace(infNfse.Servico.Discriminacao),
+		ServiceMunicipalityCode: p.serviceMunicipalityCode(infNfse.Servico),
 	}
+	parsedData.Provider = p.abrasfParty(parsedData.ProviderCNPJ, parsedData.MunicipalRegistration, infNfse.PrestadorServico.RazaoSocial, infNfse.PrestadorServico.NomeFantasia, infNfse.PrestadorServico.Endereco, infNfse.PrestadorServico.Contato)
+	parsedData.Taker = p.abrasfParty(takerCNPJ, infNfse.TomadorServico.IdentificacaoTomador.InscricaoMunicipal, infNfse.TomadorServico.RazaoSocial, "", infNfse.TomadorServico.Endereco, infNfse.TomadorServico.Contato)
+	parsedData.ValidationIssues = documentDates.issues
 
 	logger.InfoWithFields("Successfully parsed NFSe XML", map[string]any{
 		"operation":         "parse_nfse_xml",
 		"number":            parsedData.Number,
 		"verification_code": parsedData.VerificationCode,
 		"provider_cnpj":     parsedData.ProviderCNPJ,
-		"service_value":     parsedData.ServiceValue,
+		"service_value":     parsedData.ServiceValue.String(),
 		"is_cancelled":      parsedData.IsCancelled,
 		"is_substituted":    parsedData.IsSubstituted,
 	})
@@ -282,6 +353,77 @@ func (p *NFSeParser) ParseXML(xmlContent string) (*ParsedNFSeData, error) {
 	return parsedData, nil
 }
 
+// parseMoney parses an optional monetary field, rounded to centavos
+func (p *NFSeParser) parseMoney(field, raw string) decimal.Decimal {
+	return p.parseDecimal(field, raw).Round(2)
+}
+
+// parseRate parses an optional rate field such as Aliquota
+func (p *NFSeParser) parseRate(field, raw string) decimal.Decimal {
+	return p.parseDecimal(field, raw).Round(4)
+}
+
+// parseDecimal parses an optional numeric field exactly, returning zero when absent or invalid
+func (p *NFSeParser) parseDecimal(field, raw string) decimal.Decimal {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return decimal.Zero
+	}
+
+	// Some municipalities send values with a decimal comma
+	if strings.Contains(raw, ",") && !strings.Contains(raw, ".") {
+		raw = strings.ReplaceAll(raw, ",", ".")
+	}
+
+	value, err := decimal.NewFromString(raw)
+	if err != nil {
+		logger.WarnWithFields("Failed to parse NFSe value", map[string]any{
+			"operation": "parse_nfse_xml",
+			"field":     field,
+			"value":     raw,
+		})
+		return decimal.Zero
+	}
+	return value
+}
+
+// parseFlag interprets ABRASF yes/no fields (1 = Sim, 2 = Não) and their textual variants
+func (p *NFSeParser) parseFlag(raw string) bool {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "1", "true", "sim", "s":
+		return true
+	default:
+		return false
+	}
+}
+
+// serviceMunicipalityCode returns the municipality where the service was rendered
+func (p *NFSeParser) serviceMunicipalityCode(servico Servico) string {
+	if code := strings.TrimSpace(servico.CodigoMunicipio); code != "" {
+		return code
+	}
+	return strings.TrimSpace(servico.IBGE)
+}
+
+// abrasfParty builds a party from the identification, Endereco and Contato groups shared by the ABRASF-based layouts
+func (p *NFSeParser) abrasfParty(taxID, municipalRegistration, name, tradeName string, endereco Endereco, contato Contato) *models.Party {
+	return newParty(models.Party{
+		TaxID:                 taxID,
+		Name:                  name,
+		TradeName:             tradeName,
+		MunicipalRegistration: municipalRegistration,
+		Address:               endereco.Endereco,
+		Number:                endereco.Numero,
+		Complement:            endereco.Complemento,
+		District:              endereco.Bairro,
+		MunicipalityCode:      firstNonEmpty(endereco.CodigoMunicipio, endereco.IBGE),
+		State:                 endereco.Uf,
+		ZipCode:               endereco.Cep,
+		Phone:                 contato.Telefone,
+		Email:                 contato.Email,
+	})
+}
+
 // generateDocumentHash creates a hash of critical fields for additional validation
 func (p *NFSeParser) generateDocumentHash(verificationCode, number, providerCNPJ, issueDate string) string {
 	data := fmt.Sprintf("%s|%s|%s|%s", verificationCode, number, providerCNPJ, issueDate)
@@ -291,16 +433,27 @@ func (p *NFSeParser) generateDocumentHash(verificationCode, number, providerCNPJ
 
 // ConvertToDocument converts parsed NFSe data to Document model
 func (p *NFSeParser) ConvertToDocument(companyID int64, parsedData *ParsedNFSeData, storageKey string) *models.Document {
-	return &models.Document{
+	documentType := parsedData.DocumentType
+	if documentType == "" {
+		documentType = DocumentTypeNFSe
+	}
+
+	// The fallback key is unique per company, so it is only built when both parts are known
+	key := parsedData.AccessKey
+	if key == "" && parsedData.ProviderCNPJ != "" && parsedData.Number != "" {
+		key = fmt.Sprintf("%s_%s", parsedData.ProviderCNPJ, parsedData.Number)
+	}
+
+	document := &models.Document{
 		CompanyID:             companyID,
-		Type:                  "nfse",
-		Key:                   fmt.Sprintf("%s_%s", parsedData.ProviderCNPJ, parsedData.Number),
+		Type:                  documentType,
+		Key:                   key,
 		Number:                parsedData.Number,
 		IssueDate:             parsedData.IssueDate,
 		Amount:                parsedData.ServiceValue,
 		Status:                "processed",
 		StorageKey:            storageKey,
-		Metadata:              parsedData.FullXML,
+		Metadata:              p.structuredMetadata(parsedData.FullXML),
 		VerificationCode:      parsedData.VerificationCode,
 		ProviderCNPJ:          parsedData.ProviderCNPJ,
 		TakerCNPJ:             parsedData.TakerCNPJ,
@@ -318,9 +471,78 @@ func (p *NFSeParser) ConvertToDocument(companyID int64, parsedData *ParsedNFSeDa
 		TakerName:         parsedData.TakerName,
 		ProviderName:      parsedData.ProviderName,
 		ProviderTradeName: parsedData.ProviderTradeName,
+
+		Items:         parsedData.Items,
+		ProviderParty: parsedData.Provider,
+		TakerParty:    parsedData.Taker,
+	}
+
+	p.ApplyTaxFields(document, parsedData)
+	p.ApplyLocation(document, parsedData)
+	return document
+}
+
+// ApplyTaxFields copies tax values and service classification from parsed data to the document
+func (p *NFSeParser) ApplyTaxFields(document *models.Document, parsedData *ParsedNFSeData) {
+	document.DeductionsValue = parsedData.DeductionsValue
+	document.PisValue = parsedData.PisValue
+	document.CofinsValue = parsedData.CofinsValue
+	document.InssValue = parsedData.InssValue
+	document.IrValue = parsedData.IrValue
+	document.CsllValue = parsedData.CsllValue
+	document.IssWithheld = parsedData.IssWithheld
+	document.IssValue = parsedData.IssValue
+	document.OtherWithholdings = parsedData.OtherWithholdings
+	document.CalculationBase = parsedData.CalculationBase
+	document.IssRate = parsedData.IssRate
+	document.NetValue = parsedData.NetValue
+	document.ConditionalDiscount = parsedData.ConditionalDiscount
+	document.UnconditionalDiscount = parsedData.UnconditionalDiscount
+
+	document.CnaeCode = parsedData.CnaeCode
+	document.OperationNature = parsedData.OperationNature
+	document.SimplesNacionalOptant = parsedData.SimplesNacionalOptant
+	document.ServiceDescription = parsedData.ServiceDescription
+}
+
+// ApplyLocation copies the IBGE codes of the place of service, the ISS incidence and the parties to the document
+func (p *NFSeParser) ApplyLocation(document *models.Document, parsedData *ParsedNFSeData) {
+	document.ServiceMunicipalityCode = parsedData.ServiceMunicipalityCode
+	document.IssMunicipalityCode = parsedData.IssMunicipalityCode
+
+	document.ProviderMunicipalityCode = ""
+	if parsedData.Provider != nil {
+		document.ProviderMunicipalityCode = parsedData.Provider.MunicipalityCode
+	}
+	document.TakerMunicipalityCode = ""
+	if parsedData.Taker != nil {
+		document.TakerMunicipalityCode = parsedData.Taker.MunicipalityCode
 	}
 }
 
+// structuredMetadata converts the content element of the document (InfNfse, infNFSe, infNFe or infCte) to the
+// JSON tree stored in documents.metadata; the XML itself lives only in storage
+func (p *NFSeParser) structuredMetadata(xmlContent string) map[string]any {
+	tree, err := xmltree.Convert(xmlContent, xmltree.InfoElements...)
+	if err != nil {
+		logger.WarnWithFields("Failed to convert XML to metadata", map[string]any{
+			"operation": "convert_metadata",
+			"error":     err.Error(),
+		})
+		return nil
+	}
+	return tree
+}
+
+// newDecoder prepares a decoder for fiscal XML, converting ISO-8859-1 content to UTF-8 first
+func (p *NFSeParser) newDecoder(xmlContent string) (*xml.Decoder, string) {
+	xmlContent = p.convertEncoding(xmlContent)
+
+	decoder := xml.NewDecoder(strings.NewReader(xmlContent))
+	decoder.CharsetReader = p.charsetReader
+	return decoder, xmlContent
+}
+
 // convertEncoding converts ISO-8859-1 encoded XML to UTF-8
 func (p *NFSeParser) convertEncoding(xmlContent string) string {
 	// Check if content is already UTF-8 or doesn't specify encoding
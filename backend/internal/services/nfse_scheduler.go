@@ -353,11 +353,12 @@ func (s *NFSeScheduler) calculateOptimalStartDate(ctx context.Context, companyID
 	// Default fallback: use config days back
 	defaultStartDate := endDate.AddDate(0, 0, -s.config.NFSeScheduler.FetchDaysBack)
 
-	// Find the most recent document for this company
+	// Find the most recent document for this company, ignoring documents whose issue date could not be parsed
 	var latestDoc models.Document
 	err := database.DB.NewSelect().
 		Model(&latestDoc).
 		Where("company_id = ? AND type = 'nfse'", companyID).
+		Where("issue_date > ?", time.Time{}).
 		Order("issue_date DESC").
 		Limit(1).
 		Scan(ctx)
@@ -9,18 +9,20 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 
-	"github.com/zoomxml/internal/database"
 	"github.com/zoomxml/internal/logger"
 	"github.com/zoomxml/internal/models"
 )
 
+// ProviderPrefeituraModerna identifies documents fetched from the Prefeitura Moderna API
+const ProviderPrefeituraModerna = "prefeitura_moderna"
+
 // NFSeService handles NFSe API operations
 type NFSeService struct {
 	client     *http.Client
 	xmlManager *NFSeXMLManager
+	files      *ProcessedFileRegistry
 }
 
 // PrefeituraModernaResponse represents the actual response from Prefeitura Moderna API
@@ -63,6 +65,7 @@ func NewNFSeService() *NFSeService {
 			Timeout: 30 * time.Second,
 		},
 		xmlManager: NewNFSeXMLManager(),
+		files:      NewProcessedFileRegistry(),
 	}
 }
 
@@ -303,6 +306,7 @@ func (s *NFSeService) StoreNFSeDocuments(ctx context.Context, companyID int64, d
 		xmlDocuments[i] = XMLDocument{
 			FileName: doc.FileName,
 			Content:  doc.XMLContent,
+			Provider: ProviderPrefeituraModerna,
 		}
 	}
 
@@ -334,13 +338,13 @@ func (s *NFSeService) StoreNFSeDocuments(ctx context.Context, companyID int64, d
 			logger.ErrorWithFields("Document processing failed", docResult.Error, map[string]any{
 				"operation":  "store_nfse_intelligent",
 				"company_id": companyID,
-				"file_name":  documents[i].FileName,
+				"file_name":  filteredDocuments[i].FileName,
 			})
 		} else if docResult.IsDuplicate {
 			logger.InfoWithFields("Duplicate document detected", map[string]any{
 				"operation":        "store_nfse_intelligent",
 				"company_id":       companyID,
-				"file_name":        documents[i].FileName,
+				"file_name":        filteredDocuments[i].FileName,
 				"existing_id":      docResult.DocumentID,
 				"duplicate_reason": docResult.DuplicateReason,
 			})
@@ -348,7 +352,7 @@ func (s *NFSeService) StoreNFSeDocuments(ctx context.Context, companyID int64, d
 			logger.InfoWithFields("Document processed successfully", map[string]any{
 				"operation":   "store_nfse_intelligent",
 				"company_id":  companyID,
-				"file_name":   documents[i].FileName,
+				"file_name":   filteredDocuments[i].FileName,
 				"document_id": docResult.DocumentID,
 			})
 		}
@@ -357,33 +361,21 @@ func (s *NFSeService) StoreNFSeDocuments(ctx context.Context, companyID int64, d
 	return nil
 }
 
-// preFilterProcessedDocuments filters out documents that have already been processed
+// preFilterProcessedDocuments filters out documents whose content has already been processed,
+// looking their SHA-256 up in the processed files of the company
 func (s *NFSeService) preFilterProcessedDocuments(ctx context.Context, companyID int64, documents []NFSeDocument) ([]NFSeDocument, int) {
 	if len(documents) == 0 {
 		return documents, 0
 	}
 
-	// Extract file names for batch lookup
-	fileNames := make([]string, len(documents))
+	hashes := make([]string, len(documents))
 	for i, doc := range documents {
-		fileNames[i] = doc.FileName
+		hashes[i] = hashContent(doc.XMLContent)
 	}
 
-	// Check which files have already been processed by looking at existing documents
-	// We'll use the storage_key pattern to identify processed files
-	existingFiles := make(map[string]bool)
-
-	// Query existing documents with storage keys that match our file names
-	var existingDocs []models.Document
-	err := database.DB.NewSelect().
-		Model(&existingDocs).
-		Column("storage_key").
-		Where("company_id = ?", companyID).
-		Where("storage_key IS NOT NULL AND storage_key != ''").
-		Scan(ctx)
-
+	processed, err := s.files.FindProcessed(ctx, companyID, hashes)
 	if err != nil {
-		logger.WarnWithFields("Failed to check existing documents, processing all", map[string]any{
+		logger.WarnWithFields("Failed to check processed files, processing all", map[string]any{
 			"operation":  "pre_filter_documents",
 			"company_id": companyID,
 			"error":      err.Error(),
@@ -391,32 +383,23 @@ func (s *NFSeService) preFilterProcessedDocuments(ctx context.Context, companyID
 		return documents, 0
 	}
 
-	// Build map of existing file names from storage keys
-	for _, doc := range existingDocs {
-		if doc.StorageKey != "" {
-			// Extract filename from storage key (format: nfse/year/competence/cnpj/filename)
-			parts := strings.Split(doc.StorageKey, "/")
-			if len(parts) > 0 {
-				fileName := parts[len(parts)-1]
-				existingFiles[fileName] = true
-			}
-		}
-	}
-
-	// Filter out documents that already exist
+	// Filter out documents already processed, and repeated contents within the batch
 	var filteredDocuments []NFSeDocument
 	skippedCount := 0
+	seen := make(map[string]bool, len(documents))
 
-	for _, doc := range documents {
-		if existingFiles[doc.FileName] {
+	for i, doc := range documents {
+		if processed[hashes[i]] || seen[hashes[i]] {
 			skippedCount++
 			logger.DebugWithFields("Skipping already processed file", map[string]any{
 				"operation":  "pre_filter_documents",
 				"company_id": companyID,
 				"file_name":  doc.FileName,
+				"file_hash":  hashes[i],
 			})
 			continue
 		}
+		seen[hashes[i]] = true
 		filteredDocuments = append(filteredDocuments, doc)
 	}
 
@@ -424,7 +407,7 @@ func (s *NFSeService) preFilterProcessedDocuments(ctx context.Context, companyID
 		"operation":       "pre_filter_documents",
 		"company_id":      companyID,
 		"total_documents": len(documents),
-		"existing_files":  len(existingFiles),
+		"processed_files": len(processed),
 		"skipped_count":   skippedCount,
 		"remaining_count": len(filteredDocuments),
 	})
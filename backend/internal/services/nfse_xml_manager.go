@@ -2,25 +2,33 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/uptrace/bun"
+
 	"github.com/zoomxml/internal/database"
 	"github.com/zoomxml/internal/logger"
 	"github.com/zoomxml/internal/models"
 	"github.com/zoomxml/internal/storage"
+	"github.com/zoomxml/internal/xsd"
 )
 
 // ProcessingResult represents the result of XML processing
 type ProcessingResult struct {
-	Success         bool
-	DocumentID      int64
-	IsDuplicate     bool
-	DuplicateReason string
-	ProcessingTime  time.Duration
-	Error           error
+	Success          bool
+	DocumentID       int64
+	IsDuplicate      bool
+	DuplicateReason  string
+	DuplicateMethod  string // Critério que identificou a duplicata (verification_code, composite_key, document_hash, access_key)
+	ProcessingTime   time.Duration
+	ValidationErrors []xsd.ValidationError
+	QuarantineID     int64 // Registro de quarentena do XML quando a validação ou a interpretação falham
+	Revision         int   // Revisão registrada quando um documento já existente chega com conteúdo diferente
+	Error            error
 }
 
 // BatchProcessingResult represents the result of batch XML processing
@@ -28,6 +36,7 @@ type BatchProcessingResult struct {
 	TotalDocuments     int
 	ProcessedDocuments int
 	DuplicateDocuments int
+	RevisedDocuments   int
 	ErrorDocuments     int
 	ProcessingTime     time.Duration
 	Results            []ProcessingResult
@@ -37,33 +46,59 @@ type BatchProcessingResult struct {
 // NFSeXMLManager handles intelligent XML management with deduplication
 type NFSeXMLManager struct {
 	parser       *NFSeParser
+	parsers      *ParserRegistry
 	deduplicator *NFSeDeduplicator
+	validator    *XMLValidator
+	signatures   *SignatureVerifier
+	parties      *PartyRegistry
+	classifier   *ServiceClassifier
+	locations    *MunicipalityResolver
+	quarantine   *QuarantineStore
+	revisions    *RevisionTracker
+	files        *ProcessedFileRegistry
 }
 
 // NewNFSeXMLManager creates a new NFSe XML manager instance
 func NewNFSeXMLManager() *NFSeXMLManager {
 	return &NFSeXMLManager{
 		parser:       NewNFSeParser(),
+		parsers:      NewParserRegistry(),
 		deduplicator: NewNFSeDeduplicator(),
+		validator:    NewXMLValidator(),
+		signatures:   NewSignatureVerifier(),
+		parties:      NewPartyRegistry(),
+		classifier:   NewServiceClassifier(),
+		locations:    NewMunicipalityResolver(),
+		quarantine:   NewQuarantineStore(),
+		revisions:    NewRevisionTracker(),
+		files:        NewProcessedFileRegistry(),
 	}
 }
 
-// generateOrganizedStorageKey creates an organized storage path: year/competence/cnpj/filename
-// Example: 2025/012025/34194865000158/filename.xml
-func (m *NFSeXMLManager) generateOrganizedStorageKey(parsedData *ParsedNFSeData, fileName string) string {
+// generateOrganizedStorageKey creates an organized storage path: type/year/competence/cnpj/company/hash/filename
+// Example: nfse/2025/012025/34194865000158/7/<sha256>/filename.xml
+// The company and the SHA-256 of the content make the key unique: concurrent ingestions of the same note can only
+// write identical content to it, and companies receiving the same note never share an object.
+func (m *NFSeXMLManager) generateOrganizedStorageKey(companyID int64, parsedData *ParsedNFSeData, fileName, xmlContent string) string {
+	uniqueName := fmt.Sprintf("%d/%s/%s", companyID, hashContent(xmlContent), fileName)
+	return organizedPath(parsedData.DocumentType, parsedData.IssueDate, parsedData.Competence, parsedData.ProviderCNPJ, uniqueName)
+}
+
+// organizedPath builds the type/year/competence/cnpj/filename layout of stored XMLs and exports
+func organizedPath(documentType string, issueDate time.Time, rawCompetence, providerCNPJ, fileName string) string {
 	// Extract year from issue date
-	year := parsedData.IssueDate.Format("2006")
+	year := issueDate.Format("2006")
 
 	// Clean competence (remove spaces, slashes, etc.) and format as MMYYYY
-	competence := strings.ReplaceAll(parsedData.Competence, "/", "")
+	competence := strings.ReplaceAll(rawCompetence, "/", "")
 	competence = strings.ReplaceAll(competence, " ", "")
 	competence = strings.ReplaceAll(competence, ":", "")
 
 	// If competence is in format "DD/MM/YYYY HH:MM:SS", extract MM and YYYY
 	if len(competence) >= 8 {
 		// Try to parse different formats
-		if strings.Contains(parsedData.Competence, "/") {
-			parts := strings.Split(parsedData.Competence, "/")
+		if strings.Contains(rawCompetence, "/") {
+			parts := strings.Split(rawCompetence, "/")
 			if len(parts) >= 3 {
 				month := strings.TrimSpace(parts[1])
 				yearPart := strings.TrimSpace(parts[2])
@@ -80,38 +115,66 @@ func (m *NFSeXMLManager) generateOrganizedStorageKey(parsedData *ParsedNFSeData,
 
 	// If competence is still not in MMYYYY format, use issue date
 	if len(competence) != 6 {
-		competence = parsedData.IssueDate.Format("012006") // MM + YYYY
+		competence = issueDate.Format("012006") // MM + YYYY
 	}
 
 	// Clean CNPJ (remove dots, slashes, spaces)
-	cleanCNPJ := regexp.MustCompile(`[^0-9]`).ReplaceAllString(parsedData.ProviderCNPJ, "")
+	cleanCNPJ := regexp.MustCompile(`[^0-9]`).ReplaceAllString(providerCNPJ, "")
 
 	// Generate organized path: year/competence/cnpj/filename
-	return fmt.Sprintf("nfse/%s/%s/%s/%s", year, competence, cleanCNPJ, fileName)
+	if documentType == "" {
+		documentType = DocumentTypeNFSe
+	}
+
+	return fmt.Sprintf("%s/%s/%s/%s/%s", documentType, year, competence, cleanCNPJ, fileName)
 }
 
 // ProcessSingleXML processes a single NFSe XML document with intelligent deduplication
-func (m *NFSeXMLManager) ProcessSingleXML(ctx context.Context, companyID int64, xmlContent, fileName string) (*ProcessingResult, error) {
+func (m *NFSeXMLManager) ProcessSingleXML(ctx context.Context, companyID int64, xmlDoc XMLDocument) (*ProcessingResult, error) {
+	result, err := m.processSingleXML(ctx, companyID, xmlDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	m.files.Record(ctx, companyID, []XMLDocument{xmlDoc}, []ProcessingResult{*result})
+	return result, nil
+}
+
+// processSingleXML validates, parses, deduplicates and stores a single XML document
+func (m *NFSeXMLManager) processSingleXML(ctx context.Context, companyID int64, xmlDoc XMLDocument) (*ProcessingResult, error) {
 	startTime := time.Now()
+	xmlContent, fileName := xmlDoc.Content, xmlDoc.FileName
 
 	logger.InfoWithFields("Starting single XML processing", map[string]any{
 		"operation":  "process_single_xml",
 		"company_id": companyID,
 		"file_name":  fileName,
+		"provider":   xmlDoc.Provider,
 	})
 
 	result := &ProcessingResult{}
 
+	// Step 0: Validate against the bundled schemas
+	validation := m.validator.Validate(xmlDoc.Provider, fileName, xmlContent)
+	result.ValidationErrors = validation.Errors
+	if validation.Rejected() {
+		result.Error = fmt.Errorf("XML rejected by schema validation: %d errors", len(validation.Errors))
+		result.QuarantineID = m.quarantineXML(ctx, companyID, xmlDoc, QuarantineStageValidation, result.Error)
+		result.ProcessingTime = time.Since(startTime)
+		return result, nil
+	}
+
 	// Step 1: Parse XML content
-	parsedData, err := m.parser.ParseXML(xmlContent)
+	parsedData, err := m.parsers.ParseXML(xmlContent)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to parse XML: %v", err)
-		result.ProcessingTime = time.Since(startTime)
 		logger.ErrorWithFields("Failed to parse XML", err, map[string]any{
 			"operation":  "process_single_xml",
 			"company_id": companyID,
 			"file_name":  fileName,
 		})
+		result.QuarantineID = m.quarantineXML(ctx, companyID, xmlDoc, QuarantineStageParse, result.Error)
+		result.ProcessingTime = time.Since(startTime)
 		return result, nil
 	}
 
@@ -131,7 +194,25 @@ func (m *NFSeXMLManager) ProcessSingleXML(ctx context.Context, companyID int64,
 	if duplicateCheck.IsDuplicate {
 		result.IsDuplicate = true
 		result.DuplicateReason = duplicateCheck.Reason
+		result.DuplicateMethod = duplicateCheck.CheckMethod
 		result.DocumentID = duplicateCheck.ExistingDocument.ID
+
+		// Step 2.1: Keep a changed version of the document as a revision
+		incoming := m.buildDocument(companyID, parsedData, "", validation, xmlContent)
+		revision, err := m.revisions.Track(ctx, duplicateCheck.ExistingDocument, incoming, xmlDoc)
+		if err != nil {
+			result.Error = fmt.Errorf("failed to store document revision: %v", err)
+			result.ProcessingTime = time.Since(startTime)
+			logger.ErrorWithFields("Failed to store document revision", err, map[string]any{
+				"operation":   "process_single_xml",
+				"company_id":  companyID,
+				"document_id": duplicateCheck.ExistingDocument.ID,
+			})
+			return result, nil
+		}
+		if revision != nil {
+			result.Revision = revision.Revision
+		}
 		result.ProcessingTime = time.Since(startTime)
 
 		logger.InfoWithFields("Duplicate document detected", map[string]any{
@@ -146,8 +227,8 @@ func (m *NFSeXMLManager) ProcessSingleXML(ctx context.Context, companyID int64,
 	}
 
 	// Step 3: Store XML in MinIO with organized path
-	storageKey := m.generateOrganizedStorageKey(parsedData, fileName)
-	err = storage.Storage.UploadFile(ctx, "nfse-storage", storageKey, []byte(xmlContent), "application/xml")
+	storageKey := m.generateOrganizedStorageKey(companyID, parsedData, fileName, xmlContent)
+	err = storage.Storage.UploadFileWithMetadata(ctx, "nfse-storage", storageKey, []byte(xmlContent), "application/xml", storage.CompanyMetadata(companyID))
 	if err != nil {
 		result.Error = fmt.Errorf("failed to store XML: %v", err)
 		result.ProcessingTime = time.Since(startTime)
@@ -160,9 +241,9 @@ func (m *NFSeXMLManager) ProcessSingleXML(ctx context.Context, companyID int64,
 	}
 
 	// Step 4: Convert to document model and save to database
-	document := m.parser.ConvertToDocument(companyID, parsedData, storageKey)
+	document := m.buildDocument(companyID, parsedData, storageKey, validation, xmlContent)
 
-	_, err = database.DB.NewInsert().Model(document).Exec(ctx)
+	err = m.insertDocuments(ctx, []*models.Document{document})
 	if err != nil {
 		result.Error = fmt.Errorf("failed to save document: %v", err)
 		result.ProcessingTime = time.Since(startTime)
@@ -174,6 +255,25 @@ func (m *NFSeXMLManager) ProcessSingleXML(ctx context.Context, companyID int64,
 		return result, nil
 	}
 
+	if document.ID == 0 {
+		// Another ingestion stored the same document between the duplicate check and the insert
+		duplicateCheck, revision, err := m.resolveInsertConflict(ctx, document, xmlDoc)
+		if err != nil {
+			result.Error = fmt.Errorf("failed to resolve duplicate: %v", err)
+			result.ProcessingTime = time.Since(startTime)
+			return result, nil
+		}
+		result.IsDuplicate = true
+		result.DuplicateReason = duplicateCheck.Reason
+		result.DuplicateMethod = duplicateCheck.CheckMethod
+		result.DocumentID = duplicateCheck.ExistingDocument.ID
+		if revision != nil {
+			result.Revision = revision.Revision
+		}
+		result.ProcessingTime = time.Since(startTime)
+		return result, nil
+	}
+
 	result.Success = true
 	result.DocumentID = document.ID
 	result.ProcessingTime = time.Since(startTime)
@@ -210,17 +310,32 @@ func (m *NFSeXMLManager) ProcessBatchXML(ctx context.Context, companyID int64, x
 		return result, nil
 	}
 
-	// Step 1: Parse all XML documents
+	// Step 1: Validate and parse all XML documents
 	parsedDataList := make([]*ParsedNFSeData, 0, len(xmlDocuments))
 	parseErrors := make(map[int]error)
+	validations := make([]*XMLValidationOutcome, len(xmlDocuments))
 
 	for i, xmlDoc := range xmlDocuments {
-		parsedData, err := m.parser.ParseXML(xmlDoc.Content)
+		validations[i] = m.validator.Validate(xmlDoc.Provider, xmlDoc.FileName, xmlDoc.Content)
+		if validations[i].Rejected() {
+			parseErrors[i] = fmt.Errorf("XML rejected by schema validation")
+			result.Results[i] = ProcessingResult{
+				Error:            fmt.Errorf("XML rejected by schema validation: %d errors", len(validations[i].Errors)),
+				ValidationErrors: validations[i].Errors,
+			}
+			result.Results[i].QuarantineID = m.quarantineXML(ctx, companyID, xmlDoc, QuarantineStageValidation, result.Results[i].Error)
+			result.ErrorDocuments++
+			continue
+		}
+
+		parsedData, err := m.parsers.ParseXML(xmlDoc.Content)
 		if err != nil {
 			parseErrors[i] = err
 			result.Results[i] = ProcessingResult{
-				Error: fmt.Errorf("failed to parse XML: %v", err),
+				Error:            fmt.Errorf("failed to parse XML: %v", err),
+				ValidationErrors: validations[i].Errors,
 			}
+			result.Results[i].QuarantineID = m.quarantineXML(ctx, companyID, xmlDoc, QuarantineStageParse, result.Results[i].Error)
 			result.ErrorDocuments++
 			continue
 		}
@@ -256,15 +371,29 @@ func (m *NFSeXMLManager) ProcessBatchXML(ctx context.Context, companyID int64, x
 			result.Results[i] = ProcessingResult{
 				IsDuplicate:     true,
 				DuplicateReason: duplicateCheck.Reason,
+				DuplicateMethod: duplicateCheck.CheckMethod,
 				DocumentID:      duplicateCheck.ExistingDocument.ID,
 			}
-			result.DuplicateDocuments++
+
+			// Keep a changed version of the document as a revision
+			incoming := m.buildDocument(companyID, parsedData, "", validations[i], xmlDoc.Content)
+			revision, err := m.revisions.Track(ctx, duplicateCheck.ExistingDocument, incoming, xmlDoc)
+			switch {
+			case err != nil:
+				result.Results[i].Error = fmt.Errorf("failed to store document revision: %v", err)
+				result.ErrorDocuments++
+			case revision != nil:
+				result.Results[i].Revision = revision.Revision
+				result.RevisedDocuments++
+			default:
+				result.DuplicateDocuments++
+			}
 			continue
 		}
 
 		// Prepare for storage and database insertion with organized path
-		storageKey := m.generateOrganizedStorageKey(parsedData, xmlDoc.FileName)
-		document := m.parser.ConvertToDocument(companyID, parsedData, storageKey)
+		storageKey := m.generateOrganizedStorageKey(companyID, parsedData, xmlDoc.FileName, xmlDoc.Content)
+		document := m.buildDocument(companyID, parsedData, storageKey, validations[i], xmlDoc.Content)
 
 		documentsToInsert = append(documentsToInsert, document)
 		storageOperations = append(storageOperations, StorageOperation{
@@ -275,7 +404,7 @@ func (m *NFSeXMLManager) ProcessBatchXML(ctx context.Context, companyID int64, x
 	}
 
 	// Step 4: Batch upload to MinIO
-	err = m.batchUploadToStorage(ctx, storageOperations)
+	err = m.batchUploadToStorage(ctx, companyID, storageOperations)
 	if err != nil {
 		logger.ErrorWithFields("Failed to batch upload to storage", err, map[string]any{
 			"operation":  "process_batch_xml",
@@ -291,7 +420,7 @@ func (m *NFSeXMLManager) ProcessBatchXML(ctx context.Context, companyID int64, x
 	} else {
 		// Step 5: Batch insert to database
 		if len(documentsToInsert) > 0 {
-			_, err = database.DB.NewInsert().Model(&documentsToInsert).Exec(ctx)
+			err = m.insertDocuments(ctx, documentsToInsert)
 			if err != nil {
 				logger.ErrorWithFields("Failed to batch insert documents", err, map[string]any{
 					"operation":       "process_batch_xml",
@@ -306,11 +435,37 @@ func (m *NFSeXMLManager) ProcessBatchXML(ctx context.Context, companyID int64, x
 					result.ErrorDocuments++
 				}
 			} else {
-				// Mark all as successful
+				// Mark inserted documents as successful and the ones that lost a concurrent insert as duplicates
 				for i, op := range storageOperations {
+					document := documentsToInsert[i]
+					if document.ID == 0 {
+						duplicateCheck, revision, err := m.resolveInsertConflict(ctx, document, xmlDocuments[op.Index])
+						if err != nil {
+							result.Results[op.Index] = ProcessingResult{
+								Error: fmt.Errorf("failed to resolve duplicate: %v", err),
+							}
+							result.ErrorDocuments++
+							continue
+						}
+						result.Results[op.Index] = ProcessingResult{
+							IsDuplicate:     true,
+							DuplicateReason: duplicateCheck.Reason,
+							DuplicateMethod: duplicateCheck.CheckMethod,
+							DocumentID:      duplicateCheck.ExistingDocument.ID,
+						}
+						if revision != nil {
+							result.Results[op.Index].Revision = revision.Revision
+							result.RevisedDocuments++
+						} else {
+							result.DuplicateDocuments++
+						}
+						continue
+					}
+
 					result.Results[op.Index] = ProcessingResult{
-						Success:    true,
-						DocumentID: documentsToInsert[i].ID,
+						Success:          true,
+						DocumentID:       document.ID,
+						ValidationErrors: validations[op.Index].Errors,
 					}
 					result.ProcessedDocuments++
 				}
@@ -325,6 +480,7 @@ func (m *NFSeXMLManager) ProcessBatchXML(ctx context.Context, companyID int64, x
 		"total_documents":     result.TotalDocuments,
 		"processed_documents": result.ProcessedDocuments,
 		"duplicate_documents": result.DuplicateDocuments,
+		"revised_documents":   result.RevisedDocuments,
 		"error_documents":     result.ErrorDocuments,
 		"processing_time_ms":  result.ProcessingTime.Milliseconds(),
 		"success_rate":        float64(result.ProcessedDocuments) / float64(result.TotalDocuments) * 100,
@@ -332,13 +488,93 @@ func (m *NFSeXMLManager) ProcessBatchXML(ctx context.Context, companyID int64, x
 
 	logger.InfoWithFields("Completed batch XML processing", result.Statistics)
 
+	m.files.Record(ctx, companyID, xmlDocuments, result.Results)
+
 	return result, nil
 }
 
+// buildDocument converts parsed data to a document with its validation, classification, locations and signature
+func (m *NFSeXMLManager) buildDocument(companyID int64, parsedData *ParsedNFSeData, storageKey string, validation *XMLValidationOutcome, xmlContent string) *models.Document {
+	document := m.parser.ConvertToDocument(companyID, parsedData, storageKey)
+	document.Hash = hashContent(xmlContent)
+	validation.ApplyTo(document)
+	ApplyParseIssues(document, parsedData.ValidationIssues)
+	ApplyParseIssues(document, m.classifier.Classify(document))
+	m.locations.Resolve(document)
+	m.signatures.ApplySignature(document, m.signatures.Verify(xmlContent, parsedData.IssueDate))
+	return document
+}
+
+// ReprocessQuarantined runs a quarantined XML through the ingestion pipeline again.
+// Files that become documents, or turn out to duplicate one, are released from quarantine;
+// files that still fail have their quarantine record refreshed with the new error.
+func (m *NFSeXMLManager) ReprocessQuarantined(ctx context.Context, file *models.QuarantinedFile) (*ProcessingResult, error) {
+	if storage.Storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+
+	data, err := downloadVerified(ctx, file.StorageKey, file.ContentHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download quarantined XML: %w", err)
+	}
+
+	result, err := m.ProcessSingleXML(ctx, file.CompanyID, XMLDocument{
+		FileName: file.FileName,
+		Content:  string(data),
+		Provider: file.Source,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Success || result.IsDuplicate {
+		if err := m.quarantine.Release(ctx, file, result.DocumentID); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.InfoWithFields("Reprocessed quarantined XML", map[string]any{
+		"operation":     "reprocess_quarantined_xml",
+		"company_id":    file.CompanyID,
+		"quarantine_id": file.ID,
+		"file_name":     file.FileName,
+		"success":       result.Success,
+		"duplicate":     result.IsDuplicate,
+		"document_id":   result.DocumentID,
+	})
+
+	return result, nil
+}
+
+// quarantineXML keeps a failed XML in quarantine, returning the quarantine record ID or zero when it could not be stored
+func (m *NFSeXMLManager) quarantineXML(ctx context.Context, companyID int64, xmlDoc XMLDocument, stage string, cause error) int64 {
+	file, err := m.quarantine.Add(ctx, companyID, xmlDoc, stage, cause)
+	if err != nil {
+		logger.ErrorWithFields("Failed to quarantine XML", err, map[string]any{
+			"operation":  "quarantine_xml",
+			"company_id": companyID,
+			"file_name":  xmlDoc.FileName,
+			"stage":      stage,
+		})
+		return 0
+	}
+
+	logger.WarnWithFields("Quarantined XML that failed ingestion", map[string]any{
+		"operation":     "quarantine_xml",
+		"company_id":    companyID,
+		"file_name":     xmlDoc.FileName,
+		"stage":         stage,
+		"quarantine_id": file.ID,
+		"attempts":      file.Attempts,
+	})
+	return file.ID
+}
+
 // XMLDocument represents an XML document to be processed
 type XMLDocument struct {
 	FileName string
 	Content  string
+	Provider string // Provedor de origem, usado para escolher o modo de validação
 }
 
 // StorageOperation represents a storage operation
@@ -348,10 +584,120 @@ type StorageOperation struct {
 	Index   int
 }
 
+// insertDocuments saves the documents, links their parties and saves their NF-e items in a single transaction.
+// Each document is inserted with ON CONFLICT DO NOTHING against the unique indexes on verification code,
+// document hash and access key, so concurrent fetches cannot store the same note twice. Documents that
+// lost that race keep a zero ID and are neither linked to the party registry nor given items.
+func (m *NFSeXMLManager) insertDocuments(ctx context.Context, documents []*models.Document) error {
+	return database.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		inserted := make([]*models.Document, 0, len(documents))
+		for _, document := range documents {
+			res, err := tx.NewInsert().
+				Model(document).
+				On("CONFLICT DO NOTHING").
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+			if rows, _ := res.RowsAffected(); rows == 0 {
+				document.ID = 0
+				continue
+			}
+			inserted = append(inserted, document)
+		}
+
+		if len(inserted) == 0 {
+			return nil
+		}
+
+		if err := m.parties.LinkDocuments(ctx, tx, inserted); err != nil {
+			return err
+		}
+
+		items := make([]*models.DocumentItem, 0)
+		for _, document := range inserted {
+			if document.ProviderPartyID != nil || document.TakerPartyID != nil {
+				_, err := tx.NewUpdate().
+					Model(document).
+					Column("provider_party_id", "taker_party_id").
+					WherePK().
+					Exec(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to link document parties: %v", err)
+				}
+			}
+
+			for _, item := range document.Items {
+				item.DocumentID = document.ID
+				item.CompanyID = document.CompanyID
+				items = append(items, item)
+			}
+		}
+
+		if len(items) == 0 {
+			return nil
+		}
+
+		if _, err := tx.NewInsert().Model(&items).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to save document items: %v", err)
+		}
+		return nil
+	})
+}
+
+// resolveInsertConflict finds the stored document that made the insert of a document conflict and keeps the
+// rejected document as a revision of it when it differs, as the duplicate check before the insert does.
+// The XML uploaded for the rejected document is removed when the stored document uses another key and nothing
+// else references it.
+func (m *NFSeXMLManager) resolveInsertConflict(ctx context.Context, document *models.Document, xmlDoc XMLDocument) (*DuplicateCheckResult, *models.DocumentRevision, error) {
+	duplicateCheck, err := m.deduplicator.FindConflicting(ctx, document)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.InfoWithFields("Duplicate document detected on insert", map[string]any{
+		"operation":    "insert_documents",
+		"company_id":   document.CompanyID,
+		"existing_id":  duplicateCheck.ExistingDocument.ID,
+		"check_method": duplicateCheck.CheckMethod,
+		"reason":       duplicateCheck.Reason,
+	})
+
+	if document.StorageKey != "" && document.StorageKey != duplicateCheck.ExistingDocument.StorageKey {
+		m.removeUnreferencedUpload(ctx, document.CompanyID, document.StorageKey)
+	}
+
+	revision, err := m.revisions.Track(ctx, duplicateCheck.ExistingDocument, document, xmlDoc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to store document revision: %v", err)
+	}
+
+	return duplicateCheck, revision, nil
+}
+
+// removeUnreferencedUpload removes an XML uploaded for a document that was not stored. Storage keys are derived
+// from the content, so the same XML ingested concurrently under another name may share the key; the file is kept
+// when a document, revision, quarantined file or export references it, and any file left behind is found by the
+// storage reconciliation.
+func (m *NFSeXMLManager) removeUnreferencedUpload(ctx context.Context, companyID int64, storageKey string) {
+	unreferenced, err := unreferencedStorageKeys(ctx, []string{storageKey})
+	if err == nil && len(unreferenced) > 0 {
+		err = storage.Storage.DeleteFile(ctx, "nfse-storage", storageKey)
+	}
+	if err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+		logger.WarnWithFields("Failed to remove XML of duplicate document, left for the storage reconciliation", map[string]any{
+			"operation":   "insert_documents",
+			"company_id":  companyID,
+			"storage_key": storageKey,
+			"error":       err.Error(),
+		})
+	}
+}
+
 // batchUploadToStorage uploads multiple files to storage efficiently
-func (m *NFSeXMLManager) batchUploadToStorage(ctx context.Context, operations []StorageOperation) error {
+func (m *NFSeXMLManager) batchUploadToStorage(ctx context.Context, companyID int64, operations []StorageOperation) error {
 	for _, op := range operations {
-		err := storage.Storage.UploadFile(ctx, "nfse-storage", op.Key, []byte(op.Content), "application/xml")
+		err := storage.Storage.UploadFileWithMetadata(ctx, "nfse-storage", op.Key, []byte(op.Content), "application/xml", storage.CompanyMetadata(companyID))
 		if err != nil {
 			return fmt.Errorf("failed to upload %s: %v", op.Key, err)
 		}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/internal/models"
+)
+
+// partyProfileColumns are refreshed from the most recent document that mentions the party
+var partyProfileColumns = []string{
+	"name",
+	"trade_name",
+	"municipal_registration",
+	"state_registration",
+	"address",
+	"number",
+	"complement",
+	"district",
+	"municipality_code",
+	"city",
+	"state",
+	"zip_code",
+	"phone",
+	"email",
+}
+
+// PartyRegistry maintains the counterparty registry built from the providers and takers of ingested documents
+type PartyRegistry struct{}
+
+// NewPartyRegistry creates a new party registry instance
+func NewPartyRegistry() *PartyRegistry {
+	return &PartyRegistry{}
+}
+
+// LinkDocuments upserts the provider and taker of each document and links them to it.
+// The company that owns the document is not registered as its own counterparty.
+func (r *PartyRegistry) LinkDocuments(ctx context.Context, db bun.IDB, documents []*models.Document) error {
+	companyTaxIDs := make(map[int64]string)
+
+	for _, document := range documents {
+		ownTaxID, ok := companyTaxIDs[document.CompanyID]
+		if !ok {
+			var err error
+			if ownTaxID, err = r.companyTaxID(ctx, db, document.CompanyID); err != nil {
+				return err
+			}
+			companyTaxIDs[document.CompanyID] = ownTaxID
+		}
+
+		seenAt := documentSeenAt(document)
+
+		if party := document.ProviderParty; party != nil && party.TaxID != ownTaxID {
+			if err := r.upsert(ctx, db, document.CompanyID, party, seenAt, 1); err != nil {
+				return err
+			}
+			document.ProviderPartyID = &party.ID
+		}
+
+		if party := document.TakerParty; party != nil && party.TaxID != ownTaxID {
+			if err := r.upsert(ctx, db, document.CompanyID, party, seenAt, 1); err != nil {
+				return err
+			}
+			document.TakerPartyID = &party.ID
+		}
+	}
+
+	return nil
+}
+
+// RelinkDocument links a stored document that takes the state of a new revision to the provider and taker of
+// that revision. A party that stays linked only has its profile refreshed; a party replaced by another, such as
+// after a corrected CNPJ, no longer counts the document, and the new one does.
+func (r *PartyRegistry) RelinkDocument(ctx context.Context, db bun.IDB, previous, document *models.Document) error {
+	ownTaxID, err := r.companyTaxID(ctx, db, document.CompanyID)
+	if err != nil {
+		return err
+	}
+	seenAt := documentSeenAt(document)
+
+	document.ProviderPartyID, err = r.relink(ctx, db, document.CompanyID, previous.ProviderPartyID, document.ProviderParty, ownTaxID, seenAt)
+	if err != nil {
+		return err
+	}
+	document.TakerPartyID, err = r.relink(ctx, db, document.CompanyID, previous.TakerPartyID, document.TakerParty, ownTaxID, seenAt)
+	return err
+}
+
+// relink upserts the party now mentioned by a document and returns its ID, or nil when the document mentions
+// none or the company itself. The previously linked party loses the document when it is not the same one.
+func (r *PartyRegistry) relink(ctx context.Context, db bun.IDB, companyID int64, previousID *int64, party *models.Party, ownTaxID string, seenAt time.Time) (*int64, error) {
+	var linkedID *int64
+	if party != nil && party.TaxID != ownTaxID {
+		var previousTaxID string
+		if previousID != nil {
+			err := db.NewSelect().
+				Model((*models.Party)(nil)).
+				Column("tax_id").
+				Where("id = ?", *previousID).
+				Scan(ctx, &previousTaxID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("failed to load party %d: %v", *previousID, err)
+			}
+		}
+
+		count := int64(1)
+		if previousTaxID == party.TaxID {
+			count = 0
+		}
+		if err := r.upsert(ctx, db, companyID, party, seenAt, count); err != nil {
+			return nil, err
+		}
+		linkedID = &party.ID
+	}
+
+	if previousID != nil && (linkedID == nil || *linkedID != *previousID) {
+		_, err := db.NewUpdate().
+			Model((*models.Party)(nil)).
+			Set("document_count = GREATEST(document_count - 1, 0)").
+			Where("id = ?", *previousID).
+			Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlink party %d: %v", *previousID, err)
+		}
+	}
+
+	return linkedID, nil
+}
+
+// companyTaxID returns the CNPJ digits of a company, which is never registered as its own counterparty
+func (r *PartyRegistry) companyTaxID(ctx context.Context, db bun.IDB, companyID int64) (string, error) {
+	var cnpj string
+	err := db.NewSelect().
+		Model((*models.Company)(nil)).
+		Column("cnpj").
+		Where("id = ?", companyID).
+		Scan(ctx, &cnpj)
+	if err != nil {
+		return "", fmt.Errorf("failed to load company %d: %v", companyID, err)
+	}
+	return reDigits.ReplaceAllString(cnpj, ""), nil
+}
+
+// documentSeenAt returns the date a document places its parties at: its issue date, or now when it has none
+func documentSeenAt(document *models.Document) time.Time {
+	if document.IssueDate.IsZero() {
+		return time.Now()
+	}
+	return document.IssueDate
+}
+
+// upsert inserts the party or updates the existing one, adding count documents and widening the seen period.
+// Profile columns keep the values of the most recent document, never overwriting data with blanks.
+func (r *PartyRegistry) upsert(ctx context.Context, db bun.IDB, companyID int64, party *models.Party, seenAt time.Time, count int64) error {
+	party.CompanyID = companyID
+	party.FirstSeenAt = seenAt
+	party.LastSeenAt = seenAt
+	party.DocumentCount = count
+
+	query := db.NewInsert().
+		Model(party).
+		On("CONFLICT (company_id, tax_id) DO UPDATE").
+		Set("document_count = pt.document_count + EXCLUDED.document_count").
+		Set("first_seen_at = LEAST(pt.first_seen_at, EXCLUDED.first_seen_at)").
+		Set("last_seen_at = GREATEST(pt.last_seen_at, EXCLUDED.last_seen_at)").
+		Set("updated_at = EXCLUDED.updated_at")
+
+	for _, column := range partyProfileColumns {
+		query = query.Set(fmt.Sprintf(
+			"%[1]s = CASE WHEN EXCLUDED.%[1]s <> '' AND (COALESCE(pt.%[1]s, '') = '' OR COALESCE(EXCLUDED.last_seen_at >= pt.last_seen_at, true)) THEN EXCLUDED.%[1]s ELSE pt.%[1]s END",
+			column,
+		))
+	}
+
+	if _, err := query.Returning("id").Exec(ctx); err != nil {
+		return fmt.Errorf("failed to upsert party %s: %v", party.TaxID, err)
+	}
+	return nil
+}
+
+// newParty normalizes a party read from a document, returning nil when the document does not identify it by CNPJ or CPF
+func newParty(party models.Party) *models.Party {
+	party.TaxID = reDigits.ReplaceAllString(party.TaxID, "")
+	if party.TaxID == "" {
+		return nil
+	}
+
+	party.PersonType = "pj"
+	if len(party.TaxID) == 11 {
+		party.PersonType = "pf"
+	}
+
+	for _, value := range []*string{
+		&party.Name, &party.TradeName, &party.MunicipalRegistration, &party.StateRegistration,
+		&party.Address, &party.Number, &party.Complement, &party.District,
+		&party.MunicipalityCode, &party.City, &party.Phone, &party.Email,
+	} {
+		*value = strings.TrimSpace(*value)
+	}
+	party.State = strings.ToUpper(strings.TrimSpace(party.State))
+	party.ZipCode = reDigits.ReplaceAllString(party.ZipCode, "")
+
+	return &party
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+// Outcomes recorded for a processed file
+const (
+	ProcessedFileStatusProcessed   = "processed"
+	ProcessedFileStatusDuplicate   = "duplicate"
+	ProcessedFileStatusRevised     = "revised"
+	ProcessedFileStatusError       = "error"
+	ProcessedFileStatusQuarantined = "quarantined"
+)
+
+// ProcessedFileRegistry records the content hash and outcome of every ingested file
+type ProcessedFileRegistry struct{}
+
+// NewProcessedFileRegistry creates a new processed file registry instance
+func NewProcessedFileRegistry() *ProcessedFileRegistry {
+	return &ProcessedFileRegistry{}
+}
+
+// Record stores the outcome of the files of an ingestion. A file received again updates its row and attempts.
+// Failures are only logged: the documents are already stored and the file will simply not be pre-filtered.
+func (r *ProcessedFileRegistry) Record(ctx context.Context, companyID int64, xmlDocuments []XMLDocument, results []ProcessingResult) {
+	// The same content twice in one batch keeps the last outcome, as ON CONFLICT cannot update a row twice
+	byHash := make(map[string]int, len(xmlDocuments))
+	files := make([]*models.ProcessedFile, 0, len(xmlDocuments))
+	for i, xmlDoc := range xmlDocuments {
+		file := &models.ProcessedFile{
+			CompanyID:       companyID,
+			FileName:        xmlDoc.FileName,
+			FileHash:        hashContent(xmlDoc.Content),
+			Source:          xmlDoc.Provider,
+			Size:            int64(len(xmlDoc.Content)),
+			Status:          processedFileStatus(&results[i]),
+			DuplicateMethod: results[i].DuplicateMethod,
+			DocumentID:      results[i].DocumentID,
+			Attempts:        1,
+		}
+		if results[i].Error != nil {
+			file.Error = results[i].Error.Error()
+		}
+
+		if index, ok := byHash[file.FileHash]; ok {
+			files[index] = file
+			continue
+		}
+		byHash[file.FileHash] = len(files)
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		return
+	}
+
+	_, err := database.DB.NewInsert().
+		Model(&files).
+		On("CONFLICT (company_id, file_hash) DO UPDATE").
+		Set("file_name = EXCLUDED.file_name").
+		Set("source = EXCLUDED.source").
+		Set("status = EXCLUDED.status").
+		Set("error = EXCLUDED.error").
+		Set("duplicate_method = EXCLUDED.duplicate_method").
+		Set("document_id = EXCLUDED.document_id").
+		Set("attempts = pf.attempts + 1").
+		Set("processed_at = EXCLUDED.processed_at").
+		Set("updated_at = EXCLUDED.updated_at").
+		Returning("NULL").
+		Exec(ctx)
+	if err != nil {
+		logger.ErrorWithFields("Failed to record processed files", err, map[string]any{
+			"operation":   "record_processed_files",
+			"company_id":  companyID,
+			"files_count": len(files),
+		})
+	}
+}
+
+// FindProcessed returns the hashes, among the given ones, of files that already produced a document for the company.
+// Files that failed are not returned so that they are tried again.
+func (r *ProcessedFileRegistry) FindProcessed(ctx context.Context, companyID int64, hashes []string) (map[string]bool, error) {
+	processed := make(map[string]bool)
+	if len(hashes) == 0 {
+		return processed, nil
+	}
+
+	var found []string
+	err := database.DB.NewSelect().
+		Model((*models.ProcessedFile)(nil)).
+		Column("file_hash").
+		Where("company_id = ?", companyID).
+		Where("file_hash IN (?)", bun.In(hashes)).
+		Where("status IN (?)", bun.In([]string{ProcessedFileStatusProcessed, ProcessedFileStatusDuplicate, ProcessedFileStatusRevised})).
+		Where("document_id IS NOT NULL").
+		Scan(ctx, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range found {
+		processed[hash] = true
+	}
+	return processed, nil
+}
+
+// processedFileStatus maps a processing result to the outcome recorded for its file
+func processedFileStatus(result *ProcessingResult) string {
+	switch {
+	case result.Error != nil && result.QuarantineID != 0:
+		return ProcessedFileStatusQuarantined
+	case result.Error != nil:
+		return ProcessedFileStatusError
+	case result.Revision > 0:
+		return ProcessedFileStatusRevised
+	case result.IsDuplicate:
+		return ProcessedFileStatusDuplicate
+	default:
+		return ProcessedFileStatusProcessed
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// Quarantine stages: the ingestion step at which a file failed
+const (
+	QuarantineStageValidation = "validation" // Rejected by schema validation
+	QuarantineStageParse      = "parse"      // Not recognized by any parser
+)
+
+// Quarantine statuses
+const (
+	QuarantineStatusPending     = "pending"
+	QuarantineStatusReprocessed = "reprocessed"
+)
+
+// quarantinePrefix keeps quarantined XMLs apart from the organized document paths in the bucket
+const quarantinePrefix = "quarantine"
+
+// QuarantineStore keeps the XMLs that failed ingestion so they can be reprocessed after a parser fix
+type QuarantineStore struct{}
+
+// NewQuarantineStore creates a new quarantine store instance
+func NewQuarantineStore() *QuarantineStore {
+	return &QuarantineStore{}
+}
+
+// Add uploads a failed XML to the quarantine area and records the failure. A file already quarantined
+// for the company is recognized by its content hash: its error is refreshed and one more attempt is counted.
+func (q *QuarantineStore) Add(ctx context.Context, companyID int64, xmlDoc XMLDocument, stage string, cause error) (*models.QuarantinedFile, error) {
+	if storage.Storage == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+
+	contentHash := hashContent(xmlDoc.Content)
+	file := &models.QuarantinedFile{
+		CompanyID:   companyID,
+		FileName:    xmlDoc.FileName,
+		Source:      xmlDoc.Provider,
+		Stage:       stage,
+		Error:       cause.Error(),
+		ContentHash: contentHash,
+		StorageKey:  fmt.Sprintf("%s/%d/%s.xml", quarantinePrefix, companyID, contentHash),
+		Size:        int64(len(xmlDoc.Content)),
+		Attempts:    1,
+		Status:      QuarantineStatusPending,
+		FailedAt:    time.Now(),
+	}
+
+	err := storage.Storage.UploadFileWithMetadata(ctx, "nfse-storage", file.StorageKey, []byte(xmlDoc.Content), "application/xml", storage.CompanyMetadata(companyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload quarantined XML: %v", err)
+	}
+
+	_, err = database.DB.NewInsert().
+		Model(file).
+		On("CONFLICT (company_id, content_hash) DO UPDATE").
+		Set("file_name = EXCLUDED.file_name").
+		Set("source = EXCLUDED.source").
+		Set("stage = EXCLUDED.stage").
+		Set("error = EXCLUDED.error").
+		Set("attempts = qf.attempts + 1").
+		Set("status = EXCLUDED.status").
+		Set("failed_at = EXCLUDED.failed_at").
+		Set("updated_at = EXCLUDED.updated_at").
+		Returning("id, attempts").
+		Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record quarantined file: %v", err)
+	}
+
+	return file, nil
+}
+
+// Release marks a quarantined file as reprocessed into a document and removes its quarantined copy,
+// since the XML is now stored under the document path. The record is kept as history.
+func (q *QuarantineStore) Release(ctx context.Context, file *models.QuarantinedFile, documentID int64) error {
+	file.Status = QuarantineStatusReprocessed
+	file.DocumentID = documentID
+	file.ReprocessedAt = time.Now()
+
+	_, err := database.DB.NewUpdate().
+		Model(file).
+		Column("status", "document_id", "reprocessed_at", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update quarantined file: %v", err)
+	}
+
+	if storage.Storage == nil {
+		return nil
+	}
+	if err := storage.Storage.DeleteFile(ctx, "nfse-storage", file.StorageKey); err != nil {
+		logger.WarnWithFields("Failed to remove reprocessed XML from quarantine", map[string]any{
+			"operation":     "release_quarantined_file",
+			"quarantine_id": file.ID,
+			"storage_key":   file.StorageKey,
+			"error":         err.Error(),
+		})
+	}
+
+	return nil
+}
+
+// Remove deletes a quarantined file and its stored XML, recording the audit entry in the same transaction.
+// The record is only deleted when the stored XML could be removed.
+func (q *QuarantineStore) Remove(ctx context.Context, file *models.QuarantinedFile, audit *models.AuditLog) error {
+	return database.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewDelete().Model(file).WherePK().Exec(ctx); err != nil {
+			return fmt.Errorf("failed to delete quarantined file: %v", err)
+		}
+
+		if _, err := tx.NewInsert().Model(audit).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to record audit log: %v", err)
+		}
+
+		if file.Status == QuarantineStatusReprocessed {
+			// The quarantined copy was already removed on release
+			return nil
+		}
+		if storage.Storage == nil {
+			return fmt.Errorf("storage not initialized")
+		}
+		if err := storage.Storage.DeleteFile(ctx, "nfse-storage", file.StorageKey); err != nil {
+			return fmt.Errorf("failed to delete quarantined XML: %v", err)
+		}
+		return nil
+	})
+}
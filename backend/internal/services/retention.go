@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+const (
+	// MinRetentionYears is the legal minimum retention of fiscal documents: five years counted from the
+	// first day of the fiscal year after the document (CTN art. 173)
+	MinRetentionYears = 5
+	// MaxRetentionYears bounds the retention a policy can set
+	MaxRetentionYears = 100
+)
+
+// retentionReferenceExpr is the date the retention of a document counts from: the issue date, or the
+// creation date for documents without one
+const retentionReferenceExpr = "CASE WHEN ?TableAlias.issue_date > '1900-01-01' THEN ?TableAlias.issue_date ELSE ?TableAlias.created_at END"
+
+var (
+	// ErrLegalHold is returned when deleting a document under legal hold
+	ErrLegalHold = errors.New("document is under legal hold")
+	// ErrUnderRetention is returned when deleting a document before its retention period ends
+	ErrUnderRetention = errors.New("document is under retention")
+)
+
+// RetentionStatus describes how long a document must be kept and whether it can be deleted
+type RetentionStatus struct {
+	PolicyID       int64     `json:"policy_id,omitempty"` // Empty when the default retention applies
+	RetentionYears int       `json:"retention_years"`
+	RetainedUntil  time.Time `json:"retained_until"`
+	LegalHold      bool      `json:"legal_hold"`
+	Deletable      bool      `json:"deletable"`
+}
+
+// RetentionPolicies resolves the retention policies of documents and guards their deletion
+type RetentionPolicies struct {
+	config *config.Config
+}
+
+// NewRetentionPolicies creates a new retention policies instance
+func NewRetentionPolicies() *RetentionPolicies {
+	return &RetentionPolicies{
+		config: config.Get(),
+	}
+}
+
+// Validate checks the document type and the retention period of a policy
+func (r *RetentionPolicies) Validate(policy *models.RetentionPolicy) error {
+	switch policy.DocumentType {
+	case "", DocumentTypeNFSe, DocumentTypeNFe, DocumentTypeCTe:
+	default:
+		return fmt.Errorf("document_type must be one of: %s, %s, %s", DocumentTypeNFSe, DocumentTypeNFe, DocumentTypeCTe)
+	}
+	if policy.RetentionYears < MinRetentionYears || policy.RetentionYears > MaxRetentionYears {
+		return fmt.Errorf("retention_years must be between %d and %d", MinRetentionYears, MaxRetentionYears)
+	}
+	return nil
+}
+
+// List returns all retention policies, the most specific first
+func (r *RetentionPolicies) List(ctx context.Context) ([]models.RetentionPolicy, error) {
+	policies := make([]models.RetentionPolicy, 0)
+	err := database.DB.NewSelect().
+		Model(&policies).
+		OrderExpr("company_id IS NULL, COALESCE(document_type, '') = '', company_id, document_type").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load retention policies: %v", err)
+	}
+	return policies, nil
+}
+
+// DefaultYears returns the retention of documents without a policy, never below the legal minimum
+func (r *RetentionPolicies) DefaultYears() int {
+	return max(r.config.Retention.DefaultYears, MinRetentionYears)
+}
+
+// Status returns the retention of a document
+func (r *RetentionPolicies) Status(ctx context.Context, document *models.Document) (*RetentionStatus, error) {
+	policies, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := RetentionStatus{
+		RetentionYears: r.DefaultYears(),
+		LegalHold:      document.LegalHold,
+	}
+	if policy := matchRetentionPolicy(policies, document.CompanyID, document.Type); policy != nil {
+		status.PolicyID = policy.ID
+		status.RetentionYears = policy.RetentionYears
+	}
+	status.RetainedUntil = retainedUntil(retentionReference(document.IssueDate, document.CreatedAt), status.RetentionYears)
+	status.Deletable = !status.LegalHold && !time.Now().Before(status.RetainedUntil)
+
+	return &status, nil
+}
+
+// CheckDeletable returns the retention of a document, with ErrLegalHold or ErrUnderRetention when it cannot be deleted
+func (r *RetentionPolicies) CheckDeletable(ctx context.Context, document *models.Document) (*RetentionStatus, error) {
+	status, err := r.Status(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	if status.LegalHold {
+		return status, ErrLegalHold
+	}
+	if !status.Deletable {
+		return status, ErrUnderRetention
+	}
+	return status, nil
+}
+
+// CheckCompanyDeletable returns ErrLegalHold or ErrUnderRetention when a company has documents that cannot
+// be deleted, along with the date the last of them leaves retention
+func (r *RetentionPolicies) CheckCompanyDeletable(ctx context.Context, companyID int64) (time.Time, error) {
+	var groups []struct {
+		Type      string    `bun:"type"`
+		Reference time.Time `bun:"reference"`
+		Held      int       `bun:"held"`
+	}
+	err := database.DB.NewSelect().
+		Model((*models.Document)(nil)).
+		Column("type").
+		ColumnExpr("MAX("+retentionReferenceExpr+") AS reference").
+		ColumnExpr("COUNT(*) FILTER (WHERE ?TableAlias.legal_hold) AS held").
+		Where("company_id = ?", companyID).
+		Group("type").
+		Scan(ctx, &groups)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to load company documents: %v", err)
+	}
+
+	policies, err := r.List(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var latest time.Time
+	held := false
+	for _, group := range groups {
+		years := r.DefaultYears()
+		if policy := matchRetentionPolicy(policies, companyID, group.Type); policy != nil {
+			years = policy.RetentionYears
+		}
+		if until := retainedUntil(group.Reference, years); until.After(latest) {
+			latest = until
+		}
+		held = held || group.Held > 0
+	}
+
+	if held {
+		return latest, ErrLegalHold
+	}
+	if time.Now().Before(latest) {
+		return latest, ErrUnderRetention
+	}
+	return latest, nil
+}
+
+// SetLegalHold places a document under legal hold, or releases it
+func (r *RetentionPolicies) SetLegalHold(ctx context.Context, document *models.Document, hold bool, reason string) error {
+	document.LegalHold = hold
+	document.LegalHoldReason = ""
+	document.LegalHoldAt = time.Time{}
+	if hold {
+		document.LegalHoldReason = reason
+		document.LegalHoldAt = time.Now()
+	}
+	document.UpdatedAt = time.Now()
+
+	_, err := database.DB.NewUpdate().
+		Model(document).
+		Column("legal_hold", "legal_hold_reason", "legal_hold_at", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update legal hold: %v", err)
+	}
+
+	logger.InfoWithFields("Updated document legal hold", map[string]any{
+		"operation":   "legal_hold",
+		"document_id": document.ID,
+		"company_id":  document.CompanyID,
+		"legal_hold":  hold,
+	})
+	return nil
+}
+
+// matchRetentionPolicy returns the most specific policy for a company and document type: company and type,
+// then company, then type, then the policy for all documents. It returns nil when none applies.
+func matchRetentionPolicy(policies []models.RetentionPolicy, companyID int64, documentType string) *models.RetentionPolicy {
+	var best *models.RetentionPolicy
+	bestScore := -1
+	for i := range policies {
+		policy := &policies[i]
+		if policy.CompanyID != 0 && policy.CompanyID != companyID {
+			continue
+		}
+		if policy.DocumentType != "" && policy.DocumentType != documentType {
+			continue
+		}
+
+		score := 0
+		if policy.CompanyID != 0 {
+			score += 2
+		}
+		if policy.DocumentType != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = policy, score
+		}
+	}
+	return best
+}
+
+// retentionReference returns the date the retention of a document counts from
+func retentionReference(issueDate, createdAt time.Time) time.Time {
+	if issueDate.Year() > 1900 {
+		return issueDate
+	}
+	return createdAt
+}
+
+// retainedUntil returns the end of the retention of a document: the retention years counted from the first
+// day of the fiscal year after the reference date
+func retainedUntil(reference time.Time, years int) time.Time {
+	return time.Date(reference.Year()+1+years, time.January, 1, 0, 0, 0, 0, time.UTC)
+}
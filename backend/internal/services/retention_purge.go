@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+// Outcomes of a retention purge run
+const (
+	PurgeStatusRunning   = "running"
+	PurgeStatusCompleted = "completed"
+	PurgeStatusFailed    = "failed"
+)
+
+// What started a retention purge run
+const (
+	PurgeTriggerScheduled = "scheduled"
+	PurgeTriggerManual    = "manual"
+)
+
+// Result of an expired document in a retention purge run
+const (
+	PurgeResultEligible = "eligible"
+	PurgeResultPurged   = "purged"
+	PurgeResultHeld     = "held"
+	PurgeResultFailed   = "failed"
+)
+
+const (
+	// purgeBatchSize is the number of documents loaded per purge iteration
+	purgeBatchSize = 200
+	// purgeItemLimit caps the documents listed in a run; the counters keep counting past it
+	purgeItemLimit = 1000
+)
+
+// ErrPurgeRunning is returned when a purge is requested while another one is in progress
+var ErrPurgeRunning = errors.New("a retention purge is already running")
+
+// purgeMu keeps one purge running at a time in the process, whether scheduled or requested through the API
+var purgeMu sync.Mutex
+
+// purgeCandidate holds the document columns needed to decide whether its retention expired
+type purgeCandidate struct {
+	ID         int64     `bun:"id"`
+	CompanyID  int64     `bun:"company_id"`
+	Type       string    `bun:"type"`
+	Number     string    `bun:"number"`
+	IssueDate  time.Time `bun:"issue_date"`
+	CreatedAt  time.Time `bun:"created_at"`
+	StorageKey string    `bun:"storage_key"`
+	LegalHold  bool      `bun:"legal_hold"`
+}
+
+// RetentionPurger deletes the documents, with their stored XMLs, whose retention policy expired.
+// Only documents covered by a retention policy are purged; the default retention only guards deletions.
+type RetentionPurger struct {
+	ticker   *time.Ticker
+	stopChan chan bool
+	running  bool
+	config   *config.Config
+	policies *RetentionPolicies
+	files    *DocumentFiles
+}
+
+// NewRetentionPurger creates a new retention purger
+func NewRetentionPurger() *RetentionPurger {
+	return &RetentionPurger{
+		stopChan: make(chan bool),
+		config:   config.Get(),
+		policies: NewRetentionPolicies(),
+		files:    NewDocumentFiles(),
+	}
+}
+
+// Start schedules purges every RETENTION_PURGE_INTERVAL. Scheduled purges delete documents; they are disabled by default.
+func (p *RetentionPurger) Start() error {
+	interval := p.config.Retention.PurgeInterval
+	if interval <= 0 {
+		logger.InfoWithFields("Scheduled retention purge is disabled", map[string]any{
+			"operation": "start_retention_purger",
+		})
+		return nil
+	}
+
+	if p.running {
+		return nil
+	}
+
+	p.ticker = time.NewTicker(interval)
+	p.running = true
+
+	logger.InfoWithFields("Starting retention purger", map[string]any{
+		"operation": "start_retention_purger",
+		"interval":  interval.String(),
+	})
+
+	go p.run()
+	return nil
+}
+
+// Stop stops the scheduled purges
+func (p *RetentionPurger) Stop() {
+	if !p.running {
+		return
+	}
+
+	p.stopChan <- true
+	p.ticker.Stop()
+	p.running = false
+}
+
+// run is the purger loop
+func (p *RetentionPurger) run() {
+	for {
+		select {
+		case <-p.ticker.C:
+			if _, err := p.Purge(context.Background(), PurgeTriggerScheduled, false, 0); err != nil && !errors.Is(err, ErrPurgeRunning) {
+				logger.ErrorWithFields("Retention purge failed", err, map[string]any{
+					"operation": "purge_retention",
+				})
+			}
+		case <-p.stopChan:
+			return
+		}
+	}
+}
+
+// Trigger starts a purge in the background and returns its run as soon as it is recorded
+func (p *RetentionPurger) Trigger(ctx context.Context, dryRun bool, userID int64) (*models.RetentionPurgeRun, error) {
+	if !purgeMu.TryLock() {
+		return nil, ErrPurgeRunning
+	}
+
+	run, err := p.createRun(ctx, PurgeTriggerManual, dryRun, userID)
+	if err != nil {
+		purgeMu.Unlock()
+		return nil, err
+	}
+
+	go func() {
+		defer purgeMu.Unlock()
+		p.execute(context.Background(), run)
+	}()
+
+	return run, nil
+}
+
+// Purge runs a purge and waits for it to finish. A dry run only reports the expired documents.
+func (p *RetentionPurger) Purge(ctx context.Context, trigger string, dryRun bool, userID int64) (*models.RetentionPurgeRun, error) {
+	if !purgeMu.TryLock() {
+		return nil, ErrPurgeRunning
+	}
+	defer purgeMu.Unlock()
+
+	run, err := p.createRun(ctx, trigger, dryRun, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.execute(ctx, run); err != nil {
+		return run, err
+	}
+	return run, nil
+}
+
+// createRun records a new running purge
+func (p *RetentionPurger) createRun(ctx context.Context, trigger string, dryRun bool, userID int64) (*models.RetentionPurgeRun, error) {
+	run := &models.RetentionPurgeRun{
+		Status:  PurgeStatusRunning,
+		Trigger: trigger,
+		DryRun:  dryRun,
+		UserID:  userID,
+	}
+	if _, err := database.DB.NewInsert().Model(run).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record retention purge: %v", err)
+	}
+	return run, nil
+}
+
+// execute purges the expired documents and records the outcome of the run
+func (p *RetentionPurger) execute(ctx context.Context, run *models.RetentionPurgeRun) error {
+	startTime := time.Now()
+
+	logger.InfoWithFields("Starting retention purge", map[string]any{
+		"operation": "purge_retention",
+		"run_id":    run.ID,
+		"trigger":   run.Trigger,
+		"dry_run":   run.DryRun,
+	})
+
+	err := p.purge(ctx, run)
+	run.Status = PurgeStatusCompleted
+	if err != nil {
+		run.Status = PurgeStatusFailed
+		run.Error = err.Error()
+	}
+	run.FinishedAt = time.Now()
+
+	_, updateErr := database.DB.NewUpdate().
+		Model(run).
+		ExcludeColumn("id", "trigger", "dry_run", "user_id", "started_at").
+		WherePK().
+		Exec(context.Background())
+	if updateErr != nil {
+		logger.ErrorWithFields("Failed to record retention purge result", updateErr, map[string]any{
+			"operation": "purge_retention",
+			"run_id":    run.ID,
+		})
+	}
+
+	logger.InfoWithFields("Completed retention purge", map[string]any{
+		"operation":  "purge_retention",
+		"run_id":     run.ID,
+		"status":     run.Status,
+		"dry_run":    run.DryRun,
+		"eligible":   run.Eligible,
+		"purged":     run.Purged,
+		"held":       run.Held,
+		"failed":     run.Failed,
+		"elapsed_ms": time.Since(startTime).Milliseconds(),
+	})
+
+	return err
+}
+
+// purge pages through the documents old enough for the shortest policy and deletes those whose policy expired
+func (p *RetentionPurger) purge(ctx context.Context, run *models.RetentionPurgeRun) error {
+	policies, err := p.policies.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+
+	shortest := policies[0].RetentionYears
+	for _, policy := range policies {
+		shortest = min(shortest, policy.RetentionYears)
+	}
+
+	// No document referenced on or after this date can have expired under any policy
+	now := time.Now()
+	cutoff := time.Date(now.Year()-shortest, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var lastID int64
+	for {
+		var candidates []purgeCandidate
+		err := database.DB.NewSelect().
+			Model((*models.Document)(nil)).
+			Column("id", "company_id", "type", "number", "issue_date", "created_at", "storage_key", "legal_hold").
+			Where(retentionReferenceExpr+" < ?", cutoff).
+			Where("?TableAlias.id > ?", lastID).
+			OrderExpr("?TableAlias.id ASC").
+			Limit(purgeBatchSize).
+			Scan(ctx, &candidates)
+		if err != nil {
+			return fmt.Errorf("failed to load documents: %v", err)
+		}
+
+		if len(candidates) == 0 {
+			return nil
+		}
+
+		for _, candidate := range candidates {
+			lastID = candidate.ID
+
+			policy := matchRetentionPolicy(policies, candidate.CompanyID, candidate.Type)
+			if policy == nil {
+				continue
+			}
+			until := retainedUntil(retentionReference(candidate.IssueDate, candidate.CreatedAt), policy.RetentionYears)
+			if now.Before(until) {
+				continue
+			}
+
+			run.Eligible++
+			p.purgeDocument(ctx, run, candidate, models.RetentionPurgeItem{
+				DocumentID:    candidate.ID,
+				CompanyID:     candidate.CompanyID,
+				Type:          candidate.Type,
+				Number:        candidate.Number,
+				IssueDate:     candidate.IssueDate,
+				PolicyID:      policy.ID,
+				RetainedUntil: until,
+				StorageKey:    candidate.StorageKey,
+			})
+		}
+	}
+}
+
+// purgeDocument deletes an expired document unless the run is a dry run or the document is under legal hold
+func (p *RetentionPurger) purgeDocument(ctx context.Context, run *models.RetentionPurgeRun, candidate purgeCandidate, item models.RetentionPurgeItem) {
+	switch {
+	case candidate.LegalHold:
+		run.Held++
+		item.Result = PurgeResultHeld
+	case run.DryRun:
+		item.Result = PurgeResultEligible
+	default:
+		document := &models.Document{
+			ID:         candidate.ID,
+			CompanyID:  candidate.CompanyID,
+			StorageKey: candidate.StorageKey,
+		}
+		err := p.files.Delete(ctx, document)
+		switch {
+		case errors.Is(err, ErrLegalHold):
+			run.Held++
+			item.Result = PurgeResultHeld
+		case err != nil:
+			run.Failed++
+			item.Result = PurgeResultFailed
+			item.Error = err.Error()
+			logger.WarnWithFields("Failed to purge expired document", map[string]any{
+				"operation":   "purge_retention",
+				"run_id":      run.ID,
+				"document_id": candidate.ID,
+				"error":       err.Error(),
+			})
+		default:
+			run.Purged++
+			item.Result = PurgeResultPurged
+		}
+	}
+
+	if len(run.Documents) < purgeItemLimit {
+		run.Documents = append(run.Documents, item)
+	}
+}
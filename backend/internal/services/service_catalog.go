@@ -0,0 +1,117 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/catalog"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+)
+
+// Catalog validation codes
+const (
+	ValidationCodeUnknownServiceCode = "unknown_service_code" // Not a subitem of the LC 116/2003 list
+	ValidationCodeVetoedServiceCode  = "vetoed_service_code"  // Subitem vetoed from LC 116/2003
+	ValidationCodeUnknownCNAE        = "unknown_cnae_code"
+)
+
+var (
+	serviceCatalogs     *catalog.Catalogs
+	serviceCatalogsOnce sync.Once
+)
+
+// LoadServiceCatalogs loads the LC 116/2003 and CNAE catalogs once per process.
+// It returns nil when the configured catalogs cannot be read.
+func LoadServiceCatalogs() *catalog.Catalogs {
+	serviceCatalogsOnce.Do(func() {
+		path := config.Get().Catalog.Path
+
+		catalogs, err := catalog.Load(path)
+		if err != nil {
+			logger.ErrorWithFields("Failed to load service catalogs, classification disabled", err, map[string]any{
+				"operation": "load_service_catalogs",
+				"path":      path,
+			})
+			return
+		}
+
+		logger.InfoWithFields("Loaded service catalogs", map[string]any{
+			"operation":     "load_service_catalogs",
+			"path":          path,
+			"lc116_version": catalogs.ServiceList.Version,
+			"lc116_codes":   catalogs.ServiceList.Len(),
+			"cnae_version":  catalogs.CNAE.Version,
+			"cnae_codes":    catalogs.CNAE.Len(),
+			"ibge_version":  catalogs.Municipalities.Version,
+			"ibge_codes":    catalogs.Municipalities.Len(),
+		})
+
+		serviceCatalogs = catalogs
+	})
+
+	return serviceCatalogs
+}
+
+// ServiceClassifier enriches documents with the LC 116/2003 service item and CNAE descriptions
+type ServiceClassifier struct {
+	catalogs *catalog.Catalogs
+}
+
+// NewServiceClassifier creates a new service classifier backed by the loaded catalogs
+func NewServiceClassifier() *ServiceClassifier {
+	return &ServiceClassifier{
+		catalogs: LoadServiceCatalogs(),
+	}
+}
+
+// Classify fills the service item and descriptions of a document from its service and CNAE codes,
+// returning an issue for each code that is not in the catalogs
+func (s *ServiceClassifier) Classify(document *models.Document) []models.ValidationIssue {
+	if s.catalogs == nil {
+		return nil
+	}
+
+	var issues []models.ValidationIssue
+
+	document.ServiceItem = ""
+	document.ServiceItemDescription = ""
+	if document.ServiceCode != "" {
+		entry, ok := s.catalogs.ServiceList.Lookup(document.ServiceCode)
+		switch {
+		case !ok || entry.Parent == "":
+			issues = append(issues, models.ValidationIssue{
+				Code:    ValidationCodeUnknownServiceCode,
+				Path:    "service_code",
+				Message: fmt.Sprintf("service code %q is not a subitem of the %s service list", document.ServiceCode, s.catalogs.ServiceList.Version),
+			})
+		case entry.Vetoed:
+			issues = append(issues, models.ValidationIssue{
+				Code:    ValidationCodeVetoedServiceCode,
+				Path:    "service_code",
+				Message: fmt.Sprintf("service subitem %s was vetoed from the service list", entry.Code),
+			})
+			document.ServiceItem = entry.Code
+		default:
+			document.ServiceItem = entry.Code
+			document.ServiceItemDescription = entry.Description
+		}
+	}
+
+	document.CnaeDescription = ""
+	if document.CnaeCode != "" {
+		entry, ok := s.catalogs.CNAE.Resolve(document.CnaeCode)
+		if ok {
+			document.CnaeDescription = entry.Description
+		} else {
+			issues = append(issues, models.ValidationIssue{
+				Code:    ValidationCodeUnknownCNAE,
+				Path:    "cnae_code",
+				Message: fmt.Sprintf("CNAE %q is not in the %s catalog", document.CnaeCode, s.catalogs.CNAE.Version),
+			})
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/xmldsig"
+)
+
+var (
+	trustStore     *x509.CertPool
+	trustStoreOnce sync.Once
+)
+
+// loadTrustStore loads the ICP-Brasil trust store once per process
+func loadTrustStore() *x509.CertPool {
+	trustStoreOnce.Do(func() {
+		path := config.Get().Signature.TrustStorePath
+
+		pool, count, err := xmldsig.LoadTrustStore(path)
+		if err != nil {
+			logger.ErrorWithFields("Failed to load signature trust store", err, map[string]any{
+				"operation": "load_trust_store",
+				"path":      path,
+			})
+			return
+		}
+
+		if count == 0 {
+			logger.WarnWithFields("Signature trust store is empty, signed documents will be marked unverified", map[string]any{
+				"operation": "load_trust_store",
+				"path":      path,
+			})
+		} else {
+			logger.InfoWithFields("Loaded signature trust store", map[string]any{
+				"operation":    "load_trust_store",
+				"path":         path,
+				"certificates": count,
+			})
+		}
+
+		trustStore = pool
+	})
+
+	return trustStore
+}
+
+// SignatureVerifier checks the XMLDSig signatures of fiscal documents
+type SignatureVerifier struct {
+	verifier *xmldsig.Verifier
+}
+
+// NewSignatureVerifier creates a new signature verifier backed by the ICP-Brasil trust store
+func NewSignatureVerifier() *SignatureVerifier {
+	return &SignatureVerifier{
+		verifier: xmldsig.NewVerifier(loadTrustStore()),
+	}
+}
+
+// HasTrustAnchors reports whether certificate chains can be checked, that is, whether the trust store has certificates
+func (s *SignatureVerifier) HasTrustAnchors() bool {
+	return s.verifier.HasTrustAnchors()
+}
+
+// Verify checks the signatures of a document, validating certificates at its issue date
+func (s *SignatureVerifier) Verify(xmlContent string, issueDate time.Time) *xmldsig.Result {
+	result := s.verifier.Verify([]byte(xmlContent), issueDate)
+
+	if result.Status == xmldsig.StatusInvalid {
+		logger.WarnWithFields("Document signature is not valid", map[string]any{
+			"operation":   "verify_signature",
+			"signer_cnpj": result.SignerCNPJ,
+			"serial":      result.CertificateSerial,
+			"error":       result.Err.Error(),
+		})
+	}
+
+	return result
+}
+
+// ApplySignature records the authenticity status and signer on a document
+func (s *SignatureVerifier) ApplySignature(document *models.Document, result *xmldsig.Result) {
+	document.AuthenticityStatus = result.Status
+	document.SignerCNPJ = result.SignerCNPJ
+	document.SignerCertificateSerial = result.CertificateSerial
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// compressionBatchSize is the number of storage keys loaded per compression iteration
+const compressionBatchSize = 100
+
+// CompressionResult summarizes a storage compression run
+type CompressionResult struct {
+	Total       int
+	Scanned     int
+	Compressed  int
+	Skipped     int // Already stored with the configured codec
+	Missing     int // Referenced by a row but not found in storage
+	Failed      int
+	BytesBefore int64
+	BytesAfter  int64
+	Elapsed     time.Duration
+}
+
+// StorageCompressor rewrites the stored XML of documents, revisions and quarantined files with the configured codec
+type StorageCompressor struct{}
+
+// NewStorageCompressor creates a new storage compressor instance
+func NewStorageCompressor() *StorageCompressor {
+	return &StorageCompressor{}
+}
+
+// CompressExisting compresses in place every stored XML not yet written with the configured codec.
+// Objects already compressed are skipped, so the run is idempotent and can be interrupted.
+func (s *StorageCompressor) CompressExisting(ctx context.Context) (*CompressionResult, error) {
+	compressed, ok := storage.Storage.(*storage.CompressedService)
+	if !ok || compressed.Codec() == nil {
+		return nil, fmt.Errorf("storage compression is disabled")
+	}
+
+	startTime := time.Now()
+	result := &CompressionResult{}
+
+	total, err := database.DB.NewSelect().
+		TableExpr("(?) AS k", s.storageKeys()).
+		Count(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count stored files: %v", err)
+	}
+	result.Total = total
+
+	logger.InfoWithFields("Starting storage compression", map[string]any{
+		"operation": "compress_storage",
+		"codec":     compressed.Codec().Name(),
+		"total":     total,
+	})
+
+	var lastKey string
+	for {
+		var keys []string
+		err := database.DB.NewSelect().
+			TableExpr("(?) AS k", s.storageKeys()).
+			ColumnExpr("k.storage_key").
+			Where("k.storage_key > ?", lastKey).
+			OrderExpr("k.storage_key ASC").
+			Limit(compressionBatchSize).
+			Scan(ctx, &keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load stored files for compression: %v", err)
+		}
+
+		if len(keys) == 0 {
+			break
+		}
+
+		for _, key := range keys {
+			lastKey = key
+			result.Scanned++
+
+			before, after, err := compressed.CompressObject(ctx, "nfse-storage", key)
+			switch {
+			case errors.Is(err, storage.ErrFileNotFound):
+				result.Missing++
+				continue
+			case err != nil:
+				result.Failed++
+				logger.WarnWithFields("Failed to compress stored file", map[string]any{
+					"operation":   "compress_storage",
+					"storage_key": key,
+					"error":       err.Error(),
+				})
+				continue
+			}
+
+			result.BytesBefore += before
+			result.BytesAfter += after
+			if before == after {
+				result.Skipped++
+			} else {
+				result.Compressed++
+			}
+		}
+
+		logger.InfoWithFields("Storage compression progress", map[string]any{
+			"operation":    "compress_storage",
+			"scanned":      result.Scanned,
+			"total":        result.Total,
+			"compressed":   result.Compressed,
+			"bytes_before": result.BytesBefore,
+			"bytes_after":  result.BytesAfter,
+		})
+	}
+
+	result.Elapsed = time.Since(startTime)
+
+	logger.InfoWithFields("Completed storage compression", map[string]any{
+		"operation":    "compress_storage",
+		"scanned":      result.Scanned,
+		"compressed":   result.Compressed,
+		"skipped":      result.Skipped,
+		"missing":      result.Missing,
+		"failed":       result.Failed,
+		"bytes_before": result.BytesBefore,
+		"bytes_after":  result.BytesAfter,
+		"elapsed_ms":   result.Elapsed.Milliseconds(),
+	})
+
+	return result, nil
+}
+
+// storageKeys selects the distinct storage keys referenced by documents, revisions and quarantined files
+func (s *StorageCompressor) storageKeys() *bun.SelectQuery {
+	revisions := database.DB.NewSelect().
+		Model((*models.DocumentRevision)(nil)).
+		Column("storage_key").
+		Where("storage_key <> ''")
+	quarantined := database.DB.NewSelect().
+		Model((*models.QuarantinedFile)(nil)).
+		Column("storage_key").
+		Where("storage_key <> ''")
+
+	return database.DB.NewSelect().
+		Model((*models.Document)(nil)).
+		Column("storage_key").
+		Where("storage_key <> ''").
+		Union(revisions).
+		Union(quarantined)
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// encryptionBatchSize is the number of storage keys loaded per encryption iteration
+const encryptionBatchSize = 100
+
+// EncryptionResult summarizes a storage encryption run
+type EncryptionResult struct {
+	Total     int
+	Scanned   int
+	Encrypted int
+	Skipped   int // Already encrypted with the data key of their company, in the current format
+	Missing   int // Referenced by a row but not found in storage
+	Failed    int
+	Elapsed   time.Duration
+}
+
+// encryptionTarget is a stored object and the company whose data key encrypts it
+type encryptionTarget struct {
+	StorageKey string `bun:"storage_key"`
+	CompanyID  int64  `bun:"company_id"`
+}
+
+// StorageEncryptor rewrites the stored objects of documents, revisions, quarantined files and exports
+// encrypted with the data key of their company
+type StorageEncryptor struct{}
+
+// NewStorageEncryptor creates a new storage encryptor instance
+func NewStorageEncryptor() *StorageEncryptor {
+	return &StorageEncryptor{}
+}
+
+// EncryptExisting encrypts in place every stored object not yet encrypted with the data key of its company,
+// or encrypted in the previous format. Objects already encrypted are skipped, so the run is idempotent and
+// can be interrupted.
+func (s *StorageEncryptor) EncryptExisting(ctx context.Context) (*EncryptionResult, error) {
+	encrypted, ok := s.encryptedService()
+	if !ok {
+		return nil, fmt.Errorf("storage encryption is disabled")
+	}
+
+	startTime := time.Now()
+	result := &EncryptionResult{}
+
+	total, err := database.DB.NewSelect().
+		TableExpr("(?) AS t", s.targets()).
+		Count(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count stored files: %v", err)
+	}
+	result.Total = total
+
+	logger.InfoWithFields("Starting storage encryption", map[string]any{
+		"operation": "encrypt_storage",
+		"total":     total,
+	})
+
+	var lastKey string
+	for {
+		var targets []encryptionTarget
+		err := database.DB.NewSelect().
+			TableExpr("(?) AS t", s.targets()).
+			ColumnExpr("t.storage_key, t.company_id").
+			Where("t.storage_key > ?", lastKey).
+			OrderExpr("t.storage_key ASC").
+			Limit(encryptionBatchSize).
+			Scan(ctx, &targets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load stored files for encryption: %v", err)
+		}
+
+		if len(targets) == 0 {
+			break
+		}
+
+		for _, target := range targets {
+			lastKey = target.StorageKey
+			result.Scanned++
+
+			rewritten, err := encrypted.EncryptObject(ctx, "nfse-storage", target.StorageKey, target.CompanyID)
+			switch {
+			case errors.Is(err, storage.ErrFileNotFound):
+				result.Missing++
+			case err != nil:
+				result.Failed++
+				logger.WarnWithFields("Failed to encrypt stored file", map[string]any{
+					"operation":   "encrypt_storage",
+					"storage_key": target.StorageKey,
+					"company_id":  target.CompanyID,
+					"error":       err.Error(),
+				})
+			case rewritten:
+				result.Encrypted++
+			default:
+				result.Skipped++
+			}
+		}
+
+		logger.InfoWithFields("Storage encryption progress", map[string]any{
+			"operation": "encrypt_storage",
+			"scanned":   result.Scanned,
+			"total":     result.Total,
+			"encrypted": result.Encrypted,
+		})
+	}
+
+	result.Elapsed = time.Since(startTime)
+
+	logger.InfoWithFields("Completed storage encryption", map[string]any{
+		"operation":  "encrypt_storage",
+		"scanned":    result.Scanned,
+		"encrypted":  result.Encrypted,
+		"skipped":    result.Skipped,
+		"missing":    result.Missing,
+		"failed":     result.Failed,
+		"elapsed_ms": result.Elapsed.Milliseconds(),
+	})
+
+	return result, nil
+}
+
+// encryptedService returns the encryption layer of the global storage service
+func (s *StorageEncryptor) encryptedService() (*storage.EncryptedService, bool) {
+	service := storage.Storage
+	if compressed, ok := service.(*storage.CompressedService); ok {
+		service = compressed.StorageService
+	}
+	encrypted, ok := service.(*storage.EncryptedService)
+	return encrypted, ok
+}
+
+// targets selects the distinct storage keys referenced by documents, revisions, quarantined files and exports
+// with their company. A key shared by several companies is encrypted with the data key of the first one.
+func (s *StorageEncryptor) targets() *bun.SelectQuery {
+	revisions := database.DB.NewSelect().
+		Model((*models.DocumentRevision)(nil)).
+		Column("storage_key", "company_id").
+		Where("storage_key <> ''")
+	quarantined := database.DB.NewSelect().
+		Model((*models.QuarantinedFile)(nil)).
+		Column("storage_key", "company_id").
+		Where("storage_key <> ''")
+	exports := database.DB.NewSelect().
+		Model((*models.ExportJob)(nil)).
+		ColumnExpr("storage_key, COALESCE(company_id, 0) AS company_id").
+		Where("storage_key <> ''")
+	documents := database.DB.NewSelect().
+		Model((*models.Document)(nil)).
+		Column("storage_key", "company_id").
+		Where("storage_key <> ''").
+		UnionAll(revisions).
+		UnionAll(quarantined).
+		UnionAll(exports)
+
+	return database.DB.NewSelect().
+		TableExpr("(?) AS r", documents).
+		ColumnExpr("r.storage_key, MIN(r.company_id) AS company_id").
+		GroupExpr("r.storage_key")
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/metrics"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// ErrIntegrityMismatch is returned when a stored XML does not match the SHA-256 recorded at ingestion
+var ErrIntegrityMismatch = errors.New("stored XML does not match its recorded hash")
+
+// downloadVerified downloads a stored XML and checks it against the hash recorded for it.
+// An empty hash, from files stored before hashes were recorded, is not checked.
+func downloadVerified(ctx context.Context, storageKey, expectedHash string) ([]byte, error) {
+	data, err := storage.Storage.DownloadFile(ctx, "nfse-storage", storageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyContent(storageKey, expectedHash, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifyContent checks downloaded content against its recorded hash, counting and logging mismatches
+func verifyContent(storageKey, expectedHash string, data []byte) error {
+	if expectedHash == "" {
+		return nil
+	}
+
+	actualHash := hashContent(string(data))
+	if actualHash == expectedHash {
+		return nil
+	}
+
+	reportIntegrityMismatch(storageKey, expectedHash, actualHash)
+	return fmt.Errorf("%w: %s", ErrIntegrityMismatch, storageKey)
+}
+
+// reportIntegrityMismatch counts and logs a stored XML whose content hash differs from the recorded one
+func reportIntegrityMismatch(storageKey, expectedHash, actualHash string) {
+	metrics.AddCounter("zoomxml_storage_integrity_failures_total",
+		"Stored XMLs whose content did not match the recorded SHA-256 when downloaded", nil, 1)
+	logger.ErrorWithFields("Stored XML failed integrity verification", ErrIntegrityMismatch, map[string]any{
+		"operation":     "verify_stored_xml",
+		"storage_key":   storageKey,
+		"expected_hash": expectedHash,
+		"actual_hash":   actualHash,
+	})
+}
+
+// verifyingReader hashes a stored file while it is read and fails at its end when the content does not match
+// the recorded hash, for files too large to be read before they are sent
+type verifyingReader struct {
+	io.ReadCloser
+	storageKey   string
+	expectedHash string
+	hash         hash.Hash
+}
+
+// newVerifyingReader wraps reader so that reading it to the end checks it against expectedHash.
+// An empty hash is not checked.
+func newVerifyingReader(reader io.ReadCloser, storageKey, expectedHash string) io.ReadCloser {
+	if expectedHash == "" {
+		return reader
+	}
+	return &verifyingReader{ReadCloser: reader, storageKey: storageKey, expectedHash: expectedHash, hash: sha256.New()}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		actualHash := fmt.Sprintf("%x", r.hash.Sum(nil))
+		if actualHash != r.expectedHash {
+			reportIntegrityMismatch(r.storageKey, r.expectedHash, actualHash)
+			return n, fmt.Errorf("%w: %s", ErrIntegrityMismatch, r.storageKey)
+		}
+	}
+	return n, err
+}
+
+// OpenSignedFile opens a file requested through a signed download link. Only files recorded by a document,
+// a document revision or an export job are served, always checked against the hash recorded for them.
+// Any other key fails with storage.ErrFileNotFound. The caller must close the reader.
+func OpenSignedFile(ctx context.Context, storageKey string) (io.ReadCloser, *storage.FileInfo, error) {
+	if storageKey == "" {
+		return nil, nil, storage.ErrFileNotFound
+	}
+
+	document := new(models.Document)
+	err := database.DB.NewSelect().
+		Model(document).
+		Column("id", "storage_key", "hash").
+		Where("storage_key = ?", storageKey).
+		Limit(1).
+		Scan(ctx)
+	if err == nil {
+		return NewDocumentFiles().Open(ctx, document)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fmt.Errorf("failed to look up document file: %v", err)
+	}
+
+	revision := new(models.DocumentRevision)
+	err = database.DB.NewSelect().
+		Model(revision).
+		Column("id", "storage_key", "content_hash").
+		Where("storage_key = ?", storageKey).
+		Limit(1).
+		Scan(ctx)
+	if err == nil {
+		return NewDocumentFiles().Open(ctx, &models.Document{StorageKey: revision.StorageKey, Hash: revision.ContentHash})
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fmt.Errorf("failed to look up revision file: %v", err)
+	}
+
+	job := new(models.ExportJob)
+	err = database.DB.NewSelect().
+		Model(job).
+		Column("id", "storage_key", "content_hash").
+		Where("storage_key = ?", storageKey).
+		Where("status = ?", ExportStatusCompleted).
+		Limit(1).
+		Scan(ctx)
+	if err == nil {
+		return NewDocumentExporter().Open(ctx, job)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fmt.Errorf("failed to look up export file: %v", err)
+	}
+
+	return nil, nil, storage.ErrFileNotFound
+}
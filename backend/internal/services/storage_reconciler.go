@@ -0,0 +1,689 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// Policies applied to the orphaned objects found by a reconciliation
+const (
+	ReconcilePolicyReport   = "report"
+	ReconcilePolicyReimport = "reimport"
+	ReconcilePolicyDelete   = "delete"
+)
+
+// Outcomes of a storage reconciliation run
+const (
+	ReconcileStatusRunning   = "running"
+	ReconcileStatusCompleted = "completed"
+	ReconcileStatusFailed    = "failed"
+)
+
+// What started a storage reconciliation run
+const (
+	ReconcileTriggerScheduled = "scheduled"
+	ReconcileTriggerManual    = "manual"
+)
+
+// Kinds of items reported by a reconciliation
+const (
+	ReconcileItemOrphaned = "orphaned"
+	ReconcileItemMissing  = "missing"
+	ReconcileItemDeletion = "deletion"
+)
+
+// What a reconciliation did with an item
+const (
+	ReconcileActionReported    = "reported"
+	ReconcileActionReimported  = "reimported"
+	ReconcileActionDuplicate   = "duplicate"
+	ReconcileActionQuarantined = "quarantined"
+	ReconcileActionDeleted     = "deleted"
+	ReconcileActionUnresolved  = "unresolved"
+	ReconcileActionFlagged     = "flagged"
+	ReconcileActionFailed      = "failed"
+)
+
+const (
+	// reconcileBatchSize is the number of documents loaded per reconciliation iteration
+	reconcileBatchSize = 200
+	// reconcileItemLimit caps the items kept in a run; the counters keep counting past it
+	reconcileItemLimit = 1000
+)
+
+// ErrReconcileRunning is returned when a reconciliation is requested while another one is in progress
+var ErrReconcileRunning = errors.New("a storage reconciliation is already running")
+
+// reconcileMu keeps one reconciliation running at a time in the process, whether scheduled or requested through the API
+var reconcileMu sync.Mutex
+
+// reconcilePrefixes are the bucket prefixes holding document XMLs, one per document type
+var reconcilePrefixes = []string{DocumentTypeNFSe + "/", DocumentTypeNFe + "/", DocumentTypeCTe + "/"}
+
+// reconcileKeyPattern matches the type/year/competence/cnpj/company/hash/filename layout of document XMLs,
+// capturing the company
+var reconcileKeyPattern = regexp.MustCompile(`^[a-z]+/[^/]+/[^/]+/[^/]*/([0-9]+)/[0-9a-f]{64}/[^/]+$`)
+
+// reconcileDocument is a document and the key of its stored XML
+type reconcileDocument struct {
+	ID               int64     `bun:"id"`
+	CompanyID        int64     `bun:"company_id"`
+	StorageKey       string    `bun:"storage_key"`
+	StorageMissingAt time.Time `bun:"storage_missing_at,nullzero"`
+}
+
+// ValidReconcilePolicy reports whether policy is one of the reconciliation policies
+func ValidReconcilePolicy(policy string) bool {
+	switch policy {
+	case ReconcilePolicyReport, ReconcilePolicyReimport, ReconcilePolicyDelete:
+		return true
+	}
+	return false
+}
+
+// StorageReconciler periodically compares the document XMLs in the storage bucket with documents.storage_key.
+// Objects no row references are reported, reimported as documents or deleted according to the policy, and
+// documents whose object is gone are flagged with storage_missing_at.
+type StorageReconciler struct {
+	ticker   *time.Ticker
+	stopChan chan bool
+	running  bool
+	config   *config.Config
+	manager  *NFSeXMLManager
+}
+
+// NewStorageReconciler creates a new storage reconciler
+func NewStorageReconciler() *StorageReconciler {
+	return &StorageReconciler{
+		stopChan: make(chan bool),
+		config:   config.Get(),
+		manager:  NewNFSeXMLManager(),
+	}
+}
+
+// Start schedules reconciliations every STORAGE_RECONCILE_INTERVAL with the STORAGE_RECONCILE_POLICY
+func (r *StorageReconciler) Start() error {
+	interval := r.config.Storage.ReconcileInterval
+	if interval <= 0 {
+		logger.InfoWithFields("Storage reconciler is disabled", map[string]any{
+			"operation": "start_storage_reconciler",
+		})
+		return nil
+	}
+
+	policy := r.config.Storage.ReconcilePolicy
+	if !ValidReconcilePolicy(policy) {
+		return fmt.Errorf("invalid STORAGE_RECONCILE_POLICY %q", policy)
+	}
+
+	if r.running {
+		return nil
+	}
+
+	r.ticker = time.NewTicker(interval)
+	r.running = true
+
+	logger.InfoWithFields("Starting storage reconciler", map[string]any{
+		"operation": "start_storage_reconciler",
+		"interval":  interval.String(),
+		"policy":    policy,
+		"min_age":   r.config.Storage.ReconcileMinAge.String(),
+	})
+
+	go r.run()
+	return nil
+}
+
+// Stop stops the scheduled reconciliations
+func (r *StorageReconciler) Stop() {
+	if !r.running {
+		return
+	}
+
+	r.stopChan <- true
+	r.ticker.Stop()
+	r.running = false
+}
+
+// run is the reconciler loop. The first reconciliation waits for the first tick, so restarts do not relist the bucket.
+func (r *StorageReconciler) run() {
+	for {
+		select {
+		case <-r.ticker.C:
+			_, err := r.Reconcile(context.Background(), ReconcileTriggerScheduled, r.config.Storage.ReconcilePolicy, 0)
+			if err != nil && !errors.Is(err, ErrReconcileRunning) {
+				logger.ErrorWithFields("Storage reconciliation failed", err, map[string]any{
+					"operation": "reconcile_storage",
+				})
+			}
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// Trigger starts a reconciliation in the background and returns its run as soon as it is recorded
+func (r *StorageReconciler) Trigger(ctx context.Context, policy string, userID int64) (*models.StorageReconcileRun, error) {
+	if !reconcileMu.TryLock() {
+		return nil, ErrReconcileRunning
+	}
+
+	run, err := r.createRun(ctx, ReconcileTriggerManual, policy, userID)
+	if err != nil {
+		reconcileMu.Unlock()
+		return nil, err
+	}
+
+	go func() {
+		defer reconcileMu.Unlock()
+		r.execute(context.Background(), run)
+	}()
+
+	return run, nil
+}
+
+// Reconcile runs a reconciliation and waits for it to finish
+func (r *StorageReconciler) Reconcile(ctx context.Context, trigger, policy string, userID int64) (*models.StorageReconcileRun, error) {
+	if !reconcileMu.TryLock() {
+		return nil, ErrReconcileRunning
+	}
+	defer reconcileMu.Unlock()
+
+	run, err := r.createRun(ctx, trigger, policy, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.execute(ctx, run); err != nil {
+		return run, err
+	}
+	return run, nil
+}
+
+// createRun records a new running reconciliation
+func (r *StorageReconciler) createRun(ctx context.Context, trigger, policy string, userID int64) (*models.StorageReconcileRun, error) {
+	run := &models.StorageReconcileRun{
+		Status:  ReconcileStatusRunning,
+		Trigger: trigger,
+		Policy:  policy,
+		UserID:  userID,
+	}
+	if _, err := database.DB.NewInsert().Model(run).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record storage reconciliation: %v", err)
+	}
+	return run, nil
+}
+
+// execute reconciles the storage with the documents and records the outcome of the run
+func (r *StorageReconciler) execute(ctx context.Context, run *models.StorageReconcileRun) error {
+	startTime := time.Now()
+
+	logger.InfoWithFields("Starting storage reconciliation", map[string]any{
+		"operation": "reconcile_storage",
+		"run_id":    run.ID,
+		"trigger":   run.Trigger,
+		"policy":    run.Policy,
+	})
+
+	err := r.reconcile(ctx, run)
+	run.Status = ReconcileStatusCompleted
+	if err != nil {
+		run.Status = ReconcileStatusFailed
+		run.Error = err.Error()
+	}
+	run.FinishedAt = time.Now()
+
+	_, updateErr := database.DB.NewUpdate().
+		Model(run).
+		ExcludeColumn("id", "trigger", "policy", "user_id", "started_at").
+		WherePK().
+		Exec(context.Background())
+	if updateErr != nil {
+		logger.ErrorWithFields("Failed to record storage reconciliation result", updateErr, map[string]any{
+			"operation": "reconcile_storage",
+			"run_id":    run.ID,
+		})
+	}
+
+	logger.InfoWithFields("Completed storage reconciliation", map[string]any{
+		"operation":  "reconcile_storage",
+		"run_id":     run.ID,
+		"status":     run.Status,
+		"policy":     run.Policy,
+		"listed":     run.Listed,
+		"orphaned":   run.Orphaned,
+		"recent":     run.Recent,
+		"reimported": run.Reimported,
+		"deleted":    run.Deleted,
+		"unresolved": run.Unresolved,
+		"missing":    run.Missing,
+		"restored":   run.Restored,
+		"failed":     run.Failed,
+		"elapsed_ms": time.Since(startTime).Milliseconds(),
+	})
+
+	return err
+}
+
+// reconcile lists the document prefixes of the bucket, flags the documents whose object is gone and handles
+// the objects no row references
+func (r *StorageReconciler) reconcile(ctx context.Context, run *models.StorageReconcileRun) error {
+	if storage.Storage == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+
+	if err := r.collectDeletions(ctx, run); err != nil {
+		return err
+	}
+
+	listed := make(map[string]bool)
+	for _, prefix := range reconcilePrefixes {
+		err := storage.Storage.ListFiles(ctx, "nfse-storage", prefix, func(objectName string) error {
+			listed[objectName] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	run.Listed = int64(len(listed))
+
+	referenced, err := r.reconcileDocuments(ctx, run, listed)
+	if err != nil {
+		return err
+	}
+
+	var candidates []string
+	for storageKey := range listed {
+		if !referenced[storageKey] {
+			candidates = append(candidates, storageKey)
+		}
+	}
+
+	// Revisions, quarantined files and exports may share a key, and rows inserted while the bucket was
+	// listed reference objects the document pass did not see
+	orphaned, err := unreferencedStorageKeys(ctx, candidates)
+	if err != nil {
+		return err
+	}
+	for _, storageKey := range orphaned {
+		r.reconcileOrphan(ctx, run, storageKey)
+	}
+
+	return nil
+}
+
+// collectDeletions removes the XMLs of deleted documents that could not be removed with them. Their deletion
+// was already decided, so it does not depend on the policy of the run.
+func (r *StorageReconciler) collectDeletions(ctx context.Context, run *models.StorageReconcileRun) error {
+	deletions := make([]*models.StorageDeletion, 0)
+	err := database.DB.NewSelect().
+		Model(&deletions).
+		Order("id ASC").
+		Scan(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load stored XML deletions: %v", err)
+	}
+
+	for _, deletion := range deletions {
+		r.collectDeletion(ctx, run, deletion)
+	}
+	return nil
+}
+
+// collectDeletion removes the XML of a deleted document
+func (r *StorageReconciler) collectDeletion(ctx context.Context, run *models.StorageReconcileRun, deletion *models.StorageDeletion) {
+	item := models.StorageReconcileItem{
+		Kind:       ReconcileItemDeletion,
+		StorageKey: deletion.StorageKey,
+		DocumentID: deletion.DocumentID,
+		CompanyID:  deletion.CompanyID,
+	}
+
+	if err := removeStorageDeletion(ctx, deletion); err != nil {
+		run.Failed++
+		item.Action = ReconcileActionFailed
+		item.Error = err.Error()
+		addReconcileItem(run, item)
+
+		logger.WarnWithFields("Failed to delete stored XML of deleted document", map[string]any{
+			"operation":   "reconcile_storage",
+			"run_id":      run.ID,
+			"document_id": deletion.DocumentID,
+			"storage_key": deletion.StorageKey,
+			"error":       err.Error(),
+		})
+		return
+	}
+
+	run.Deleted++
+	item.Action = ReconcileActionDeleted
+	addReconcileItem(run, item)
+}
+
+// reconcileDocuments pages through the documents, flagging those whose object was not listed and is not in
+// storage and clearing the flag of those whose object is back. It returns the storage keys of the documents.
+func (r *StorageReconciler) reconcileDocuments(ctx context.Context, run *models.StorageReconcileRun, listed map[string]bool) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+
+	var lastID int64
+	for {
+		var documents []reconcileDocument
+		err := database.DB.NewSelect().
+			Model((*models.Document)(nil)).
+			Column("id", "company_id", "storage_key", "storage_missing_at").
+			Where("storage_key <> ''").
+			Where("id > ?", lastID).
+			Order("id ASC").
+			Limit(reconcileBatchSize).
+			Scan(ctx, &documents)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load document storage keys: %v", err)
+		}
+
+		if len(documents) == 0 {
+			return referenced, nil
+		}
+
+		for _, document := range documents {
+			lastID = document.ID
+			referenced[document.StorageKey] = true
+
+			// Documents stored outside the listed prefixes, or written after the listing, are checked one by one
+			exists := listed[document.StorageKey]
+			if !exists {
+				exists, err = storage.Storage.FileExists(ctx, "nfse-storage", document.StorageKey)
+				if err != nil {
+					run.Failed++
+					logger.WarnWithFields("Failed to check stored XML during reconciliation", map[string]any{
+						"operation":   "reconcile_storage",
+						"run_id":      run.ID,
+						"document_id": document.ID,
+						"storage_key": document.StorageKey,
+						"error":       err.Error(),
+					})
+					continue
+				}
+			}
+
+			switch {
+			case !exists:
+				r.flagMissing(ctx, run, document)
+			case !document.StorageMissingAt.IsZero():
+				if err := r.setStorageMissing(ctx, document.ID, time.Time{}); err != nil {
+					run.Failed++
+					continue
+				}
+				run.Restored++
+			}
+		}
+	}
+}
+
+// flagMissing records a document whose object is not in storage, keeping the time it was first found missing
+func (r *StorageReconciler) flagMissing(ctx context.Context, run *models.StorageReconcileRun, document reconcileDocument) {
+	run.Missing++
+	item := models.StorageReconcileItem{
+		Kind:       ReconcileItemMissing,
+		Action:     ReconcileActionFlagged,
+		StorageKey: document.StorageKey,
+		DocumentID: document.ID,
+		CompanyID:  document.CompanyID,
+	}
+
+	if document.StorageMissingAt.IsZero() {
+		if err := r.setStorageMissing(ctx, document.ID, time.Now()); err != nil {
+			run.Failed++
+			item.Action = ReconcileActionFailed
+			item.Error = err.Error()
+		}
+	}
+
+	addReconcileItem(run, item)
+}
+
+// setStorageMissing sets or, with a zero time, clears the missing storage flag of a document
+func (r *StorageReconciler) setStorageMissing(ctx context.Context, documentID int64, missingAt time.Time) error {
+	query := database.DB.NewUpdate().
+		Model((*models.Document)(nil)).
+		Where("id = ?", documentID)
+	if missingAt.IsZero() {
+		query = query.Set("storage_missing_at = NULL")
+	} else {
+		query = query.Set("storage_missing_at = ?", missingAt)
+	}
+
+	if _, err := query.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to update document storage flag: %v", err)
+	}
+	return nil
+}
+
+// reconcileOrphan applies the policy of the run to an object no row references. Objects written less than
+// STORAGE_RECONCILE_MIN_AGE ago are skipped: their row may still be on its way.
+func (r *StorageReconciler) reconcileOrphan(ctx context.Context, run *models.StorageReconcileRun, storageKey string) {
+	item := models.StorageReconcileItem{
+		Kind:       ReconcileItemOrphaned,
+		StorageKey: storageKey,
+	}
+
+	// The XML of a document deleted since the deletions were collected is removed, never reimported
+	deletion := new(models.StorageDeletion)
+	err := database.DB.NewSelect().
+		Model(deletion).
+		Where("storage_key = ?", storageKey).
+		Scan(ctx)
+	if err == nil {
+		r.collectDeletion(ctx, run, deletion)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		r.failOrphan(run, item, fmt.Errorf("failed to check stored XML deletion: %v", err))
+		return
+	}
+
+	reader, info, err := storage.Storage.OpenFile(ctx, "nfse-storage", storageKey)
+	if errors.Is(err, storage.ErrFileNotFound) {
+		// Removed since the listing
+		return
+	}
+	if err != nil {
+		r.failOrphan(run, item, err)
+		return
+	}
+	defer reader.Close()
+
+	if time.Since(info.ModifiedAt) < r.config.Storage.ReconcileMinAge {
+		run.Recent++
+		return
+	}
+
+	run.Orphaned++
+	switch run.Policy {
+	case ReconcilePolicyDelete:
+		r.deleteOrphan(ctx, run, item)
+	case ReconcilePolicyReimport:
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			r.failOrphan(run, item, err)
+			return
+		}
+		r.reimportOrphan(ctx, run, item, info, string(content))
+	default:
+		item.Action = ReconcileActionReported
+		addReconcileItem(run, item)
+	}
+}
+
+// reimportOrphan imports an orphaned XML as a document of the company it belongs to. Once the XML is imported,
+// found to be a duplicate or quarantined, the orphaned object is deleted unless the import reused its key.
+func (r *StorageReconciler) reimportOrphan(ctx context.Context, run *models.StorageReconcileRun, item models.StorageReconcileItem, info *storage.FileInfo, content string) {
+	companyID, err := r.resolveCompany(ctx, item.StorageKey, info)
+	if err != nil {
+		r.failOrphan(run, item, err)
+		return
+	}
+	if companyID == 0 {
+		run.Unresolved++
+		item.Action = ReconcileActionUnresolved
+		addReconcileItem(run, item)
+		return
+	}
+	item.CompanyID = companyID
+
+	result, err := r.manager.ProcessSingleXML(ctx, companyID, XMLDocument{
+		FileName: path.Base(item.StorageKey),
+		Content:  content,
+	})
+	if err == nil && result.Error != nil && result.QuarantineID == 0 {
+		err = result.Error
+	}
+	if err != nil {
+		r.failOrphan(run, item, err)
+		return
+	}
+
+	item.DocumentID = result.DocumentID
+	switch {
+	case result.IsDuplicate:
+		item.Action = ReconcileActionDuplicate
+	case result.QuarantineID != 0:
+		item.Action = ReconcileActionQuarantined
+	default:
+		item.Action = ReconcileActionReimported
+		run.Reimported++
+	}
+
+	keep, err := r.keepReimported(ctx, result)
+	if err == nil && !keep {
+		var orphaned []string
+		orphaned, err = unreferencedStorageKeys(ctx, []string{item.StorageKey})
+		keep = len(orphaned) == 0
+	}
+	if err != nil {
+		r.failOrphan(run, item, err)
+		return
+	}
+	if !keep {
+		if err := storage.Storage.DeleteFile(ctx, "nfse-storage", item.StorageKey); err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+			r.failOrphan(run, item, err)
+			return
+		}
+	}
+
+	addReconcileItem(run, item)
+}
+
+// keepReimported reports whether an orphaned XML found to be a duplicate must be kept because the existing
+// document has lost its own object; it may be the only copy left of that document
+func (r *StorageReconciler) keepReimported(ctx context.Context, result *ProcessingResult) (bool, error) {
+	if !result.IsDuplicate || result.DocumentID == 0 {
+		return false, nil
+	}
+
+	var storageKey string
+	err := database.DB.NewSelect().
+		Model((*models.Document)(nil)).
+		Column("storage_key").
+		Where("id = ?", result.DocumentID).
+		Scan(ctx, &storageKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to load duplicate document: %v", err)
+	}
+	if storageKey == "" {
+		return true, nil
+	}
+
+	exists, err := storage.Storage.FileExists(ctx, "nfse-storage", storageKey)
+	if err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+// deleteOrphan removes an orphaned object from storage
+func (r *StorageReconciler) deleteOrphan(ctx context.Context, run *models.StorageReconcileRun, item models.StorageReconcileItem) {
+	if err := storage.Storage.DeleteFile(ctx, "nfse-storage", item.StorageKey); err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+		r.failOrphan(run, item, err)
+		return
+	}
+
+	run.Deleted++
+	item.Action = ReconcileActionDeleted
+	addReconcileItem(run, item)
+}
+
+// failOrphan records an orphaned object that could not be handled
+func (r *StorageReconciler) failOrphan(run *models.StorageReconcileRun, item models.StorageReconcileItem, err error) {
+	run.Failed++
+	item.Action = ReconcileActionFailed
+	item.Error = err.Error()
+	addReconcileItem(run, item)
+
+	logger.WarnWithFields("Failed to reconcile orphaned XML", map[string]any{
+		"operation":   "reconcile_storage",
+		"run_id":      run.ID,
+		"storage_key": item.StorageKey,
+		"error":       err.Error(),
+	})
+}
+
+// resolveCompany identifies the company of an orphaned XML from the object alone: the company recorded in its
+// metadata or, for objects stored without it, the company segment of its key. The XML content is never used: the
+// CNPJs in a note of a deleted company may belong to another tenant. It returns 0 when the company is unknown or
+// no longer exists, leaving the object unresolved.
+func (r *StorageReconciler) resolveCompany(ctx context.Context, storageKey string, info *storage.FileInfo) (int64, error) {
+	var companyID int64
+	if value, ok := info.Metadata[storage.MetadataCompany]; ok {
+		companyID, _ = strconv.ParseInt(value, 10, 64)
+	} else {
+		companyID = keyCompanyID(storageKey)
+	}
+	if companyID <= 0 {
+		return 0, nil
+	}
+
+	exists, err := database.DB.NewSelect().
+		Model((*models.Company)(nil)).
+		Where("id = ?", companyID).
+		Exists(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check company: %v", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+	return companyID, nil
+}
+
+// keyCompanyID returns the company segment of a document XML key, or 0 for keys of the earlier
+// type/year/competence/cnpj/filename layout, which do not record it
+func keyCompanyID(storageKey string) int64 {
+	match := reconcileKeyPattern.FindStringSubmatch(storageKey)
+	if match == nil {
+		return 0
+	}
+	companyID, _ := strconv.ParseInt(match[1], 10, 64)
+	return companyID
+}
+
+// addReconcileItem keeps an item in the run, up to reconcileItemLimit
+func addReconcileItem(run *models.StorageReconcileRun, item models.StorageReconcileItem) {
+	if len(run.Items) < reconcileItemLimit {
+		run.Items = append(run.Items, item)
+	}
+}
@@ -0,0 +1,25 @@
+package services
+
+import "testing"
+
+// Only the company segment of the current key layout identifies the company of an orphaned XML
+func TestKeyCompanyID(t *testing.T) {
+	hash := hashContent("nota")
+	tests := []struct {
+		storageKey string
+		want       int64
+	}{
+		{"nfse/2025/022025/34194865000158/42/" + hash + "/nota.xml", 42},
+		{"nfe/2025/022025//7/" + hash + "/nota.xml", 7},
+		// Earlier layout, without the company
+		{"nfse/2025/022025/34194865000158/nota.xml", 0},
+		{"nfse/2025/022025/34194865000158/42/nota.xml", 0},
+		{"revisions/42/10/" + hash + ".xml", 0},
+	}
+
+	for _, test := range tests {
+		if got := keyCompanyID(test.storageKey); got != test.want {
+			t.Errorf("keyCompanyID(%q) = %d; want %d", test.storageKey, got, test.want)
+		}
+	}
+}
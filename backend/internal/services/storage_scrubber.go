@@ -0,0 +1,451 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/database"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/metrics"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/storage"
+)
+
+// Outcomes of a storage scrub run
+const (
+	ScrubStatusRunning   = "running"
+	ScrubStatusCompleted = "completed"
+	ScrubStatusFailed    = "failed"
+)
+
+// What started a storage scrub run
+const (
+	ScrubTriggerScheduled = "scheduled"
+	ScrubTriggerManual    = "manual"
+)
+
+// Kinds of problems reported by the storage scrubber
+const (
+	ScrubIssueMissing  = "missing"
+	ScrubIssueMismatch = "mismatch"
+	ScrubIssueOrphaned = "orphaned"
+)
+
+const (
+	// scrubBatchSize is the number of rows loaded per scrub iteration
+	scrubBatchSize = 200
+	// scrubIssueLimit caps the issues kept in a run; the counters keep counting past it
+	scrubIssueLimit = 1000
+)
+
+// ErrScrubRunning is returned when a scrub is requested while another one is in progress
+var ErrScrubRunning = errors.New("a storage scrub is already running")
+
+// scrubMu keeps one scrub running at a time in the process, whether scheduled or requested through the API
+var scrubMu sync.Mutex
+
+// scrubReference is a row that references a stored XML, with the hash recorded for it
+type scrubReference struct {
+	ID         int64  `bun:"id"`
+	CompanyID  int64  `bun:"company_id"`
+	StorageKey string `bun:"storage_key"`
+	Hash       string `bun:"hash"`
+}
+
+// scrubSource is a table whose rows reference stored XMLs
+type scrubSource struct {
+	name       string
+	model      any
+	hashColumn string
+}
+
+// scrubSources lists the tables checked by the scrubber; an object referenced by none of them is orphaned
+var scrubSources = []scrubSource{
+	{"document", (*models.Document)(nil), "hash"},
+	{"revision", (*models.DocumentRevision)(nil), "content_hash"},
+	{"quarantine", (*models.QuarantinedFile)(nil), "content_hash"},
+	{"export", (*models.ExportJob)(nil), "content_hash"},
+}
+
+// StorageScrubber periodically walks the database and the storage bucket, reporting stored XMLs that are missing,
+// do not match the hash recorded at ingestion, or are not referenced by any row
+type StorageScrubber struct {
+	ticker   *time.Ticker
+	stopChan chan bool
+	running  bool
+	config   *config.Config
+}
+
+// NewStorageScrubber creates a new storage scrubber
+func NewStorageScrubber() *StorageScrubber {
+	return &StorageScrubber{
+		stopChan: make(chan bool),
+		config:   config.Get(),
+	}
+}
+
+// Start publishes the metrics of the last run and schedules scrubs every STORAGE_SCRUB_INTERVAL
+func (s *StorageScrubber) Start() error {
+	if last, err := s.LatestRun(context.Background()); err == nil && last != nil {
+		publishScrubMetrics(last)
+	}
+
+	interval := s.config.Storage.ScrubInterval
+	if interval <= 0 {
+		logger.InfoWithFields("Storage scrubber is disabled", map[string]any{
+			"operation": "start_storage_scrubber",
+		})
+		return nil
+	}
+
+	if s.running {
+		return nil
+	}
+
+	s.ticker = time.NewTicker(interval)
+	s.running = true
+
+	logger.InfoWithFields("Starting storage scrubber", map[string]any{
+		"operation": "start_storage_scrubber",
+		"interval":  interval.String(),
+	})
+
+	go s.run()
+	return nil
+}
+
+// Stop stops the scheduled scrubs
+func (s *StorageScrubber) Stop() {
+	if !s.running {
+		return
+	}
+
+	s.stopChan <- true
+	s.ticker.Stop()
+	s.running = false
+}
+
+// run is the scrubber loop. The first scrub waits for the first tick, so restarts do not rescan the storage.
+func (s *StorageScrubber) run() {
+	for {
+		select {
+		case <-s.ticker.C:
+			if _, err := s.Scrub(context.Background(), ScrubTriggerScheduled); err != nil && !errors.Is(err, ErrScrubRunning) {
+				logger.ErrorWithFields("Storage scrub failed", err, map[string]any{
+					"operation": "scrub_storage",
+				})
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// Trigger starts a scrub in the background and returns its run as soon as it is recorded
+func (s *StorageScrubber) Trigger(ctx context.Context) (*models.StorageScrubRun, error) {
+	if !scrubMu.TryLock() {
+		return nil, ErrScrubRunning
+	}
+
+	run, err := s.createRun(ctx, ScrubTriggerManual)
+	if err != nil {
+		scrubMu.Unlock()
+		return nil, err
+	}
+
+	go func() {
+		defer scrubMu.Unlock()
+		s.execute(context.Background(), run)
+	}()
+
+	return run, nil
+}
+
+// Scrub runs a scrub and waits for it to finish
+func (s *StorageScrubber) Scrub(ctx context.Context, trigger string) (*models.StorageScrubRun, error) {
+	if !scrubMu.TryLock() {
+		return nil, ErrScrubRunning
+	}
+	defer scrubMu.Unlock()
+
+	run, err := s.createRun(ctx, trigger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.execute(ctx, run); err != nil {
+		return run, err
+	}
+	return run, nil
+}
+
+// LatestRun returns the most recent finished run, or nil when the storage was never scrubbed
+func (s *StorageScrubber) LatestRun(ctx context.Context) (*models.StorageScrubRun, error) {
+	var runs []models.StorageScrubRun
+	err := database.DB.NewSelect().
+		Model(&runs).
+		Where("status <> ?", ScrubStatusRunning).
+		Order("started_at DESC").
+		Limit(1).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, nil
+	}
+	return &runs[0], nil
+}
+
+// createRun records a new running scrub
+func (s *StorageScrubber) createRun(ctx context.Context, trigger string) (*models.StorageScrubRun, error) {
+	run := &models.StorageScrubRun{
+		Status:  ScrubStatusRunning,
+		Trigger: trigger,
+	}
+	if _, err := database.DB.NewInsert().Model(run).Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record storage scrub: %v", err)
+	}
+	return run, nil
+}
+
+// execute checks every referenced XML, then lists the bucket for orphaned objects, and records the outcome of the run
+func (s *StorageScrubber) execute(ctx context.Context, run *models.StorageScrubRun) error {
+	startTime := time.Now()
+
+	logger.InfoWithFields("Starting storage scrub", map[string]any{
+		"operation": "scrub_storage",
+		"run_id":    run.ID,
+		"trigger":   run.Trigger,
+	})
+
+	err := s.scrub(ctx, run)
+	run.Status = ScrubStatusCompleted
+	if err != nil {
+		run.Status = ScrubStatusFailed
+		run.Error = err.Error()
+	}
+	run.FinishedAt = time.Now()
+
+	_, updateErr := database.DB.NewUpdate().
+		Model(run).
+		ExcludeColumn("id", "trigger", "started_at").
+		WherePK().
+		Exec(context.Background())
+	if updateErr != nil {
+		logger.ErrorWithFields("Failed to record storage scrub result", updateErr, map[string]any{
+			"operation": "scrub_storage",
+			"run_id":    run.ID,
+		})
+	}
+
+	publishScrubMetrics(run)
+
+	logger.InfoWithFields("Completed storage scrub", map[string]any{
+		"operation":  "scrub_storage",
+		"run_id":     run.ID,
+		"status":     run.Status,
+		"checked":    run.Checked,
+		"verified":   run.Verified,
+		"unverified": run.Unverified,
+		"missing":    run.Missing,
+		"mismatched": run.Mismatched,
+		"orphaned":   run.Orphaned,
+		"failed":     run.Failed,
+		"elapsed_ms": time.Since(startTime).Milliseconds(),
+	})
+
+	return err
+}
+
+// scrub fills the counters and issues of a run
+func (s *StorageScrubber) scrub(ctx context.Context, run *models.StorageScrubRun) error {
+	if storage.Storage == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+
+	// Objects already checked, by key and hash; the first revision of a document shares the document's key
+	checked := make(map[string]map[string]bool)
+	for _, source := range scrubSources {
+		if err := s.scrubSource(ctx, run, source, checked); err != nil {
+			return err
+		}
+	}
+
+	var candidates []string
+	err := storage.Storage.ListFiles(ctx, "nfse-storage", "", func(objectName string) error {
+		if _, ok := checked[objectName]; !ok {
+			candidates = append(candidates, objectName)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Rows inserted while the bucket was listed reference objects the first pass did not see
+	orphaned, err := unreferencedStorageKeys(ctx, candidates)
+	if err != nil {
+		return err
+	}
+	for _, storageKey := range orphaned {
+		run.Orphaned++
+		addScrubIssue(run, models.StorageScrubIssue{
+			Kind:       ScrubIssueOrphaned,
+			StorageKey: storageKey,
+		})
+	}
+
+	return nil
+}
+
+// scrubSource pages through the rows of a table that reference stored XMLs and checks each object
+func (s *StorageScrubber) scrubSource(ctx context.Context, run *models.StorageScrubRun, source scrubSource, checked map[string]map[string]bool) error {
+	var lastID int64
+	for {
+		var references []scrubReference
+		err := database.DB.NewSelect().
+			Model(source.model).
+			ColumnExpr("?TableAlias.id, COALESCE(?TableAlias.company_id, 0) AS company_id, ?TableAlias.storage_key").
+			ColumnExpr("COALESCE(?TableAlias.?, '') AS hash", bun.Ident(source.hashColumn)).
+			Where("?TableAlias.storage_key <> ''").
+			Where("?TableAlias.id > ?", lastID).
+			OrderExpr("?TableAlias.id ASC").
+			Limit(scrubBatchSize).
+			Scan(ctx, &references)
+		if err != nil {
+			return fmt.Errorf("failed to load %s storage keys: %v", source.name, err)
+		}
+
+		if len(references) == 0 {
+			return nil
+		}
+
+		for _, reference := range references {
+			lastID = reference.ID
+			if checked[reference.StorageKey][reference.Hash] {
+				continue
+			}
+			if checked[reference.StorageKey] == nil {
+				checked[reference.StorageKey] = make(map[string]bool)
+			}
+			checked[reference.StorageKey][reference.Hash] = true
+
+			s.checkObject(ctx, run, source.name, reference)
+		}
+	}
+}
+
+// checkObject downloads a referenced XML and compares it with the recorded hash
+func (s *StorageScrubber) checkObject(ctx context.Context, run *models.StorageScrubRun, source string, reference scrubReference) {
+	issue := models.StorageScrubIssue{
+		StorageKey:   reference.StorageKey,
+		Source:       source,
+		RecordID:     reference.ID,
+		CompanyID:    reference.CompanyID,
+		ExpectedHash: reference.Hash,
+	}
+
+	data, err := storage.Storage.DownloadFile(ctx, "nfse-storage", reference.StorageKey)
+	switch {
+	case errors.Is(err, storage.ErrFileNotFound):
+		run.Missing++
+		issue.Kind = ScrubIssueMissing
+		addScrubIssue(run, issue)
+		return
+	case err != nil:
+		run.Failed++
+		logger.WarnWithFields("Failed to read stored XML during scrub", map[string]any{
+			"operation":   "scrub_storage",
+			"run_id":      run.ID,
+			"storage_key": reference.StorageKey,
+			"error":       err.Error(),
+		})
+		return
+	}
+
+	run.Checked++
+	if reference.Hash == "" {
+		run.Unverified++
+		return
+	}
+
+	issue.ActualHash = hashContent(string(data))
+	if issue.ActualHash == reference.Hash {
+		run.Verified++
+		return
+	}
+
+	run.Mismatched++
+	issue.Kind = ScrubIssueMismatch
+	addScrubIssue(run, issue)
+}
+
+// unreferencedStorageKeys returns the storage keys that no document, revision, quarantined file or export references
+func unreferencedStorageKeys(ctx context.Context, storageKeys []string) ([]string, error) {
+	unreferenced := make([]string, 0)
+	for start := 0; start < len(storageKeys); start += scrubBatchSize {
+		batch := storageKeys[start:min(start+scrubBatchSize, len(storageKeys))]
+
+		referenced := make(map[string]bool)
+		for _, source := range scrubSources {
+			var found []string
+			err := database.DB.NewSelect().
+				Model(source.model).
+				Column("storage_key").
+				Where("storage_key IN (?)", bun.In(batch)).
+				Scan(ctx, &found)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check %s storage keys: %v", source.name, err)
+			}
+			for _, storageKey := range found {
+				referenced[storageKey] = true
+			}
+		}
+
+		for _, storageKey := range batch {
+			if !referenced[storageKey] {
+				unreferenced = append(unreferenced, storageKey)
+			}
+		}
+	}
+
+	return unreferenced, nil
+}
+
+// addScrubIssue keeps an issue in the run, up to scrubIssueLimit
+func addScrubIssue(run *models.StorageScrubRun, issue models.StorageScrubIssue) {
+	if len(run.Issues) < scrubIssueLimit {
+		run.Issues = append(run.Issues, issue)
+	}
+}
+
+// publishScrubMetrics exposes the outcome of a finished run
+func publishScrubMetrics(run *models.StorageScrubRun) {
+	results := map[string]int64{
+		"verified":   run.Verified,
+		"unverified": run.Unverified,
+		"missing":    run.Missing,
+		"mismatched": run.Mismatched,
+		"orphaned":   run.Orphaned,
+		"failed":     run.Failed,
+	}
+	for result, count := range results {
+		metrics.SetGauge("zoomxml_storage_scrub_objects", "Stored XMLs by outcome of the last storage scrub",
+			metrics.Labels{"result": result}, float64(count))
+	}
+
+	success := 0.0
+	if run.Status == ScrubStatusCompleted {
+		success = 1
+	}
+	metrics.SetGauge("zoomxml_storage_scrub_last_success", "Whether the last storage scrub completed (1) or failed (0)", nil, success)
+	metrics.SetGauge("zoomxml_storage_scrub_last_run_timestamp_seconds", "Unix time the last storage scrub finished", nil,
+		float64(run.FinishedAt.Unix()))
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/logger"
+	"github.com/zoomxml/internal/models"
+	"github.com/zoomxml/internal/xsd"
+)
+
+// Document validation statuses
+const (
+	ValidationStatusValid   = "valid"
+	ValidationStatusInvalid = "invalid"
+	ValidationStatusSkipped = "skipped"
+)
+
+// ValidationCodeInvalidDate flags date fields that are missing or could not be parsed
+const ValidationCodeInvalidDate = "invalid_date"
+
+// XMLValidationOutcome is the result of the validation stage for a single document
+type XMLValidationOutcome struct {
+	Mode   string
+	Status string
+	Schema string
+	Errors []xsd.ValidationError
+}
+
+// Rejected reports whether the document must not be stored
+func (o *XMLValidationOutcome) Rejected() bool {
+	return o.Mode == config.XMLValidationReject && o.Status == ValidationStatusInvalid
+}
+
+// ApplyTo records the validation status and errors on a document
+func (o *XMLValidationOutcome) ApplyTo(document *models.Document) {
+	document.ValidationStatus = o.Status
+	document.ValidationErrors = nil
+	for _, validationErr := range o.Errors {
+		document.ValidationErrors = append(document.ValidationErrors, models.ValidationIssue{
+			Code:    validationErr.Code,
+			Path:    validationErr.Path,
+			Line:    validationErr.Line,
+			Message: validationErr.Message,
+		})
+	}
+}
+
+// ApplyParseIssues records problems found while parsing, marking the document invalid
+func ApplyParseIssues(document *models.Document, issues []models.ValidationIssue) {
+	if len(issues) == 0 {
+		return
+	}
+	document.ValidationErrors = append(document.ValidationErrors, issues...)
+	document.ValidationStatus = ValidationStatusInvalid
+}
+
+// XMLValidator checks the structure of fiscal XML against the bundled XSDs using the mode configured per provider
+type XMLValidator struct {
+	schemas *xsd.Validator
+	config  config.XMLValidationConfig
+}
+
+// NewXMLValidator creates a new XML validator from the application configuration
+func NewXMLValidator() *XMLValidator {
+	schemas, err := xsd.Default()
+	if err != nil {
+		// Bundled schemas are compiled into the binary; failing here is a programming error
+		logger.ErrorWithFields("Failed to load bundled XML schemas, validation disabled", err, map[string]any{
+			"operation": "xml_validation",
+		})
+	}
+
+	return &XMLValidator{
+		schemas: schemas,
+		config:  config.Get().XMLValidation,
+	}
+}
+
+// Validate runs the validation stage for a document received from a provider
+func (v *XMLValidator) Validate(provider, fileName, content string) *XMLValidationOutcome {
+	outcome := &XMLValidationOutcome{
+		Mode:   v.config.ModeFor(provider),
+		Status: ValidationStatusSkipped,
+	}
+
+	if outcome.Mode == config.XMLValidationOff || v.schemas == nil {
+		return outcome
+	}
+
+	result := v.schemas.Validate([]byte(content))
+	outcome.Schema = result.Schema
+	outcome.Errors = result.Errors
+
+	if result.Valid() {
+		outcome.Status = ValidationStatusValid
+		return outcome
+	}
+
+	outcome.Status = ValidationStatusInvalid
+	logger.WarnWithFields("XML document failed schema validation", map[string]any{
+		"operation":    "xml_validation",
+		"provider":     provider,
+		"file_name":    fileName,
+		"mode":         outcome.Mode,
+		"schema":       result.Schema,
+		"errors_count": len(result.Errors),
+		"first_error":  result.Errors[0].Error(),
+	})
+
+	return outcome
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zoomxml/config"
+	"github.com/zoomxml/internal/xsd"
+)
+
+// validationSample is a note as issued by a provider, kept in testdata/validation
+type validationSample struct {
+	file     string
+	provider string
+	schema   string
+	number   string
+	cnpj     string
+}
+
+// validationSamples has one note of each layout covered by the bundled schemas
+var validationSamples = []validationSample{
+	{"abrasf-v204.xml", LayoutABRASF, "abrasf-nfse-v2", "123", "34194865000158"},
+	{"nfse-nacional-v1.xml", LayoutNFSeNacional, "nfse-nacional-v1", "45", "34194865000158"},
+	{"nfe-v4.xml", LayoutNFe, "nfe-v4", "1842", "34194865000158"},
+	{"prefeitura-moderna.xml", LayoutPrefeituraModerna, "prefeitura-moderna-nfse", "2718", "34194865000158"},
+}
+
+// newRejectingValidator returns a validator that rejects every provider's invalid documents
+func newRejectingValidator(t *testing.T) *XMLValidator {
+	t.Helper()
+
+	schemas, err := xsd.Default()
+	if err != nil {
+		t.Fatalf("failed to load bundled schemas: %v", err)
+	}
+	return &XMLValidator{
+		schemas: schemas,
+		config:  config.XMLValidationConfig{DefaultMode: config.XMLValidationReject},
+	}
+}
+
+// readSample reads a note from testdata/validation
+func readSample(t *testing.T, file string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join("testdata", "validation", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// The bundled schemas only check structure, so they must never reject a note a provider actually issues
+func TestXMLValidatorAcceptsSampleNotesInRejectMode(t *testing.T) {
+	validator := newRejectingValidator(t)
+	parsers := NewParserRegistry()
+
+	for _, sample := range validationSamples {
+		t.Run(sample.file, func(t *testing.T) {
+			content := readSample(t, sample.file)
+
+			outcome := validator.Validate(sample.provider, sample.file, content)
+			if outcome.Rejected() || outcome.Status != ValidationStatusValid {
+				t.Fatalf("Validate status = %s, rejected = %v; want valid: %v", outcome.Status, outcome.Rejected(), outcome.Errors)
+			}
+			if outcome.Schema != sample.schema {
+				t.Errorf("Validate schema = %q; want %q", outcome.Schema, sample.schema)
+			}
+
+			parsed, err := parsers.ParseXML(content)
+			if err != nil {
+				t.Fatalf("ParseXML: %v", err)
+			}
+			if parsed.Number != sample.number || parsed.ProviderCNPJ != sample.cnpj {
+				t.Errorf("ParseXML number, provider = %q, %q; want %q, %q", parsed.Number, parsed.ProviderCNPJ, sample.number, sample.cnpj)
+			}
+		})
+	}
+}
+
+// A sample note with a broken structure is rejected, so the test above is not passing by accident
+func TestXMLValidatorRejectsBrokenSampleNotes(t *testing.T) {
+	validator := newRejectingValidator(t)
+
+	broken := map[string]func(string) string{
+		"missing element": func(content string) string {
+			return removeElement(content, "CodigoVerificacao")
+		},
+		"invalid value": func(content string) string {
+			return strings.Replace(content, "<Cnpj>34194865000158</Cnpj>", "<Cnpj>34.194.865/0001-58</Cnpj>", 1)
+		},
+		"truncated": func(content string) string {
+			return content[:len(content)/2]
+		},
+	}
+
+	content := readSample(t, "abrasf-v204.xml")
+	for name, breakSample := range broken {
+		t.Run(name, func(t *testing.T) {
+			outcome := validator.Validate("abrasf", "abrasf-v204.xml", breakSample(content))
+			if !outcome.Rejected() {
+				t.Fatalf("Validate status = %s; want the note rejected", outcome.Status)
+			}
+		})
+	}
+}
+
+// removeElement drops the first occurrence of a text element from a document
+func removeElement(content, name string) string {
+	start := strings.Index(content, "<"+name+">")
+	end := strings.Index(content, "</"+name+">")
+	if start < 0 || end < start {
+		return content
+	}
+	return content[:start] + content[end+len(name)+3:]
+}
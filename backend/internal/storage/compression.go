@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// MetadataCodec é a chave de metadados que registra o codec de compressão de um objeto
+const MetadataCodec = "codec"
+
+// Codecs de compressão
+const (
+	CodecNone = "none"
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+// Codec comprime e descomprime o conteúdo dos objetos
+type Codec interface {
+	Name() string
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// NewCodec retorna o codec com o nome informado; "none" ou vazio desativa a compressão e retorna nil
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "", CodecNone:
+		return nil, nil
+	case CodecGzip:
+		return gzipCodec{}, nil
+	case CodecZstd:
+		return newZstdCodec()
+	default:
+		return nil, fmt.Errorf("unknown compression codec %q", name)
+	}
+}
+
+// gzipCodec comprime com gzip (compress/gzip)
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return CodecGzip
+}
+
+func (gzipCodec) Encode(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gzipCodec) Decode(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// zstdCodec comprime com zstd; o encoder e o decoder são seguros para uso concorrente com EncodeAll/DecodeAll
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCodec() (*zstdCodec, error) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdCodec{encoder: encoder, decoder: decoder}, nil
+}
+
+func (c *zstdCodec) Name() string {
+	return CodecZstd
+}
+
+func (c *zstdCodec) Encode(data []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCodec) Decode(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
+
+// CompressedService comprime os objetos gravados em outro StorageService e os descomprime na leitura.
+// O codec fica nos metadados do objeto, então objetos gravados sem compressão ou com outro codec
+// continuam legíveis; com codec nil nada é comprimido, mas os objetos comprimidos ainda são lidos.
+type CompressedService struct {
+	StorageService
+	codec  Codec
+	codecs map[string]Codec
+	signer *URLSigner
+}
+
+// NewCompressedService cria um StorageService que comprime com o codec informado
+func NewCompressedService(inner StorageService, codec Codec) (*CompressedService, error) {
+	s := &CompressedService{
+		StorageService: inner,
+		codec:          codec,
+		codecs:         make(map[string]Codec),
+		signer:         NewURLSigner(),
+	}
+	for _, name := range []string{CodecGzip, CodecZstd} {
+		decoder, err := NewCodec(name)
+		if err != nil {
+			return nil, err
+		}
+		s.codecs[name] = decoder
+	}
+	if codec != nil {
+		s.codecs[codec.Name()] = codec
+	}
+	return s, nil
+}
+
+// Codec retorna o codec usado nas gravações, ou nil quando a compressão está desativada
+func (s *CompressedService) Codec() Codec {
+	return s.codec
+}
+
+// UploadFile comprime e grava um arquivo
+func (s *CompressedService) UploadFile(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+	return s.UploadFileWithMetadata(ctx, bucketName, objectName, data, contentType, nil)
+}
+
+// UploadFileWithMetadata comprime e grava um arquivo, registrando o codec nos metadados.
+// Dados que já trazem um codec nos metadados são gravados como estão.
+func (s *CompressedService) UploadFileWithMetadata(ctx context.Context, bucketName, objectName string, data []byte, contentType string, metadata map[string]string) error {
+	if s.codec == nil || metadata[MetadataCodec] != "" {
+		return s.StorageService.UploadFileWithMetadata(ctx, bucketName, objectName, data, contentType, metadata)
+	}
+
+	encoded, err := s.codec.Encode(data)
+	if err != nil {
+		return fmt.Errorf("failed to compress %s: %v", objectName, err)
+	}
+
+	metadata = copyMetadata(metadata)
+	metadata[MetadataCodec] = s.codec.Name()
+	return s.StorageService.UploadFileWithMetadata(ctx, bucketName, objectName, encoded, contentType, metadata)
+}
+
+// UploadStream grava o stream sem compressão: os streams são arquivos grandes, como exportações ZIP,
+// cujo conteúdo já é comprimido, e comprimi-los exigiria carregá-los em memória
+func (s *CompressedService) UploadStream(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, contentType string, metadata map[string]string) error {
+	return s.StorageService.UploadStream(ctx, bucketName, objectName, reader, size, contentType, metadata)
+}
+
+// DownloadFile lê e descomprime um arquivo
+func (s *CompressedService) DownloadFile(ctx context.Context, bucketName, objectName string) ([]byte, error) {
+	data, _, err := s.read(ctx, bucketName, objectName)
+	return data, err
+}
+
+// OpenFile abre um arquivo descomprimido. Objetos comprimidos são descomprimidos em memória para que
+// o tamanho informado seja o do conteúdo original; os demais são lidos em streaming.
+func (s *CompressedService) OpenFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, *FileInfo, error) {
+	reader, info, err := s.StorageService.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Metadata[MetadataCodec] == "" {
+		return reader, info, nil
+	}
+
+	data, err := s.decode(reader, info, objectName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+// PresignedURL gera o link de download de um arquivo. Objetos comprimidos recebem um link assinado
+// servido pela API, que os descomprime; os demais usam o link do storage.
+func (s *CompressedService) PresignedURL(ctx context.Context, bucketName, objectName string, expiry time.Duration, fileName string) (string, error) {
+	reader, info, err := s.StorageService.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		return "", err
+	}
+	reader.Close()
+
+	if info.Metadata[MetadataCodec] != "" {
+		return s.signer.Sign(bucketName, objectName, fileName, expiry)
+	}
+	return s.StorageService.PresignedURL(ctx, bucketName, objectName, expiry, fileName)
+}
+
+// CompressObject regrava um objeto existente com o codec configurado. Retorna os tamanhos armazenados
+// antes e depois; objetos que já usam o codec não são regravados e retornam before igual a after.
+func (s *CompressedService) CompressObject(ctx context.Context, bucketName, objectName string) (before, after int64, err error) {
+	if s.codec == nil {
+		return 0, 0, fmt.Errorf("compression is disabled")
+	}
+
+	reader, info, err := s.StorageService.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		return 0, 0, err
+	}
+	if info.Metadata[MetadataCodec] == s.codec.Name() {
+		reader.Close()
+		return info.Size, info.Size, nil
+	}
+
+	stored := info.Size
+	data, err := s.decode(reader, info, objectName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	encoded, err := s.codec.Encode(data)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to compress %s: %v", objectName, err)
+	}
+
+	metadata := copyMetadata(info.Metadata)
+	metadata[MetadataCodec] = s.codec.Name()
+	if err := s.StorageService.UploadFileWithMetadata(ctx, bucketName, objectName, encoded, info.ContentType, metadata); err != nil {
+		return 0, 0, err
+	}
+
+	return stored, int64(len(encoded)), nil
+}
+
+// read lê um objeto inteiro e o descomprime
+func (s *CompressedService) read(ctx context.Context, bucketName, objectName string) ([]byte, *FileInfo, error) {
+	reader, info, err := s.StorageService.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := s.decode(reader, info, objectName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, info, nil
+}
+
+// decode lê e fecha o leitor de um objeto, descomprime o conteúdo e ajusta info para o conteúdo original
+func (s *CompressedService) decode(reader io.ReadCloser, info *FileInfo, objectName string) ([]byte, error) {
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	name := info.Metadata[MetadataCodec]
+	if name == "" {
+		return data, nil
+	}
+
+	codec, ok := s.codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("object %s uses unknown codec %q", objectName, name)
+	}
+
+	decoded, err := codec.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %v", objectName, err)
+	}
+
+	info.Size = int64(len(decoded))
+	delete(info.Metadata, MetadataCodec)
+	return decoded, nil
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+)
+
+// newEncryptionTestService returns an encryption layer over an in-memory backend, and the backend
+func newEncryptionTestService(t *testing.T) (*EncryptedService, StorageService) {
+	t.Helper()
+
+	inner := NewMemoryService()
+	if err := inner.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return NewEncryptedService(inner, NewMemoryKeyStore()), inner
+}
+
+// sampleContent returns random content spanning more than one encrypted segment
+func sampleContent(t *testing.T) []byte {
+	t.Helper()
+
+	content := make([]byte, 2*encryptionSegmentSize+123)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// Each object has its own subkey, so the same content under the same data key never repeats its ciphertext
+func TestEncryptedServiceUsesPerObjectSubkeys(t *testing.T) {
+	ctx := context.Background()
+	service, inner := newEncryptionTestService(t)
+	content := sampleContent(t)
+	metadata := CompanyMetadata(7)
+
+	var stored [][]byte
+	for _, objectName := range []string{"a.xml", "b.xml"} {
+		if err := service.UploadFileWithMetadata(ctx, conformanceBucket, objectName, content, "application/xml", metadata); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := inner.DownloadFile(ctx, conformanceBucket, objectName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw[0] != encryptionVersion {
+			t.Fatalf("%s encryption version = %d; want %d", objectName, raw[0], encryptionVersion)
+		}
+		if int64(len(raw)) != encryptedLength(int64(len(content))) {
+			t.Fatalf("%s encrypted size = %d; want %d", objectName, len(raw), encryptedLength(int64(len(content))))
+		}
+		stored = append(stored, raw)
+	}
+
+	if bytes.Equal(stored[0][1:1+encryptionSaltSize], stored[1][1:1+encryptionSaltSize]) {
+		t.Fatal("objects share the subkey salt")
+	}
+	if bytes.Equal(stored[0][1+encryptionSaltSize:], stored[1][1+encryptionSaltSize:]) {
+		t.Fatal("objects share the ciphertext")
+	}
+}
+
+// The object name and data key ID are authenticated, so an object copied under another name or relabeled with
+// another key ID is not decrypted
+func TestEncryptedServiceBindsObjectNameAndKeyID(t *testing.T) {
+	ctx := context.Background()
+	service, inner := newEncryptionTestService(t)
+	content := sampleContent(t)
+
+	if err := service.UploadFileWithMetadata(ctx, conformanceBucket, "original.xml", content, "application/xml", CompanyMetadata(7)); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := inner.DownloadFile(ctx, conformanceBucket, "original.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, info, err := inner.OpenFile(ctx, conformanceBucket, "original.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+
+	if err := inner.UploadFileWithMetadata(ctx, conformanceBucket, "copy.xml", raw, "application/xml", info.Metadata); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.DownloadFile(ctx, conformanceBucket, "copy.xml"); err == nil {
+		t.Fatal("DownloadFile decrypted an object copied under another name")
+	}
+
+	// The key ID of the same company's data key, as if the metadata had been rewritten
+	otherKeyID, _, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := service.keys.(*MemoryKeyStore)
+	keys.keys[otherKeyID] = keys.keys[info.Metadata[MetadataKeyID]]
+
+	relabeled := copyMetadata(info.Metadata)
+	relabeled[MetadataKeyID] = otherKeyID
+	if err := inner.UploadFileWithMetadata(ctx, conformanceBucket, "original.xml", raw, "application/xml", relabeled); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.DownloadFile(ctx, conformanceBucket, "original.xml"); err == nil {
+		t.Fatal("DownloadFile decrypted an object relabeled with another key ID")
+	}
+}
+
+// Objects written in the previous format, with a nonce prefix and no authenticated data, stay readable and are
+// rewritten in the current format by EncryptObject
+func TestEncryptedServiceReadsPreviousFormat(t *testing.T) {
+	ctx := context.Background()
+	service, inner := newEncryptionTestService(t)
+	content := sampleContent(t)
+
+	keyID, key, err := service.keys.CompanyKey(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := CompanyMetadata(7)
+	metadata[MetadataKeyID] = keyID
+	if err := inner.UploadFileWithMetadata(ctx, conformanceBucket, "legacy.xml", encryptPreviousFormat(t, key, content), "application/xml", metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := service.DownloadFile(ctx, conformanceBucket, "legacy.xml")
+	if err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("DownloadFile returned different content")
+	}
+
+	rewritten, err := service.EncryptObject(ctx, conformanceBucket, "legacy.xml", 7)
+	if err != nil || !rewritten {
+		t.Fatalf("EncryptObject = %v, %v; want true, nil", rewritten, err)
+	}
+	raw, err := inner.DownloadFile(ctx, conformanceBucket, "legacy.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw[0] != encryptionVersion {
+		t.Fatalf("rewritten encryption version = %d; want %d", raw[0], encryptionVersion)
+	}
+
+	rewritten, err = service.EncryptObject(ctx, conformanceBucket, "legacy.xml", 7)
+	if err != nil || rewritten {
+		t.Fatalf("EncryptObject = %v, %v; want false, nil", rewritten, err)
+	}
+	if data, err := service.DownloadFile(ctx, conformanceBucket, "legacy.xml"); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("DownloadFile after EncryptObject: %v", err)
+	}
+}
+
+// encryptPreviousFormat encrypts content as the previous format did: the data key itself, a random 7-byte
+// nonce prefix in the header and no authenticated data
+func encryptPreviousFormat(t *testing.T, key, content []byte) []byte {
+	t.Helper()
+
+	aead, err := newDataKeyCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 8)
+	header[0] = encryptionVersionNoncePrefix
+	if _, err := rand.Read(header[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := append([]byte(nil), header...)
+	for counter := uint32(0); ; counter++ {
+		n := min(len(content), encryptionSegmentSize)
+		last := n == len(content)
+		encrypted = aead.Seal(encrypted, segmentNonce(header[1:], counter, last), content[:n], nil)
+		content = content[n:]
+		if last {
+			return encrypted
+		}
+	}
+}
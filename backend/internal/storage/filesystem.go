@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zoomxml/internal/logger"
+)
+
+// filesystemMeta guarda o nome original, o content type e os metadados ao lado de cada objeto
+type filesystemMeta struct {
+	ObjectName  string            `json:"object_name"`
+	ContentType string            `json:"content_type"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// FilesystemService implementa StorageService em um diretório local, para instalações sem MinIO.
+// Os objetos ficam em {root}/{bucket}/{ab}/{cd}/{sha256 do nome}, o que distribui os arquivos em
+// subdiretórios e impede que nomes com ".." saiam da raiz. As gravações são atômicas: o arquivo é
+// escrito em um temporário no mesmo diretório e renomeado.
+type FilesystemService struct {
+	root   string
+	signer *URLSigner
+}
+
+// NewFilesystemService cria uma nova instância do serviço de storage em disco
+func NewFilesystemService(root string) *FilesystemService {
+	return &FilesystemService{
+		root:   root,
+		signer: NewURLSigner(),
+	}
+}
+
+// Initialize cria o diretório raiz se necessário
+func (s *FilesystemService) Initialize() error {
+	logger.Printf("Initializing filesystem storage service...")
+	logger.Printf("Path: %s", s.root)
+
+	if err := os.MkdirAll(s.root, 0o755); err != nil {
+		return fmt.Errorf("failed to create storage directory: %v", err)
+	}
+
+	logger.Println("Filesystem storage service initialized successfully")
+	return nil
+}
+
+// UploadFile grava um arquivo, substituindo o anterior com o mesmo nome
+func (s *FilesystemService) UploadFile(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+	return s.UploadFileWithMetadata(ctx, bucketName, objectName, data, contentType, nil)
+}
+
+// UploadFileWithMetadata grava um arquivo e os seus metadados
+func (s *FilesystemService) UploadFileWithMetadata(ctx context.Context, bucketName, objectName string, data []byte, contentType string, metadata map[string]string) error {
+	return s.write(bucketName, objectName, bytes.NewReader(data), contentType, metadata)
+}
+
+// UploadStream grava um arquivo lido de um reader, copiando-o direto para o arquivo temporário
+func (s *FilesystemService) UploadStream(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, contentType string, metadata map[string]string) error {
+	return s.write(bucketName, objectName, reader, contentType, metadata)
+}
+
+// write grava um objeto e os seus metadados
+func (s *FilesystemService) write(bucketName, objectName string, reader io.Reader, contentType string, metadata map[string]string) error {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create object directory: %v", err)
+	}
+
+	meta, err := json.Marshal(filesystemMeta{
+		ObjectName:  objectName,
+		ContentType: contentType,
+		Metadata:    copyMetadata(metadata),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Os metadados vão antes dos dados: um objeto visível sempre tem metadados
+	if err := writeFileAtomic(path+".meta", bytes.NewReader(meta)); err != nil {
+		return fmt.Errorf("failed to write object metadata: %v", err)
+	}
+	if err := writeFileAtomic(path, reader); err != nil {
+		logger.Printf("Failed to write file to storage: %v", err)
+		return fmt.Errorf("failed to write object: %v", err)
+	}
+
+	return nil
+}
+
+// DownloadFile lê um arquivo
+func (s *FilesystemService) DownloadFile(ctx context.Context, bucketName, objectName string) ([]byte, error) {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	return data, nil
+}
+
+// OpenFile abre um arquivo para leitura em streaming; o chamador deve fechar o leitor
+func (s *FilesystemService) OpenFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, *FileInfo, error) {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fileError(err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	info := &FileInfo{
+		Size:        stat.Size(),
+		ContentType: defaultContentType,
+		Metadata:    map[string]string{},
+		ModifiedAt:  stat.ModTime(),
+	}
+	if data, err := os.ReadFile(path + ".meta"); err == nil {
+		var meta filesystemMeta
+		if json.Unmarshal(data, &meta) == nil {
+			if meta.ContentType != "" {
+				info.ContentType = meta.ContentType
+			}
+			info.Metadata = copyMetadata(meta.Metadata)
+		}
+	}
+
+	return file, info, nil
+}
+
+// DeleteFile remove um arquivo; remover um objeto inexistente não é erro
+func (s *FilesystemService) DeleteFile(ctx context.Context, bucketName, objectName string) error {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{path, path + ".meta"} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Failed to delete file from storage: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// FileExists verifica se um arquivo existe
+func (s *FilesystemService) FileExists(ctx context.Context, bucketName, objectName string) (bool, error) {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// PresignedURL gera um link assinado servido pela API, já que o disco não é acessível pelos clientes
+func (s *FilesystemService) PresignedURL(ctx context.Context, bucketName, objectName string, expiry time.Duration, fileName string) (string, error) {
+	exists, err := s.FileExists(ctx, bucketName, objectName)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrFileNotFound
+	}
+	return s.signer.Sign(bucketName, objectName, fileName, expiry)
+}
+
+// ListFiles percorre os objetos do bucket com o prefixo informado. Como os arquivos são nomeados pelo
+// hash do nome, o nome original vem do arquivo .meta; um .meta sem o arquivo de dados, deixado por uma
+// gravação interrompida, é ignorado.
+func (s *FilesystemService) ListFiles(ctx context.Context, bucketName, prefix string, fn func(objectName string) error) error {
+	bucketPath, err := s.bucketPath(bucketName)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(bucketPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && path == bucketPath {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, ".meta") {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var meta filesystemMeta
+		if err := json.Unmarshal(data, &meta); err != nil || !strings.HasPrefix(meta.ObjectName, prefix) {
+			return nil
+		}
+		if _, err := os.Stat(strings.TrimSuffix(path, ".meta")); err != nil {
+			return nil
+		}
+
+		return fn(meta.ObjectName)
+	})
+}
+
+// bucketPath retorna o diretório de um bucket
+func (s *FilesystemService) bucketPath(bucketName string) (string, error) {
+	if bucketName == "" || bucketName == "." || bucketName == ".." || strings.ContainsAny(bucketName, `/\`) {
+		return "", fmt.Errorf("invalid bucket name %q", bucketName)
+	}
+	return filepath.Join(s.root, bucketName), nil
+}
+
+// objectPath retorna o caminho em disco de um objeto
+func (s *FilesystemService) objectPath(bucketName, objectName string) (string, error) {
+	bucketPath, err := s.bucketPath(bucketName)
+	if err != nil {
+		return "", err
+	}
+	if objectName == "" {
+		return "", fmt.Errorf("object name is required")
+	}
+
+	sum := sha256.Sum256([]byte(objectName))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(bucketPath, name[0:2], name[2:4], name), nil
+}
+
+// writeFileAtomic grava um arquivo em um temporário do mesmo diretório e o renomeia sobre o destino
+func writeFileAtomic(path string, reader io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// fileError converte a ausência do arquivo em ErrFileNotFound
+func fileError(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrFileNotFound
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryObject é um objeto guardado pelo MemoryService
+type memoryObject struct {
+	data        []byte
+	contentType string
+	metadata    map[string]string
+	modifiedAt  time.Time
+}
+
+// MemoryService implementa StorageService em memória, para testes e desenvolvimento; nada é persistido
+type MemoryService struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	signer  *URLSigner
+}
+
+// NewMemoryService cria uma nova instância do serviço de storage em memória
+func NewMemoryService() *MemoryService {
+	return &MemoryService{
+		objects: make(map[string]memoryObject),
+		signer:  NewURLSigner(),
+	}
+}
+
+// Initialize não tem nada a preparar no storage em memória
+func (s *MemoryService) Initialize() error {
+	return nil
+}
+
+// UploadFile guarda uma cópia do arquivo, substituindo o anterior com o mesmo nome
+func (s *MemoryService) UploadFile(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+	return s.UploadFileWithMetadata(ctx, bucketName, objectName, data, contentType, nil)
+}
+
+// UploadFileWithMetadata guarda uma cópia do arquivo e dos metadados
+func (s *MemoryService) UploadFileWithMetadata(ctx context.Context, bucketName, objectName string, data []byte, contentType string, metadata map[string]string) error {
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[memoryKey(bucketName, objectName)] = memoryObject{
+		data:        bytes.Clone(data),
+		contentType: contentType,
+		metadata:    copyMetadata(metadata),
+		modifiedAt:  time.Now(),
+	}
+	return nil
+}
+
+// UploadStream guarda o conteúdo lido de reader
+func (s *MemoryService) UploadStream(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, contentType string, metadata map[string]string) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return s.UploadFileWithMetadata(ctx, bucketName, objectName, data, contentType, metadata)
+}
+
+// DownloadFile retorna uma cópia do arquivo
+func (s *MemoryService) DownloadFile(ctx context.Context, bucketName, objectName string) ([]byte, error) {
+	object, ok := s.object(bucketName, objectName)
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	return bytes.Clone(object.data), nil
+}
+
+// OpenFile abre um arquivo para leitura
+func (s *MemoryService) OpenFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, *FileInfo, error) {
+	object, ok := s.object(bucketName, objectName)
+	if !ok {
+		return nil, nil, ErrFileNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(object.data)), &FileInfo{
+		Size:        int64(len(object.data)),
+		ContentType: object.contentType,
+		Metadata:    copyMetadata(object.metadata),
+		ModifiedAt:  object.modifiedAt,
+	}, nil
+}
+
+// DeleteFile remove um arquivo; remover um objeto inexistente não é erro
+func (s *MemoryService) DeleteFile(ctx context.Context, bucketName, objectName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, memoryKey(bucketName, objectName))
+	return nil
+}
+
+// FileExists verifica se um arquivo existe
+func (s *MemoryService) FileExists(ctx context.Context, bucketName, objectName string) (bool, error) {
+	_, ok := s.object(bucketName, objectName)
+	return ok, nil
+}
+
+// ListFiles percorre os objetos do bucket com o prefixo informado, em ordem alfabética
+func (s *MemoryService) ListFiles(ctx context.Context, bucketName, prefix string, fn func(objectName string) error) error {
+	s.mu.RLock()
+	names := make([]string, 0)
+	for key := range s.objects {
+		if strings.HasPrefix(key, memoryKey(bucketName, prefix)) {
+			names = append(names, strings.TrimPrefix(key, bucketName+"/"))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		if err := fn(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PresignedURL gera um link assinado servido pela API
+func (s *MemoryService) PresignedURL(ctx context.Context, bucketName, objectName string, expiry time.Duration, fileName string) (string, error) {
+	if _, ok := s.object(bucketName, objectName); !ok {
+		return "", ErrFileNotFound
+	}
+	return s.signer.Sign(bucketName, objectName, fileName, expiry)
+}
+
+// object retorna o objeto guardado com o nome informado
+func (s *MemoryService) object(bucketName, objectName string) (memoryObject, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[memoryKey(bucketName, objectName)]
+	return object, ok
+}
+
+// memoryKey identifica um objeto pelo bucket e pelo nome
+func memoryKey(bucketName, objectName string) string {
+	return bucketName + "/" + objectName
+}
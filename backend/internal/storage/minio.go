@@ -3,7 +3,11 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -12,14 +16,8 @@ import (
 	"github.com/zoomxml/config"
 )
 
-// StorageService interface para operações de storage
-type StorageService interface {
-	Initialize() error
-	UploadFile(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error
-	DownloadFile(ctx context.Context, bucketName, objectName string) ([]byte, error)
-	DeleteFile(ctx context.Context, bucketName, objectName string) error
-	FileExists(ctx context.Context, bucketName, objectName string) (bool, error)
-}
+// Tamanho das partes dos uploads multipart de streams
+const streamPartSize = 16 << 20
 
 // MinIOService implementa StorageService usando MinIO
 type MinIOService struct {
@@ -73,12 +71,18 @@ func (s *MinIOService) Initialize() error {
 
 // UploadFile faz upload de um arquivo
 func (s *MinIOService) UploadFile(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+	return s.UploadFileWithMetadata(ctx, bucketName, objectName, data, contentType, nil)
+}
+
+// UploadFileWithMetadata faz upload de um arquivo gravando os metadados como user metadata do objeto
+func (s *MinIOService) UploadFileWithMetadata(ctx context.Context, bucketName, objectName string, data []byte, contentType string, metadata map[string]string) error {
 	logger.Printf("Uploading file: %s/%s (%d bytes)", bucketName, objectName, len(data))
 
 	// Upload do arquivo para o MinIO
 	reader := bytes.NewReader(data)
 	_, err := s.client.PutObject(ctx, bucketName, objectName, reader, int64(len(data)), minio.PutObjectOptions{
-		ContentType: contentType,
+		ContentType:  contentType,
+		UserMetadata: metadata,
 	})
 
 	if err != nil {
@@ -90,32 +94,134 @@ func (s *MinIOService) UploadFile(ctx context.Context, bucketName, objectName st
 	return nil
 }
 
+// UploadStream faz upload de um arquivo lido de um reader. Com tamanho desconhecido o upload é multipart,
+// em partes de streamPartSize, o que limita a memória usada.
+func (s *MinIOService) UploadStream(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, contentType string, metadata map[string]string) error {
+	logger.Printf("Uploading stream: %s/%s", bucketName, objectName)
+
+	_, err := s.client.PutObject(ctx, bucketName, objectName, reader, size, minio.PutObjectOptions{
+		ContentType:  contentType,
+		UserMetadata: metadata,
+		PartSize:     streamPartSize,
+	})
+	if err != nil {
+		logger.Printf("Failed to upload stream to MinIO: %v", err)
+		return err
+	}
+
+	logger.Printf("Successfully uploaded stream: %s/%s", bucketName, objectName)
+	return nil
+}
+
 // DownloadFile faz download de um arquivo
 func (s *MinIOService) DownloadFile(ctx context.Context, bucketName, objectName string) ([]byte, error) {
-	// TODO: Implementar download real
-	logger.Printf("Downloading file: %s/%s", bucketName, objectName)
-	return nil, fmt.Errorf("not implemented yet")
+	object, _, err := s.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		logger.Printf("Failed to download file from MinIO: %v", err)
+		return nil, err
+	}
+
+	return data, nil
 }
 
-// DeleteFile remove um arquivo
+// OpenFile abre um arquivo para leitura em streaming; o chamador deve fechar o leitor
+func (s *MinIOService) OpenFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, *FileInfo, error) {
+	object, err := s.client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s.objectError(err)
+	}
+
+	// GetObject só acessa o servidor na primeira leitura; Stat confirma que o objeto existe
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, nil, s.objectError(err)
+	}
+
+	return object, &FileInfo{
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		Metadata:    copyMetadata(info.UserMetadata),
+		ModifiedAt:  info.LastModified,
+	}, nil
+}
+
+// DeleteFile remove um arquivo; remover um objeto inexistente não é erro
 func (s *MinIOService) DeleteFile(ctx context.Context, bucketName, objectName string) error {
-	// TODO: Implementar remoção real
 	logger.Printf("Deleting file: %s/%s", bucketName, objectName)
+
+	err := s.client.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{})
+	if err != nil {
+		logger.Printf("Failed to delete file from MinIO: %v", err)
+		return err
+	}
+
 	return nil
 }
 
 // FileExists verifica se um arquivo existe
 func (s *MinIOService) FileExists(ctx context.Context, bucketName, objectName string) (bool, error) {
-	// TODO: Implementar verificação real
-	logger.Printf("Checking if file exists: %s/%s", bucketName, objectName)
-	return false, nil
+	_, err := s.client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		if err = s.objectError(err); errors.Is(err, ErrFileNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ListFiles percorre os objetos do bucket com o prefixo informado
+func (s *MinIOService) ListFiles(ctx context.Context, bucketName, prefix string, fn func(objectName string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for object := range s.client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list objects: %v", object.Err)
+		}
+		if err := fn(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Global storage service instance
-var Storage StorageService
+// PresignedURL gera uma URL pré-assinada do MinIO; fileName vai no Content-Disposition da resposta
+func (s *MinIOService) PresignedURL(ctx context.Context, bucketName, objectName string, expiry time.Duration, fileName string) (string, error) {
+	if err := validateExpiry(expiry); err != nil {
+		return "", err
+	}
+
+	if _, err := s.client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{}); err != nil {
+		return "", s.objectError(err)
+	}
+
+	params := url.Values{}
+	if fileName != "" {
+		params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	}
 
-// InitializeStorage inicializa o serviço de storage global
-func InitializeStorage() error {
-	Storage = NewMinIOService()
-	return Storage.Initialize()
+	presigned, err := s.client.PresignedGetObject(ctx, bucketName, objectName, expiry, params)
+	if err != nil {
+		logger.Printf("Failed to presign MinIO object: %v", err)
+		return "", err
+	}
+
+	return presigned.String(), nil
+}
+
+// objectError converte a resposta de objeto inexistente do MinIO em ErrFileNotFound
+func (s *MinIOService) objectError(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrFileNotFound
+	}
+	return err
 }
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zoomxml/config"
+)
+
+// Erros de validação dos links assinados localmente
+var (
+	ErrInvalidSignedURL = errors.New("invalid signed URL")
+	ErrSignedURLExpired = errors.New("signed URL expired")
+	// ErrSigningDisabled é retornado quando não há chave de assinatura além do JWT_SECRET padrão, que é público
+	ErrSigningDisabled = errors.New("signed URLs are disabled: set STORAGE_SIGNING_KEY or a non-default JWT_SECRET")
+)
+
+// SignedFilesPath é o caminho da API que serve os arquivos dos links assinados localmente
+const SignedFilesPath = "/api/files"
+
+// MaxPresignExpiry é a validade máxima de um link de download, o limite do S3 para URLs pré-assinadas
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+// URLSigner gera e valida links de download assinados com HMAC-SHA256, servidos pela própria API.
+// Usado pelos drivers sem URLs pré-assinadas nativas (filesystem e memória) e por objetos que precisam
+// ser decodificados antes de enviados. Sem chave configurada os links ficam desativados.
+type URLSigner struct {
+	key     []byte
+	baseURL string
+}
+
+// NewURLSigner cria o assinador de links com STORAGE_SIGNING_KEY (ou JWT_SECRET) e STORAGE_PUBLIC_URL.
+// O JWT_SECRET padrão nunca é usado: qualquer um poderia forjar links com ele.
+func NewURLSigner() *URLSigner {
+	cfg := config.Get()
+
+	key := cfg.Storage.SigningKey
+	if key == "" {
+		key = cfg.Auth.JWTSecret
+	}
+	if key == config.DefaultJWTSecret {
+		key = ""
+	}
+	baseURL := cfg.Storage.PublicURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("http://localhost:%d", cfg.Server.Port)
+	}
+
+	return &URLSigner{
+		key:     []byte(key),
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Enabled informa se há uma chave para assinar e validar links
+func (s *URLSigner) Enabled() bool {
+	return len(s.key) > 0
+}
+
+// Sign retorna um link para o objeto válido por expiry; fileName é o nome sugerido no download
+func (s *URLSigner) Sign(bucketName, objectName, fileName string, expiry time.Duration) (string, error) {
+	if !s.Enabled() {
+		return "", ErrSigningDisabled
+	}
+	if err := validateExpiry(expiry); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+
+	segments := strings.Split(objectName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	query := url.Values{}
+	query.Set("expires", expires)
+	if fileName != "" {
+		query.Set("filename", fileName)
+	}
+	query.Set("signature", s.signature(bucketName, objectName, fileName, expires))
+
+	return fmt.Sprintf("%s%s/%s/%s?%s", s.baseURL, SignedFilesPath, url.PathEscape(bucketName),
+		strings.Join(segments, "/"), query.Encode()), nil
+}
+
+// Verify confere a assinatura e a validade de um link gerado por Sign
+func (s *URLSigner) Verify(bucketName, objectName, fileName, expires, signature string) error {
+	if !s.Enabled() {
+		return ErrSigningDisabled
+	}
+	expected := s.signature(bucketName, objectName, fileName, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignedURL
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignedURL
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrSignedURLExpired
+	}
+	return nil
+}
+
+// signature assina o bucket, o objeto, o nome do download e a expiração do link
+func (s *URLSigner) signature(bucketName, objectName, fileName, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(bucketName + "\n" + objectName + "\n" + fileName + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateExpiry recusa validades fora do intervalo aceito pelas URLs pré-assinadas do S3
+func validateExpiry(expiry time.Duration) error {
+	if expiry < time.Second || expiry > MaxPresignExpiry {
+		return fmt.Errorf("presigned URL expiry must be between 1s and %s", MaxPresignExpiry)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/zoomxml/config"
+)
+
+// ErrFileNotFound é retornado quando o objeto não existe no bucket
+var ErrFileNotFound = errors.New("file not found")
+
+// Content type dos objetos enviados sem um, como no MinIO
+const defaultContentType = "application/octet-stream"
+
+// FileInfo descreve um objeto armazenado
+type FileInfo struct {
+	Size        int64
+	ContentType string
+	Metadata    map[string]string // Metadados gravados com o objeto, com chaves em minúsculas
+	ModifiedAt  time.Time         // Última gravação do objeto
+}
+
+// StorageService interface para operações de storage
+type StorageService interface {
+	Initialize() error
+	UploadFile(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error
+	UploadFileWithMetadata(ctx context.Context, bucketName, objectName string, data []byte, contentType string, metadata map[string]string) error
+	// UploadStream grava o conteúdo lido de reader sem carregá-lo inteiro em memória; size é -1 quando desconhecido
+	UploadStream(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, contentType string, metadata map[string]string) error
+	DownloadFile(ctx context.Context, bucketName, objectName string) ([]byte, error)
+	OpenFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, *FileInfo, error)
+	DeleteFile(ctx context.Context, bucketName, objectName string) error
+	FileExists(ctx context.Context, bucketName, objectName string) (bool, error)
+	// ListFiles chama fn com o nome de cada objeto do bucket que começa com prefix, sem ordem definida.
+	// Um erro retornado por fn interrompe a listagem e é retornado.
+	ListFiles(ctx context.Context, bucketName, prefix string, fn func(objectName string) error) error
+	// PresignedURL retorna um link de download do objeto que dispensa autenticação e expira após expiry
+	// (no máximo MaxPresignExpiry); fileName é o nome sugerido no download. Retorna ErrFileNotFound
+	// quando o objeto não existe.
+	PresignedURL(ctx context.Context, bucketName, objectName string, expiry time.Duration, fileName string) (string, error)
+}
+
+// Global storage service instance
+var Storage StorageService
+
+// NewStorageService cria o serviço de storage do driver configurado em STORAGE_DRIVER
+func NewStorageService() (StorageService, error) {
+	cfg := config.Get()
+	switch cfg.Storage.Driver {
+	case config.StorageDriverMinIO:
+		return NewMinIOService(), nil
+	case config.StorageDriverFilesystem:
+		return NewFilesystemService(cfg.Storage.Path), nil
+	case config.StorageDriverMemory:
+		return NewMemoryService(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+	}
+}
+
+// InitializeStorage inicializa o serviço de storage global. O serviço sempre passa pela camada de
+// compressão, para que objetos já comprimidos continuem legíveis com STORAGE_COMPRESSION=none. Com keys
+// os objetos também são criptografados, depois de comprimidos, com as chaves de dados das empresas.
+func InitializeStorage(keys KeyStore) error {
+	service, err := NewStorageService()
+	if err != nil {
+		return err
+	}
+	if err := service.Initialize(); err != nil {
+		return err
+	}
+	if keys != nil {
+		service = NewEncryptedService(service, keys)
+	}
+
+	codec, err := NewCodec(config.Get().Storage.Compression)
+	if err != nil {
+		return err
+	}
+	compressed, err := NewCompressedService(service, codec)
+	if err != nil {
+		return err
+	}
+
+	Storage = compressed
+	return nil
+}
+
+// copyMetadata copia os metadados de um objeto, normalizando as chaves para minúsculas
+func copyMetadata(metadata map[string]string) map[string]string {
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[strings.ToLower(key)] = value
+	}
+	return copied
+}
@@ -0,0 +1,432 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zoomxml/config"
+)
+
+// conformanceBucket is the bucket used by the conformance checks
+const conformanceBucket = "nfse-storage"
+
+// conformanceCheck is one expectation of the StorageService contract
+type conformanceCheck struct {
+	name string
+	run  func(ctx context.Context, service StorageService, bucketName, prefix string) error
+}
+
+// conformanceChecks lists the semantics every StorageService backend must share
+var conformanceChecks = []conformanceCheck{
+	{"missing object", checkMissingObject},
+	{"round trip", checkRoundTrip},
+	{"overwrite", checkOverwrite},
+	{"empty object", checkEmptyObject},
+	{"binary content", checkBinaryContent},
+	{"isolated buffers", checkIsolatedBuffers},
+	{"metadata", checkMetadata},
+	{"stream", checkStream},
+	{"list", checkList},
+	{"presign", checkPresign},
+	{"delete", checkDelete},
+}
+
+// TestConformance runs the StorageService contract against the memory and filesystem backends, wrapped by the
+// compression layer with each codec, with and without the encryption layer. MinIO is checked too when
+// STORAGE_TEST_MINIO is set, with the MINIO_* settings:
+//
+//	STORAGE_TEST_MINIO=1 MINIO_ENDPOINT=localhost:9000 go test ./internal/storage
+func TestConformance(t *testing.T) {
+	// The checks sign download links
+	t.Setenv("STORAGE_SIGNING_KEY", "conformance")
+	config.Load()
+
+	drivers := []string{config.StorageDriverMemory, config.StorageDriverFilesystem}
+	if os.Getenv("STORAGE_TEST_MINIO") != "" {
+		drivers = append(drivers, config.StorageDriverMinIO)
+	}
+
+	for _, driver := range drivers {
+		for _, codecName := range []string{CodecNone, CodecGzip, CodecZstd} {
+			for _, encrypted := range []bool{false, true} {
+				name := driver + "/" + codecName
+				if encrypted {
+					name += "/encrypted"
+				}
+
+				t.Run(name, func(t *testing.T) {
+					service := newConformanceService(t, driver, codecName, encrypted)
+
+					// Objects go under a unique prefix, so a shared MinIO bucket is left as it was
+					prefix := fmt.Sprintf("conformance/%d", time.Now().UnixNano())
+					for _, check := range conformanceChecks {
+						t.Run(check.name, func(t *testing.T) {
+							if err := check.run(context.Background(), service, conformanceBucket, prefix+"/"+check.name); err != nil {
+								t.Error(err)
+							}
+						})
+					}
+				})
+			}
+		}
+	}
+}
+
+// newConformanceService initializes a backend, wrapped with the compression codec and, when encrypted, with the
+// encryption layer using in-memory data keys
+func newConformanceService(t *testing.T, driver, codecName string, encrypted bool) StorageService {
+	t.Helper()
+
+	var service StorageService
+	switch driver {
+	case config.StorageDriverMinIO:
+		service = NewMinIOService()
+	case config.StorageDriverFilesystem:
+		service = NewFilesystemService(t.TempDir())
+	case config.StorageDriverMemory:
+		service = NewMemoryService()
+	default:
+		t.Fatalf("unknown storage driver %q", driver)
+	}
+
+	if err := service.Initialize(); err != nil {
+		t.Fatalf("initialize %s: %v", driver, err)
+	}
+
+	if encrypted {
+		service = NewEncryptedService(service, NewMemoryKeyStore())
+	}
+
+	codec, err := NewCodec(codecName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := NewCompressedService(service, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compressed
+}
+
+func checkMissingObject(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/missing.xml"
+
+	exists, err := service.FileExists(ctx, bucketName, objectName)
+	if err != nil || exists {
+		return fmt.Errorf("FileExists = %v, %v; want false, nil", exists, err)
+	}
+	if _, err := service.DownloadFile(ctx, bucketName, objectName); !errors.Is(err, ErrFileNotFound) {
+		return fmt.Errorf("DownloadFile error = %v; want ErrFileNotFound", err)
+	}
+	if reader, _, err := service.OpenFile(ctx, bucketName, objectName); !errors.Is(err, ErrFileNotFound) {
+		if reader != nil {
+			reader.Close()
+		}
+		return fmt.Errorf("OpenFile error = %v; want ErrFileNotFound", err)
+	}
+	if err := service.DeleteFile(ctx, bucketName, objectName); err != nil {
+		return fmt.Errorf("DeleteFile error = %v; want nil", err)
+	}
+	return nil
+}
+
+func checkRoundTrip(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/nfse/2025/012025/34194865000158/nota.xml"
+	content := []byte(`<?xml version="1.0" encoding="UTF-8"?><CompNfse><Numero>1</Numero></CompNfse>`)
+	defer service.DeleteFile(ctx, bucketName, objectName)
+
+	if err := service.UploadFile(ctx, bucketName, objectName, content, "application/xml"); err != nil {
+		return fmt.Errorf("UploadFile: %v", err)
+	}
+
+	exists, err := service.FileExists(ctx, bucketName, objectName)
+	if err != nil || !exists {
+		return fmt.Errorf("FileExists = %v, %v; want true, nil", exists, err)
+	}
+
+	return expectObject(ctx, service, bucketName, objectName, content, "application/xml")
+}
+
+func checkOverwrite(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/nota.xml"
+	defer service.DeleteFile(ctx, bucketName, objectName)
+
+	if err := service.UploadFile(ctx, bucketName, objectName, []byte("<v1/>"), "application/xml"); err != nil {
+		return fmt.Errorf("UploadFile: %v", err)
+	}
+	content := []byte("<v2>longer than the first version</v2>")
+	if err := service.UploadFile(ctx, bucketName, objectName, content, "text/xml"); err != nil {
+		return fmt.Errorf("UploadFile over existing object: %v", err)
+	}
+
+	return expectObject(ctx, service, bucketName, objectName, content, "text/xml")
+}
+
+func checkEmptyObject(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/empty"
+	defer service.DeleteFile(ctx, bucketName, objectName)
+
+	if err := service.UploadFile(ctx, bucketName, objectName, []byte{}, ""); err != nil {
+		return fmt.Errorf("UploadFile: %v", err)
+	}
+
+	return expectObject(ctx, service, bucketName, objectName, []byte{}, defaultContentType)
+}
+
+func checkBinaryContent(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/binary.zip"
+	content := make([]byte, 64*1024)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	defer service.DeleteFile(ctx, bucketName, objectName)
+
+	if err := service.UploadFile(ctx, bucketName, objectName, content, "application/zip"); err != nil {
+		return fmt.Errorf("UploadFile: %v", err)
+	}
+
+	return expectObject(ctx, service, bucketName, objectName, content, "application/zip")
+}
+
+func checkIsolatedBuffers(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/nota.xml"
+	content := []byte("<nota/>")
+	upload := bytes.Clone(content)
+	defer service.DeleteFile(ctx, bucketName, objectName)
+
+	if err := service.UploadFile(ctx, bucketName, objectName, upload, "application/xml"); err != nil {
+		return fmt.Errorf("UploadFile: %v", err)
+	}
+	upload[0] = 'X'
+
+	downloaded, err := service.DownloadFile(ctx, bucketName, objectName)
+	if err != nil {
+		return fmt.Errorf("DownloadFile: %v", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		return fmt.Errorf("changing the uploaded buffer changed the object")
+	}
+	downloaded[0] = 'X'
+
+	return expectObject(ctx, service, bucketName, objectName, content, "application/xml")
+}
+
+func checkMetadata(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/nota.xml"
+	content := []byte("<nota/>")
+	defer service.DeleteFile(ctx, bucketName, objectName)
+
+	metadata := map[string]string{"Source": "abrasf", "key-id": "2025-01"}
+	if err := service.UploadFileWithMetadata(ctx, bucketName, objectName, content, "application/xml", metadata); err != nil {
+		return fmt.Errorf("UploadFileWithMetadata: %v", err)
+	}
+	if err := expectObject(ctx, service, bucketName, objectName, content, "application/xml"); err != nil {
+		return err
+	}
+	if err := expectMetadata(ctx, service, bucketName, objectName, map[string]string{"source": "abrasf", "key-id": "2025-01"}); err != nil {
+		return err
+	}
+
+	// Overwriting without metadata drops the previous metadata
+	if err := service.UploadFile(ctx, bucketName, objectName, content, "application/xml"); err != nil {
+		return fmt.Errorf("UploadFile: %v", err)
+	}
+	return expectMetadata(ctx, service, bucketName, objectName, map[string]string{})
+}
+
+func checkStream(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/export.zip"
+	content := bytes.Repeat([]byte("PK\x03\x04 conformance "), 64<<10)
+	defer service.DeleteFile(ctx, bucketName, objectName)
+
+	// Unknown size, read in small chunks
+	reader := io.MultiReader(bytes.NewReader(content[:1000]), bytes.NewReader(content[1000:]))
+	if err := service.UploadStream(ctx, bucketName, objectName, reader, -1, "application/zip", map[string]string{"Source": "export"}); err != nil {
+		return fmt.Errorf("UploadStream: %v", err)
+	}
+	if err := expectObject(ctx, service, bucketName, objectName, content, "application/zip"); err != nil {
+		return err
+	}
+	if err := expectMetadata(ctx, service, bucketName, objectName, map[string]string{"source": "export"}); err != nil {
+		return err
+	}
+
+	// Known size, replacing the object
+	replaced := content[:4096]
+	if err := service.UploadStream(ctx, bucketName, objectName, bytes.NewReader(replaced), int64(len(replaced)), "application/zip", nil); err != nil {
+		return fmt.Errorf("UploadStream with size: %v", err)
+	}
+	return expectObject(ctx, service, bucketName, objectName, replaced, "application/zip")
+}
+
+func checkPresign(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/nfse/2025/012025/34194865000158/nota 1.xml"
+	defer service.DeleteFile(ctx, bucketName, objectName)
+
+	if _, err := service.PresignedURL(ctx, bucketName, objectName, time.Hour, "nota.xml"); !errors.Is(err, ErrFileNotFound) {
+		return fmt.Errorf("PresignedURL of missing object error = %v; want ErrFileNotFound", err)
+	}
+
+	if err := service.UploadFile(ctx, bucketName, objectName, []byte("<nota/>"), "application/xml"); err != nil {
+		return fmt.Errorf("UploadFile: %v", err)
+	}
+
+	link, err := service.PresignedURL(ctx, bucketName, objectName, time.Hour, "nota.xml")
+	if err != nil {
+		return fmt.Errorf("PresignedURL: %v", err)
+	}
+	parsed, err := url.Parse(link)
+	if err != nil || !parsed.IsAbs() {
+		return fmt.Errorf("PresignedURL = %q; want an absolute URL", link)
+	}
+
+	for _, expiry := range []time.Duration{0, MaxPresignExpiry + time.Hour} {
+		if _, err := service.PresignedURL(ctx, bucketName, objectName, expiry, "nota.xml"); err == nil {
+			return fmt.Errorf("PresignedURL with expiry %s succeeded; want an error", expiry)
+		}
+	}
+	return nil
+}
+
+func checkList(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	listed := []string{prefix + "/list/a.xml", prefix + "/list/2025/01/b.xml"}
+	outside := prefix + "/listing.xml"
+	for _, name := range append(listed, outside) {
+		defer service.DeleteFile(ctx, bucketName, name)
+		if err := service.UploadFile(ctx, bucketName, name, []byte("<nota/>"), "application/xml"); err != nil {
+			return fmt.Errorf("UploadFile: %v", err)
+		}
+	}
+
+	found := make(map[string]bool)
+	err := service.ListFiles(ctx, bucketName, prefix+"/list/", func(objectName string) error {
+		found[objectName] = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ListFiles: %v", err)
+	}
+	if len(found) != len(listed) || !found[listed[0]] || !found[listed[1]] {
+		return fmt.Errorf("ListFiles = %v; want %v", found, listed)
+	}
+
+	// An error of the callback stops the listing and is returned
+	stop := errors.New("stop")
+	calls := 0
+	err = service.ListFiles(ctx, bucketName, prefix+"/list/", func(objectName string) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		return fmt.Errorf("ListFiles with failing callback = %v after %d calls; want stop after 1", err, calls)
+	}
+
+	// Deleted objects are no longer listed
+	if err := service.DeleteFile(ctx, bucketName, listed[0]); err != nil {
+		return fmt.Errorf("DeleteFile: %v", err)
+	}
+	delete(found, listed[0])
+	err = service.ListFiles(ctx, bucketName, prefix+"/list/", func(objectName string) error {
+		if !found[objectName] {
+			return fmt.Errorf("unexpected object %s", objectName)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ListFiles after delete: %v", err)
+	}
+	return nil
+}
+
+func checkDelete(ctx context.Context, service StorageService, bucketName, prefix string) error {
+	objectName := prefix + "/nota.xml"
+	sibling := prefix + "/other.xml"
+	defer service.DeleteFile(ctx, bucketName, sibling)
+
+	for _, name := range []string{objectName, sibling} {
+		if err := service.UploadFile(ctx, bucketName, name, []byte("<nota/>"), "application/xml"); err != nil {
+			return fmt.Errorf("UploadFile: %v", err)
+		}
+	}
+
+	if err := service.DeleteFile(ctx, bucketName, objectName); err != nil {
+		return fmt.Errorf("DeleteFile: %v", err)
+	}
+	exists, err := service.FileExists(ctx, bucketName, objectName)
+	if err != nil || exists {
+		return fmt.Errorf("FileExists after delete = %v, %v; want false, nil", exists, err)
+	}
+	if _, err := service.DownloadFile(ctx, bucketName, objectName); !errors.Is(err, ErrFileNotFound) {
+		return fmt.Errorf("DownloadFile after delete error = %v; want ErrFileNotFound", err)
+	}
+	if err := service.DeleteFile(ctx, bucketName, objectName); err != nil {
+		return fmt.Errorf("DeleteFile of deleted object: %v", err)
+	}
+
+	exists, err = service.FileExists(ctx, bucketName, sibling)
+	if err != nil || !exists {
+		return fmt.Errorf("FileExists of sibling = %v, %v; want true, nil", exists, err)
+	}
+	return nil
+}
+
+// expectObject checks the content and content type of an object through DownloadFile and OpenFile
+func expectObject(ctx context.Context, service StorageService, bucketName, objectName string, content []byte, contentType string) error {
+	downloaded, err := service.DownloadFile(ctx, bucketName, objectName)
+	if err != nil {
+		return fmt.Errorf("DownloadFile: %v", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		return fmt.Errorf("DownloadFile returned %d bytes; want the %d uploaded", len(downloaded), len(content))
+	}
+
+	reader, info, err := service.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		return fmt.Errorf("OpenFile: %v", err)
+	}
+	defer reader.Close()
+
+	streamed, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("reading OpenFile: %v", err)
+	}
+	if !bytes.Equal(streamed, content) {
+		return fmt.Errorf("OpenFile streamed %d bytes; want the %d uploaded", len(streamed), len(content))
+	}
+	if info.Size != int64(len(content)) {
+		return fmt.Errorf("OpenFile size = %d; want %d", info.Size, len(content))
+	}
+	if info.ContentType != contentType {
+		return fmt.Errorf("OpenFile content type = %q; want %q", info.ContentType, contentType)
+	}
+	if age := time.Since(info.ModifiedAt); age < -time.Minute || age > time.Hour {
+		return fmt.Errorf("OpenFile modified at = %v; want the upload time", info.ModifiedAt)
+	}
+	return nil
+}
+
+// expectMetadata checks the metadata returned by OpenFile
+func expectMetadata(ctx context.Context, service StorageService, bucketName, objectName string, metadata map[string]string) error {
+	reader, info, err := service.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		return fmt.Errorf("OpenFile: %v", err)
+	}
+	reader.Close()
+
+	if len(info.Metadata) != len(metadata) {
+		return fmt.Errorf("OpenFile metadata = %v; want %v", info.Metadata, metadata)
+	}
+	for key, value := range metadata {
+		if info.Metadata[key] != value {
+			return fmt.Errorf("OpenFile metadata = %v; want %v", info.Metadata, metadata)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package xmldsig
+
+import (
+	"crypto/x509"
+	"embed"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//go:embed truststore
+var bundledTrustStore embed.FS
+
+// oidSubjectAltName identifies the subject alternative name extension
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// oidICPBrasilCNPJ is the ICP-Brasil otherName carrying the CNPJ of a legal entity certificate
+var oidICPBrasilCNPJ = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 3}
+
+// commonNameCNPJ matches the "NAME:CNPJ" convention of ICP-Brasil certificate subjects
+var commonNameCNPJ = regexp.MustCompile(`:(\d{14})$`)
+
+// LoadTrustStore builds a pool from the bundled certificates plus those found in extraDir, if set.
+// It returns the pool and the number of certificates loaded.
+func LoadTrustStore(extraDir string) (*x509.CertPool, int, error) {
+	pool := x509.NewCertPool()
+	count := 0
+
+	entries, err := bundledTrustStore.ReadDir("truststore")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read bundled trust store: %v", err)
+	}
+	for _, entry := range entries {
+		if !isCertificateFile(entry.Name()) {
+			continue
+		}
+		data, err := bundledTrustStore.ReadFile("truststore/" + entry.Name())
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read %s: %v", entry.Name(), err)
+		}
+		added, err := addCertificates(pool, data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid certificate %s: %v", entry.Name(), err)
+		}
+		count += added
+	}
+
+	if extraDir == "" {
+		return pool, count, nil
+	}
+
+	files, err := os.ReadDir(extraDir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read trust store directory: %v", err)
+	}
+	for _, file := range files {
+		if file.IsDir() || !isCertificateFile(file.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(extraDir, file.Name()))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read %s: %v", file.Name(), err)
+		}
+		added, err := addCertificates(pool, data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid certificate %s: %v", file.Name(), err)
+		}
+		count += added
+	}
+
+	return pool, count, nil
+}
+
+// isCertificateFile reports whether a file name has a certificate extension
+func isCertificateFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pem", ".crt", ".cer":
+		return true
+	}
+	return false
+}
+
+// addCertificates adds PEM or DER encoded certificates to a pool
+func addCertificates(pool *x509.CertPool, data []byte) (int, error) {
+	if !strings.Contains(string(data), "-----BEGIN") {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return 0, err
+		}
+		pool.AddCert(cert)
+		return 1, nil
+	}
+
+	count := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return count, err
+		}
+		pool.AddCert(cert)
+		count++
+	}
+	return count, nil
+}
+
+// SignerCNPJ extracts the CNPJ of an ICP-Brasil e-CNPJ certificate, from the subject alternative
+// name otherName 2.16.76.1.3.3 or, failing that, from the common name suffix
+func SignerCNPJ(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			break
+		}
+		for _, name := range names {
+			// otherName is [0] IMPLICIT SEQUENCE { type-id OID, value [0] EXPLICIT ANY }
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+			var typeID asn1.ObjectIdentifier
+			rest, err := asn1.Unmarshal(name.Bytes, &typeID)
+			if err != nil || !typeID.Equal(oidICPBrasilCNPJ) {
+				continue
+			}
+			var wrapper asn1.RawValue
+			if _, err := asn1.Unmarshal(rest, &wrapper); err != nil {
+				continue
+			}
+			var value asn1.RawValue
+			if _, err := asn1.Unmarshal(wrapper.Bytes, &value); err != nil {
+				continue
+			}
+			if cnpj := digitsOnly(string(value.Bytes)); len(cnpj) == 14 {
+				return cnpj
+			}
+		}
+	}
+
+	if match := commonNameCNPJ.FindStringSubmatch(cert.Subject.CommonName); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// digitsOnly strips every non-digit character
+func digitsOnly(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,476 @@
+// Package xmldsig verifies the enveloped XMLDSig signatures carried by fiscal XML documents.
+//
+// NF-e, NFS-e and CT-e sign an element identified by an Id attribute with a Signature that is
+// usually its sibling, using certificates issued under the ICP-Brasil hierarchy. Verification
+// canonicalizes the referenced element, checks its digest and the SignedInfo signature, and
+// validates the signer certificate chain against a trust store at the time the document was issued.
+package xmldsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+	"golang.org/x/net/html/charset"
+)
+
+// Namespace is the XMLDSig namespace
+const Namespace = "http://www.w3.org/2000/09/xmldsig#"
+
+// Authenticity statuses
+const (
+	StatusValid      = "valid"
+	StatusInvalid    = "invalid"
+	StatusUnsigned   = "unsigned"
+	StatusUnverified = "unverified" // Intact signature whose chain could not be checked: no trusted root is loaded
+)
+
+// ErrNoTrustAnchors is returned when a certificate chain is checked without trusted root certificates
+var ErrNoTrustAnchors = errors.New("no trusted root certificates configured")
+
+// Result is the outcome of verifying the signatures of a document
+type Result struct {
+	Status            string
+	SignerCNPJ        string
+	CertificateSerial string
+	Err               error
+}
+
+// Verifier checks document signatures against a pool of trusted root certificates
+type Verifier struct {
+	roots *x509.CertPool
+}
+
+// NewVerifier creates a verifier trusting the given root certificates
+func NewVerifier(roots *x509.CertPool) *Verifier {
+	return &Verifier{roots: roots}
+}
+
+// HasTrustAnchors reports whether the verifier has any trusted root certificate to check chains against
+func (v *Verifier) HasTrustAnchors() bool {
+	return v.roots != nil && !v.roots.Equal(x509.NewCertPool())
+}
+
+// Verify checks every signature in a document. The signature closest to the root identifies the signer;
+// the document is only valid if all of its signatures are. Without trusted roots, intact signatures are
+// unverified rather than invalid. Certificate chains are checked at signedAt,
+// or at the current time when it is zero, so archived documents remain verifiable after expiry.
+func (v *Verifier) Verify(content []byte, signedAt time.Time) *Result {
+	doc := etree.NewDocument()
+	doc.ReadSettings.CharsetReader = charset.NewReaderLabel
+	if err := doc.ReadFromBytes(content); err != nil {
+		return &Result{Status: StatusInvalid, Err: fmt.Errorf("failed to read XML: %v", err)}
+	}
+
+	root := doc.Root()
+	if root == nil {
+		return &Result{Status: StatusInvalid, Err: errors.New("document has no root element")}
+	}
+
+	signatures := findSignatures(root)
+	if len(signatures) == 0 {
+		return &Result{Status: StatusUnsigned}
+	}
+
+	if signedAt.IsZero() {
+		signedAt = time.Now()
+	}
+
+	result := &Result{Status: StatusValid}
+	for i, signature := range signatures {
+		cert, err := v.verifySignature(root, signature, signedAt)
+
+		// The first signature found is the shallowest one, which signs the document itself
+		if i == 0 && cert != nil {
+			result.SignerCNPJ = SignerCNPJ(cert)
+			result.CertificateSerial = strings.ToUpper(cert.SerialNumber.Text(16))
+		}
+
+		switch {
+		case err == nil || result.Status == StatusInvalid:
+		case errors.Is(err, ErrNoTrustAnchors):
+			if result.Status == StatusValid {
+				result.Status = StatusUnverified
+				result.Err = err
+			}
+		default:
+			result.Status = StatusInvalid
+			result.Err = err
+		}
+	}
+
+	return result
+}
+
+// findSignatures returns the Signature elements of a document ordered by depth
+func findSignatures(root *etree.Element) []*etree.Element {
+	var signatures []*etree.Element
+	level := []*etree.Element{root}
+
+	for len(level) > 0 {
+		var next []*etree.Element
+		for _, el := range level {
+			if el.Tag == "Signature" && el.NamespaceURI() == Namespace {
+				signatures = append(signatures, el)
+				continue
+			}
+			next = append(next, el.ChildElements()...)
+		}
+		level = next
+	}
+
+	return signatures
+}
+
+// verifySignature verifies one signature and returns the signer certificate when it could be read
+func (v *Verifier) verifySignature(root, signature *etree.Element, signedAt time.Time) (*x509.Certificate, error) {
+	signedInfo := childElement(signature, "SignedInfo")
+	if signedInfo == nil {
+		return nil, errors.New("signature without SignedInfo")
+	}
+
+	chain, err := keyInfoCertificates(signature)
+	if err != nil {
+		return nil, err
+	}
+	cert := chain[0]
+
+	reference := childElement(signedInfo, "Reference")
+	if reference == nil {
+		return cert, errors.New("signature without Reference")
+	}
+
+	if err := verifyReference(root, signature, reference); err != nil {
+		return cert, err
+	}
+
+	if err := verifySignedInfo(signature, signedInfo, cert); err != nil {
+		return cert, err
+	}
+
+	if err := v.verifyChain(chain, signedAt); err != nil {
+		return cert, err
+	}
+
+	return cert, nil
+}
+
+// verifyReference recomputes the digest of the referenced element
+func verifyReference(root, signature, reference *etree.Element) error {
+	uri := reference.SelectAttrValue("URI", "")
+	target := root
+	if uri != "" {
+		target = findByID(root, strings.TrimPrefix(uri, "#"))
+		if target == nil {
+			return fmt.Errorf("referenced element %s not found", uri)
+		}
+	}
+
+	canonicalizer := dsig.Canonicalizer(dsig.MakeC14N10RecCanonicalizer())
+	enveloped := false
+	if transforms := childElement(reference, "Transforms"); transforms != nil {
+		for _, transform := range childElements(transforms, "Transform") {
+			algorithm := transform.SelectAttrValue("Algorithm", "")
+			if dsig.AlgorithmID(algorithm) == dsig.EnvelopedSignatureAltorithmId {
+				enveloped = true
+				continue
+			}
+			c, err := canonicalizerFor(algorithm, transform)
+			if err != nil {
+				return err
+			}
+			canonicalizer = c
+		}
+	}
+
+	signaturePath := pathTo(target, signature)
+
+	detached, err := detach(target)
+	if err != nil {
+		return err
+	}
+	if enveloped && signaturePath != nil {
+		removeAtPath(detached, signaturePath)
+	}
+
+	canonical, err := canonicalizer.Canonicalize(detached)
+	if err != nil {
+		return fmt.Errorf("failed to canonicalize referenced element: %v", err)
+	}
+
+	digestMethod := childElement(reference, "DigestMethod")
+	if digestMethod == nil {
+		return errors.New("reference without DigestMethod")
+	}
+	hash, err := hashFor(digestMethod.SelectAttrValue("Algorithm", ""))
+	if err != nil {
+		return err
+	}
+
+	digestValue := childElement(reference, "DigestValue")
+	if digestValue == nil {
+		return errors.New("reference without DigestValue")
+	}
+	expected, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(digestValue.Text()), ""))
+	if err != nil {
+		return errors.New("invalid DigestValue encoding")
+	}
+
+	h := hash.New()
+	h.Write(canonical)
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return errors.New("digest mismatch: document was modified after signing")
+	}
+
+	return nil
+}
+
+// verifySignedInfo checks the SignatureValue over the canonicalized SignedInfo
+func verifySignedInfo(signature, signedInfo *etree.Element, cert *x509.Certificate) error {
+	c14nMethod := childElement(signedInfo, "CanonicalizationMethod")
+	if c14nMethod == nil {
+		return errors.New("SignedInfo without CanonicalizationMethod")
+	}
+	canonicalizer, err := canonicalizerFor(c14nMethod.SelectAttrValue("Algorithm", ""), c14nMethod)
+	if err != nil {
+		return err
+	}
+
+	detached, err := detach(signedInfo)
+	if err != nil {
+		return err
+	}
+	canonical, err := canonicalizer.Canonicalize(detached)
+	if err != nil {
+		return fmt.Errorf("failed to canonicalize SignedInfo: %v", err)
+	}
+
+	signatureMethod := childElement(signedInfo, "SignatureMethod")
+	if signatureMethod == nil {
+		return errors.New("SignedInfo without SignatureMethod")
+	}
+	hash, err := hashFor(signatureMethod.SelectAttrValue("Algorithm", ""))
+	if err != nil {
+		return err
+	}
+
+	signatureValue := childElement(signature, "SignatureValue")
+	if signatureValue == nil {
+		return errors.New("signature without SignatureValue")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(signatureValue.Text()), ""))
+	if err != nil {
+		return errors.New("invalid SignatureValue encoding")
+	}
+
+	h := hash.New()
+	h.Write(canonical)
+	hashed := h.Sum(nil)
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, hash, hashed, decoded)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed, decoded) {
+			err = errors.New("ecdsa verification failed")
+		}
+	default:
+		err = fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+	}
+	if err != nil {
+		return fmt.Errorf("signature value does not match: %v", err)
+	}
+
+	return nil
+}
+
+// verifyChain validates the signer certificate up to a trusted root
+func (v *Verifier) verifyChain(chain []*x509.Certificate, signedAt time.Time) error {
+	if !v.HasTrustAnchors() {
+		return ErrNoTrustAnchors
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("certificate chain not trusted: %v", err)
+	}
+	return nil
+}
+
+// keyInfoCertificates reads the certificates from KeyInfo/X509Data, signer first
+func keyInfoCertificates(signature *etree.Element) ([]*x509.Certificate, error) {
+	keyInfo := childElement(signature, "KeyInfo")
+	if keyInfo == nil {
+		return nil, errors.New("signature without KeyInfo")
+	}
+
+	var chain []*x509.Certificate
+	for _, data := range childElements(keyInfo, "X509Data") {
+		for _, el := range childElements(data, "X509Certificate") {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(el.Text()), ""))
+			if err != nil {
+				return nil, errors.New("invalid X509Certificate encoding")
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("invalid X509Certificate: %v", err)
+			}
+			chain = append(chain, cert)
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("signature without X509Certificate")
+	}
+	return chain, nil
+}
+
+// canonicalizerFor returns the canonicalizer for a C14N algorithm identifier
+func canonicalizerFor(algorithm string, el *etree.Element) (dsig.Canonicalizer, error) {
+	prefixList := ""
+	if inclusive := childElementNS(el, "InclusiveNamespaces"); inclusive != nil {
+		prefixList = inclusive.SelectAttrValue("PrefixList", "")
+	}
+
+	switch dsig.AlgorithmID(algorithm) {
+	case dsig.CanonicalXML10RecAlgorithmId:
+		return dsig.MakeC14N10RecCanonicalizer(), nil
+	case dsig.CanonicalXML10WithCommentsAlgorithmId:
+		return dsig.MakeC14N10WithCommentsCanonicalizer(), nil
+	case dsig.CanonicalXML11AlgorithmId:
+		return dsig.MakeC14N11Canonicalizer(), nil
+	case dsig.CanonicalXML11WithCommentsAlgorithmId:
+		return dsig.MakeC14N11WithCommentsCanonicalizer(), nil
+	case dsig.CanonicalXML10ExclusiveAlgorithmId:
+		return dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList(prefixList), nil
+	case dsig.CanonicalXML10ExclusiveWithCommentsAlgorithmId:
+		return dsig.MakeC14N10ExclusiveWithCommentsCanonicalizerWithPrefixList(prefixList), nil
+	default:
+		return nil, fmt.Errorf("unsupported canonicalization algorithm %q", algorithm)
+	}
+}
+
+// hashFor maps digest and signature algorithm identifiers to hash functions
+func hashFor(algorithm string) (crypto.Hash, error) {
+	switch {
+	case strings.HasSuffix(algorithm, "sha1"):
+		return crypto.SHA1, nil
+	case strings.HasSuffix(algorithm, "sha256"):
+		return crypto.SHA256, nil
+	case strings.HasSuffix(algorithm, "sha384"):
+		return crypto.SHA384, nil
+	case strings.HasSuffix(algorithm, "sha512"):
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+}
+
+// detach copies an element, declaring every namespace in scope so it can be canonicalized alone
+func detach(el *etree.Element) (*etree.Element, error) {
+	ctx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+	return etreeutils.NSDetatch(ctx, el)
+}
+
+// findByID finds the element whose Id attribute matches
+func findByID(root *etree.Element, id string) *etree.Element {
+	for _, name := range []string{"Id", "ID", "id"} {
+		if attr := root.SelectAttr(name); attr != nil && attr.Space == "" && attr.Value == id {
+			return root
+		}
+	}
+	for _, child := range root.ChildElements() {
+		if found := findByID(child, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// pathTo returns the child indexes leading from ancestor to el, or nil when el is outside ancestor
+func pathTo(ancestor, el *etree.Element) []int {
+	var path []int
+	for current := el; current != nil; current = current.Parent() {
+		if current == ancestor {
+			// Reverse into root-to-leaf order
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path
+		}
+		path = append(path, current.Index())
+	}
+	return nil
+}
+
+// removeAtPath removes the element found by following child indexes from el
+func removeAtPath(el *etree.Element, path []int) {
+	for i, index := range path {
+		if index >= len(el.Child) {
+			return
+		}
+		child, ok := el.Child[index].(*etree.Element)
+		if !ok {
+			return
+		}
+		if i == len(path)-1 {
+			el.RemoveChild(child)
+			return
+		}
+		el = child
+	}
+}
+
+// childElement returns the first XMLDSig child element with the given tag
+func childElement(el *etree.Element, tag string) *etree.Element {
+	for _, child := range el.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == Namespace {
+			return child
+		}
+	}
+	return nil
+}
+
+// childElements returns every XMLDSig child element with the given tag
+func childElements(el *etree.Element, tag string) []*etree.Element {
+	var children []*etree.Element
+	for _, child := range el.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == Namespace {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// childElementNS returns the first child with the given tag regardless of namespace
+func childElementNS(el *etree.Element, tag string) *etree.Element {
+	for _, child := range el.ChildElements() {
+		if child.Tag == tag {
+			return child
+		}
+	}
+	return nil
+}
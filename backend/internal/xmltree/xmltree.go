@@ -0,0 +1,149 @@
+// Package xmltree converts fiscal XML documents to a normalized JSON tree, so the parsed content of a note
+// can be stored in a jsonb column and queried by any field.
+//
+// Each element becomes an object keyed by the local names of its children; namespaces and namespace
+// declarations are dropped. Attributes are kept under "@" + name. A child that repeats becomes an array,
+// an element with neither attributes nor children becomes its trimmed text, and the text of an element that
+// also has attributes or children is kept under "#text". Values stay strings, so CNPJs, codes and amounts
+// keep their leading zeros and exact decimal digits.
+package xmltree
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// TextKey holds the text of elements that also have attributes or children
+const TextKey = "#text"
+
+// AttributePrefix precedes the names of attributes
+const AttributePrefix = "@"
+
+// InfoElements are the elements holding the content of each supported fiscal document: InfNfse (ABRASF and
+// Prefeitura Moderna), infNFSe (NFS-e Nacional), infNFe and infCte. Names match regardless of case.
+var InfoElements = []string{"InfNfse", "infNFe", "infCte"}
+
+// ErrNotFound is returned when the document has none of the requested elements
+var ErrNotFound = errors.New("element not found")
+
+// element is an XML element being converted
+type element struct {
+	fields map[string]any
+	text   strings.Builder
+}
+
+// Convert returns the tree of the first element, in document order, whose local name matches one of names
+// regardless of case, or ErrNotFound. Without names, the root element is converted.
+func Convert(xmlContent string, names ...string) (map[string]any, error) {
+	decoder := xml.NewDecoder(strings.NewReader(xmlContent))
+	decoder.CharsetReader = charsetReader
+
+	// Elements open above the one being converted are skipped until one matches
+	var stack []*element
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XML: %v", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 && !matches(token.Name.Local, names) {
+				continue
+			}
+			stack = append(stack, newElement(token))
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(token)
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+
+			current := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			value := current.value()
+			if len(stack) == 0 {
+				tree, ok := value.(map[string]any)
+				if !ok {
+					tree = map[string]any{TextKey: value}
+				}
+				return tree, nil
+			}
+			stack[len(stack)-1].add(token.Name.Local, value)
+		}
+	}
+}
+
+// newElement starts an element with its attributes
+func newElement(start xml.StartElement) *element {
+	e := &element{fields: make(map[string]any)}
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		e.add(AttributePrefix+attr.Name.Local, attr.Value)
+	}
+	return e
+}
+
+// add sets a field of the element, turning it into an array when the name repeats
+func (e *element) add(name string, value any) {
+	existing, ok := e.fields[name]
+	if !ok {
+		e.fields[name] = value
+		return
+	}
+
+	if values, ok := existing.([]any); ok {
+		e.fields[name] = append(values, value)
+		return
+	}
+	e.fields[name] = []any{existing, value}
+}
+
+// value returns the converted element: its text when it has no fields, otherwise its fields
+func (e *element) value() any {
+	text := strings.TrimSpace(e.text.String())
+	if len(e.fields) == 0 {
+		return text
+	}
+	if text != "" {
+		e.fields[TextKey] = text
+	}
+	return e.fields
+}
+
+// matches reports whether name is one of names, ignoring case. Any name matches an empty list.
+func matches(name string, names []string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, candidate := range names {
+		if strings.EqualFold(name, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// charsetReader decodes the ISO-8859-1 and Windows-1252 documents still sent by some municipalities
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1":
+		return charmap.ISO8859_1.NewDecoder().Reader(input), nil
+	case "windows-1252":
+		return charmap.Windows1252.NewDecoder().Reader(input), nil
+	default:
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+}
@@ -0,0 +1,51 @@
+package xsd
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+//go:embed schemas/*.xsd
+var bundledSchemas embed.FS
+
+var (
+	defaultValidator *Validator
+	defaultErr       error
+	defaultOnce      sync.Once
+)
+
+// Default returns a validator for the bundled ABRASF, Prefeitura Moderna, national NFS-e and NF-e schemas,
+// which check the structure of the stored groups only. Schemas are compiled once on first use.
+func Default() (*Validator, error) {
+	defaultOnce.Do(func() {
+		defaultValidator, defaultErr = loadBundled()
+	})
+	return defaultValidator, defaultErr
+}
+
+// loadBundled compiles every schema embedded in the schemas directory
+func loadBundled() (*Validator, error) {
+	entries, err := bundledSchemas.ReadDir("schemas")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundled schemas: %v", err)
+	}
+
+	var schemas []*Schema
+	for _, entry := range entries {
+		data, err := bundledSchemas.ReadFile(path.Join("schemas", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema %s: %v", entry.Name(), err)
+		}
+
+		schema, err := compileSchema(strings.TrimSuffix(entry.Name(), ".xsd"), data)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+
+	return NewValidator(schemas...), nil
+}
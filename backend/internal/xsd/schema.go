@@ -0,0 +1,659 @@
+package xsd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// xsdNamespace is the XML Schema namespace
+const xsdNamespace = "http://www.w3.org/2001/XMLSchema"
+
+// unbounded represents maxOccurs="unbounded"
+const unbounded = -1
+
+// particleKind identifies the kind of a content model particle
+type particleKind int
+
+const (
+	elementParticle particleKind = iota
+	sequenceParticle
+	choiceParticle
+	allParticle
+	anyParticle
+)
+
+// particle is a node of a complex type content model
+type particle struct {
+	kind     particleKind
+	min      int
+	max      int
+	element  *elementDecl
+	children []*particle
+
+	// namespaces and targetNamespace constrain xs:any wildcards
+	namespaces      []string
+	targetNamespace string
+}
+
+// elementDecl is an element declaration, global or local
+type elementDecl struct {
+	name     xml.Name
+	typeName xml.Name
+	typ      *typeDef
+	ref      xml.Name
+}
+
+// attributeDecl is an attribute declaration of a complex type
+type attributeDecl struct {
+	name     string
+	typeName xml.Name
+	simple   *simpleType
+	required bool
+}
+
+// typeDef is a named or anonymous type definition
+type typeDef struct {
+	complex  *complexType
+	simple   *simpleType
+	resolved bool
+}
+
+// complexType describes the allowed children, attributes and text of an element
+type complexType struct {
+	content      *particle
+	attributes   []attributeDecl
+	textType     *simpleType
+	textBase     xml.Name
+	mixed        bool
+	simpleText   bool
+	unrestricted bool
+}
+
+// simpleType is a restriction of a built-in or another simple type
+type simpleType struct {
+	name         string
+	baseName     xml.Name
+	base         *simpleType
+	builtin      string
+	enumerations []string
+	patterns     []*regexp.Regexp
+	length       *int
+	minLength    *int
+	maxLength    *int
+	totalDigits  *int
+	fractionDig  *int
+	minInclusive string
+	maxInclusive string
+	minExclusive string
+	maxExclusive string
+}
+
+// Schema is a compiled XML schema for a single target namespace
+type Schema struct {
+	Name            string
+	TargetNamespace string
+
+	elements    map[string]*elementDecl
+	types       map[string]*typeDef
+	pendingRefs []*elementDecl
+	qualified   bool
+}
+
+// schemaNode is a generic XSD element tree node, keeping namespace prefixes in scope
+type schemaNode struct {
+	name     xml.Name
+	attrs    map[string]string
+	prefixes map[string]string
+	children []*schemaNode
+}
+
+// attr returns an attribute value or the empty string
+func (n *schemaNode) attr(name string) string {
+	return n.attrs[name]
+}
+
+// qname resolves a prefixed name such as "tns:TNumero" against the prefixes in scope
+func (n *schemaNode) qname(value string) xml.Name {
+	prefix, local, found := strings.Cut(value, ":")
+	if !found {
+		return xml.Name{Space: n.prefixes[""], Local: value}
+	}
+	return xml.Name{Space: n.prefixes[prefix], Local: local}
+}
+
+// parseSchemaTree reads an XSD document into a generic node tree
+func parseSchemaTree(data []byte) (*schemaNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var stack []*schemaNode
+	var root *schemaNode
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &schemaNode{
+				name:     t.Name,
+				attrs:    make(map[string]string),
+				prefixes: make(map[string]string),
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				for prefix, uri := range parent.prefixes {
+					node.prefixes[prefix] = uri
+				}
+				parent.children = append(parent.children, node)
+			} else {
+				root = node
+			}
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "xmlns":
+					node.prefixes[attr.Name.Local] = attr.Value
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					node.prefixes[""] = attr.Value
+				case attr.Name.Space == "":
+					node.attrs[attr.Name.Local] = attr.Value
+				}
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if root == nil || root.name.Space != xsdNamespace || root.name.Local != "schema" {
+		return nil, fmt.Errorf("document is not an XML schema")
+	}
+	return root, nil
+}
+
+// compileSchema compiles an XSD document
+func compileSchema(name string, data []byte) (*Schema, error) {
+	root, err := parseSchemaTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %v", name, err)
+	}
+
+	schema := &Schema{
+		Name:            name,
+		TargetNamespace: root.attr("targetNamespace"),
+		elements:        make(map[string]*elementDecl),
+		types:           make(map[string]*typeDef),
+		qualified:       root.attr("elementFormDefault") == "qualified",
+	}
+
+	// Register named types first so references can be resolved in any order
+	for _, child := range root.children {
+		typeName := child.attr("name")
+		switch child.name.Local {
+		case "complexType":
+			schema.types[typeName] = &typeDef{complex: &complexType{}}
+		case "simpleType":
+			schema.types[typeName] = &typeDef{simple: &simpleType{name: typeName}}
+		}
+	}
+
+	for _, child := range root.children {
+		switch child.name.Local {
+		case "complexType":
+			if err := schema.compileComplexType(child, schema.types[child.attr("name")].complex); err != nil {
+				return nil, fmt.Errorf("schema %s: %v", name, err)
+			}
+		case "simpleType":
+			if err := schema.compileSimpleType(child, schema.types[child.attr("name")].simple); err != nil {
+				return nil, fmt.Errorf("schema %s: %v", name, err)
+			}
+		case "element":
+			decl, err := schema.compileElement(child, true)
+			if err != nil {
+				return nil, fmt.Errorf("schema %s: %v", name, err)
+			}
+			schema.elements[decl.name.Local] = decl
+		}
+	}
+
+	if err := schema.resolve(); err != nil {
+		return nil, fmt.Errorf("schema %s: %v", name, err)
+	}
+
+	return schema, nil
+}
+
+// compileElement compiles an element declaration
+func (s *Schema) compileElement(node *schemaNode, global bool) (*elementDecl, error) {
+	decl := &elementDecl{}
+
+	if ref := node.attr("ref"); ref != "" {
+		decl.ref = node.qname(ref)
+		s.pendingRefs = append(s.pendingRefs, decl)
+		return decl, nil
+	}
+
+	decl.name = xml.Name{Local: node.attr("name")}
+	if global || s.qualified || node.attr("form") == "qualified" {
+		decl.name.Space = s.TargetNamespace
+	}
+	if decl.name.Local == "" {
+		return nil, fmt.Errorf("element without name")
+	}
+
+	if typeName := node.attr("type"); typeName != "" {
+		decl.typeName = node.qname(typeName)
+		return decl, nil
+	}
+
+	for _, child := range node.children {
+		switch child.name.Local {
+		case "complexType":
+			complex := &complexType{}
+			if err := s.compileComplexType(child, complex); err != nil {
+				return nil, err
+			}
+			decl.typ = &typeDef{complex: complex}
+		case "simpleType":
+			simple := &simpleType{}
+			if err := s.compileSimpleType(child, simple); err != nil {
+				return nil, err
+			}
+			decl.typ = &typeDef{simple: simple}
+		}
+	}
+
+	if decl.typ == nil {
+		// Elements without a type accept any content (xs:anyType)
+		decl.typ = &typeDef{complex: &complexType{unrestricted: true}}
+	}
+
+	return decl, nil
+}
+
+// compileComplexType fills a complex type from its XSD node
+func (s *Schema) compileComplexType(node *schemaNode, complex *complexType) error {
+	complex.mixed = node.attr("mixed") == "true"
+
+	for _, child := range node.children {
+		switch child.name.Local {
+		case "sequence", "choice", "all":
+			content, err := s.compileGroup(child)
+			if err != nil {
+				return err
+			}
+			complex.content = content
+		case "attribute":
+			attr, err := s.compileAttribute(child)
+			if err != nil {
+				return err
+			}
+			complex.attributes = append(complex.attributes, attr)
+		case "simpleContent":
+			for _, derivation := range child.children {
+				if derivation.name.Local != "extension" && derivation.name.Local != "restriction" {
+					continue
+				}
+				complex.simpleText = true
+				complex.textBase = derivation.qname(derivation.attr("base"))
+				for _, item := range derivation.children {
+					if item.name.Local == "attribute" {
+						attr, err := s.compileAttribute(item)
+						if err != nil {
+							return err
+						}
+						complex.attributes = append(complex.attributes, attr)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// compileGroup compiles a sequence, choice or all model group
+func (s *Schema) compileGroup(node *schemaNode) (*particle, error) {
+	group := &particle{}
+	switch node.name.Local {
+	case "sequence":
+		group.kind = sequenceParticle
+	case "choice":
+		group.kind = choiceParticle
+	case "all":
+		group.kind = allParticle
+	}
+
+	var err error
+	if group.min, group.max, err = occurrences(node); err != nil {
+		return nil, err
+	}
+
+	for _, child := range node.children {
+		switch child.name.Local {
+		case "element":
+			decl, err := s.compileElement(child, false)
+			if err != nil {
+				return nil, err
+			}
+			item := &particle{kind: elementParticle, element: decl}
+			if item.min, item.max, err = occurrences(child); err != nil {
+				return nil, err
+			}
+			group.children = append(group.children, item)
+		case "sequence", "choice", "all":
+			item, err := s.compileGroup(child)
+			if err != nil {
+				return nil, err
+			}
+			group.children = append(group.children, item)
+		case "any":
+			item := &particle{
+				kind:            anyParticle,
+				namespaces:      strings.Fields(child.attr("namespace")),
+				targetNamespace: s.TargetNamespace,
+			}
+			if item.min, item.max, err = occurrences(child); err != nil {
+				return nil, err
+			}
+			group.children = append(group.children, item)
+		}
+	}
+
+	return group, nil
+}
+
+// compileAttribute compiles an attribute declaration
+func (s *Schema) compileAttribute(node *schemaNode) (attributeDecl, error) {
+	attr := attributeDecl{
+		name:     node.attr("name"),
+		required: node.attr("use") == "required",
+	}
+
+	if typeName := node.attr("type"); typeName != "" {
+		attr.typeName = node.qname(typeName)
+	}
+
+	for _, child := range node.children {
+		if child.name.Local == "simpleType" {
+			attr.simple = &simpleType{}
+			if err := s.compileSimpleType(child, attr.simple); err != nil {
+				return attr, err
+			}
+		}
+	}
+
+	if attr.name == "" {
+		return attr, fmt.Errorf("attribute without name")
+	}
+	return attr, nil
+}
+
+// compileSimpleType fills a simple type from its XSD node.
+// Lists and unions are accepted as plain strings.
+func (s *Schema) compileSimpleType(node *schemaNode, simple *simpleType) error {
+	for _, child := range node.children {
+		if child.name.Local != "restriction" {
+			simple.builtin = "string"
+			continue
+		}
+
+		simple.baseName = child.qname(child.attr("base"))
+		for _, facet := range child.children {
+			value := facet.attr("value")
+			switch facet.name.Local {
+			case "enumeration":
+				simple.enumerations = append(simple.enumerations, value)
+			case "pattern":
+				pattern, err := regexp.Compile("^(?:" + value + ")$")
+				if err != nil {
+					return fmt.Errorf("unsupported pattern %q: %v", value, err)
+				}
+				simple.patterns = append(simple.patterns, pattern)
+			case "length":
+				simple.length = facetInt(value)
+			case "minLength":
+				simple.minLength = facetInt(value)
+			case "maxLength":
+				simple.maxLength = facetInt(value)
+			case "totalDigits":
+				simple.totalDigits = facetInt(value)
+			case "fractionDigits":
+				simple.fractionDig = facetInt(value)
+			case "minInclusive":
+				simple.minInclusive = value
+			case "maxInclusive":
+				simple.maxInclusive = value
+			case "minExclusive":
+				simple.minExclusive = value
+			case "maxExclusive":
+				simple.maxExclusive = value
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolve links element references and type names to their definitions
+func (s *Schema) resolve() error {
+	for _, decl := range s.pendingRefs {
+		if decl.ref.Space != s.TargetNamespace {
+			return fmt.Errorf("reference to foreign element %s", decl.ref.Local)
+		}
+		target, ok := s.elements[decl.ref.Local]
+		if !ok {
+			return fmt.Errorf("reference to undeclared element %s", decl.ref.Local)
+		}
+		*decl = *target
+	}
+	s.pendingRefs = nil
+
+	for _, def := range s.types {
+		if err := s.resolveType(def); err != nil {
+			return err
+		}
+	}
+	for _, decl := range s.elements {
+		if err := s.resolveElement(decl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveElement resolves the type of an element and of its nested declarations
+func (s *Schema) resolveElement(decl *elementDecl) error {
+	if decl.typ != nil {
+		return s.resolveType(decl.typ)
+	}
+
+	if decl.typeName.Space == xsdNamespace {
+		if decl.typeName.Local == "anyType" {
+			decl.typ = &typeDef{complex: &complexType{unrestricted: true}}
+			return nil
+		}
+		builtin, err := builtinType(decl.typeName.Local)
+		if err != nil {
+			return err
+		}
+		decl.typ = &typeDef{simple: builtin}
+		return nil
+	}
+
+	def, ok := s.types[decl.typeName.Local]
+	if !ok || decl.typeName.Space != s.TargetNamespace {
+		return fmt.Errorf("element %s has undeclared type %s", decl.name.Local, decl.typeName.Local)
+	}
+	decl.typ = def
+	return nil
+}
+
+// resolveType resolves the references inside a type definition
+func (s *Schema) resolveType(def *typeDef) error {
+	if def.resolved {
+		return nil
+	}
+	def.resolved = true
+
+	if def.simple != nil {
+		return s.resolveSimpleType(def.simple)
+	}
+
+	complex := def.complex
+	for i := range complex.attributes {
+		attr := &complex.attributes[i]
+		if attr.simple != nil {
+			if err := s.resolveSimpleType(attr.simple); err != nil {
+				return err
+			}
+			continue
+		}
+		if attr.typeName.Local == "" {
+			attr.simple = &simpleType{builtin: "string"}
+			continue
+		}
+		simple, err := s.lookupSimpleType(attr.typeName)
+		if err != nil {
+			return err
+		}
+		attr.simple = simple
+	}
+
+	if complex.simpleText && complex.textType == nil {
+		simple, err := s.lookupSimpleType(complex.textBase)
+		if err != nil {
+			return err
+		}
+		complex.textType = simple
+	}
+
+	return s.resolveParticle(complex.content)
+}
+
+// resolveParticle resolves the element declarations of a content model
+func (s *Schema) resolveParticle(p *particle) error {
+	if p == nil {
+		return nil
+	}
+	if p.kind == elementParticle {
+		return s.resolveElement(p.element)
+	}
+	for _, child := range p.children {
+		if err := s.resolveParticle(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveSimpleType links a simple type to its base type
+func (s *Schema) resolveSimpleType(simple *simpleType) error {
+	if simple.builtin != "" || simple.base != nil {
+		return nil
+	}
+	if simple.baseName.Local == "" {
+		simple.builtin = "string"
+		return nil
+	}
+
+	base, err := s.lookupSimpleType(simple.baseName)
+	if err != nil {
+		return err
+	}
+	if base == simple {
+		return fmt.Errorf("simple type %s derives from itself", simple.name)
+	}
+	simple.base = base
+	return nil
+}
+
+// lookupSimpleType finds a built-in or schema simple type by qualified name
+func (s *Schema) lookupSimpleType(name xml.Name) (*simpleType, error) {
+	if name.Space == xsdNamespace {
+		return builtinType(name.Local)
+	}
+
+	def, ok := s.types[name.Local]
+	if !ok || def.simple == nil || name.Space != s.TargetNamespace {
+		return nil, fmt.Errorf("undeclared simple type %s", name.Local)
+	}
+	if err := s.resolveSimpleType(def.simple); err != nil {
+		return nil, err
+	}
+	return def.simple, nil
+}
+
+// allows reports whether an xs:any wildcard accepts an element namespace
+func (p *particle) allows(namespace string) bool {
+	if len(p.namespaces) == 0 {
+		return true
+	}
+	for _, allowed := range p.namespaces {
+		switch allowed {
+		case "##any":
+			return true
+		case "##other":
+			if namespace != p.targetNamespace && namespace != "" {
+				return true
+			}
+		case "##local":
+			if namespace == "" {
+				return true
+			}
+		case "##targetNamespace":
+			if namespace == p.targetNamespace {
+				return true
+			}
+		default:
+			if namespace == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// occurrences reads minOccurs and maxOccurs, both defaulting to 1
+func occurrences(node *schemaNode) (int, int, error) {
+	minOccurs, maxOccurs := 1, 1
+
+	if value := node.attr("minOccurs"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid minOccurs %q", value)
+		}
+		minOccurs = parsed
+	}
+
+	if value := node.attr("maxOccurs"); value != "" {
+		if value == "unbounded" {
+			maxOccurs = unbounded
+		} else {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid maxOccurs %q", value)
+			}
+			maxOccurs = parsed
+		}
+	}
+
+	return minOccurs, maxOccurs, nil
+}
+
+// facetInt parses an integer facet value
+func facetInt(value string) *int {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
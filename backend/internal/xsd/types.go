@@ -0,0 +1,244 @@
+package xsd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	decimalPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+	integerPattern = regexp.MustCompile(`^[+-]?\d+$`)
+	timezoneSuffix = regexp.MustCompile(`(Z|[+-]\d{2}:\d{2})?$`)
+)
+
+// integerBounds holds the value space of the bounded built-in integer types
+var integerBounds = map[string][2]string{
+	"long":               {"-9223372036854775808", "9223372036854775807"},
+	"int":                {"-2147483648", "2147483647"},
+	"short":              {"-32768", "32767"},
+	"byte":               {"-128", "127"},
+	"unsignedLong":       {"0", "18446744073709551615"},
+	"unsignedInt":        {"0", "4294967295"},
+	"unsignedShort":      {"0", "65535"},
+	"unsignedByte":       {"0", "255"},
+	"nonNegativeInteger": {"0", ""},
+	"positiveInteger":    {"1", ""},
+}
+
+// builtinTypes lists the supported XML Schema built-in simple types
+var builtinTypes = map[string]bool{
+	"string": true, "normalizedString": true, "token": true, "anyURI": true,
+	"ID": true, "IDREF": true, "NCName": true, "Name": true, "language": true,
+	"base64Binary": true, "hexBinary": true, "boolean": true,
+	"decimal": true, "integer": true, "double": true, "float": true,
+	"date": true, "dateTime": true, "time": true, "gYearMonth": true, "gYear": true,
+}
+
+// builtinType returns a simple type backed by an XML Schema built-in type
+func builtinType(name string) (*simpleType, error) {
+	if !builtinTypes[name] {
+		if _, ok := integerBounds[name]; !ok {
+			return nil, fmt.Errorf("unsupported built-in type xs:%s", name)
+		}
+	}
+	return &simpleType{name: name, builtin: name}, nil
+}
+
+// primitive returns the built-in type at the root of the derivation chain
+func (t *simpleType) primitive() string {
+	for current := t; current != nil; current = current.base {
+		if current.builtin != "" {
+			return current.builtin
+		}
+	}
+	return "string"
+}
+
+// check validates a lexical value against the type and every base type, returning a description of the first violation
+func (t *simpleType) check(value string) string {
+	primitive := t.primitive()
+	if primitive != "string" && primitive != "normalizedString" {
+		value = strings.TrimSpace(value)
+	}
+
+	for current := t; current != nil; current = current.base {
+		if current.builtin != "" {
+			if problem := checkBuiltin(current.builtin, value); problem != "" {
+				return problem
+			}
+		}
+		if problem := current.checkFacets(value, primitive); problem != "" {
+			return problem
+		}
+	}
+	return ""
+}
+
+// checkFacets validates a value against the facets declared directly on the type
+func (t *simpleType) checkFacets(value, primitive string) string {
+	if len(t.enumerations) > 0 {
+		allowed := false
+		for _, enumeration := range t.enumerations {
+			if value == enumeration {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("value %q is not one of %s", value, strings.Join(t.enumerations, ", "))
+		}
+	}
+
+	for _, pattern := range t.patterns {
+		if !pattern.MatchString(value) {
+			return fmt.Sprintf("value %q does not match pattern %s", value, strings.TrimSuffix(strings.TrimPrefix(pattern.String(), "^(?:"), ")$"))
+		}
+	}
+
+	length := utf8.RuneCountInString(value)
+	if t.length != nil && length != *t.length {
+		return fmt.Sprintf("value %q must have exactly %d characters", value, *t.length)
+	}
+	if t.minLength != nil && length < *t.minLength {
+		return fmt.Sprintf("value %q is shorter than %d characters", value, *t.minLength)
+	}
+	if t.maxLength != nil && length > *t.maxLength {
+		return fmt.Sprintf("value %q is longer than %d characters", value, *t.maxLength)
+	}
+
+	if !isNumeric(primitive) {
+		return ""
+	}
+
+	number, err := decimal.NewFromString(value)
+	if err != nil {
+		return ""
+	}
+
+	if t.totalDigits != nil || t.fractionDig != nil {
+		digits, fraction := countDigits(value)
+		if t.totalDigits != nil && digits > *t.totalDigits {
+			return fmt.Sprintf("value %s has more than %d digits", value, *t.totalDigits)
+		}
+		if t.fractionDig != nil && fraction > *t.fractionDig {
+			return fmt.Sprintf("value %s has more than %d decimal places", value, *t.fractionDig)
+		}
+	}
+
+	if problem := checkBound(number, t.minInclusive, func(c int) bool { return c >= 0 }, "less than"); problem != "" {
+		return problem
+	}
+	if problem := checkBound(number, t.maxInclusive, func(c int) bool { return c <= 0 }, "greater than"); problem != "" {
+		return problem
+	}
+	if problem := checkBound(number, t.minExclusive, func(c int) bool { return c > 0 }, "not greater than"); problem != "" {
+		return problem
+	}
+	return checkBound(number, t.maxExclusive, func(c int) bool { return c < 0 }, "not less than")
+}
+
+// checkBuiltin validates the lexical form of a built-in type
+func checkBuiltin(builtin, value string) string {
+	switch builtin {
+	case "decimal", "double", "float":
+		if !decimalPattern.MatchString(value) {
+			return fmt.Sprintf("value %q is not a valid decimal", value)
+		}
+	case "integer":
+		if !integerPattern.MatchString(value) {
+			return fmt.Sprintf("value %q is not a valid integer", value)
+		}
+	case "boolean":
+		switch value {
+		case "true", "false", "1", "0":
+		default:
+			return fmt.Sprintf("value %q is not a valid boolean", value)
+		}
+	case "date":
+		if !parsesAs(value, "2006-01-02") {
+			return fmt.Sprintf("value %q is not a valid date", value)
+		}
+	case "dateTime":
+		if !parsesAs(value, "2006-01-02T15:04:05.999999999") {
+			return fmt.Sprintf("value %q is not a valid date and time", value)
+		}
+	case "time":
+		if !parsesAs(value, "15:04:05.999999999") {
+			return fmt.Sprintf("value %q is not a valid time", value)
+		}
+	case "gYearMonth":
+		if !parsesAs(value, "2006-01") {
+			return fmt.Sprintf("value %q is not a valid year and month", value)
+		}
+	case "gYear":
+		if !parsesAs(value, "2006") {
+			return fmt.Sprintf("value %q is not a valid year", value)
+		}
+	case "base64Binary":
+		if _, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), "")); err != nil {
+			return "value is not valid base64"
+		}
+	default:
+		bounds, ok := integerBounds[builtin]
+		if !ok {
+			return ""
+		}
+		if !integerPattern.MatchString(value) {
+			return fmt.Sprintf("value %q is not a valid integer", value)
+		}
+		number := decimal.RequireFromString(value)
+		if problem := checkBound(number, bounds[0], func(c int) bool { return c >= 0 }, "less than"); problem != "" {
+			return problem
+		}
+		return checkBound(number, bounds[1], func(c int) bool { return c <= 0 }, "greater than")
+	}
+	return ""
+}
+
+// parsesAs reports whether a value matches a time layout, with an optional timezone suffix
+func parsesAs(value, layout string) bool {
+	location := timezoneSuffix.FindStringIndex(value)
+	if location != nil {
+		value = value[:location[0]]
+	}
+	_, err := time.Parse(layout, value)
+	return err == nil
+}
+
+// checkBound compares a number with a facet bound, skipping empty bounds
+func checkBound(number decimal.Decimal, bound string, ok func(int) bool, relation string) string {
+	if bound == "" {
+		return ""
+	}
+	limit, err := decimal.NewFromString(bound)
+	if err != nil {
+		return ""
+	}
+	if !ok(number.Cmp(limit)) {
+		return fmt.Sprintf("value %s is %s %s", number.String(), relation, bound)
+	}
+	return ""
+}
+
+// countDigits returns the significant and fraction digits of a decimal literal
+func countDigits(value string) (int, int) {
+	value = strings.TrimLeft(value, "+-")
+	integerPart, fractionPart, _ := strings.Cut(value, ".")
+	integerPart = strings.TrimLeft(integerPart, "0")
+	fractionPart = strings.TrimRight(fractionPart, "0")
+	return len(integerPart) + len(fractionPart), len(fractionPart)
+}
+
+// isNumeric reports whether a primitive type has a numeric value space
+func isNumeric(primitive string) bool {
+	if _, ok := integerBounds[primitive]; ok {
+		return true
+	}
+	return primitive == "decimal" || primitive == "integer" || primitive == "double" || primitive == "float"
+}
@@ -0,0 +1,409 @@
+// Package xsd validates fiscal XML documents against a bundled subset of XML Schema.
+//
+// Only the constructs used by the bundled ABRASF, national NFS-e and NF-e schemas
+// are supported: global and local elements, named and anonymous types, sequence,
+// choice, all and any particles, attributes, simple content and restriction facets.
+//
+// The bundled schemas are reduced by hand from the official ones to the groups the
+// application stores, and every other group is accepted without checking its content.
+// Validation is therefore a structural check of the stored fields, not conformance
+// with the official XSDs published by ABRASF, the Receita Federal or the SEFAZ.
+package xsd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// maxErrors limits the number of errors reported for a single document
+const maxErrors = 50
+
+// Validation error codes
+const (
+	CodeMalformedXML      = "malformed_xml"
+	CodeUnknownDocument   = "unknown_document"
+	CodeUnexpectedElement = "unexpected_element"
+	CodeMissingElement    = "missing_element"
+	CodeMissingAttribute  = "missing_attribute"
+	CodeInvalidValue      = "invalid_value"
+	CodeUnexpectedText    = "unexpected_text"
+)
+
+// ValidationError describes a single schema violation
+type ValidationError struct {
+	Code    string `json:"code"`
+	Path    string `json:"path,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Result holds the outcome of validating one document
+type Result struct {
+	Schema string            `json:"schema,omitempty"`
+	Errors []ValidationError `json:"errors,omitempty"`
+}
+
+// Valid reports whether the document satisfied its schema
+func (r *Result) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Validator validates documents against the schema declaring their root element
+type Validator struct {
+	schemas []*Schema
+}
+
+// NewValidator creates a validator from compiled schemas
+func NewValidator(schemas ...*Schema) *Validator {
+	return &Validator{schemas: schemas}
+}
+
+// Validate checks a document against the schema matching its root element
+func (v *Validator) Validate(content []byte) *Result {
+	result := &Result{}
+
+	root, err := parseInstance(content)
+	if err != nil {
+		result.Errors = append(result.Errors, ValidationError{
+			Code:    CodeMalformedXML,
+			Message: err.Error(),
+		})
+		return result
+	}
+
+	schema, decl := v.lookupRoot(root.name)
+	if decl == nil {
+		result.Errors = append(result.Errors, ValidationError{
+			Code:    CodeUnknownDocument,
+			Path:    "/" + root.name.Local,
+			Line:    root.line,
+			Message: fmt.Sprintf("no bundled schema declares root element {%s}%s", root.name.Space, root.name.Local),
+		})
+		return result
+	}
+
+	result.Schema = schema.Name
+	run := &validation{result: result}
+	run.validateElement(root, decl, "/"+root.name.Local)
+	return result
+}
+
+// lookupRoot finds the global element declaration for a root element
+func (v *Validator) lookupRoot(name xml.Name) (*Schema, *elementDecl) {
+	for _, schema := range v.schemas {
+		if schema.TargetNamespace != name.Space {
+			continue
+		}
+		if decl, ok := schema.elements[name.Local]; ok {
+			return schema, decl
+		}
+	}
+	return nil, nil
+}
+
+// instanceNode is an element of the document being validated
+type instanceNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	text     strings.Builder
+	children []*instanceNode
+	line     int
+}
+
+// parseInstance reads a document into an element tree, keeping line numbers
+func parseInstance(content []byte) (*instanceNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	var stack []*instanceNode
+	var root *instanceNode
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			line, _ := decoder.InputPos()
+			node := &instanceNode{name: t.Name, attrs: t.Attr, line: line}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root == nil {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("document has no root element")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("document is truncated: element %s is not closed", stack[len(stack)-1].name.Local)
+	}
+	return root, nil
+}
+
+// validation accumulates errors while walking a document
+type validation struct {
+	result *Result
+}
+
+// report records an error unless the limit was reached
+func (v *validation) report(code, path string, line int, format string, args ...any) {
+	if len(v.result.Errors) >= maxErrors {
+		return
+	}
+	v.result.Errors = append(v.result.Errors, ValidationError{
+		Code:    code,
+		Path:    path,
+		Line:    line,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// validateElement validates an element and its descendants against a declaration
+func (v *validation) validateElement(node *instanceNode, decl *elementDecl, path string) {
+	if decl.typ.simple != nil {
+		if len(node.children) > 0 {
+			v.report(CodeUnexpectedElement, path, node.children[0].line, "element %s must contain only text", node.name.Local)
+			return
+		}
+		if problem := decl.typ.simple.check(node.text.String()); problem != "" {
+			v.report(CodeInvalidValue, path, node.line, "%s", problem)
+		}
+		return
+	}
+
+	complex := decl.typ.complex
+	if complex.unrestricted {
+		return
+	}
+
+	v.validateAttributes(node, complex, path)
+
+	if complex.simpleText {
+		if len(node.children) > 0 {
+			v.report(CodeUnexpectedElement, path, node.children[0].line, "element %s must contain only text", node.name.Local)
+			return
+		}
+		if problem := complex.textType.check(node.text.String()); problem != "" {
+			v.report(CodeInvalidValue, path, node.line, "%s", problem)
+		}
+		return
+	}
+
+	if !complex.mixed && strings.TrimSpace(node.text.String()) != "" {
+		v.report(CodeUnexpectedText, path, node.line, "element %s must not contain text", node.name.Local)
+	}
+
+	if complex.content == nil {
+		if len(node.children) > 0 {
+			v.report(CodeUnexpectedElement, path+"/"+node.children[0].name.Local, node.children[0].line, "element %s must be empty", node.name.Local)
+		}
+		return
+	}
+
+	m := &matcher{children: node.children, assigned: make([]*elementDecl, len(node.children))}
+	consumed, ok := m.match(complex.content, 0)
+
+	switch {
+	case !ok && m.missingAt < len(node.children):
+		found := node.children[m.missingAt]
+		v.report(CodeUnexpectedElement, path+"/"+found.name.Local, found.line, "element %s found where required element %s was expected", found.name.Local, m.missing.Local)
+	case !ok:
+		v.report(CodeMissingElement, path, node.line, "required element %s is missing", m.missing.Local)
+	case consumed < len(node.children):
+		unexpected := node.children[consumed]
+		v.report(CodeUnexpectedElement, path+"/"+unexpected.name.Local, unexpected.line, "element %s is not expected here", unexpected.name.Local)
+	}
+
+	for i := 0; i < consumed; i++ {
+		if m.assigned[i] != nil {
+			child := node.children[i]
+			v.validateElement(child, m.assigned[i], path+"/"+child.name.Local)
+		}
+	}
+}
+
+// validateAttributes checks required attributes and the values of declared ones
+func (v *validation) validateAttributes(node *instanceNode, complex *complexType, path string) {
+	for _, decl := range complex.attributes {
+		value, found := "", false
+		for _, attr := range node.attrs {
+			if attr.Name.Space == "" && attr.Name.Local == decl.name {
+				value, found = attr.Value, true
+				break
+			}
+		}
+
+		if !found {
+			if decl.required {
+				v.report(CodeMissingAttribute, path, node.line, "required attribute %s is missing", decl.name)
+			}
+			continue
+		}
+
+		if problem := decl.simple.check(value); problem != "" {
+			v.report(CodeInvalidValue, path+"/@"+decl.name, node.line, "%s", problem)
+		}
+	}
+}
+
+// matcher assigns child elements to the particles of a content model.
+// Matching is greedy, which is sufficient for the deterministic models XSD requires.
+type matcher struct {
+	children  []*instanceNode
+	assigned  []*elementDecl
+	missing   xml.Name
+	missingAt int
+}
+
+// noteMissing remembers the furthest required element that could not be matched
+func (m *matcher) noteMissing(name xml.Name, pos int) {
+	if m.missing.Local == "" || pos >= m.missingAt {
+		m.missing = name
+		m.missingAt = pos
+	}
+}
+
+// match consumes children starting at pos, returning the new position and whether the particle was satisfied
+func (m *matcher) match(p *particle, pos int) (int, bool) {
+	switch p.kind {
+	case elementParticle, anyParticle:
+		count := 0
+		for (p.max == unbounded || count < p.max) && pos < len(m.children) {
+			child := m.children[pos]
+			if p.kind == elementParticle {
+				if child.name != p.element.name {
+					break
+				}
+				m.assigned[pos] = p.element
+			} else {
+				if !p.allows(child.name.Space) {
+					break
+				}
+				m.assigned[pos] = nil
+			}
+			pos++
+			count++
+		}
+		if count < p.min {
+			if p.kind == elementParticle {
+				m.noteMissing(p.element.name, pos)
+			} else {
+				m.noteMissing(xml.Name{Local: "(any)"}, pos)
+			}
+			return pos, false
+		}
+		return pos, true
+
+	case sequenceParticle:
+		return m.repeat(p, pos, func(start int) (int, bool) {
+			current := start
+			for _, child := range p.children {
+				var ok bool
+				if current, ok = m.match(child, current); !ok {
+					return start, false
+				}
+			}
+			return current, true
+		})
+
+	case choiceParticle:
+		return m.repeat(p, pos, func(start int) (int, bool) {
+			satisfiedEmpty := false
+			for _, child := range p.children {
+				next, ok := m.match(child, start)
+				if ok && next > start {
+					return next, true
+				}
+				if ok {
+					satisfiedEmpty = true
+				}
+			}
+			return start, satisfiedEmpty
+		})
+
+	case allParticle:
+		used := make([]bool, len(p.children))
+		for pos < len(m.children) {
+			matched := false
+			for i, child := range p.children {
+				if used[i] || child.kind != elementParticle || m.children[pos].name != child.element.name {
+					continue
+				}
+				m.assigned[pos] = child.element
+				used[i] = true
+				matched = true
+				pos++
+				break
+			}
+			if !matched {
+				break
+			}
+		}
+		for i, child := range p.children {
+			if !used[i] && child.min > 0 && child.kind == elementParticle {
+				m.noteMissing(child.element.name, pos)
+				return pos, p.min == 0 && !anyUsed(used)
+			}
+		}
+		return pos, true
+	}
+
+	return pos, false
+}
+
+// repeat applies a group body up to maxOccurs times, requiring minOccurs successful iterations
+func (m *matcher) repeat(p *particle, pos int, body func(int) (int, bool)) (int, bool) {
+	count := 0
+	for p.max == unbounded || count < p.max {
+		next, ok := body(pos)
+		if !ok {
+			break
+		}
+		count++
+		if next == pos {
+			// The group matched without consuming anything; further iterations cannot progress
+			return pos, true
+		}
+		pos = next
+	}
+	return pos, count >= p.min
+}
+
+// anyUsed reports whether any element of an all group was matched
+func anyUsed(used []bool) bool {
+	for _, u := range used {
+		if u {
+			return true
+		}
+	}
+	return false
+}